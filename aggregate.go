@@ -0,0 +1,147 @@
+// aggregate.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/paulmach/orb"
+)
+
+// 单次聚合的点数上限
+const maxAggregatePoints = 100000
+
+type AggregatePoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// 可选权重（如订单金额），缺省为 1
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+type AggregateRequest struct {
+	Points []AggregatePoint `json:"points"`
+	Level  *int             `json:"level"`
+}
+
+type AggregateItem struct {
+	GID        string  `json:"code"`
+	Name       string  `json:"name"`
+	ParentCode string  `json:"parentCode"`
+	Level      string  `json:"level"`
+	Count      int     `json:"count"`
+	Weight     float64 `json:"weight"`
+}
+
+type AggregateResult struct {
+	List []AggregateItem `json:"list"`
+	// 不在任何行政区内（海上等）或坐标非法的点数
+	Unmatched int `json:"unmatched"`
+}
+
+type AggregateRes struct {
+	Code int              `json:"code"`
+	Msg  string           `json:"msg"`
+	Data *AggregateResult `json:"data"`
+}
+
+/************* 点集按行政区计数 *************/
+func (s *Server) aggregate(ctx context.Context, points []AggregatePoint, level int, mode nameMode) (*AggregateResult, error) {
+	byGID := make(map[string]*AggregateItem)
+	res := &AggregateResult{List: make([]AggregateItem, 0)}
+
+	// 最近命中的几个叶子多边形，聚集的点大多落在其中，省去 r-tree 查询
+	const recentSize = 8
+	var recent []*AdminLevels
+
+	for _, p := range points {
+		if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+			res.Unmatched++
+			continue
+		}
+		rlon, rlat := s.roundPoint(p.Longitude, p.Latitude)
+		var hit *AdminLevels
+		for _, a := range recent {
+			if a.contains(orb.Point{rlon, rlat}) {
+				hit = a
+				break
+			}
+		}
+		if hit == nil {
+			a, err := s.reverse(ctx, p.Longitude, p.Latitude, mode)
+			if errors.Is(err, sql.ErrNoRows) {
+				res.Unmatched++
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			s.views.apply(a, s.views.def, mode)
+			hit = a
+			recent = append([]*AdminLevels{a}, recent[:min(len(recent), recentSize-1)]...)
+		}
+		if len(hit.List) <= level {
+			// 该处的数据没有这么深的层级
+			res.Unmatched++
+			continue
+		}
+		area := hit.List[level]
+		item, ok := byGID[area.GID]
+		if !ok {
+			item = &AggregateItem{GID: area.GID, Name: area.Name, ParentCode: area.ParentCode, Level: area.Level}
+			byGID[area.GID] = item
+		}
+		item.Count++
+		if p.Weight != nil {
+			item.Weight += *p.Weight
+		} else {
+			item.Weight++
+		}
+	}
+
+	for _, item := range byGID {
+		res.List = append(res.List, *item)
+	}
+	sort.Slice(res.List, func(i, j int) bool {
+		if res.List[i].Count != res.List[j].Count {
+			return res.List[i].Count > res.List[j].Count
+		}
+		return res.List[i].GID < res.List[j].GID
+	})
+	return res, nil
+}
+
+func (s *Server) handleAggregate(w http.ResponseWriter, r *http.Request) {
+	var req AggregateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<20)).Decode(&req); err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid json body")
+		return
+	}
+	if len(req.Points) == 0 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "points required")
+		return
+	}
+	if len(req.Points) > maxAggregatePoints {
+		writeErrorJSON(w, http.StatusBadRequest, 400, fmt.Sprintf("too many points, max %d", maxAggregatePoints))
+		return
+	}
+	if req.Level == nil || *req.Level < 0 || *req.Level > 5 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+		return
+	}
+
+	res, err := s.aggregate(r.Context(), req.Points, *req.Level, parseNameMode(r))
+	if err != nil {
+		writeQueryError(w, "aggregate", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, AggregateRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
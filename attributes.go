@@ -0,0 +1,188 @@
+// attributes.go
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 按 GID 关联的外部属性（人口、贫困指数等）：ATTRIBUTES_PATH 为逗号分隔的 CSV 文件，首行为表头，
+// gid（或 code）列为 GID，其余每列一个属性。/details、/children 用 ?include=population,poverty_index 带上，
+// 不用另外维护一个关联服务。数值按数字输出，空值不输出
+type attributeStore struct {
+	columns []string
+	byGID   map[string]map[string]any
+}
+
+func loadAttributes(paths []string) (*attributeStore, error) {
+	st := &attributeStore{byGID: make(map[string]map[string]any)}
+	seen := make(map[string]string)
+	for _, path := range paths {
+		if strings.EqualFold(filepath.Ext(path), ".parquet") {
+			return nil, fmt.Errorf("%s: parquet is not supported, convert it to CSV", path)
+		}
+		cols, err := st.loadCSV(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, col := range cols {
+			if prev, ok := seen[col]; ok {
+				return nil, fmt.Errorf("%s: attribute %s already in %s", path, col, prev)
+			}
+			seen[col] = path
+			st.columns = append(st.columns, col)
+		}
+	}
+	return st, nil
+}
+
+// 返回该文件中的属性列名
+func (st *attributeStore) loadCSV(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	key := -1
+	cols := make([]string, len(header))
+	for i, h := range header {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if key < 0 && (strings.EqualFold(h, "gid") || strings.EqualFold(h, "code")) {
+			key = i
+			continue
+		}
+		if h == "" {
+			return nil, fmt.Errorf("column %d has no name", i+1)
+		}
+		cols[i] = h
+	}
+	if key < 0 {
+		return nil, fmt.Errorf("gid or code column required")
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key >= len(row) {
+			continue
+		}
+		gid := strings.TrimSpace(row[key])
+		if gid == "" {
+			continue
+		}
+		attrs := st.byGID[gid]
+		if attrs == nil {
+			attrs = make(map[string]any)
+			st.byGID[gid] = attrs
+		}
+		for i, v := range row {
+			if i == key || i >= len(cols) {
+				continue
+			}
+			if v = strings.TrimSpace(v); v != "" {
+				attrs[cols[i]] = attributeValue(v)
+			}
+		}
+	}
+	out := make([]string, 0, len(cols))
+	for _, col := range cols {
+		if col != "" {
+			out = append(out, col)
+		}
+	}
+	return out, nil
+}
+
+// 能解析为有限数字的按数字输出；0 开头的（如邮编 0123）保留原文
+func attributeValue(v string) any {
+	if len(v) > 1 && v[0] == '0' && v[1] != '.' {
+		return v
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+		return n
+	}
+	return v
+}
+
+// ?include= 中的属性名；all 为全部，未加载的属性报错
+func (s *Server) parseInclude(r *http.Request) ([]string, error) {
+	v := strings.TrimSpace(r.URL.Query().Get("include"))
+	if v == "" {
+		return nil, nil
+	}
+	if v == "all" {
+		return s.attributes.columns, nil
+	}
+	names := splitList(v)
+	for _, name := range names {
+		found := false
+		for _, col := range s.attributes.columns {
+			if col == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown attribute %s", name)
+		}
+	}
+	return names, nil
+}
+
+// 该 GID 的指定属性，都没有时为 nil
+func (st *attributeStore) of(gid string, names []string) map[string]any {
+	attrs := st.byGID[gid]
+	if attrs == nil {
+		return nil
+	}
+	var out map[string]any
+	for _, name := range names {
+		if v, ok := attrs[name]; ok {
+			if out == nil {
+				out = make(map[string]any, len(names))
+			}
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// 文本形式，用于 CSV 和 Protobuf
+func attributeText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// 按键排序，输出稳定
+func sortedAttributeKeys(attrs map[string]any) []string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
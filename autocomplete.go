@@ -0,0 +1,139 @@
+// autocomplete.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 名称索引中的一条记录；同一个行政区按每个词的起始位置各有一条 key
+type nameEntry struct {
+	key  string
+	word bool // 由名称中间某个词开始的 key
+	item *ChildrenItem
+}
+
+// 启动时从 GADM 表构建的内存名称索引，按层级分桶、桶内按 key 排序
+type nameIndex struct {
+	levels   [6][]nameEntry
+	items    []*ChildrenItem
+	byGID    map[string]*ChildrenItem
+	trigrams map[string][]int32
+}
+
+var errNameIndexNotReady = errors.New("name index not ready")
+
+func normalizeName(v string) string {
+	return strings.ToLower(strings.Join(strings.Fields(v), " "))
+}
+
+func (s *Server) buildNameIndex() (*nameIndex, error) {
+	idx := &nameIndex{byGID: make(map[string]*ChildrenItem)}
+	levelName := levelNameMap()
+	for lvl := 0; lvl <= 5; lvl++ {
+		parentCol := "''"
+		if lvl > 0 {
+			parentCol = fmt.Sprintf("IFNULL(GID_%d, '')", lvl-1)
+		}
+		sqlStr := fmt.Sprintf(`
+SELECT DISTINCT GID_%d, IFNULL(NAME_%d, ''), %s
+FROM %s
+WHERE GID_%d IS NOT NULL AND GID_%d <> '';`,
+			lvl, lvl, parentCol, s.table, lvl, lvl)
+		if s.built {
+			sqlStr = fmt.Sprintf(`SELECT gid, name, parent FROM "%s";`, levelTableName(s.table, lvl))
+		}
+		rows, err := s.db.Query(sqlStr)
+		if err != nil {
+			return nil, err
+		}
+		var entries []nameEntry
+		for rows.Next() {
+			item := &ChildrenItem{Level: levelName[lvl]}
+			if err := rows.Scan(&item.GID, &item.Name, &item.ParentCode); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			idx.items = append(idx.items, item)
+			idx.byGID[item.GID] = item
+			key := normalizeName(item.Name)
+			entries = append(entries, nameEntry{key: key, item: item})
+			// 词首也能命中，如 "band" → "Kota Bandung"
+			for i := 0; i < len(key); i++ {
+				if key[i] == ' ' && i+1 < len(key) {
+					entries = append(entries, nameEntry{key: key[i+1:], word: true, item: item})
+				}
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+		idx.levels[lvl] = entries
+	}
+	idx.buildTrigrams()
+	return idx, nil
+}
+
+// 前缀匹配，按层级（省份在村庄之前）排序；同层先整名前缀、再词首前缀，各自按名称排序
+func (idx *nameIndex) prefix(q string, limit int) []ChildrenItem {
+	q = normalizeName(q)
+	out := make([]ChildrenItem, 0, limit)
+	seen := make(map[string]bool)
+	for lvl := 0; lvl <= 5 && len(out) < limit; lvl++ {
+		entries := idx.levels[lvl]
+		start := sort.Search(len(entries), func(i int) bool { return entries[i].key >= q })
+		for _, word := range []bool{false, true} {
+			for i := start; i < len(entries) && len(out) < limit; i++ {
+				e := entries[i]
+				if !strings.HasPrefix(e.key, q) {
+					break
+				}
+				if e.word != word || seen[e.item.GID] {
+					continue
+				}
+				seen[e.item.GID] = true
+				out = append(out, *e.item)
+			}
+		}
+	}
+	return out
+}
+
+// 后台构建名称索引，构建完成前相关接口返回 503
+func (s *Server) loadNameIndex() {
+	start := time.Now()
+	idx, err := s.buildNameIndex()
+	if err != nil {
+		log.Println("name index error:", err)
+		return
+	}
+	s.names.Store(idx)
+	log.Printf("name index ready: %d areas in %s", len(idx.items), time.Since(start).Round(time.Millisecond))
+}
+
+func (s *Server) handleAutocomplete(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "q required")
+		return
+	}
+	idx := s.names.Load()
+	if idx == nil {
+		writeErrorJSON(w, http.StatusServiceUnavailable, 503, errNameIndexNotReady.Error())
+		return
+	}
+	limit := queryInt(r, "limit", 10, 1, 50)
+	items := idx.prefix(q, limit)
+	writeJSON(w, http.StatusOK, ChildrenRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &ChildrenItemList{List: items, Total: len(items), Limit: limit},
+	})
+}
@@ -0,0 +1,126 @@
+// batch.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 单次批量查询的 GID 上限
+const maxBatchCodes = 1000
+
+type LatlngBatchRequest struct {
+	Codes []string `json:"codes"`
+}
+
+type LatlngBatchResult struct {
+	List []LatlngItem `json:"list"`
+	// 找不到的 code，按请求顺序
+	Missing []string `json:"missing"`
+}
+
+type LatlngBatchRes struct {
+	Code int                `json:"code"`
+	Msg  string             `json:"msg"`
+	Data *LatlngBatchResult `json:"data"`
+}
+
+// 批量取中心点；海拔只读缓存，未缓存的为 0，不逐个请求谷歌
+func (s *Server) latlngBatch(ctx context.Context, codes []string, geohashPrecision int) (*LatlngBatchResult, error) {
+	res := &LatlngBatchResult{List: make([]LatlngItem, 0, len(codes)), Missing: make([]string, 0)}
+	err := s.latlngBatchEach(ctx, codes, geohashPrecision, func(code string, item *LatlngItem) error {
+		if item == nil {
+			res.Missing = append(res.Missing, code)
+		} else {
+			res.List = append(res.List, *item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// 按请求顺序逐个回调，重复的 code 只回调一次；找不到时 item 为 nil
+func (s *Server) latlngBatchEach(ctx context.Context, codes []string, geohashPrecision int, emit func(code string, item *LatlngItem) error) error {
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+
+		item, err := s.latlngOf(ctx, code)
+		if err != nil {
+			if strings.Contains(err.Error(), "gid not found") {
+				if err := emit(code, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		elevation, err := s.getElevation(item.GID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		item.Elevation = elevation
+		if geohashPrecision > 0 {
+			item.Geohash = encodeGeohash(item.Latitude, item.Longitude, geohashPrecision)
+		}
+		if err := emit(code, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleLatlngBatch(w http.ResponseWriter, r *http.Request) {
+	var req LatlngBatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid json body")
+		return
+	}
+	if len(req.Codes) == 0 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "codes required")
+		return
+	}
+	if len(req.Codes) > maxBatchCodes {
+		writeErrorJSON(w, http.StatusBadRequest, 400, fmt.Sprintf("too many codes, max %d", maxBatchCodes))
+		return
+	}
+
+	geohashPrecision := queryInt(r, "geohash_precision", 0, 0, 12)
+	if wantsNDJSON(r) {
+		nd := newNDJSONStream(w)
+		nd.finish("latlng batch", s.latlngBatchEach(r.Context(), req.Codes, geohashPrecision, func(code string, item *LatlngItem) error {
+			if item == nil {
+				return nd.write(ndjsonMissing{GID: code, Missing: true})
+			}
+			return nd.write(item)
+		}))
+		return
+	}
+
+	res, err := s.latlngBatch(r.Context(), req.Codes, geohashPrecision)
+	if err != nil {
+		writeQueryError(w, "latlng batch", err)
+		return
+	}
+	if wantsCSV(r) {
+		writeLatlngCSV(w, res.List)
+		return
+	}
+	writeJSON(w, http.StatusOK, LatlngBatchRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
@@ -0,0 +1,250 @@
+// bench.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 命令行：在进程内（不经 HTTP）反查一批点，报告吞吐、每次的内存分配和延迟分位数，
+// 性能相关的改动合并前后各跑一次对比。数据集与服务相同，按 GPKG_PATH、DATASET 等环境变量打开。
+//
+//	gpkg-reverse bench -n 100000 -c 4
+//	gpkg-reverse bench -points warmup.txt -json
+//
+// 点默认随机生成：随机取一个叶子，在其外接矩形内均匀取点，大部分能命中；-bbox 时在该范围内均匀取点；
+// -points 读取记录的点，格式同 WARMUP_PATH（见 warmup.go），其中的 GID 忽略，按顺序循环使用。
+// 各缓存照常生效，需要时用 RESULT_CACHE_SIZE=0、GEOM_CACHE_MB=0 等关掉
+
+type BenchResult struct {
+	Requests    int     `json:"requests"`
+	Concurrency int     `json:"concurrency"`
+	Seconds     float64 `json:"seconds"`
+	PerSecond   float64 `json:"perSecond"`
+	Found       int     `json:"found"`
+	Errors      int     `json:"errors"`
+	// 每次反查的平均分配次数和字节数，含并发的后台分配
+	AllocsPerOp float64 `json:"allocsPerOp"`
+	BytesPerOp  float64 `json:"bytesPerOp"`
+	GCs         uint32  `json:"gcs"`
+	// 延迟（微秒）
+	P50  float64 `json:"p50Us"`
+	P90  float64 `json:"p90Us"`
+	P99  float64 `json:"p99Us"`
+	P999 float64 `json:"p999Us"`
+	Max  float64 `json:"maxUs"`
+}
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := fs.Int("n", 100000, "number of reverse lookups to measure")
+	concurrency := fs.Int("c", runtime.NumCPU(), "concurrent workers")
+	warmupN := fs.Int("warmup", 1000, "lookups before measuring, not counted")
+	pointsPath := fs.String("points", "", "recorded points, same format as WARMUP_PATH")
+	bboxStr := fs.String("bbox", "", "random points uniformly in minLon,minLat,maxLon,maxLat instead of inside random leaves")
+	seed := fs.Int64("seed", 1, "random seed")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *n < 1 || *concurrency < 1 || *warmupN < 0 {
+		return fmt.Errorf("-n and -c must be at least 1, -warmup at least 0")
+	}
+	s, err := openBenchServer()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	var points [][2]float64 // lon, lat
+	switch {
+	case *pointsPath != "":
+		entries, err := loadWarmup(*pointsPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.gid == "" {
+				points = append(points, [2]float64{e.lon, e.lat})
+			}
+		}
+		if len(points) == 0 {
+			return fmt.Errorf("%s: no points", *pointsPath)
+		}
+	case *bboxStr != "":
+		b, err := parseBBox(*bboxStr)
+		if err != nil {
+			return err
+		}
+		r := rand.New(rand.NewSource(*seed))
+		for i := 0; i < *n; i++ {
+			points = append(points, [2]float64{
+				b.Min[0] + r.Float64()*(b.Max[0]-b.Min[0]),
+				b.Min[1] + r.Float64()*(b.Max[1]-b.Min[1]),
+			})
+		}
+	default:
+		if points, err = s.randomLeafPoints(*n, *seed); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < *warmupN; i++ {
+		p := points[i%len(points)]
+		s.reverse(context.Background(), p[0], p[1], nameLatin)
+	}
+
+	res := benchReverse(s, points, *n, *concurrency)
+	if *asJSON {
+		return json.NewEncoder(os.Stdout).Encode(res)
+	}
+	fmt.Printf("requests     %d (%d workers)\n", res.Requests, res.Concurrency)
+	fmt.Printf("found        %d, errors %d\n", res.Found, res.Errors)
+	fmt.Printf("throughput   %.0f/s in %.2fs\n", res.PerSecond, res.Seconds)
+	fmt.Printf("allocations  %.1f allocs/op, %.0f B/op, %d GCs\n", res.AllocsPerOp, res.BytesPerOp, res.GCs)
+	fmt.Printf("latency      p50 %.0fµs  p90 %.0fµs  p99 %.0fµs  p99.9 %.0fµs  max %.0fµs\n",
+		res.P50, res.P90, res.P99, res.P999, res.Max)
+	return nil
+}
+
+// 与服务相同的方式打开数据集；GPKG_DIR 有多个数据集，不支持
+func openBenchServer() (*Server, error) {
+	if env("GPKG_DIR", "") != "" {
+		return nil, fmt.Errorf("GPKG_DIR is not supported, set GPKG_PATH to one of its files")
+	}
+	var (
+		files func() ([]string, error)
+		open  func() ([]*Server, []apiRoute, error)
+	)
+	switch dataset := env("DATASET", "gadm"); dataset {
+	case "gadm":
+		files, open = singleDataset(nil, nil)
+	case "naturalearth":
+		files, open = naturalEarthDataset(nil, nil)
+	default:
+		return nil, fmt.Errorf("invalid DATASET %q, use gadm or naturalearth", dataset)
+	}
+	if path := env("OVERRIDES_PATH", ""); path != "" {
+		if err := initOverrides(path); err != nil {
+			return nil, fmt.Errorf("overrides: %w", err)
+		}
+	}
+	if _, err := files(); err != nil {
+		return nil, err
+	}
+	servers, _, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return servers[0], nil
+}
+
+// 随机取叶子，在其外接矩形内均匀取点
+func (s *Server) randomLeafPoints(n int, seed int64) ([][2]float64, error) {
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT minx, maxx, miny, maxy FROM %s;`, s.rtreeTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var boxes [][4]float64
+	for rows.Next() {
+		var b [4]float64
+		if err := rows.Scan(&b[0], &b[1], &b[2], &b[3]); err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(boxes) == 0 {
+		return nil, fmt.Errorf("%s is empty", s.rtreeTable)
+	}
+	r := rand.New(rand.NewSource(seed))
+	points := make([][2]float64, n)
+	for i := range points {
+		b := boxes[r.Intn(len(boxes))]
+		points[i] = [2]float64{b[0] + r.Float64()*(b[1]-b[0]), b[2] + r.Float64()*(b[3]-b[2])}
+	}
+	return points, nil
+}
+
+// c 个 goroutine 共反查 n 次，依次取 points 中的点（不够时循环）
+func benchReverse(s *Server, points [][2]float64, n, c int) BenchResult {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		latencies = make([]time.Duration, 0, n)
+		found     int
+		errCount  int
+		next      = make(chan int, c)
+	)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for w := 0; w < c; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]time.Duration, 0, n/c+1)
+			hits, errs := 0, 0
+			for i := range next {
+				p := points[i%len(points)]
+				t := time.Now()
+				_, err := s.reverse(context.Background(), p[0], p[1], nameLatin)
+				local = append(local, time.Since(t))
+				switch {
+				case err == nil:
+					hits++
+				case !errors.Is(err, sql.ErrNoRows):
+					errs++
+				}
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			found += hits
+			errCount += errs
+			mu.Unlock()
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	slices.Sort(latencies)
+	pct := func(p float64) float64 {
+		i := int(p * float64(len(latencies)-1))
+		return float64(latencies[i]) / float64(time.Microsecond)
+	}
+	return BenchResult{
+		Requests:    n,
+		Concurrency: c,
+		Seconds:     elapsed.Seconds(),
+		PerSecond:   float64(n) / elapsed.Seconds(),
+		Found:       found,
+		Errors:      errCount,
+		AllocsPerOp: float64(after.Mallocs-before.Mallocs) / float64(n),
+		BytesPerOp:  float64(after.TotalAlloc-before.TotalAlloc) / float64(n),
+		GCs:         after.NumGC - before.NumGC,
+		P50:         pct(0.5),
+		P90:         pct(0.9),
+		P99:         pct(0.99),
+		P999:        pct(0.999),
+		Max:         pct(1),
+	}
+}
@@ -0,0 +1,436 @@
+// boundary.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/simplify"
+)
+
+// 行政区的完整几何：该 GID 下所有叶子行的多边形，shapeOf 为拼接，shapeAt 为合并后的轮廓
+type AreaShape struct {
+	Item  ChildrenItem
+	Level int
+	Geom  orb.MultiPolygon
+}
+
+type BoundaryRes struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	// 默认为 GeoJSON Feature，geom_format=wkt 时为 BoundaryWKT
+	Data any `json:"data"`
+}
+
+// geom_format=wkt 时 /boundary 的 data
+type BoundaryWKT struct {
+	ChildrenItem
+	Geometry string `json:"geometry"`
+}
+
+// 按 ?geom_format= 输出的几何：默认 GeoJSON 对象，wkt 时为 WKT 字符串
+type OutputGeometry struct {
+	Geom orb.Geometry
+	WKT  bool
+}
+
+func (g *OutputGeometry) MarshalJSON() ([]byte, error) {
+	if g.WKT {
+		return json.Marshal(wkt.MarshalString(g.Geom))
+	}
+	return json.Marshal(geojson.NewGeometry(g.Geom))
+}
+
+// geom_format=geojson（默认）或 wkt，返回是否输出 WKT
+func parseGeomFormat(r *http.Request) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(r.URL.Query().Get("geom_format"))) {
+	case "", "geojson":
+		return false, nil
+	case "wkt":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid geom_format, use geojson or wkt")
+}
+
+/************* GID → 几何 *************/
+// 原始几何（各叶子拼接，不合并），点面判断、面积等计算都用它
+func (s *Server) shapeOf(ctx context.Context, GID string) (*AreaShape, error) {
+	return s.leafShapes(ctx, GID, 0)
+}
+
+// 按 tolerance 简化的几何，只用于输出；tolerance 为 0 时不简化。
+// 只有一个叶子时取自不比 tolerance 粗的最粗一档预简化几何（见 resolutions.go），其容差小于 tolerance 时再简化；
+// 有多个叶子时先合并原始几何、去掉叶子之间的边界（见 dissolve），再整体简化。
+// 合并失败（叶子几何不合法等）时退回各叶子分别简化后拼接
+func (s *Server) shapeAt(ctx context.Context, GID string, tolerance float64) (*AreaShape, error) {
+	GID = strings.TrimSpace(GID)
+	if GID == "" {
+		return nil, fmt.Errorf("gid required")
+	}
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+	var leaves int
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE GID_%d = ?;`, s.table, level), GID).Scan(&leaves); err != nil {
+		return nil, err
+	}
+	if leaves <= 1 {
+		return s.leafShapes(ctx, GID, tolerance)
+	}
+	shape, err := s.leafShapes(ctx, GID, 0)
+	if err != nil {
+		return nil, err
+	}
+	geom, _, err := dissolve(shape.Geom)
+	if err != nil {
+		log.Printf("boundary: dissolve %s: %v, leaves simplified separately", GID, err)
+		return s.leafShapes(ctx, GID, tolerance)
+	}
+	shape.Geom = geom
+	if tolerance > 0 {
+		shape.Geom = resimplify(geom, tolerance)
+	}
+	return shape, nil
+}
+
+// 各叶子按 tolerance 简化后拼接（同 eachLeafShape）
+func (s *Server) leafShapes(ctx context.Context, GID string, tolerance float64) (*AreaShape, error) {
+	var shape *AreaShape
+	err := s.eachLeafShape(ctx, GID, tolerance, func(head *AreaShape, mp orb.MultiPolygon) error {
+		shape = head
+		shape.Geom = append(shape.Geom, mp...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shape, nil
+}
+
+// 逐个叶子回调该 GID 的几何（已按 tolerance 简化，预简化几何的选取同 shapeAt），head 为该行政区的属性，每次相同、不含几何。
+// 几何无法解码的叶子跳过，都无法解码时 fn 至少以 nil 几何调用一次
+func (s *Server) eachLeafShape(ctx context.Context, GID string, tolerance float64, fn func(head *AreaShape, mp orb.MultiPolygon) error) error {
+	GID = strings.TrimSpace(GID)
+	if GID == "" {
+		return fmt.Errorf("gid required")
+	}
+
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return err
+	}
+
+	parentGidCol := "NULL"
+	if level > 0 {
+		parentGidCol = fmt.Sprintf("a.GID_%d", level-1)
+	}
+	geomTable, geomCol, sourceTolerance := s.geomSource(tolerance)
+	geomExpr, join := "a."+geomCol, ""
+	if geomTable != s.table {
+		geomExpr, join = "g."+geomCol, fmt.Sprintf(` LEFT JOIN "%s" AS g ON g.fid = a.rowid`, geomTable)
+	}
+	sqlStr := fmt.Sprintf(`SELECT a.NAME_%d, %s, %s FROM %s AS a%s WHERE a.GID_%d = ?`,
+		level, parentGidCol, geomExpr, s.table, join, level)
+
+	rows, err := s.db.QueryContext(ctx, sqlStr, GID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var head *AreaShape
+	decoded := false
+	for rows.Next() {
+		var (
+			name      sql.NullString
+			parentGid sql.NullString
+			blob      []byte
+		)
+		if err := rows.Scan(&name, &parentGid, &blob); err != nil {
+			return err
+		}
+		if head == nil {
+			head = &AreaShape{Level: level, Item: ChildrenItem{
+				GID:        GID,
+				Name:       name.String,
+				ParentCode: parentGid.String,
+				Level:      levelNameMap()[level],
+			}}
+		}
+		wkbBytes, _, err := gpkgToWKB(blob)
+		if err != nil {
+			continue
+		}
+		mp, err := decodeMultiPolygon(wkbBytes)
+		if err != nil {
+			continue
+		}
+		// 按叶子分别简化，某个叶子简化后退化时只有它保留原始几何
+		if sourceTolerance < tolerance {
+			mp = resimplify(mp, tolerance)
+		}
+		if err := fn(head, mp); err != nil {
+			return err
+		}
+		decoded = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if head == nil {
+		return fmt.Errorf("gid not found")
+	}
+	if !decoded {
+		return fn(head, nil)
+	}
+	return nil
+}
+
+// Douglas-Peucker 简化，tolerance 单位为度；<= 0 时原样返回
+func simplifyShape(mp orb.MultiPolygon, tolerance float64) orb.MultiPolygon {
+	if tolerance <= 0 {
+		return mp
+	}
+	return simplify.DouglasPeucker(tolerance).MultiPolygon(mp.Clone())
+}
+
+// /reverse 内联几何的默认简化容差（度，约 110 m）
+const defaultInlineTolerance = 0.001
+
+// 把命中区域的边界附加到反查结果上。结果被 ?level= 截断时取该层的完整几何，
+// 否则直接用已解码的最末级多边形。
+func (s *Server) attachGeometry(ctx context.Context, res *AdminLevels, full bool, tolerance float64, asWKT bool) error {
+	switch {
+	case full:
+		tolerance = 0
+	case tolerance <= 0:
+		tolerance = defaultInlineTolerance
+	}
+	geom := res.geom
+	if last := res.List[len(res.List)-1]; last.GID != res.leaf || geom == nil {
+		shape, err := s.simplifiedShapeOf(ctx, last.GID, tolerance)
+		if err != nil {
+			return err
+		}
+		geom = shape.Geom
+	} else {
+		geom = simplifyShape(geom, tolerance)
+	}
+	res.Geometry = &OutputGeometry{Geom: outputGeometry(geom), WKT: asWKT}
+	return nil
+}
+
+func shapeFeature(shape *AreaShape, geom orb.Geometry) *geojson.Feature {
+	f := geojson.NewFeature(geom)
+	f.ID = shape.Item.GID
+	// 外部属性与其他属性平铺，同名时以行政区自身的为准
+	for k, v := range shape.Item.Attributes {
+		f.Properties[k] = v
+	}
+	f.Properties["code"] = shape.Item.GID
+	f.Properties["name"] = shape.Item.Name
+	f.Properties["parentCode"] = shape.Item.ParentCode
+	f.Properties["level"] = shape.Item.Level
+	return f
+}
+
+// 单个多边形时按 Polygon 输出，更贴近 GeoJSON 习惯
+func outputGeometry(mp orb.MultiPolygon) orb.Geometry {
+	if len(mp) == 1 {
+		return mp[0]
+	}
+	return mp
+}
+
+// ?tolerance=（度）或 ?zoom=（按该缩放级别一个瓦片像素换算），都没有时为 0
+func parseTolerance(r *http.Request) (float64, error) {
+	v := strings.TrimSpace(r.URL.Query().Get("tolerance"))
+	if z := strings.TrimSpace(r.URL.Query().Get("zoom")); z != "" {
+		if v != "" {
+			return 0, fmt.Errorf("use either tolerance or zoom")
+		}
+		n, err := strconv.Atoi(z)
+		if err != nil || n < 0 || n > maxTileZoom {
+			return 0, fmt.Errorf("invalid zoom, use 0..%d", maxTileZoom)
+		}
+		return zoomTolerance(n), nil
+	}
+	if v == "" {
+		return 0, nil
+	}
+	t, err := strconv.ParseFloat(v, 64)
+	if err != nil || t < 0 || t > 1 {
+		return 0, fmt.Errorf("invalid tolerance, use degrees in [0, 1]")
+	}
+	return t, nil
+}
+
+// 获取行政区域的边界 GeoJSON；?format=fgb 时为 FlatGeobuf
+func (s *Server) handleBoundary(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	tolerance, err := parseTolerance(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	asWKT, err := parseGeomFormat(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	fgb := strings.EqualFold(r.URL.Query().Get("format"), "fgb")
+	// 不在每层表中的大边界边编码边输出；每层表中的一个几何或 WKT 照常整体编码
+	if !fgb && !asWKT && !s.levelGeomCovers(tolerance) && streamsJSON(w, BoundaryRes{}) {
+		w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+		s.streamBoundary(r.Context(), w, code, tolerance)
+		return
+	}
+	shape, err := s.simplifiedShapeOf(r.Context(), code, tolerance)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "shapeOf", err)
+		return
+	}
+	if len(shape.Geom) == 0 {
+		log.Printf("boundary error: no decodable geometry for GID %s", code)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+
+	if fgb {
+		feature := fgbFeature{
+			props: []string{shape.Item.GID, shape.Item.Name, shape.Item.ParentCode, shape.Item.Level},
+			geom:  shape.Geom,
+		}
+		base := strings.ReplaceAll(shape.Item.GID, ".", "_")
+		serveFlatGeobuf(w, r, base, encodeFlatGeobuf(base, []string{"code", "name", "parentCode", "level"}, []fgbFeature{feature}))
+		return
+	}
+
+	geom := outputGeometry(shape.Geom)
+	var data any = shapeFeature(shape, geom)
+	if asWKT {
+		data = BoundaryWKT{ChildrenItem: shape.Item, Geometry: wkt.MarshalString(geom)}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, BoundaryRes{
+		Code: 200,
+		Msg:  "success",
+		Data: data,
+	})
+}
+
+// 边界逐个多边形输出（见 stream.go），与整体编码 shapeFeature 的结果相同；只有一个多边形时输出 Polygon
+func (s *Server) streamBoundary(ctx context.Context, w http.ResponseWriter, code string, tolerance float64) {
+	st := newJSONStream(w, formatContentTypes[formatJSON], !isBare(w))
+	shape, err := s.shapeAt(ctx, code, tolerance)
+	if err == nil && len(shape.Geom) == 0 {
+		log.Printf("boundary error: no decodable geometry for GID %s", code)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	if err == nil {
+		st.open()
+		var id []byte
+		if id, err = json.Marshal(shape.Item.GID); err == nil {
+			err = st.raw(`{"id":` + string(id) + `,"type":"Feature","geometry":`)
+		}
+	}
+	if err == nil {
+		if len(shape.Geom) == 1 {
+			err = st.value(geojson.NewGeometry(shape.Geom[0]))
+		} else {
+			err = st.raw(`{"type":"MultiPolygon","coordinates":[`)
+			for i, p := range shape.Geom {
+				if err == nil && i > 0 {
+					err = st.raw(",")
+				}
+				if err == nil {
+					err = st.value(p)
+				}
+			}
+			if err == nil {
+				err = st.raw("]}")
+			}
+		}
+	}
+	if err == nil {
+		if err = st.raw(`,"properties":`); err == nil {
+			err = st.value(shapeFeature(shape, nil).Properties)
+		}
+	}
+	if err != nil {
+		st.fail("boundary", err)
+		return
+	}
+	st.raw("}")
+	st.close()
+}
+
+// ?format=geojson 或 Accept: application/geo+json
+func wantsGeoJSON(r *http.Request) bool {
+	if strings.EqualFold(r.URL.Query().Get("format"), "geojson") {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/geo+json")
+}
+
+// 每个子区域一个带简化边界的 Feature，逐个取边界、输出（见 stream.go），附带 extra 中的成员；
+// tolerance 为 0 时用 defaultInlineTolerance
+func (s *Server) streamItemsFeatureCollection(ctx context.Context, w http.ResponseWriter, items []ChildrenItem, tolerance float64, extra geojson.Properties) {
+	if tolerance <= 0 {
+		tolerance = defaultInlineTolerance
+	}
+	st := newJSONStream(w, "application/geo+json", false)
+	var err error
+	sep := `{"features":[`
+	for _, item := range items {
+		var shape *AreaShape
+		if shape, err = s.simplifiedShapeOf(ctx, item.GID, tolerance); err != nil {
+			break
+		}
+		// 名称沿用列表中的（可能是 lang 对应的本地名）
+		shape.Item = item
+		if err = st.raw(sep); err != nil {
+			break
+		}
+		if err = st.value(shapeFeature(shape, outputGeometry(shape.Geom))); err != nil {
+			break
+		}
+		sep = ","
+	}
+	if err == nil && sep != "," {
+		err = st.raw(sep)
+	}
+	if err == nil {
+		// 与 FeatureCollection 的编码一样，其余成员按键名排序
+		extra = extra.Clone()
+		extra["type"] = "FeatureCollection"
+		var b []byte
+		if b, err = json.Marshal(extra); err == nil {
+			err = st.raw("]," + string(b[1:]))
+		}
+	}
+	if err != nil {
+		st.fail("children geojson", err)
+		return
+	}
+	st.close()
+}
@@ -0,0 +1,517 @@
+// build.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+)
+
+// 命令行：把原始 GADM GeoPackage 预处理成运行时用的库，之后用 GPKG_PATH 加载。
+//
+//	gpkg-reverse build -in data/gadm_410.gpkg -out data/gadm_410.sqlite
+//
+// 生成的库中：
+//   - <table>：叶子行，属性列不变，几何为去掉 GeoPackage 头的 WKB，带 r-tree 和各层 GID 索引
+//   - <table>_level0..5：每层一行一个行政区，含名称、上级、外接矩形、质心、不可达极点、球面面积、简化后的几何和按层反查用的合并几何
+//   - <table>_geom_z6 / _z10：按缩放级别预简化的叶子几何，见 resolutions.go
+//   - <table>_geom_coarse：反查时先行判断用的简化叶子几何，见 coarse.go
+//   - <table>_gids：GID → 层级
+//   - <table>_cells：给出 -cells 时，叶子内部的 S2 格子 → 叶子，见 cells.go
+//   - <table>_build：来源、简化容差等构建信息
+//   - <table>_crosswalk：给出 -prev 时，旧版本 GID → 新 GID 的对照表，见 crosswalk.go
+//
+// 打开时发现 <table>_gids 即按预处理的库使用，层级判断、/latlng、/bbox、名称索引和简化边界直接查表，
+// 不再扫描叶子行、解码几何
+
+func runBuild(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	in := fs.String("in", env("GPKG_PATH", "data/gadm_410.gpkg"), "raw GADM GeoPackage path")
+	out := fs.String("out", "data/gadm_410.sqlite", "output database path")
+	table := fs.String("table", env("GPKG_TABLE", "gadm_410"), "table name, kept in the output")
+	geomCol := fs.String("geom", env("GPKG_GEOM_COL", "geom"), "geometry column")
+	tolerance := fs.Float64("tolerance", defaultInlineTolerance, "simplification tolerance in degrees for the per-level geometries")
+	prevPath := fs.String("prev", "", "previous GADM version, to build the GID crosswalk for /migrate")
+	prevTable := fs.String("prev-table", env("GPKG_PREV_TABLE", "gadm"), "previous version table name")
+	prevGeom := fs.String("prev-geom", env("GPKG_PREV_GEOM_COL", env("GPKG_GEOM_COL", "geom")), "previous version geometry column")
+	samples := fs.Int("samples", defaultCrosswalkSamples, "crosswalk sample points per previous leaf")
+	cellLevel := fs.Int("cells", 0, "finest S2 cell level of the interior cell index for reverse lookups, 0 for none")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *samples < 1 {
+		return fmt.Errorf("invalid -samples, use at least 1")
+	}
+	if *cellLevel < 0 || *cellLevel > maxCellLevel {
+		return fmt.Errorf("invalid -cells, use a level in [0, %d]", maxCellLevel)
+	}
+	if *tolerance < 0 || *tolerance > 1 {
+		return fmt.Errorf("invalid tolerance, use degrees in [0, 1]")
+	}
+	if abs, err := filepath.Abs(*in); err == nil {
+		if absOut, err := filepath.Abs(*out); err == nil && abs == absOut {
+			return fmt.Errorf("-out must differ from -in")
+		}
+	}
+	var prev *dataset
+	if *prevPath != "" {
+		var err error
+		if prev, err = openDataset(*prevPath, *prevTable, *prevGeom); err != nil {
+			return err
+		}
+		defer prev.db.Close()
+	}
+	start := time.Now()
+	if err := buildRuntime(*in, *out, *table, *geomCol, *tolerance, prev, *samples, *cellLevel); err != nil {
+		return err
+	}
+	log.Printf("build: %s -> %s in %s", *in, *out, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// 预处理的库中各表的名称
+func levelTableName(table string, level int) string {
+	return fmt.Sprintf("%s_level%d", table, level)
+}
+
+func gidsTableName(table string) string {
+	return table + "_gids"
+}
+
+// 一个行政区的预计算结果
+type builtArea struct {
+	gid, name, parent string
+	leaves            int
+	bound             orb.Bound
+	centroid, pole    orb.Point
+	// 球面面积（平方米），各叶子面积之和
+	area       float64
+	simplified []byte
+	// 按 coarseTolerance 简化的合并几何，用于按层反查（见 levelreverse.go）；合并失败时为 nil
+	coarse []byte
+}
+
+// 先写临时文件再改名，失败时不留下半个库；prev 不为空时另建 GID 对照表，cellLevel 大于 0 时另建格子索引
+func buildRuntime(in, out, table, geomCol string, tolerance float64, prev *dataset, samples, cellLevel int) (err error) {
+	if _, err := os.Stat(in); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	src, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&immutable=1", in))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defs, cols, pk, err := sourceColumns(src, "main", table, geomCol)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+
+	tmp := out + ".tmp"
+	os.Remove(tmp)
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	db, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (fid INTEGER PRIMARY KEY, %s, "%s" BLOB);`,
+		table, strings.Join(defs, ", "), geomCol)); err != nil {
+		return err
+	}
+	n, err := copyLeaves(src, db, table, geomCol, pk, cols)
+	if err != nil {
+		return err
+	}
+	log.Printf("build: %d leaves copied", n)
+	if err := buildResolutions(src, db, table, geomCol, pk); err != nil {
+		return err
+	}
+	if err := buildCoarse(src, db, table, geomCol, pk); err != nil {
+		return err
+	}
+	src.Close()
+
+	if err := buildRTree(db, table, geomCol); err != nil {
+		return err
+	}
+	if cellLevel > 0 {
+		if err := buildCells(db, table, geomCol, cellLevel); err != nil {
+			return fmt.Errorf("cells: %w", err)
+		}
+	}
+	for lvl := 0; lvl <= 5; lvl++ {
+		stmt := fmt.Sprintf(`CREATE INDEX "idx_%[1]s_gid%[2]d" ON "%[1]s" (GID_%[2]d, GID_%[3]d, NAME_%[3]d);`, table, lvl, lvl+1)
+		if lvl == 5 {
+			stmt = fmt.Sprintf(`CREATE INDEX "idx_%[1]s_gid5" ON "%[1]s" (GID_5);`, table)
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	gids := gidsTableName(table)
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (gid TEXT PRIMARY KEY, level INTEGER NOT NULL) WITHOUT ROWID;`, gids)); err != nil {
+		return err
+	}
+	for lvl := 0; lvl <= 5; lvl++ {
+		areas, err := buildLevelAreas(db, table, geomCol, lvl, tolerance)
+		if err != nil {
+			return fmt.Errorf("level %d: %w", lvl, err)
+		}
+		if err := writeLevelTable(db, table, lvl, areas); err != nil {
+			return fmt.Errorf("level %d: %w", lvl, err)
+		}
+		log.Printf("build: level %d, %d areas", lvl, len(areas))
+	}
+
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE "%s_build" (key TEXT PRIMARY KEY, value TEXT NOT NULL);`, table),
+		fmt.Sprintf(`INSERT INTO "%s_build" VALUES ('source', %s), ('tolerance', '%s'), ('resolutions', '%s'), ('coarse', '%s'), ('built_at', '%s');`,
+			table, sqlQuote(filepath.Base(in)), strconv.FormatFloat(tolerance, 'f', -1, 64), resolutionsMeta(),
+			strconv.FormatFloat(coarseTolerance, 'f', -1, 64), time.Now().UTC().Format(time.RFC3339)),
+	}
+	if cellLevel > 0 {
+		stmts = append(stmts, fmt.Sprintf(`INSERT INTO "%s_build" VALUES ('cells', '%d');`, table, cellLevel))
+	}
+	if prev != nil {
+		entries, err := computeCrosswalk(context.Background(), prev, &dataset{db: db, table: table, geomCol: geomCol, rtree: fmt.Sprintf("rtree_%s_%s", table, geomCol)}, "", samples)
+		if err != nil {
+			return fmt.Errorf("crosswalk: %w", err)
+		}
+		if err := writeCrosswalkTable(db, table, entries); err != nil {
+			return fmt.Errorf("crosswalk: %w", err)
+		}
+		stmts = append(stmts, fmt.Sprintf(`INSERT INTO "%s_build" VALUES ('previous', %s);`, table, sqlQuote(filepath.Base(prev.path))))
+	}
+	stmts = append(stmts, `ANALYZE;`)
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	db.Close()
+	return os.Rename(tmp, out)
+}
+
+func sqlQuote(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// 逐行复制叶子，几何去掉 GeoPackage 头；两个库各用一个连接，边读边写
+func copyLeaves(src, db *sql.DB, table, geomCol, pk string, cols []string) (int, error) {
+	rows, err := src.Query(fmt.Sprintf(`SELECT "%s", %s, "%s" FROM "%s";`, pk, strings.Join(cols, ", "), geomCol, table))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	ins, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" (fid, %s, "%s") VALUES (?%s);`,
+		table, strings.Join(cols, ", "), geomCol, strings.Repeat(", ?", len(cols)+1)))
+	if err != nil {
+		return 0, err
+	}
+	defer ins.Close()
+
+	n := 0
+	vals := make([]any, len(cols)+2)
+	dest := make([]any, len(vals))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+		if blob, ok := vals[len(vals)-1].([]byte); ok {
+			b, _, err := gpkgToWKB(blob)
+			if err != nil {
+				return 0, fmt.Errorf("fid %v: %w", vals[0], err)
+			}
+			vals[len(vals)-1] = b
+		}
+		if _, err := ins.Exec(vals...); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// 该层的行政区及其外接矩形、质心、不可达极点、面积和简化几何。
+// 有多个叶子时先合并（见 dissolve）再简化，合并失败时退回拼接的叶子
+func buildLevelAreas(db *sql.DB, table, geomCol string, level int, tolerance float64) ([]builtArea, error) {
+	var areas []builtArea
+	err := eachLevelArea(db, table, geomCol, level, func(a *builtArea, geom orb.MultiPolygon) error {
+		// 叶子互不重叠，合并前直接求和，与 /area 相同
+		a.area = geo.Area(geom)
+		// 合并时修复过不合法的叶子、或合并失败时，没有按层反查用的几何
+		exact := true
+		if a.leaves > 1 && len(geom) > 1 {
+			d, repaired, err := dissolve(geom)
+			if err != nil {
+				log.Printf("build: level %d, dissolve %s: %v, leaves kept separate", level, a.gid, err)
+			} else {
+				geom = d
+			}
+			exact = err == nil && !repaired
+		}
+		if len(geom) > 0 && exact && level < maxLevelLookup {
+			coarse, _ := coarseSimplify(geom, coarseTolerance)
+			b, err := wkb.Marshal(coarse, binary.LittleEndian)
+			if err != nil {
+				return fmt.Errorf("%s: %w", a.gid, err)
+			}
+			a.coarse = b
+		}
+		if len(geom) > 0 {
+			a.bound = geom.Bound()
+			a.centroid, _ = planar.CentroidArea(geom)
+			a.pole = poleOfInaccessibility(geom)
+			simplified, _ := validatedSimplify(geom, a.pole, tolerance)
+			b, err := wkb.Marshal(simplified, binary.LittleEndian)
+			if err != nil {
+				return fmt.Errorf("%s: %w", a.gid, err)
+			}
+			a.simplified = b
+		}
+		areas = append(areas, *a)
+		return nil
+	})
+	return areas, err
+}
+
+// 按 GID 顺序读取该层的叶子，相同 GID 的多边形拼接后交给 fn（gid、name、parent、leaves 已填好）；
+// 几何无法解码的叶子跳过，全部无法解码时 geom 为空
+func eachLevelArea(db *sql.DB, table, geomCol string, level int, fn func(a *builtArea, geom orb.MultiPolygon) error) error {
+	parentCol := "''"
+	if level > 0 {
+		parentCol = fmt.Sprintf("IFNULL(GID_%d, '')", level-1)
+	}
+	rows, err := db.Query(fmt.Sprintf(`
+SELECT GID_%[1]d, IFNULL(NAME_%[1]d, ''), %[2]s, "%[3]s"
+FROM "%[4]s"
+WHERE GID_%[1]d IS NOT NULL AND GID_%[1]d <> ''
+ORDER BY GID_%[1]d;`, level, parentCol, geomCol, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		cur  *builtArea
+		geom orb.MultiPolygon
+	)
+	for rows.Next() {
+		var (
+			a    builtArea
+			blob []byte
+		)
+		if err := rows.Scan(&a.gid, &a.name, &a.parent, &blob); err != nil {
+			return err
+		}
+		if cur == nil || cur.gid != a.gid {
+			if cur != nil {
+				if err := fn(cur, geom); err != nil {
+					return err
+				}
+			}
+			cur, geom = &a, nil
+		}
+		cur.leaves++
+		if wkbBytes, _, err := gpkgToWKB(blob); err == nil {
+			if mp, err := decodeMultiPolygon(wkbBytes); err == nil {
+				geom = append(geom, mp...)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if cur != nil {
+		return fn(cur, geom)
+	}
+	return nil
+}
+
+// 同一 GID 出现在多层时（不应发生）以较高的一层为准，同 detectLevel
+func writeLevelTable(db *sql.DB, table string, level int, areas []builtArea) error {
+	name := levelTableName(table, level)
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE "%s" (
+  gid TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  parent TEXT NOT NULL,
+  leaves INTEGER NOT NULL,
+  minx REAL, miny REAL, maxx REAL, maxy REAL,
+  lon REAL, lat REAL,
+  pole_lon REAL, pole_lat REAL,
+  area REAL,
+  geom BLOB,
+  coarse BLOB
+);`, name),
+		fmt.Sprintf(`CREATE INDEX "idx_%[1]s_parent" ON "%[1]s" (parent);`, name),
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ins, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`, name))
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+	insGID, err := tx.Prepare(fmt.Sprintf(`INSERT OR IGNORE INTO "%s" VALUES (?, ?);`, gidsTableName(table)))
+	if err != nil {
+		return err
+	}
+	defer insGID.Close()
+	for _, a := range areas {
+		var (
+			bound, centroid []any
+			area            any
+		)
+		if a.simplified != nil {
+			bound = []any{a.bound.Min[0], a.bound.Min[1], a.bound.Max[0], a.bound.Max[1]}
+			centroid = []any{a.centroid.Lon(), a.centroid.Lat(), a.pole.Lon(), a.pole.Lat()}
+			area = a.area
+		} else {
+			bound, centroid = []any{nil, nil, nil, nil}, []any{nil, nil, nil, nil}
+		}
+		args := append([]any{a.gid, a.name, a.parent, a.leaves}, bound...)
+		args = append(append(args, centroid...), area, a.simplified, a.coarse)
+		if _, err := ins.Exec(args...); err != nil {
+			return err
+		}
+		if _, err := insGID.Exec(a.gid, level); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+/************* 运行时查表 *************/
+
+func (s *Server) builtLatlng(ctx context.Context, GID string, level int) (*LatlngItem, error) {
+	var (
+		item                       = LatlngItem{Level: levelNameMap()[level]}
+		lon, lat, poleLon, poleLat sql.NullFloat64
+	)
+	err := s.stmts.queryRow(ctx, fmt.Sprintf(`SELECT gid, name, parent, lon, lat, pole_lon, pole_lat FROM "%s" WHERE gid = ?;`, levelTableName(s.table, level)), GID).
+		Scan(&item.GID, &item.Name, &item.ParentCode, &lon, &lat, &poleLon, &poleLat)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("gid not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !lon.Valid {
+		return nil, fmt.Errorf("failed to decode multipolygon: no geometry for %s", GID)
+	}
+	item.Longitude, item.Latitude = lon.Float64, lat.Float64
+	item.Pole = &LabelPoint{Latitude: poleLat.Float64, Longitude: poleLon.Float64}
+	return &item, nil
+}
+
+func (s *Server) builtBBox(ctx context.Context, GID string, level int) (*BBoxResult, error) {
+	var minx, miny, maxx, maxy sql.NullFloat64
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT minx, miny, maxx, maxy FROM "%s" WHERE gid = ?;`, levelTableName(s.table, level)), GID).
+		Scan(&minx, &miny, &maxx, &maxy)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if !minx.Valid {
+		return nil, fmt.Errorf("gid not found")
+	}
+	return &BBoxResult{GID: GID, MinLon: minx.Float64, MinLat: miny.Float64, MaxLon: maxx.Float64, MaxLat: maxy.Float64}, nil
+}
+
+// 该层表中的面积（平方米）；旧版本构建的库没有 area 列，由调用方现算
+func (s *Server) builtAreaM2(ctx context.Context, GID string, level int) (float64, bool, error) {
+	if !s.builtAreas {
+		return 0, false, nil
+	}
+	var area sql.NullFloat64
+	err := s.stmts.queryRow(ctx, fmt.Sprintf(`SELECT area FROM "%s" WHERE gid = ?;`, levelTableName(s.table, level)), GID).Scan(&area)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, fmt.Errorf("gid not found")
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return area.Float64, area.Valid, nil
+}
+
+// 每层表中预先简化的几何能否满足该容差
+func (s *Server) levelGeomCovers(tolerance float64) bool {
+	return s.built && tolerance > 0 && tolerance >= s.builtTolerance
+}
+
+// 按 tolerance 简化的行政区几何，只用于输出；预处理的库中容差不小于构建容差时从该层已简化的几何再简化，
+// 否则拼接分辨率表中的叶子（见 resolutions.go）
+func (s *Server) simplifiedShapeOf(ctx context.Context, GID string, tolerance float64) (*AreaShape, error) {
+	if !s.levelGeomCovers(tolerance) {
+		return s.shapeAt(ctx, GID, tolerance)
+	}
+	GID = strings.TrimSpace(GID)
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		shape = &AreaShape{Level: level, Item: ChildrenItem{Level: levelNameMap()[level]}}
+		blob  []byte
+	)
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT gid, name, parent, geom FROM "%s" WHERE gid = ?;`, levelTableName(s.table, level)), GID).
+		Scan(&shape.Item.GID, &shape.Item.Name, &shape.Item.ParentCode, &blob)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("gid not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if blob != nil {
+		if shape.Geom, err = decodeMultiPolygon(blob); err != nil {
+			return nil, err
+		}
+	}
+	if tolerance > s.builtTolerance {
+		shape.Geom = resimplify(shape.Geom, tolerance)
+	}
+	return shape, nil
+}
@@ -0,0 +1,261 @@
+// cells.go
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/golang/geo/s2"
+	"github.com/paulmach/orb"
+)
+
+// 格子预索引：build -cells N 时用 S2 格子覆盖每个叶子，只记下整个落在叶子内部的格子（最细到第 N 级），
+// 存为 <table>_cells（格子的叶级 id 范围 → 叶子 fid）。反查时先按点所在的格子二分查找，
+// 落在内部格子里的点直接取该叶子，不再筛选候选、逐个判断点面；边界附近的点照常判断。
+// 格子按平面经纬度判断，与点面判断一致：格子的经纬度外接矩形离叶子的边界有距离、在叶子内，
+// 且不与其他任何叶子相交（重叠的争议地区等）时才记下，所以结果与逐个判断相同。
+// 第 10 级格子边长约 10 km，第 12 级约 2.5 km，第 14 级约 600 m；级别越高边界附近漏掉的越少，表和内存也越大。
+// 打开预处理的库时发现该表即整个载入内存（每个格子 24 字节），CELL_INDEX=0 时不用
+const maxCellLevel = 20
+
+func cellsTableName(table string) string {
+	return table + "_cells"
+}
+
+// 一个内部格子：叶级 id 的范围 [min, max]
+type cellRange struct {
+	min, max uint64
+	fid      int64
+}
+
+// 按 min 排序，互不重叠
+type cellIndex []cellRange
+
+func loadCellIndex(db *sql.DB, table string) (cellIndex, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT cell_min, cell_max, fid FROM "%s";`, cellsTableName(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ci cellIndex
+	for rows.Next() {
+		var lo, hi, fid int64
+		if err := rows.Scan(&lo, &hi, &fid); err != nil {
+			return nil, err
+		}
+		// SQLite 的整数有符号，按位存取
+		ci = append(ci, cellRange{min: uint64(lo), max: uint64(hi), fid: fid})
+	}
+	slices.SortFunc(ci, func(a, b cellRange) int { return cmp.Compare(a.min, b.min) })
+	return ci, rows.Err()
+}
+
+// 点所在的内部格子属于哪个叶子
+func (ci cellIndex) lookup(pt orb.Point) (int64, bool) {
+	if len(ci) == 0 {
+		return 0, false
+	}
+	id := uint64(s2.CellIDFromLatLng(s2.LatLngFromDegrees(pt[1], pt[0])))
+	i := sort.Search(len(ci), func(i int) bool { return ci[i].min > id }) - 1
+	if i < 0 || id > ci[i].max {
+		return 0, false
+	}
+	return ci[i].fid, true
+}
+
+// 点落在内部格子里时取该叶子（几何已解码，同 firstContaining 的结果），否则返回 nil
+func (s *Server) cellCandidate(ctx context.Context, mode nameMode, pt orb.Point) (*candidate, error) {
+	if len(s.cells) == 0 {
+		return nil, nil
+	}
+	fid, ok := s.cells.lookup(pt)
+	cellIndexStats.record(ok)
+	if !ok {
+		return nil, nil
+	}
+	rows, err := s.stmts.query(ctx, s.sqlCandidateByID[mode], fmt.Sprintf("[%d]", fid))
+	if err != nil {
+		return nil, err
+	}
+	var hit *candidate
+	if _, _, err := scanCandidates(rows, func(c *candidate) bool {
+		hit = c
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	if hit == nil || !s.decodeCandidate(hit) {
+		return nil, nil
+	}
+	return hit, nil
+}
+
+/************* build -cells *************/
+
+type cellState int
+
+const (
+	cellOutside cellState = iota
+	cellBorder
+	cellInside
+)
+
+// 格子的经纬度外接矩形与叶子的关系：矩形的外接圆与边界不相交时按中心点判断内外，否则为边界格子
+func classifyCell(idx *shapeIndex, b orb.Bound) cellState {
+	c := b.Center()
+	// 外接圆半径略放宽，抵消浮点误差
+	r := math.Hypot(b.Max[0]-b.Min[0], b.Max[1]-b.Min[1])/2*1.001 + 1e-12
+	switch {
+	case idx.near(c, r):
+		return cellBorder
+	case idx.contains(c):
+		return cellInside
+	default:
+		return cellOutside
+	}
+}
+
+func buildCells(db *sql.DB, table, geomCol string, level int) error {
+	name := cellsTableName(table)
+	boxes, err := loadMemIndex(db, fmt.Sprintf("rtree_%s_%s", table, geomCol))
+	if err != nil {
+		return err
+	}
+	var fids []int64
+	rows, err := db.Query(fmt.Sprintf(`SELECT fid FROM "%s" ORDER BY fid;`, table))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var fid int64
+		if err := rows.Scan(&fid); err != nil {
+			rows.Close()
+			return err
+		}
+		fids = append(fids, fid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// 相邻的叶子反复用到，解码结果缓存；无法解码的几何为 nil，反查时也不会命中
+	shapes := newGeomCache(512, nil)
+	shape := func(fid int64) (*shapeIndex, error) {
+		if _, idx, ok := shapes.get(fid); ok {
+			return idx, nil
+		}
+		var blob []byte
+		if err := db.QueryRow(fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE fid = ?;`, geomCol, table), fid).Scan(&blob); err != nil {
+			return nil, err
+		}
+		wkbBytes, _, err := gpkgToWKB(blob)
+		if err != nil {
+			return nil, nil
+		}
+		mp, err := decodeMultiPolygon(wkbBytes)
+		if err != nil || len(mp) == 0 {
+			return nil, nil
+		}
+		idx := newShapeIndex(mp)
+		shapes.put(fid, mp, idx)
+		return idx, nil
+	}
+
+	var cells []cellRange
+	for _, fid := range fids {
+		leaf, err := shape(fid)
+		if err != nil {
+			return err
+		}
+		if leaf == nil {
+			continue
+		}
+		b := leaf.bound
+		var others []*shapeIndex
+		for _, id := range boxes.search(b.Min[0], b.Min[1], b.Max[0], b.Max[1]) {
+			if id == fid {
+				continue
+			}
+			other, err := shape(id)
+			if err != nil {
+				return err
+			}
+			if other != nil {
+				others = append(others, other)
+			}
+		}
+		overlaps := func(cb orb.Bound) bool {
+			for _, o := range others {
+				if o.bound.Intersects(cb) && classifyCell(o, cb) != cellOutside {
+					return true
+				}
+			}
+			return false
+		}
+
+		var visit func(id s2.CellID)
+		visit = func(id s2.CellID) {
+			rb := s2.CellFromCellID(id).RectBound()
+			// 跨 180° 经线的格子不能用一个经纬度矩形表示，只往下细分
+			if !rb.Lng.IsInverted() {
+				cb := orb.Bound{
+					Min: orb.Point{rb.Lo().Lng.Degrees(), rb.Lo().Lat.Degrees()},
+					Max: orb.Point{rb.Hi().Lng.Degrees(), rb.Hi().Lat.Degrees()},
+				}
+				if !cb.Intersects(b) {
+					return
+				}
+				switch classifyCell(leaf, cb) {
+				case cellOutside:
+					return
+				case cellInside:
+					if !overlaps(cb) {
+						cells = append(cells, cellRange{min: uint64(id.RangeMin()), max: uint64(id.RangeMax()), fid: fid})
+						return
+					}
+				}
+			}
+			if id.Level() < level {
+				for _, child := range id.Children() {
+					visit(child)
+				}
+			}
+		}
+		rc := &s2.RegionCoverer{MaxLevel: level, MaxCells: 8}
+		rect := s2.RectFromLatLng(s2.LatLngFromDegrees(b.Min[1], b.Min[0])).AddPoint(s2.LatLngFromDegrees(b.Max[1], b.Max[0]))
+		for _, id := range rc.Covering(rect) {
+			visit(id)
+		}
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (cell_min INTEGER PRIMARY KEY, cell_max INTEGER NOT NULL, fid INTEGER NOT NULL);`, name)); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ins, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" VALUES (?, ?, ?);`, name))
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+	for _, c := range cells {
+		if _, err := ins.Exec(int64(c.min), int64(c.max), c.fid); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("build: %s (level %d), %d cells for %d leaves", name, level, len(cells), len(fids))
+	return nil
+}
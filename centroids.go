@@ -0,0 +1,317 @@
+// centroids.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+)
+
+// 各行政区的质心和不可达极点。/latlng 缓存未命中时要解码并拼接整个区域的多边形，大的省份每次几百毫秒；
+// 启动时在后台算好一次，存到数据文件旁的缓存库（CENTROIDS_PATH），之后直接查表；/bbox 的外接矩形（取自 r-tree）
+// 和 /intersect 用的球面面积一并存下。
+// 数据文件变化（版本同 reload.go）时重新计算；build 命令预处理的库中已含这些列，不需要缓存库。
+// warm 命令离线生成的缓存库另记数据文件的校验和，随数据文件分发后路径和修改时间变了，内容相同时照样使用
+
+// 区域内的一个点
+type LabelPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (p *LabelPoint) appendProto(b []byte) []byte {
+	b = appendProtoDouble(b, 1, p.Latitude)
+	return appendProtoDouble(b, 2, p.Longitude)
+}
+
+func labelPoint(p orb.Point) *LabelPoint {
+	return &LabelPoint{Latitude: p.Lat(), Longitude: p.Lon()}
+}
+
+type centroidStore struct {
+	db                 *sql.DB
+	lookup, bbox, area *sql.Stmt
+}
+
+// 后台加载，完成前 /latlng 按原方式现算；预热时已加载过的不再加载（见 warmup.go）
+func (s *Server) loadCentroids() {
+	if s.built || s.centroidsPath == "" || s.centroids.Load() != nil {
+		return
+	}
+	start := time.Now()
+	st, err := openCentroids(s.path, s.centroidsPath, s.table, s.geomCol)
+	if err != nil {
+		log.Println("centroids error:", err)
+		return
+	}
+	s.centroids.Store(st)
+	log.Printf("centroids ready: %s in %s", s.centroidsPath, time.Since(start).Round(time.Millisecond))
+}
+
+func openCentroids(src, path, table, geomCol string) (*centroidStore, error) {
+	version, err := datasetVersion([]string{src})
+	if err != nil {
+		return nil, err
+	}
+	open := func() (*centroidStore, error) {
+		if !centroidsCurrent(src, path, version) {
+			return nil, nil
+		}
+		db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&immutable=1", path))
+		if err != nil {
+			return nil, err
+		}
+		lookup, err := db.Prepare(`SELECT lon, lat, pole_lon, pole_lat FROM centroids WHERE gid = ?;`)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		bbox, err := db.Prepare(`SELECT minx, miny, maxx, maxy FROM bboxes WHERE gid = ?;`)
+		if err != nil {
+			// 旧版本的缓存库没有外接矩形，重新计算
+			db.Close()
+			return nil, nil
+		}
+		area, err := db.Prepare(`SELECT area FROM centroids WHERE gid = ?;`)
+		if err != nil {
+			// 旧版本的缓存库没有面积，重新计算
+			db.Close()
+			return nil, nil
+		}
+		return &centroidStore{db: db, lookup: lookup, bbox: bbox, area: area}, nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		if st, err := open(); err != nil || st != nil {
+			return st, err
+		}
+	}
+	log.Printf("centroids: computing %s into %s", src, path)
+	if err := buildCentroids(src, path, table, geomCol, version, ""); err != nil {
+		return nil, err
+	}
+	st, err := open()
+	if err == nil && st == nil {
+		err = fmt.Errorf("%s: version mismatch after build", path)
+	}
+	return st, err
+}
+
+// 缓存库是否对应当前的数据文件：先比较版本，不同时若记有校验和则按内容比较，
+// 相同时改记新的版本，之后启动不用再算校验和（缓存库只读时下次照样按内容比较）
+func centroidsCurrent(src, path, version string) bool {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return false
+	}
+	var cached, sum string
+	err = db.QueryRow(`SELECT value FROM meta WHERE key = 'version';`).Scan(&cached)
+	if err == nil && cached != version {
+		err = db.QueryRow(`SELECT value FROM meta WHERE key = 'sha256';`).Scan(&sum)
+	}
+	db.Close()
+	if err != nil {
+		return false
+	}
+	if cached == version {
+		return true
+	}
+	start := time.Now()
+	got, err := fileSHA256(src)
+	if err != nil || got != sum {
+		return false
+	}
+	log.Printf("centroids: %s matches the checksum of %s (%s), keeping it", path, src, time.Since(start).Round(time.Millisecond))
+	if db, err := sql.Open("sqlite3", path); err == nil {
+		if _, err := db.Exec(`UPDATE meta SET value = ? WHERE key = 'version';`, version); err != nil {
+			log.Println("centroids: failed to record version:", err)
+		}
+		db.Close()
+	}
+	return true
+}
+
+// 先写临时文件再改名；读数据文件另开一个连接，不占用请求用的连接。sum 不为空时记下数据文件的校验和（见 warm.go）
+func buildCentroids(src, out, table, geomCol, version, sum string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	srcDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&immutable=1", src))
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	tmp := out + ".tmp"
+	os.Remove(tmp)
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	db, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	stmts := []string{
+		`CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);`,
+		`CREATE TABLE centroids (gid TEXT PRIMARY KEY, level INTEGER NOT NULL, lon REAL NOT NULL, lat REAL NOT NULL, pole_lon REAL NOT NULL, pole_lat REAL NOT NULL, area REAL NOT NULL) WITHOUT ROWID;`,
+		`CREATE TABLE bboxes (gid TEXT PRIMARY KEY, minx REAL NOT NULL, miny REAL NOT NULL, maxx REAL NOT NULL, maxy REAL NOT NULL) WITHOUT ROWID;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ins, err := tx.Prepare(`INSERT OR IGNORE INTO centroids VALUES (?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+	for lvl := 0; lvl <= 5; lvl++ {
+		err := eachLevelArea(srcDB, table, geomCol, lvl, func(a *builtArea, geom orb.MultiPolygon) error {
+			if len(geom) == 0 {
+				return nil
+			}
+			c, _ := planar.CentroidArea(geom)
+			p := poleOfInaccessibility(geom)
+			_, err := ins.Exec(a.gid, lvl, c.Lon(), c.Lat(), p.Lon(), p.Lat(), geo.Area(geom))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("level %d: %w", lvl, err)
+		}
+	}
+	// 与 bboxOf 相同，取自 r-tree，有无缓存库时结果一致
+	insBBox, err := tx.Prepare(`INSERT OR IGNORE INTO bboxes VALUES (?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer insBBox.Close()
+	for lvl := 0; lvl <= 5; lvl++ {
+		if err := copyBBoxes(srcDB, insBBox, table, geomCol, lvl); err != nil {
+			return fmt.Errorf("level %d: %w", lvl, err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO meta VALUES ('version', ?), ('table', ?);`, version, table); err != nil {
+		return err
+	}
+	if sum != "" {
+		if _, err := tx.Exec(`INSERT INTO meta VALUES ('sha256', ?);`, sum); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.Close()
+	return os.Rename(tmp, out)
+}
+
+func copyBBoxes(src *sql.DB, ins *sql.Stmt, table, geomCol string, level int) error {
+	rows, err := src.Query(fmt.Sprintf(`
+SELECT a.GID_%[1]d, MIN(r.minx), MIN(r.miny), MAX(r.maxx), MAX(r.maxy)
+FROM "%[2]s" AS a
+JOIN "rtree_%[2]s_%[3]s" AS r ON a.rowid = r.id
+WHERE a.GID_%[1]d IS NOT NULL AND a.GID_%[1]d <> ''
+GROUP BY a.GID_%[1]d;`, level, table, geomCol))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			gid                    string
+			minx, miny, maxx, maxy float64
+		)
+		if err := rows.Scan(&gid, &minx, &miny, &maxx, &maxy); err != nil {
+			return err
+		}
+		if _, err := ins.Exec(gid, minx, miny, maxx, maxy); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// 质心和不可达极点：优先查缓存库，没有时解码该区域的所有叶子现算
+func (s *Server) centroidOf(ctx context.Context, GID string) (centroid, pole orb.Point, err error) {
+	if st := s.centroids.Load(); st != nil {
+		err := st.lookup.QueryRowContext(ctx, GID).
+			Scan(&centroid[0], &centroid[1], &pole[0], &pole[1])
+		if err == nil {
+			return centroid, pole, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return centroid, pole, err
+		}
+	}
+	shape, err := s.shapeOf(ctx, GID)
+	if err != nil {
+		return centroid, pole, err
+	}
+	if len(shape.Geom) == 0 {
+		return centroid, pole, fmt.Errorf("failed to decode multipolygon: no geometry for %s", GID)
+	}
+	centroid, _ = planar.CentroidArea(shape.Geom)
+	return centroid, poleOfInaccessibility(shape.Geom), nil
+}
+
+// 行政区的球面面积（平方米）：预处理的库和缓存库中有时直接查表，没有时解码该区域的所有叶子现算
+func (s *Server) areaM2(ctx context.Context, GID string) (float64, error) {
+	if s.built {
+		level, err := s.detectLevel(ctx, GID)
+		if err != nil {
+			return 0, err
+		}
+		if area, ok, err := s.builtAreaM2(ctx, GID, level); ok || err != nil {
+			return area, err
+		}
+	} else if st := s.centroids.Load(); st != nil {
+		var area float64
+		err := st.area.QueryRowContext(ctx, GID).Scan(&area)
+		if err == nil {
+			return area, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+	shape, err := s.shapeOf(ctx, GID)
+	if err != nil {
+		return 0, err
+	}
+	return geo.Area(shape.Geom), nil
+}
+
+// 缓存库中的外接矩形，没有缓存库或不在其中时返回 nil
+func (s *Server) cachedBBox(ctx context.Context, GID string) (*BBoxResult, error) {
+	st := s.centroids.Load()
+	if st == nil {
+		return nil, nil
+	}
+	res := &BBoxResult{GID: GID}
+	err := st.bbox.QueryRowContext(ctx, GID).Scan(&res.MinLon, &res.MinLat, &res.MaxLon, &res.MaxLat)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
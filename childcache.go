@@ -0,0 +1,81 @@
+// childcache.go
+package main
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+)
+
+// /children 结果缓存（LRU + TTL）：省、市等热门上级被反复查询，层级在两次热更新之间不变，
+// 同样的上级和参数（排序、过滤、分页、名称语言）直接返回上次的结果。
+// 每个数据集版本一个缓存，热更新（包括修正层的修改）后随新版本重建，不会返回旧数据；
+// TTL 只是兜底，防止长期不热更新时少用的条目一直占着。
+// 条数由 CHILDREN_CACHE_SIZE 设置（默认 10000，0 为不缓存），TTL 由 CHILDREN_CACHE_TTL 设置（默认 1h）。
+// 只缓存成功的结果；取出的是副本，调用方可以修改（如填外部属性）
+type childrenKey struct {
+	parent string
+	cq     ChildrenQuery
+}
+
+type childrenCache struct {
+	mu    sync.Mutex
+	max   int
+	ttl   time.Duration
+	order *list.List
+	items map[childrenKey]*list.Element
+	stats *cacheStats
+}
+
+type childrenEntry struct {
+	key     childrenKey
+	items   []ChildrenItem
+	total   int
+	expires time.Time
+}
+
+func newChildrenCache(max int, ttl time.Duration, stats *cacheStats) *childrenCache {
+	return &childrenCache{max: max, ttl: ttl, order: list.New(), items: make(map[childrenKey]*list.Element), stats: stats}
+}
+
+func (c *childrenCache) get(key childrenKey) ([]ChildrenItem, int, bool) {
+	if c.max <= 0 {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if ok && time.Now().After(e.Value.(*childrenEntry).expires) {
+		c.order.Remove(e)
+		delete(c.items, key)
+		ok = false
+	}
+	c.stats.record(ok)
+	if !ok {
+		return nil, 0, false
+	}
+	c.order.MoveToFront(e)
+	ent := e.Value.(*childrenEntry)
+	return slices.Clone(ent.items), ent.total, true
+}
+
+func (c *childrenCache) put(key childrenKey, items []ChildrenItem, total int) {
+	if c.max <= 0 {
+		return
+	}
+	ent := &childrenEntry{key: key, items: slices.Clone(items), total: total, expires: time.Now().Add(c.ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value = ent
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(ent)
+	for c.order.Len() > c.max {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*childrenEntry).key)
+	}
+}
@@ -0,0 +1,112 @@
+// coarse.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/simplify"
+)
+
+// 两段点面判断：build 时另存一份按 coarseTolerance 简化的叶子几何（<table>_geom_coarse，fid 与叶子表相同），
+// 反查时先用它判断，点离简化后的边界超过容差时直接采用简化几何的结果，不解码原始几何。
+// 道格拉斯-普克简化保证原始边界上的每一点到简化边界的距离不超过容差，反之亦然：
+// 原始边界可以在容差带内连续变形成简化边界，不会越过带外的点，所以带外的点两者结论相同。
+// 点落在容差带内、或该叶子没有简化几何时，照常用原始几何判断。
+// 简化后退化的环（小岛、小湖）保留原样，不能像输出用的简化那样丢掉，否则岛上的点会判到带外。
+// 命中的候选仍要解码原始几何（内联边界、批量反查复用要用），省下的是排在它前面、不包含该点的候选
+const coarseTolerance = 0.0001 // 度，约 11 米
+
+func coarseTableName(table string) string {
+	return table + "_geom_coarse"
+}
+
+// 逐环简化，退化的环保留原样
+func coarseSimplify(mp orb.MultiPolygon, tolerance float64) (orb.MultiPolygon, bool) {
+	dp := simplify.DouglasPeucker(tolerance)
+	kept := false
+	out := make(orb.MultiPolygon, len(mp))
+	for i, p := range mp {
+		out[i] = make(orb.Polygon, len(p))
+		for j, r := range p {
+			if sr := dp.Ring(r.Clone()); len(sr) >= 4 {
+				out[i][j] = sr
+			} else {
+				out[i][j], kept = r, true
+			}
+		}
+	}
+	return out, kept
+}
+
+func buildCoarse(src, db *sql.DB, table, geomCol, pk string) error {
+	name := coarseTableName(table)
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (fid INTEGER PRIMARY KEY, geom BLOB NOT NULL);`, name)); err != nil {
+		return err
+	}
+	n, kept, err := fillResolution(src, db, table, geomCol, pk, name, func(mp orb.MultiPolygon) (orb.MultiPolygon, bool) {
+		return coarseSimplify(mp, coarseTolerance)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	log.Printf("build: %s (tolerance %g), %d leaves, %d with rings kept unsimplified", name, coarseTolerance, n, kept)
+	return nil
+}
+
+// 预处理的库中简化几何的容差，没有时为 0（旧版本的库或未预处理的 GeoPackage）
+func builtCoarseTolerance(db *sql.DB, table string) float64 {
+	var tol float64
+	if err := db.QueryRow(fmt.Sprintf(`SELECT value FROM "%s_build" WHERE key = 'coarse';`, table)).Scan(&tol); err != nil {
+		return 0
+	}
+	if cols, err := tableColumns(db, coarseTableName(table)); err != nil || len(cols) == 0 {
+		return 0
+	}
+	return tol
+}
+
+// 候选行的查询附带简化几何：返回选取的列和 JOIN 子句
+func (s *Server) coarseColumns() (col, join string) {
+	if s.coarseTolerance == 0 {
+		return "NULL", ""
+	}
+	return "c.geom", fmt.Sprintf(`LEFT JOIN "%s" AS c ON c.fid = a.rowid`, coarseTableName(s.table))
+}
+
+// 候选是否包含该点，先用简化几何判断；包含时解码原始几何，供命中后使用。
+// 原始几何已在缓存中时直接用它
+func (s *Server) candidateContains(c *candidate, pt orb.Point) bool {
+	if geom, index, ok := s.geomCache.get(c.rowid); ok {
+		c.geom, c.index, c.blob = geom, index, nil
+		return c.contains(pt)
+	}
+	// 容差带略放宽，抵消浮点误差
+	if idx := s.coarseShape(c); idx != nil && !idx.near(pt, s.coarseTolerance*1.001) {
+		return idx.contains(pt) && s.decodeCandidate(c)
+	}
+	return s.decodeCandidate(c) && c.contains(pt)
+}
+
+// 解码候选的简化几何，与原始几何分开缓存；没有或无法解码时为 nil
+func (s *Server) coarseShape(c *candidate) *shapeIndex {
+	if c.coarse == nil {
+		return nil
+	}
+	if _, idx, ok := s.coarseCache.get(c.rowid); ok {
+		return idx
+	}
+	wkbBytes, _, err := gpkgToWKB(c.coarse)
+	if err != nil {
+		return nil
+	}
+	mp, err := decodeMultiPolygon(wkbBytes)
+	if err != nil {
+		return nil
+	}
+	idx := newShapeIndex(mp)
+	s.coarseCache.put(c.rowid, mp, idx)
+	return idx
+}
@@ -0,0 +1,215 @@
+// compat_google.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 仿谷歌 Geocoding API 的反查响应，替换谷歌时客户端的解析代码不用改
+
+type GoogleGeocodeResponse struct {
+	PlusCode     *GooglePlusCode `json:"plus_code,omitempty"`
+	Results      []GoogleResult  `json:"results"`
+	Status       string          `json:"status"`
+	ErrorMessage string          `json:"error_message,omitempty"`
+}
+
+type GooglePlusCode struct {
+	GlobalCode string `json:"global_code"`
+}
+
+type GoogleResult struct {
+	AddressComponents []GoogleAddressComponent `json:"address_components"`
+	FormattedAddress  string                   `json:"formatted_address"`
+	Geometry          GoogleGeometry           `json:"geometry"`
+	PlaceID           string                   `json:"place_id"`
+	Types             []string                 `json:"types"`
+}
+
+type GoogleAddressComponent struct {
+	LongName  string   `json:"long_name"`
+	ShortName string   `json:"short_name"`
+	Types     []string `json:"types"`
+}
+
+type GoogleLatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type GoogleBounds struct {
+	Northeast GoogleLatLng `json:"northeast"`
+	Southwest GoogleLatLng `json:"southwest"`
+}
+
+type GoogleGeometry struct {
+	Bounds       GoogleBounds `json:"bounds"`
+	Location     GoogleLatLng `json:"location"`
+	LocationType string       `json:"location_type"`
+	Viewport     GoogleBounds `json:"viewport"`
+}
+
+// GADM 第 0 层为 country，第 N 层为 administrative_area_level_N
+func googleTypes(depth int) []string {
+	if depth == 0 {
+		return []string{"country", "political"}
+	}
+	return []string{fmt.Sprintf("administrative_area_level_%d", depth), "political"}
+}
+
+func writeGoogle(w http.ResponseWriter, status int, res GoogleGeocodeResponse) {
+	if res.Results == nil {
+		res.Results = []GoogleResult{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "   ")
+	_ = enc.Encode(res)
+}
+
+// 按谷歌的习惯从最具体的层级到国家各返回一条结果
+func (s *Server) googleResults(ctx context.Context, res *AdminLevels) ([]GoogleResult, error) {
+	if err := s.attachLevelDetails(ctx, res); err != nil {
+		return nil, err
+	}
+	components := make([]GoogleAddressComponent, len(res.Levels))
+	for depth, lv := range res.Levels {
+		short := lv.Name
+		if depth == 0 {
+			short = lv.GID
+			if tables, err := loadISOTables(); err == nil {
+				if c := tables.byAlpha3[lv.GID]; c != nil {
+					short = c.Alpha2
+				}
+			}
+		}
+		components[depth] = GoogleAddressComponent{LongName: lv.Name, ShortName: short, Types: googleTypes(depth)}
+	}
+
+	out := make([]GoogleResult, 0, len(res.Levels))
+	for depth := len(res.Levels) - 1; depth >= 0; depth-- {
+		lv := res.Levels[depth]
+		comps := make([]GoogleAddressComponent, 0, depth+1)
+		names := make([]string, 0, depth+1)
+		for i := depth; i >= 0; i-- {
+			comps = append(comps, components[i])
+			names = append(names, components[i].LongName)
+		}
+		bounds := GoogleBounds{
+			Northeast: GoogleLatLng{Lat: lv.BBox[3], Lng: lv.BBox[2]},
+			Southwest: GoogleLatLng{Lat: lv.BBox[1], Lng: lv.BBox[0]},
+		}
+		out = append(out, GoogleResult{
+			AddressComponents: comps,
+			FormattedAddress:  strings.Join(names, ", "),
+			Geometry: GoogleGeometry{
+				Bounds:       bounds,
+				Location:     GoogleLatLng{Lat: lv.Latitude, Lng: lv.Longitude},
+				LocationType: "APPROXIMATE",
+				Viewport:     bounds,
+			},
+			PlaceID: lv.GID,
+			Types:   googleTypes(depth),
+		})
+	}
+	return out, nil
+}
+
+// 按 result_type（| 分隔）过滤；location_type 只有 APPROXIMATE
+func filterGoogleResults(results []GoogleResult, resultType, locationType string) []GoogleResult {
+	if locationType != "" {
+		ok := false
+		for _, t := range strings.Split(locationType, "|") {
+			ok = ok || strings.EqualFold(strings.TrimSpace(t), "APPROXIMATE")
+		}
+		if !ok {
+			return nil
+		}
+	}
+	if resultType == "" {
+		return results
+	}
+	want := make(map[string]bool)
+	for _, t := range strings.Split(resultType, "|") {
+		want[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	out := results[:0]
+	for _, res := range results {
+		for _, t := range res.Types {
+			if want[t] {
+				out = append(out, res)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// 谷歌的 region（ccTLD，如 in）与已配置的争议地区视角同名时按该视角返回，否则用默认视角
+func (s *Server) googleView(region string) string {
+	if view := viewName(region); s.views.views[view] != nil {
+		return view
+	}
+	return s.views.def
+}
+
+// /compat/google/geocode/json?latlng=lat,lng[&language=][&region=][&result_type=][&location_type=]；key 忽略
+func (s *Server) handleGoogleGeocode(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ll := strings.TrimSpace(q.Get("latlng"))
+	if ll == "" {
+		writeGoogle(w, http.StatusBadRequest, GoogleGeocodeResponse{Status: "INVALID_REQUEST", ErrorMessage: "Only reverse geocoding is supported, latlng is required."})
+		return
+	}
+	parts := strings.Split(ll, ",")
+	var lat, lon float64
+	var err1, err2 error
+	if len(parts) == 2 {
+		lat, err1 = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lon, err2 = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	}
+	if len(parts) != 2 || err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		writeGoogle(w, http.StatusBadRequest, GoogleGeocodeResponse{Status: "INVALID_REQUEST", ErrorMessage: "Invalid request. Invalid 'latlng' parameter."})
+		return
+	}
+
+	mode := nameModeOf(q.Get("language"))
+	res, err := s.reverse(r.Context(), lon, lat, mode)
+	if errors.Is(err, sql.ErrNoRows) {
+		res, err = s.nearest(r.Context(), lon, lat, s.nearestMaxM, mode)
+	}
+	if err == nil {
+		s.views.apply(res, s.googleView(q.Get("region")), mode)
+	}
+	plus := encodePlusCodePairs(lat, lon)
+	resp := GoogleGeocodeResponse{PlusCode: &GooglePlusCode{GlobalCode: plus[:8] + "+" + plus[8:]}}
+	if err == nil {
+		resp.Results, err = s.googleResults(r.Context(), res)
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		resp.Status = "ZERO_RESULTS"
+		writeGoogle(w, http.StatusOK, resp)
+		return
+	case err != nil:
+		log.Println("google compat error:", err)
+		writeGoogle(w, http.StatusInternalServerError, GoogleGeocodeResponse{Status: "UNKNOWN_ERROR", ErrorMessage: "internal error"})
+		return
+	}
+	resp.Results = filterGoogleResults(resp.Results, q.Get("result_type"), q.Get("location_type"))
+	resp.Status = "OK"
+	if len(resp.Results) == 0 {
+		resp.Status = "ZERO_RESULTS"
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeGoogle(w, http.StatusOK, resp)
+}
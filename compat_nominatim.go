@@ -0,0 +1,337 @@
+// compat_nominatim.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/geojson"
+)
+
+// 仿 Nominatim /reverse 的响应，供只支持 Nominatim 的开源工具使用
+
+const nominatimLicence = "Data © GADM, https://gadm.org/license.html"
+
+// GADM 各层在 Nominatim address 中的键和 place_rank
+var nominatimLevels = []struct {
+	key  string
+	rank int
+}{
+	{"country", 4},
+	{"state", 8},
+	{"county", 12},
+	{"municipality", 14},
+	{"village", 19},
+	{"hamlet", 20},
+}
+
+type NominatimAddressPart struct {
+	Key, Value string
+}
+
+// 保持 Nominatim 的键顺序：从最细一级到国家
+type NominatimAddress []NominatimAddressPart
+
+func (a NominatimAddress) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range a {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(p.Key)
+		v, _ := json.Marshal(p.Value)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type NominatimPlace struct {
+	PlaceID     uint32           `json:"place_id"`
+	Licence     string           `json:"licence"`
+	Lat         string           `json:"lat"`
+	Lon         string           `json:"lon"`
+	Class       string           `json:"class,omitempty"`
+	Category    string           `json:"category,omitempty"`
+	Type        string           `json:"type"`
+	PlaceRank   int              `json:"place_rank"`
+	AddressType string           `json:"addresstype"`
+	Name        string           `json:"name"`
+	DisplayName string           `json:"display_name"`
+	Address     NominatimAddress `json:"address,omitempty"`
+	BoundingBox []string         `json:"boundingbox"`
+	GeoJSON     *OutputGeometry  `json:"geojson,omitempty"`
+	GeoText     string           `json:"geotext,omitempty"`
+
+	// 内部使用
+	code string
+	geom *OutputGeometry
+}
+
+// Nominatim 的 place_id 是整数，这里由 GID 散列得到，同一数据集内稳定
+func nominatimPlaceID(GID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(GID))
+	return h.Sum32()
+}
+
+// zoom 3 为国家、5 为省州、8 为县、10 为城市、12 以上为乡镇村
+func nominatimZoomLevel(zoom int) int {
+	switch {
+	case zoom <= 4:
+		return 0
+	case zoom <= 7:
+		return 1
+	case zoom <= 9:
+		return 2
+	case zoom <= 11:
+		return 3
+	case zoom <= 13:
+		return 4
+	}
+	return 5
+}
+
+func nominatimCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', 7, 64)
+}
+
+func (s *Server) nominatimPlace(ctx context.Context, res *AdminLevels, addressDetails bool) (*NominatimPlace, error) {
+	if err := s.attachLevelDetails(ctx, res); err != nil {
+		return nil, err
+	}
+	depth := len(res.Levels) - 1
+	lv := res.Levels[depth]
+	names := make([]string, 0, depth+1)
+	for i := depth; i >= 0; i-- {
+		names = append(names, res.Levels[i].Name)
+	}
+	p := &NominatimPlace{
+		PlaceID:     nominatimPlaceID(lv.GID),
+		Licence:     nominatimLicence,
+		Lat:         nominatimCoord(lv.Latitude),
+		Lon:         nominatimCoord(lv.Longitude),
+		Type:        "administrative",
+		PlaceRank:   nominatimLevels[depth].rank,
+		AddressType: nominatimLevels[depth].key,
+		Name:        lv.Name,
+		DisplayName: strings.Join(names, ", "),
+		BoundingBox: []string{
+			nominatimCoord(lv.BBox[1]), nominatimCoord(lv.BBox[3]),
+			nominatimCoord(lv.BBox[0]), nominatimCoord(lv.BBox[2]),
+		},
+		code: lv.GID,
+	}
+	if !addressDetails {
+		return p, nil
+	}
+	for i := depth; i >= 0; i-- {
+		p.Address = append(p.Address, NominatimAddressPart{nominatimLevels[i].key, res.Levels[i].Name})
+		if i == 1 {
+			iso, err := s.isoOf(ctx, res.Levels[1].GID)
+			if err != nil {
+				return nil, err
+			}
+			if iso.Subdivision != "" {
+				p.Address = append(p.Address, NominatimAddressPart{"ISO3166-2-lvl4", iso.Subdivision})
+			}
+		}
+	}
+	if tables, err := loadISOTables(); err == nil {
+		if c := tables.byAlpha3[res.Levels[0].GID]; c != nil {
+			p.Address = append(p.Address, NominatimAddressPart{"country_code", strings.ToLower(c.Alpha2)})
+		}
+	}
+	return p, nil
+}
+
+// 错误格式同 Nominatim：参数错误为 400，查不到为 200 + "Unable to geocode"
+func writeNominatimError(w http.ResponseWriter, format string, status int, msg string) {
+	if format == "xml" {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<reversegeocode><error>%s</error></reversegeocode>\n", xmlEscape(msg))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": status, "message": msg}})
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func writeNominatimXML(w http.ResponseWriter, r *http.Request, p *NominatimPlace) {
+	var buf bytes.Buffer
+	buf.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(&buf, "<reversegeocode timestamp=\"%s\" attribution=\"%s\" querystring=\"%s\">",
+		time.Now().UTC().Format(time.RFC1123Z), xmlEscape(p.Licence), xmlEscape(r.URL.RawQuery))
+	fmt.Fprintf(&buf, "<result place_id=\"%d\" lat=\"%s\" lon=\"%s\" boundingbox=\"%s\" place_rank=\"%d\" address_rank=\"%d\"",
+		p.PlaceID, p.Lat, p.Lon, strings.Join(p.BoundingBox, ","), p.PlaceRank, p.PlaceRank)
+	if p.geom != nil {
+		if p.geom.WKT {
+			fmt.Fprintf(&buf, " geotext=\"%s\"", xmlEscape(wkt.MarshalString(p.geom.Geom)))
+		} else {
+			b, _ := json.Marshal(p.geom)
+			fmt.Fprintf(&buf, " geojson=\"%s\"", xmlEscape(string(b)))
+		}
+	}
+	fmt.Fprintf(&buf, ">%s</result>", xmlEscape(p.DisplayName))
+	if len(p.Address) > 0 {
+		buf.WriteString("<addressparts>")
+		for _, part := range p.Address {
+			fmt.Fprintf(&buf, "<%s>%s</%s>", part.Key, xmlEscape(part.Value), part.Key)
+		}
+		buf.WriteString("</addressparts>")
+	}
+	buf.WriteString("</reversegeocode>\n")
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func writeNominatimGeoJSON(w http.ResponseWriter, p *NominatimPlace) {
+	var f *geojson.Feature
+	if p.geom != nil && !p.geom.WKT {
+		f = geojson.NewFeature(p.geom.Geom)
+	} else {
+		plat, _ := strconv.ParseFloat(p.Lat, 64)
+		plon, _ := strconv.ParseFloat(p.Lon, 64)
+		f = geojson.NewFeature(orb.Point{plon, plat})
+	}
+	f.Properties = geojson.Properties{
+		"place_id":     p.PlaceID,
+		"place_rank":   p.PlaceRank,
+		"category":     "boundary",
+		"type":         p.Type,
+		"addresstype":  p.AddressType,
+		"name":         p.Name,
+		"display_name": p.DisplayName,
+	}
+	if len(p.Address) > 0 {
+		f.Properties["address"] = p.Address
+	}
+	bb := make([]float64, 4)
+	for i, j := range []int{2, 0, 3, 1} {
+		bb[i], _ = strconv.ParseFloat(p.BoundingBox[j], 64)
+	}
+	f.BBox = bb
+	fc := geojson.NewFeatureCollection().Append(f)
+	fc.ExtraMembers = geojson.Properties{"licence": p.Licence}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	_ = json.NewEncoder(w).Encode(fc)
+}
+
+// /compat/nominatim/reverse?lat=&lon=[&format=xml|json|jsonv2|geojson][&zoom=][&addressdetails=][&accept-language=]
+// [&polygon_geojson=1|&polygon_text=1][&polygon_threshold=]
+func (s *Server) handleNominatimReverse(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := strings.ToLower(strings.TrimSpace(q.Get("format")))
+	switch format {
+	case "":
+		format = "xml"
+	case "xml", "json", "jsonv2", "geojson":
+	default:
+		writeNominatimError(w, "json", http.StatusBadRequest, "Parameter 'format': must be one of: xml, json, jsonv2, geojson")
+		return
+	}
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(q.Get("lat")), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(q.Get("lon")), 64)
+	if err1 != nil || err2 != nil {
+		writeNominatimError(w, format, http.StatusBadRequest, "Need coordinates or OSM object to lookup.")
+		return
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		writeNominatimError(w, format, http.StatusBadRequest, "Invalid coordinates.")
+		return
+	}
+	zoom := 18
+	if v := strings.TrimSpace(q.Get("zoom")); v != "" {
+		if zoom, err1 = strconv.Atoi(v); err1 != nil {
+			writeNominatimError(w, format, http.StatusBadRequest, "Parameter 'zoom' must be a number.")
+			return
+		}
+	}
+	threshold := 0.0
+	if v := strings.TrimSpace(q.Get("polygon_threshold")); v != "" {
+		if threshold, err1 = strconv.ParseFloat(v, 64); err1 != nil || threshold < 0 {
+			writeNominatimError(w, format, http.StatusBadRequest, "Parameter 'polygon_threshold' must be a non-negative number.")
+			return
+		}
+	}
+
+	mode := nameModeOf(q.Get("accept-language"))
+	res, err := s.reverseLevel(r.Context(), lon, lat, mode, nominatimZoomLevel(zoom))
+	if errors.Is(err, sql.ErrNoRows) {
+		res, err = s.nearest(r.Context(), lon, lat, s.nearestMaxM, mode)
+	}
+	if err == nil {
+		s.views.apply(res, s.views.def, mode)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeNominatimError(w, format, http.StatusOK, "Unable to geocode")
+		return
+	}
+	var p *NominatimPlace
+	if err == nil {
+		res.truncate(nominatimZoomLevel(zoom))
+		p, err = s.nominatimPlace(r.Context(), res, q.Get("addressdetails") != "0")
+	}
+	if err == nil && (q.Get("polygon_geojson") == "1" || q.Get("polygon_text") == "1") {
+		if err = s.attachGeometry(r.Context(), res, threshold == 0, threshold, q.Get("polygon_geojson") != "1"); err == nil {
+			p.geom = res.Geometry
+		}
+	}
+	if err != nil {
+		log.Println("nominatim compat error:", err)
+		writeNominatimError(w, format, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	switch format {
+	case "xml":
+		writeNominatimXML(w, r, p)
+		return
+	case "geojson":
+		writeNominatimGeoJSON(w, p)
+		return
+	case "jsonv2":
+		p.Category = "boundary"
+	default:
+		p.Class = "boundary"
+	}
+	if p.geom != nil {
+		if p.geom.WKT {
+			p.GeoText = wkt.MarshalString(p.geom.Geom)
+		} else {
+			p.GeoJSON = p.geom
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	_ = json.NewEncoder(w).Encode(p)
+}
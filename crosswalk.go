@@ -0,0 +1,509 @@
+// crosswalk.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+)
+
+// 旧版本 GID → 新版本 GID 的对照表：GADM 每次改版都可能重新编号，库里存的旧代码随之失效。
+// 按几何匹配生成：在旧版本每个叶子内按面积均匀取样点，查样点落在新版本的哪个行政区，
+// 各层按面积加权，得到旧区域有多大比例（share）落在每个新区域中。
+//
+//	gpkg-reverse build -in data/gadm_410.gpkg -out data/gadm_410.sqlite -prev data/gadm36.gpkg
+//	gpkg-reverse crosswalk -old data/gadm36.gpkg -new data/gadm_410.gpkg > crosswalk.csv
+//
+// build 时写入 <table>_crosswalk 表，加载时自动识别；未预处理的库用 CROSSWALK_PATH 加载 crosswalk 命令输出的 CSV
+
+// share 低于此值的新区域视为边界误差，不输出
+const crosswalkMinShare = 0.01
+
+// 面积占比最大的新区域达到此值时视为整体换了代码，否则为拆分
+const crosswalkMajorShare = 0.9
+
+// 每个旧叶子默认的样点数
+const defaultCrosswalkSamples = 16
+
+// 对照表的一行；NewGID 为空表示旧区域在新版本中没有对应（如海岸线变化后成了海面）
+type CrosswalkEntry struct {
+	Level   int
+	OldGID  string
+	OldName string
+	NewGID  string
+	NewName string
+	Share   float64
+}
+
+func crosswalkTableName(table string) string {
+	return table + "_crosswalk"
+}
+
+// 某个数据集中的一个叶子
+type crosswalkLeaf struct {
+	gids, names [6]string
+	geom        orb.MultiPolygon
+}
+
+// 叶子表的查询列；缺少的层级列（旧版本 GADM 可能没有 GID_5）取空串
+func (d *dataset) leafColumns() (string, error) {
+	cols, err := tableColumns(d.db, d.table)
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, 0, 13)
+	for _, prefix := range []string{"GID", "NAME"} {
+		for lvl := 0; lvl <= 5; lvl++ {
+			col := fmt.Sprintf("%s_%d", prefix, lvl)
+			if !cols[col] {
+				col = "''"
+			}
+			out = append(out, "a."+col)
+		}
+	}
+	out = append(out, fmt.Sprintf(`a."%s"`, d.geomCol))
+	return strings.Join(out, ", "), nil
+}
+
+func scanCrosswalkLeaf(rows *sql.Rows) (*crosswalkLeaf, error) {
+	var (
+		vals [12]sql.NullString
+		blob []byte
+	)
+	dest := make([]any, 0, 13)
+	for i := range vals {
+		dest = append(dest, &vals[i])
+	}
+	dest = append(dest, &blob)
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	leaf := &crosswalkLeaf{}
+	for lvl := 0; lvl <= 5; lvl++ {
+		leaf.gids[lvl], leaf.names[lvl] = vals[lvl].String, vals[6+lvl].String
+	}
+	w, _, err := gpkgToWKB(blob)
+	if err != nil {
+		return nil, err
+	}
+	if leaf.geom, err = decodeMultiPolygon(w); err != nil {
+		return nil, err
+	}
+	return leaf, nil
+}
+
+// 在新版本中逐点查叶子；同一旧叶子的样点大多落在同一新叶子中，先查最近命中的几个
+type crosswalkLocator struct {
+	d      *dataset
+	query  string
+	recent []*crosswalkLeaf
+}
+
+func newCrosswalkLocator(d *dataset) (*crosswalkLocator, error) {
+	cols, err := d.leafColumns()
+	if err != nil {
+		return nil, err
+	}
+	return &crosswalkLocator{d: d, query: fmt.Sprintf(`
+SELECT %s
+FROM "%s" AS a
+JOIN "%s" AS r ON a.rowid = r.id
+WHERE r.minx <= ? AND r.maxx >= ? AND r.miny <= ? AND r.maxy >= ?;`, cols, d.table, d.rtree)}, nil
+}
+
+func (l *crosswalkLocator) locate(ctx context.Context, pt orb.Point) (*crosswalkLeaf, error) {
+	const recentSize = 8
+	for _, leaf := range l.recent {
+		if planar.MultiPolygonContains(leaf.geom, pt) {
+			return leaf, nil
+		}
+	}
+	rows, err := l.d.db.QueryContext(ctx, l.query, pt.Lon(), pt.Lon(), pt.Lat(), pt.Lat())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		leaf, err := scanCrosswalkLeaf(rows)
+		if err != nil {
+			return nil, err
+		}
+		if planar.MultiPolygonContains(leaf.geom, pt) {
+			l.recent = append([]*crosswalkLeaf{leaf}, l.recent[:min(len(l.recent), recentSize-1)]...)
+			return leaf, nil
+		}
+	}
+	return nil, rows.Err()
+}
+
+// 一个旧区域落在各新区域中的面积
+type crosswalkVotes struct {
+	name  string
+	total float64
+	byNew map[string]float64
+}
+
+/************* 按几何匹配生成对照表 *************/
+func computeCrosswalk(ctx context.Context, oldDS, newDS *dataset, country string, samples int) ([]CrosswalkEntry, error) {
+	loc, err := newCrosswalkLocator(newDS)
+	if err != nil {
+		return nil, fmt.Errorf("new dataset: %w", err)
+	}
+	cols, err := oldDS.leafColumns()
+	if err != nil {
+		return nil, fmt.Errorf("old dataset: %w", err)
+	}
+	rows, err := oldDS.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM "%s" AS a WHERE a."%s" IS NOT NULL AND (? = '' OR a.GID_0 = ?);`,
+		cols, oldDS.table, oldDS.geomCol), country, country)
+	if err != nil {
+		return nil, fmt.Errorf("old dataset: %w", err)
+	}
+	defer rows.Close()
+
+	var votes [6]map[string]*crosswalkVotes
+	for lvl := range votes {
+		votes[lvl] = make(map[string]*crosswalkVotes)
+	}
+	newNames := make(map[string]string)
+	n := 0
+	for rows.Next() {
+		leaf, err := scanCrosswalkLeaf(rows)
+		if err != nil {
+			return nil, fmt.Errorf("old dataset: %w", err)
+		}
+		points, weight := crosswalkSamples(leaf, samples)
+		for _, pt := range points {
+			hit, err := loc.locate(ctx, pt)
+			if err != nil {
+				return nil, fmt.Errorf("new dataset: %w", err)
+			}
+			for lvl := 0; lvl <= 5; lvl++ {
+				gid := leaf.gids[lvl]
+				if gid == "" {
+					continue
+				}
+				v := votes[lvl][gid]
+				if v == nil {
+					v = &crosswalkVotes{name: leaf.names[lvl], byNew: make(map[string]float64)}
+					votes[lvl][gid] = v
+				}
+				v.total += weight
+				if hit != nil && hit.gids[lvl] != "" {
+					v.byNew[hit.gids[lvl]] += weight
+					newNames[hit.gids[lvl]] = hit.names[lvl]
+				}
+			}
+		}
+		if n++; n%10000 == 0 {
+			log.Printf("crosswalk: %d leaves", n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("old dataset: %w", err)
+	}
+
+	var out []CrosswalkEntry
+	for lvl, byOld := range votes {
+		olds := make([]string, 0, len(byOld))
+		for gid := range byOld {
+			olds = append(olds, gid)
+		}
+		sort.Strings(olds)
+		for _, gid := range olds {
+			v := byOld[gid]
+			var list []CrosswalkEntry
+			for newGID, w := range v.byNew {
+				if share := w / v.total; share >= crosswalkMinShare {
+					list = append(list, CrosswalkEntry{Level: lvl, OldGID: gid, OldName: v.name, NewGID: newGID, NewName: newNames[newGID], Share: math.Round(share*1e4) / 1e4})
+				}
+			}
+			if len(list) == 0 {
+				list = append(list, CrosswalkEntry{Level: lvl, OldGID: gid, OldName: v.name})
+			}
+			sort.Slice(list, func(i, j int) bool {
+				if list[i].Share != list[j].Share {
+					return list[i].Share > list[j].Share
+				}
+				return list[i].NewGID < list[j].NewGID
+			})
+			out = append(out, list...)
+		}
+	}
+	log.Printf("crosswalk: %d leaves, %d rows", n, len(out))
+	return out, nil
+}
+
+// 叶子内按面积均匀的样点及每个样点代表的面积；随机数以 GID 为种子，结果可复现。
+// 太小或退化、取不到样点的叶子用不可达极点代替
+func crosswalkSamples(leaf *crosswalkLeaf, samples int) ([]orb.Point, float64) {
+	// 面积至少按 1 平方米计，退化的叶子也有一票
+	area := max(geo.Area(leaf.geom), 1)
+	h := fnv.New64a()
+	for _, gid := range leaf.gids {
+		h.Write([]byte(gid + "/"))
+	}
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+	points, err := randomPoints(leaf.geom, samples, rng)
+	if err != nil {
+		return []orb.Point{poleOfInaccessibility(leaf.geom)}, area
+	}
+	out := make([]orb.Point, len(points))
+	for i, p := range points {
+		out[i] = orb.Point{p.Longitude, p.Latitude}
+	}
+	return out, area / float64(len(out))
+}
+
+// build -prev 时写入预处理的库
+func writeCrosswalkTable(db *sql.DB, table string, entries []CrosswalkEntry) error {
+	name := crosswalkTableName(table)
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE "%s" (level INTEGER NOT NULL, old_gid TEXT NOT NULL, old_name TEXT NOT NULL,
+  new_gid TEXT NOT NULL, new_name TEXT NOT NULL, share REAL NOT NULL);`, name),
+		fmt.Sprintf(`CREATE INDEX "idx_%[1]s_old" ON "%[1]s" (old_gid);`, name),
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ins, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" VALUES (?, ?, ?, ?, ?, ?);`, name))
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+	for _, e := range entries {
+		if _, err := ins.Exec(e.Level, e.OldGID, e.OldName, e.NewGID, e.NewName, e.Share); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+var crosswalkCSVHeader = []string{"level", "old_code", "old_name", "new_code", "new_name", "share"}
+
+func writeCrosswalkCSV(w io.Writer, entries []CrosswalkEntry) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(crosswalkCSVHeader)
+	for _, e := range entries {
+		_ = cw.Write([]string{strconv.Itoa(e.Level), e.OldGID, e.OldName, e.NewGID, e.NewName, strconv.FormatFloat(e.Share, 'f', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 运行时的对照表：预处理的库中的表，或 CROSSWALK_PATH 的 CSV（读入内存）
+type gidCrosswalk struct {
+	db    *sql.DB
+	table string
+	byOld map[string][]CrosswalkEntry
+}
+
+func loadCrosswalkCSV(path string) (*gidCrosswalk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: header: %w", path, err)
+	}
+	if strings.Join(header, ",") != strings.Join(crosswalkCSVHeader, ",") {
+		return nil, fmt.Errorf("%s: header must be %s", path, strings.Join(crosswalkCSVHeader, ","))
+	}
+	c := &gidCrosswalk{byOld: make(map[string][]CrosswalkEntry)}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		e := CrosswalkEntry{OldGID: row[1], OldName: row[2], NewGID: row[3], NewName: row[4]}
+		if e.Level, err = strconv.Atoi(row[0]); err != nil {
+			return nil, fmt.Errorf("%s: invalid level %q", path, row[0])
+		}
+		if e.Share, err = strconv.ParseFloat(row[5], 64); err != nil {
+			return nil, fmt.Errorf("%s: invalid share %q", path, row[5])
+		}
+		c.byOld[e.OldGID] = append(c.byOld[e.OldGID], e)
+	}
+	return c, nil
+}
+
+// 该旧 GID 的所有对应行，按 share 从大到小；不在旧版本中时为空
+func (c *gidCrosswalk) of(ctx context.Context, code string) ([]CrosswalkEntry, error) {
+	if c.db == nil {
+		return c.byOld[code], nil
+	}
+	rows, err := c.db.QueryContext(ctx, fmt.Sprintf(`SELECT level, old_gid, old_name, new_gid, new_name, share FROM "%s" WHERE old_gid = ? ORDER BY share DESC, new_gid;`,
+		crosswalkTableName(c.table)), code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []CrosswalkEntry
+	for rows.Next() {
+		var e CrosswalkEntry
+		if err := rows.Scan(&e.Level, &e.OldGID, &e.OldName, &e.NewGID, &e.NewName, &e.Share); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+type MigrateTarget struct {
+	GID   string  `json:"code"`
+	Name  string  `json:"name"`
+	Share float64 `json:"share"`
+}
+
+type MigrateResult struct {
+	GID   string `json:"code"`
+	Name  string `json:"name"`
+	Level string `json:"level"`
+	// unchanged | recoded | split | removed
+	Status string `json:"status"`
+	// 面积占比最大的新区域，removed 时为空
+	NewGID string          `json:"newCode,omitempty"`
+	List   []MigrateTarget `json:"list"`
+}
+
+type MigrateRes struct {
+	Code int            `json:"code"`
+	Msg  string         `json:"msg"`
+	Data *MigrateResult `json:"data"`
+}
+
+func migrateResult(entries []CrosswalkEntry) *MigrateResult {
+	first := entries[0]
+	res := &MigrateResult{GID: first.OldGID, Name: first.OldName, Level: levelNameMap()[first.Level], List: make([]MigrateTarget, 0, len(entries))}
+	for _, e := range entries {
+		if e.NewGID != "" {
+			res.List = append(res.List, MigrateTarget{GID: e.NewGID, Name: e.NewName, Share: e.Share})
+		}
+	}
+	switch {
+	case len(res.List) == 0:
+		res.Status = "removed"
+		return res
+	case res.List[0].GID == res.GID:
+		res.Status = "unchanged"
+	case res.List[0].Share >= crosswalkMajorShare:
+		res.Status = "recoded"
+	default:
+		res.Status = "split"
+	}
+	res.NewGID = res.List[0].GID
+	return res
+}
+
+func (s *Server) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	if s.crosswalk == nil {
+		writeErrorJSON(w, http.StatusServiceUnavailable, 503, "crosswalk not configured, run build -prev or set CROSSWALK_PATH")
+		return
+	}
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	entries, err := s.crosswalk.of(r.Context(), code)
+	if err != nil {
+		writeQueryError(w, "migrate", err)
+		return
+	}
+	if len(entries) == 0 {
+		writeErrorJSON(w, http.StatusNotFound, 404, "code not found in previous version")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, MigrateRes{
+		Code: 200,
+		Msg:  "success",
+		Data: migrateResult(entries),
+	})
+}
+
+// 命令行：gpkg-reverse crosswalk -old gadm36.gpkg -new gadm_410.gpkg [-country IDN] > crosswalk.csv
+func runCrosswalk(args []string) error {
+	fs := flag.NewFlagSet("crosswalk", flag.ExitOnError)
+	oldPath := fs.String("old", "", "old GeoPackage path")
+	oldTable := fs.String("old-table", env("GPKG_PREV_TABLE", "gadm"), "old table name")
+	oldGeom := fs.String("old-geom", env("GPKG_PREV_GEOM_COL", env("GPKG_GEOM_COL", "geom")), "old geometry column")
+	newPath := fs.String("new", env("GPKG_PATH", "data/gadm_410.gpkg"), "new GeoPackage path")
+	newTable := fs.String("new-table", env("GPKG_TABLE", "gadm_410"), "new table name")
+	newGeom := fs.String("geom", env("GPKG_GEOM_COL", "geom"), "new geometry column")
+	country := fs.String("country", "", "only match leaves of this GID_0 in the old dataset")
+	samples := fs.Int("samples", defaultCrosswalkSamples, "sample points per old leaf")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *oldPath == "" {
+		return fmt.Errorf("-old required")
+	}
+	if *samples < 1 {
+		return fmt.Errorf("invalid -samples, use at least 1")
+	}
+	oldDS, err := openDataset(*oldPath, *oldTable, *oldGeom)
+	if err != nil {
+		return err
+	}
+	defer oldDS.db.Close()
+	newDS, err := openDataset(*newPath, *newTable, *newGeom)
+	if err != nil {
+		return err
+	}
+	defer newDS.db.Close()
+
+	start := time.Now()
+	entries, err := computeCrosswalk(context.Background(), oldDS, newDS, strings.ToUpper(*country), *samples)
+	if err != nil {
+		return err
+	}
+	if err := writeCrosswalkCSV(os.Stdout, entries); err != nil {
+		return err
+	}
+	log.Printf("crosswalk: %s -> %s in %s", *oldPath, *newPath, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// 预处理的库中有对照表时直接用，CROSSWALK_PATH 优先
+func (s *Server) loadCrosswalk(path string) (*gidCrosswalk, error) {
+	if path != "" {
+		return loadCrosswalkCSV(path)
+	}
+	if !s.built {
+		return nil, nil
+	}
+	if cols, err := tableColumns(s.db, crosswalkTableName(s.table)); err != nil || len(cols) == 0 {
+		return nil, err
+	}
+	return &gidCrosswalk{db: s.db, table: s.table}, nil
+}
@@ -0,0 +1,121 @@
+// crs.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WGS84 椭球（DGN95 与其差异在米级以下，按同一椭球处理）
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+)
+
+// 横轴墨卡托投影参数
+type tmParams struct {
+	lon0, k0, fe, fn float64
+}
+
+// 支持的投影坐标系：
+//   - EPSG:4326        x=经度 y=纬度
+//   - EPSG:3857        Web Mercator
+//   - EPSG:32601-32660 WGS 84 / UTM 北半球，32701-32760 南半球
+//   - EPSG:23866-23872 DGN95 / UTM 46N-52N，23877-23884 47S-54S
+//   - EPSG:23830-23845 DGN95 / Indonesia TM-3 46.2 .. 54.1
+func parseCRS(crs string) (tm *tmParams, mercator bool, err error) {
+	s := strings.ToUpper(strings.TrimSpace(crs))
+	s = strings.TrimPrefix(s, "EPSG:")
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid crs, use EPSG:<code>")
+	}
+	utm := func(zone int, south bool) *tmParams {
+		p := &tmParams{lon0: float64(zone*6 - 183), k0: 0.9996, fe: 500000}
+		if south {
+			p.fn = 10000000
+		}
+		return p
+	}
+	switch {
+	case code == 4326:
+		return nil, false, nil
+	case code == 3857 || code == 900913:
+		return nil, true, nil
+	case code >= 32601 && code <= 32660:
+		return utm(code-32600, false), false, nil
+	case code >= 32701 && code <= 32760:
+		return utm(code-32700, true), false, nil
+	case code >= 23866 && code <= 23872:
+		return utm(code-23866+46, false), false, nil
+	case code >= 23877 && code <= 23884:
+		return utm(code-23877+47, true), false, nil
+	case code >= 23830 && code <= 23845:
+		// 每个 UTM 带再分成东西两个 3° 的子带，46.2 的中央经线为 94.5°
+		return &tmParams{lon0: 94.5 + float64(code-23830)*3, k0: 0.9999, fe: 200000, fn: 1500000}, false, nil
+	}
+	return nil, false, fmt.Errorf("unsupported crs EPSG:%d", code)
+}
+
+// 投影坐标转 WGS84 经纬度
+func toWGS84(crs string, x, y float64) (lat, lon float64, err error) {
+	tm, mercator, err := parseCRS(crs)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case mercator:
+		lon = x / wgs84A * 180 / math.Pi
+		lat = (2*math.Atan(math.Exp(y/wgs84A)) - math.Pi/2) * 180 / math.Pi
+	case tm != nil:
+		lat, lon = tm.inverse(x, y)
+	default:
+		lat, lon = y, x
+	}
+	return lat, lon, nil
+}
+
+// 横轴墨卡托反算（Snyder, Map Projections: A Working Manual, 式 8-12 ~ 8-25）
+func (p *tmParams) inverse(x, y float64) (lat, lon float64) {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+
+	m := (y - p.fn) / p.k0
+	mu := m / (wgs84A * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	phi1 := mu +
+		(3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sin1, cos1, tan1 := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+	c1 := ep2 * cos1 * cos1
+	t1 := tan1 * tan1
+	n1 := wgs84A / math.Sqrt(1-e2*sin1*sin1)
+	r1 := wgs84A * (1 - e2) / math.Pow(1-e2*sin1*sin1, 1.5)
+	d := (x - p.fe) / (n1 * p.k0)
+
+	phi := phi1 - (n1*tan1/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lam := (d - (1+2*t1+c1)*math.Pow(d, 3)/6 +
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / cos1
+
+	return phi * 180 / math.Pi, p.lon0 + lam*180/math.Pi
+}
+
+// 读取 crs + x/y 参数
+func parseProjected(crs, xs, ys string) (lat, lon float64, err error) {
+	if xs == "" || ys == "" {
+		return 0, 0, fmt.Errorf("x and y are required with crs")
+	}
+	x, err1 := strconv.ParseFloat(strings.TrimSpace(xs), 64)
+	y, err2 := strconv.ParseFloat(strings.TrimSpace(ys), 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid x/y values")
+	}
+	return toWGS84(crs, x, y)
+}
@@ -0,0 +1,101 @@
+// csv.go
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 每写这么多行 Flush 一次，客户端可以边收边处理
+const csvFlushRows = 1000
+
+var areaCSVHeader = []string{"code", "name", "parentCode", "level"}
+
+func wantsCSV(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("format"), "csv")
+}
+
+// 按行写出 CSV，首行为表头
+type csvStream struct {
+	cw   *csv.Writer
+	rows int
+}
+
+func newCSVStream(w http.ResponseWriter, header []string) *csvStream {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	c := &csvStream{cw: csv.NewWriter(w)}
+	c.write(header)
+	return c
+}
+
+func (c *csvStream) write(record []string) {
+	c.cw.Write(record)
+	c.rows++
+	if c.rows%csvFlushRows == 0 {
+		c.cw.Flush()
+	}
+}
+
+// 响应头已发出，出错只能记日志
+func (c *csvStream) close() {
+	c.cw.Flush()
+	if err := c.cw.Error(); err != nil {
+		log.Println("csv write error:", err)
+	}
+}
+
+func (c ChildrenItem) csvRecord() []string {
+	return []string{c.GID, c.Name, c.ParentCode, c.Level}
+}
+
+// include 中的外部属性各占一列，没有值的留空
+func writeChildrenCSV(w http.ResponseWriter, items []ChildrenItem, include []string) {
+	c := newCSVStream(w, append(append([]string{}, areaCSVHeader...), include...))
+	for _, item := range items {
+		record := item.csvRecord()
+		for _, name := range include {
+			record = append(record, attributeText(item.Attributes[name]))
+		}
+		c.write(record)
+	}
+	c.close()
+}
+
+// 子树按深度优先展开成一行一个节点，父节点在子节点之前
+func writeTreeCSV(w http.ResponseWriter, root *TreeNode) {
+	c := newCSVStream(w, areaCSVHeader)
+	var walk func(n *TreeNode)
+	walk = func(n *TreeNode) {
+		c.write([]string{n.GID, n.Name, n.ParentCode, n.Level})
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	c.close()
+}
+
+// 找不到的 code 不输出行
+func writeLatlngCSV(w http.ResponseWriter, items []LatlngItem) {
+	header := append(append([]string{}, areaCSVHeader...), "latitude", "longitude", "elevation", "geohash", "pole_latitude", "pole_longitude")
+	c := newCSVStream(w, header)
+	for _, item := range items {
+		var poleLat, poleLon string
+		if item.Pole != nil {
+			poleLat = strconv.FormatFloat(item.Pole.Latitude, 'f', -1, 64)
+			poleLon = strconv.FormatFloat(item.Pole.Longitude, 'f', -1, 64)
+		}
+		c.write([]string{
+			item.GID, item.Name, item.ParentCode, item.Level,
+			strconv.FormatFloat(item.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(item.Longitude, 'f', -1, 64),
+			strconv.FormatFloat(item.Elevation, 'f', -1, 64),
+			item.Geohash, poleLat, poleLon,
+		})
+	}
+	c.close()
+}
@@ -0,0 +1,870 @@
+// dem.go
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+	"golang.org/x/image/tiff/lzw"
+)
+
+// 海拔来源：ELEVATION_PROVIDER=google（默认）时请求谷歌 Elevation API；
+// ELEVATION_PROVIDER=dem 时直接读 DEM_DIR（默认 data/dem）下的本地瓦片，完全不访问外网，适合隔离网络中的部署。
+//
+// 支持的瓦片（目录下递归查找）：
+//   - SRTM .hgt：文件名为西南角，如 S07E107.hgt，1201×1201（3″）或 3601×3601（1″）的大端 int16，-32768 为空洞
+//   - GeoTIFF（.tif/.tiff，含 BigTIFF）：单波段或取第一波段，条带或分块，不压缩、LZW 或 Deflate（含预测器 2/3），
+//     整数或浮点；必须是经纬度坐标（EPSG:4326 等地理坐标系），GDAL_NODATA 标记的值为空洞
+//
+// 取点周围 4 个像素中心双线性插值，空洞像素不参与；点不在任何瓦片内或周围全是空洞时没有结果（/latlng 的海拔为 0，不缓存）。
+// 同一位置有多个瓦片时 .hgt 优先，GeoTIFF 中分辨率高的优先。
+// 读出的数据按块（.hgt 一行，GeoTIFF 一个条带或分块）缓存，总量由 DEM_CACHE_MB（默认 64）限制
+type elevationSource interface {
+	// 结果与 pts 一一对应，没有数据的点为 NaN
+	elevations(pts []orb.Point) ([]float64, error)
+}
+
+type googleElevation struct {
+	apiKey string
+}
+
+func (g googleElevation) elevations(pts []orb.Point) ([]float64, error) {
+	return fetchElevations(g.apiKey, pts)
+}
+
+// 多个数据集（GPKG_DIR）共用一个来源，瓦片只扫描一次
+var loadElevationSource = sync.OnceValues(func() (elevationSource, error) {
+	switch provider := env("ELEVATION_PROVIDER", "google"); provider {
+	case "google":
+		return googleElevation{apiKey: env("GOOGLE_API_KEY", "")}, nil
+	case "dem":
+		cacheMB, err := strconv.Atoi(env("DEM_CACHE_MB", "64"))
+		if err != nil || cacheMB < 0 {
+			return nil, fmt.Errorf("invalid DEM_CACHE_MB")
+		}
+		return openDEM(env("DEM_DIR", "data/dem"), int64(cacheMB)<<20)
+	default:
+		return nil, fmt.Errorf("invalid ELEVATION_PROVIDER %q, use google or dem", provider)
+	}
+})
+
+// 一个瓦片：行列以像素中心为整数坐标，块内数据已换算为 float32，空洞为 NaN
+type demTile interface {
+	// 点在栅格中的行列，不在瓦片范围内时 ok 为 false
+	locate(lon, lat float64) (r, c float64, ok bool)
+	dims() (rows, cols int)
+	// 像素所在的块及其在块内的下标
+	blockOf(r, c int) (block, i int)
+	readBlock(block int) ([]float32, error)
+	// 像素大小（度），多个瓦片重叠时小的优先
+	resolution() float64
+}
+
+type demSource struct {
+	// .hgt 按西南角（纬度、经度）索引；GeoTIFF 按分辨率由高到低
+	hgt   map[[2]int]demTile
+	tiffs []demTile
+	cache *demCache
+}
+
+var hgtNameRe = regexp.MustCompile(`(?i)^([NS])(\d{2})([EW])(\d{3})\.hgt$`)
+
+func openDEM(dir string, cacheBytes int64) (*demSource, error) {
+	start := time.Now()
+	d := &demSource{hgt: make(map[[2]int]demTile), cache: newDEMCache(cacheBytes)}
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		name := e.Name()
+		switch ext := strings.ToLower(filepath.Ext(name)); {
+		case ext == ".hgt":
+			m := hgtNameRe.FindStringSubmatch(name)
+			if m == nil {
+				return fmt.Errorf("%s: .hgt name must be the south-west corner, like S07E107.hgt", path)
+			}
+			lat, _ := strconv.Atoi(m[2])
+			lon, _ := strconv.Atoi(m[4])
+			if strings.EqualFold(m[1], "S") {
+				lat = -lat
+			}
+			if strings.EqualFold(m[3], "W") {
+				lon = -lon
+			}
+			t, err := openHGT(path, lat, lon)
+			if err != nil {
+				return err
+			}
+			d.hgt[[2]int{lat, lon}] = t
+		case ext == ".tif" || ext == ".tiff":
+			t, err := openGeoTIFF(path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			d.tiffs = append(d.tiffs, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dem: %w", err)
+	}
+	if len(d.hgt)+len(d.tiffs) == 0 {
+		return nil, fmt.Errorf("dem: no .hgt or .tif tiles in %s", dir)
+	}
+	sort.SliceStable(d.tiffs, func(i, j int) bool { return d.tiffs[i].resolution() < d.tiffs[j].resolution() })
+	log.Printf("dem: %d hgt and %d GeoTIFF tiles from %s in %s", len(d.hgt), len(d.tiffs), dir, time.Since(start).Round(time.Millisecond))
+	return d, nil
+}
+
+func (d *demSource) elevations(pts []orb.Point) ([]float64, error) {
+	out := make([]float64, len(pts))
+	for i, p := range pts {
+		v, err := d.elevation(p.Lon(), p.Lat())
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// 没有数据时为 NaN
+func (d *demSource) elevation(lon, lat float64) (float64, error) {
+	for _, t := range d.tilesAt(lon, lat) {
+		v, ok, err := d.interpolate(t, lon, lat)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return v, nil
+		}
+	}
+	return math.NaN(), nil
+}
+
+// 覆盖该点的瓦片，按优先顺序；点在整度线上时相邻的 .hgt 也算
+func (d *demSource) tilesAt(lon, lat float64) []demTile {
+	var out []demTile
+	seen := make(map[[2]int]bool, 4)
+	la, lo := int(math.Floor(lat)), int(math.Floor(lon))
+	for _, k := range [][2]int{{la, lo}, {la - 1, lo}, {la, lo - 1}, {la - 1, lo - 1}} {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if t, ok := d.hgt[k]; ok {
+			if _, _, ok := t.locate(lon, lat); ok {
+				out = append(out, t)
+			}
+		}
+	}
+	for _, t := range d.tiffs {
+		if _, _, ok := t.locate(lon, lat); ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// 周围 4 个像素中心双线性插值，空洞不参与、其余按权重重新归一；全是空洞时 ok 为 false
+func (d *demSource) interpolate(t demTile, lon, lat float64) (float64, bool, error) {
+	r, c, _ := t.locate(lon, lat)
+	rows, cols := t.dims()
+	r = math.Max(0, math.Min(r, float64(rows-1)))
+	c = math.Max(0, math.Min(c, float64(cols-1)))
+	r0, c0 := int(math.Floor(r)), int(math.Floor(c))
+	r1, c1 := min(r0+1, rows-1), min(c0+1, cols-1)
+	fr, fc := r-float64(r0), c-float64(c0)
+
+	var sum, weight float64
+	for _, p := range [4]struct {
+		r, c int
+		w    float64
+	}{
+		{r0, c0, (1 - fr) * (1 - fc)},
+		{r0, c1, (1 - fr) * fc},
+		{r1, c0, fr * (1 - fc)},
+		{r1, c1, fr * fc},
+	} {
+		if p.w == 0 {
+			continue
+		}
+		v, err := d.pixel(t, p.r, p.c)
+		if err != nil {
+			return 0, false, err
+		}
+		if math.IsNaN(float64(v)) {
+			continue
+		}
+		sum += float64(v) * p.w
+		weight += p.w
+	}
+	if weight == 0 {
+		// 正好落在空洞像素上（权重全在一个像素）或周围全是空洞
+		return 0, false, nil
+	}
+	return sum / weight, true, nil
+}
+
+func (d *demSource) pixel(t demTile, r, c int) (float32, error) {
+	block, i := t.blockOf(r, c)
+	data, err := d.cache.get(t, block)
+	if err != nil {
+		return 0, err
+	}
+	return data[i], nil
+}
+
+/************* 块缓存（LRU，按字节数淘汰） *************/
+
+type demBlockKey struct {
+	tile  demTile
+	block int
+}
+
+type demCache struct {
+	mu       sync.Mutex
+	max, cur int64
+	order    *list.List
+	items    map[demBlockKey]*list.Element
+}
+
+type demCacheEntry struct {
+	key  demBlockKey
+	data []float32
+}
+
+func newDEMCache(max int64) *demCache {
+	return &demCache{max: max, order: list.New(), items: make(map[demBlockKey]*list.Element)}
+}
+
+// 未命中时在锁外读取，同一块并发读取时各读一次
+func (c *demCache) get(t demTile, block int) ([]float32, error) {
+	key := demBlockKey{t, block}
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		demCacheStats.record(true)
+		return e.Value.(*demCacheEntry).data, nil
+	}
+	c.mu.Unlock()
+	demCacheStats.record(false)
+
+	data, err := t.readBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(data)) * 4
+	if size > c.max {
+		return data, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; !ok {
+		c.items[key] = c.order.PushFront(&demCacheEntry{key: key, data: data})
+		c.cur += size
+	}
+	for c.cur > c.max {
+		e := c.order.Back()
+		ent := e.Value.(*demCacheEntry)
+		c.order.Remove(e)
+		delete(c.items, ent.key)
+		c.cur -= int64(len(ent.data)) * 4
+	}
+	return data, nil
+}
+
+/************* SRTM .hgt *************/
+
+type hgtTile struct {
+	path     string
+	lat, lon int
+	n        int
+}
+
+func openHGT(path string, lat, lon int) (*hgtTile, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	n := int(math.Round(math.Sqrt(float64(st.Size() / 2))))
+	if n < 2 || int64(n)*int64(n)*2 != st.Size() {
+		return nil, fmt.Errorf("%s: size %d is not a square grid of int16", path, st.Size())
+	}
+	return &hgtTile{path: path, lat: lat, lon: lon, n: n}, nil
+}
+
+// 第 0 行为北边（纬度 lat+1），第 0 列为西边；首尾行列与相邻瓦片重合
+func (t *hgtTile) locate(lon, lat float64) (float64, float64, bool) {
+	if lat < float64(t.lat) || lat > float64(t.lat+1) || lon < float64(t.lon) || lon > float64(t.lon+1) {
+		return 0, 0, false
+	}
+	step := float64(t.n - 1)
+	return (float64(t.lat+1) - lat) * step, (lon - float64(t.lon)) * step, true
+}
+
+func (t *hgtTile) dims() (int, int)            { return t.n, t.n }
+func (t *hgtTile) blockOf(r, c int) (int, int) { return r, c }
+func (t *hgtTile) resolution() float64         { return 1 / float64(t.n-1) }
+
+func (t *hgtTile) readBlock(row int) ([]float32, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, t.n*2)
+	if _, err := f.ReadAt(buf, int64(row)*int64(t.n)*2); err != nil {
+		return nil, fmt.Errorf("%s: %w", t.path, err)
+	}
+	out := make([]float32, t.n)
+	for i := range out {
+		v := int16(binary.BigEndian.Uint16(buf[i*2:]))
+		if v == -32768 {
+			out[i] = float32(math.NaN())
+		} else {
+			out[i] = float32(v)
+		}
+	}
+	return out, nil
+}
+
+/************* GeoTIFF *************/
+
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPlanarConfig    = 284
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffTileLength      = 323
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffSampleFormat    = 339
+	geoPixelScale       = 33550
+	geoTiepoint         = 33922
+	geoTransformation   = 34264
+	geoKeyDirectory     = 34735
+	gdalNodata          = 42113
+)
+
+// 各数据类型的字节数，下标为 TIFF 类型号
+var tiffTypeSizes = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8, 0, 0, 0, 8, 8, 8}
+
+type tiffField struct {
+	typ int
+	raw []byte
+}
+
+type tiffTile struct {
+	path  string
+	order binary.ByteOrder
+
+	width, height   int
+	blockW, blockH  int
+	tiled           bool
+	offsets, counts []uint64
+	compression     int
+	predictor       int
+	samples, bps    int
+	sampleFormat    int
+	nodata          float64
+	hasNodata       bool
+	x0, y0, dx, dy  float64 // 第 0 行第 0 列像素中心的经纬度、像素大小
+	halfW, halfH    float64 // 边缘像素向外延伸的范围（像素），PixelIsArea 时为半个像素
+}
+
+func openGeoTIFF(path string) (*tiffTile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &tiffTile{path: path}
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(f, head[:8]); err != nil {
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+	switch string(head[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+	var (
+		big       bool
+		ifdOffset uint64
+	)
+	switch t.order.Uint16(head[2:]) {
+	case 42:
+		ifdOffset = uint64(t.order.Uint32(head[4:]))
+	case 43:
+		if _, err := io.ReadFull(f, head[8:16]); err != nil {
+			return nil, err
+		}
+		big, ifdOffset = true, t.order.Uint64(head[8:])
+	default:
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+
+	// 只读第一个 IFD（全分辨率），其后的金字塔忽略
+	fields, err := t.readIFD(f, ifdOffset, big)
+	if err != nil {
+		return nil, err
+	}
+	ints := func(tag int, def uint64) ([]uint64, error) {
+		fd, ok := fields[tag]
+		if !ok {
+			return []uint64{def}, nil
+		}
+		return t.fieldInts(tag, fd)
+	}
+	one := func(tag int, def uint64) (int, error) {
+		v, err := ints(tag, def)
+		if err != nil {
+			return 0, err
+		}
+		if len(v) == 0 {
+			return int(def), nil
+		}
+		return int(v[0]), nil
+	}
+	var bits int
+	for _, p := range []struct {
+		tag int
+		def uint64
+		dst *int
+	}{
+		{tiffImageWidth, 0, &t.width},
+		{tiffImageLength, 0, &t.height},
+		{tiffBitsPerSample, 1, &bits},
+		{tiffCompression, 1, &t.compression},
+		{tiffSamplesPerPixel, 1, &t.samples},
+		{tiffPredictor, 1, &t.predictor},
+		{tiffSampleFormat, 1, &t.sampleFormat},
+	} {
+		if *p.dst, err = one(p.tag, p.def); err != nil {
+			return nil, err
+		}
+	}
+	planar, err := one(tiffPlanarConfig, 1)
+	if err != nil {
+		return nil, err
+	}
+	if t.width < 1 || t.height < 1 {
+		return nil, fmt.Errorf("missing image size")
+	}
+	switch bits {
+	case 8, 16, 32, 64:
+	default:
+		return nil, fmt.Errorf("unsupported %d bits per sample", bits)
+	}
+	t.bps = bits / 8
+	switch {
+	case t.sampleFormat == 3 && t.bps != 4 && t.bps != 8,
+		t.sampleFormat != 1 && t.sampleFormat != 2 && t.sampleFormat != 3:
+		return nil, fmt.Errorf("unsupported sample format %d with %d bits", t.sampleFormat, bits)
+	}
+	switch t.compression {
+	case 1, 5, 8, 32946:
+	default:
+		return nil, fmt.Errorf("unsupported compression %d, use none, LZW or Deflate", t.compression)
+	}
+	if t.predictor != 1 && t.predictor != 2 && t.predictor != 3 {
+		return nil, fmt.Errorf("unsupported predictor %d", t.predictor)
+	}
+	// 按平面存放时只用第一个平面，像素之间不再间隔
+	if planar == 2 {
+		t.samples = 1
+	}
+
+	offsetTag, countTag := tiffStripOffsets, tiffStripByteCounts
+	if _, ok := fields[tiffTileWidth]; ok {
+		t.tiled = true
+		offsetTag, countTag = tiffTileOffsets, tiffTileByteCounts
+		if t.blockW, err = one(tiffTileWidth, 0); err != nil {
+			return nil, err
+		}
+		if t.blockH, err = one(tiffTileLength, 0); err != nil {
+			return nil, err
+		}
+	} else {
+		t.blockW = t.width
+		if t.blockH, err = one(tiffRowsPerStrip, uint64(t.height)); err != nil {
+			return nil, err
+		}
+		t.blockH = min(t.blockH, t.height)
+	}
+	if t.blockW < 1 || t.blockH < 1 {
+		return nil, fmt.Errorf("invalid strip or tile size")
+	}
+	if t.offsets, err = ints(offsetTag, 0); err != nil {
+		return nil, err
+	}
+	if t.counts, err = ints(countTag, 0); err != nil {
+		return nil, err
+	}
+	across := (t.width + t.blockW - 1) / t.blockW
+	down := (t.height + t.blockH - 1) / t.blockH
+	if len(t.offsets) < across*down || len(t.counts) < across*down {
+		return nil, fmt.Errorf("missing strip or tile offsets")
+	}
+
+	if err := t.georeference(fields); err != nil {
+		return nil, err
+	}
+	if fd, ok := fields[gdalNodata]; ok {
+		s := strings.Trim(string(fd.raw), "\x00 ")
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			t.nodata, t.hasNodata = v, true
+		}
+	}
+	return t, nil
+}
+
+func (t *tiffTile) readIFD(f *os.File, offset uint64, big bool) (map[int]tiffField, error) {
+	countSize, entrySize, inline := 2, 12, 4
+	if big {
+		countSize, entrySize, inline = 8, 20, 8
+	}
+	buf := make([]byte, countSize)
+	if _, err := f.ReadAt(buf, int64(offset)); err != nil {
+		return nil, fmt.Errorf("IFD: %w", err)
+	}
+	var n uint64
+	if big {
+		n = t.order.Uint64(buf)
+	} else {
+		n = uint64(t.order.Uint16(buf))
+	}
+	if n == 0 || n > 4096 {
+		return nil, fmt.Errorf("IFD: invalid entry count %d", n)
+	}
+	entries := make([]byte, int(n)*entrySize)
+	if _, err := f.ReadAt(entries, int64(offset)+int64(countSize)); err != nil {
+		return nil, fmt.Errorf("IFD: %w", err)
+	}
+	fields := make(map[int]tiffField, n)
+	for i := 0; i < int(n); i++ {
+		e := entries[i*entrySize:]
+		tag, typ := int(t.order.Uint16(e)), int(t.order.Uint16(e[2:]))
+		var count uint64
+		value := e[8:]
+		if big {
+			count = t.order.Uint64(e[4:])
+			value = e[12:20]
+		} else {
+			count = uint64(t.order.Uint32(e[4:]))
+			value = e[8:12]
+		}
+		if typ <= 0 || typ >= len(tiffTypeSizes) || tiffTypeSizes[typ] == 0 {
+			continue
+		}
+		size := count * uint64(tiffTypeSizes[typ])
+		if size > 1<<30 {
+			return nil, fmt.Errorf("IFD: tag %d too large", tag)
+		}
+		raw := make([]byte, size)
+		if size <= uint64(inline) {
+			copy(raw, value)
+		} else {
+			var at uint64
+			if big {
+				at = t.order.Uint64(value)
+			} else {
+				at = uint64(t.order.Uint32(value))
+			}
+			if _, err := f.ReadAt(raw, int64(at)); err != nil {
+				return nil, fmt.Errorf("tag %d: %w", tag, err)
+			}
+		}
+		fields[tag] = tiffField{typ: typ, raw: raw}
+	}
+	return fields, nil
+}
+
+func (t *tiffTile) fieldInts(tag int, fd tiffField) ([]uint64, error) {
+	size := tiffTypeSizes[fd.typ]
+	out := make([]uint64, len(fd.raw)/size)
+	for i := range out {
+		b := fd.raw[i*size:]
+		switch fd.typ {
+		case 1, 7:
+			out[i] = uint64(b[0])
+		case 3:
+			out[i] = uint64(t.order.Uint16(b))
+		case 4:
+			out[i] = uint64(t.order.Uint32(b))
+		case 16:
+			out[i] = t.order.Uint64(b)
+		default:
+			return nil, fmt.Errorf("tag %d: unexpected type %d", tag, fd.typ)
+		}
+	}
+	return out, nil
+}
+
+func (t *tiffTile) fieldFloats(tag int, fd tiffField) ([]float64, error) {
+	if fd.typ != 12 {
+		return nil, fmt.Errorf("tag %d: unexpected type %d", tag, fd.typ)
+	}
+	out := make([]float64, len(fd.raw)/8)
+	for i := range out {
+		out[i] = math.Float64frombits(t.order.Uint64(fd.raw[i*8:]))
+	}
+	return out, nil
+}
+
+// 由 ModelTiepoint + ModelPixelScale 得到像素中心的经纬度；GeoKey 中为投影坐标系时不支持
+func (t *tiffTile) georeference(fields map[int]tiffField) error {
+	pixelIsPoint := false
+	if fd, ok := fields[geoKeyDirectory]; ok {
+		keys, err := t.fieldInts(geoKeyDirectory, fd)
+		if err != nil {
+			return err
+		}
+		for i := 4; i+3 < len(keys); i += 4 {
+			id, loc, value := keys[i], keys[i+1], keys[i+3]
+			if loc != 0 {
+				continue
+			}
+			switch {
+			case id == 1024 && value == 1: // GTModelTypeGeoKey = ModelTypeProjected
+				return fmt.Errorf("projected DEM is not supported, reproject to EPSG:4326")
+			case id == 1025 && value == 2: // GTRasterTypeGeoKey = RasterPixelIsPoint
+				pixelIsPoint = true
+			}
+		}
+	}
+	scaleFd, ok1 := fields[geoPixelScale]
+	tieFd, ok2 := fields[geoTiepoint]
+	if !ok1 || !ok2 {
+		if _, ok := fields[geoTransformation]; ok {
+			return fmt.Errorf("ModelTransformation is not supported, use a north-up DEM")
+		}
+		return fmt.Errorf("not a GeoTIFF, missing ModelPixelScale or ModelTiepoint")
+	}
+	scale, err := t.fieldFloats(geoPixelScale, scaleFd)
+	if err != nil {
+		return err
+	}
+	tie, err := t.fieldFloats(geoTiepoint, tieFd)
+	if err != nil {
+		return err
+	}
+	if len(scale) < 2 || len(tie) < 6 || scale[0] <= 0 || scale[1] <= 0 {
+		return fmt.Errorf("invalid ModelPixelScale or ModelTiepoint")
+	}
+	t.dx, t.dy = scale[0], scale[1]
+	// 栅格坐标 (i, j) 对应经纬度 (x, y)；PixelIsArea 时整数栅格坐标为像素角点，中心再偏半个像素
+	t.x0 = tie[3] - tie[0]*t.dx
+	t.y0 = tie[4] + tie[1]*t.dy
+	if !pixelIsPoint {
+		t.x0 += t.dx / 2
+		t.y0 -= t.dy / 2
+		t.halfW, t.halfH = 0.5, 0.5
+	}
+	if t.x0 < -181 || t.x0 > 361 || t.y0 < -91 || t.y0 > 91 {
+		return fmt.Errorf("coordinates are not longitude/latitude, reproject to EPSG:4326")
+	}
+	return nil
+}
+
+func (t *tiffTile) locate(lon, lat float64) (float64, float64, bool) {
+	c := (lon - t.x0) / t.dx
+	r := (t.y0 - lat) / t.dy
+	if c < -t.halfW || c > float64(t.width-1)+t.halfW || r < -t.halfH || r > float64(t.height-1)+t.halfH {
+		return 0, 0, false
+	}
+	return r, c, true
+}
+
+func (t *tiffTile) dims() (int, int)    { return t.height, t.width }
+func (t *tiffTile) resolution() float64 { return t.dx }
+
+func (t *tiffTile) blockOf(r, c int) (int, int) {
+	if !t.tiled {
+		return r / t.blockH, (r%t.blockH)*t.width + c
+	}
+	across := (t.width + t.blockW - 1) / t.blockW
+	return (r/t.blockH)*across + c/t.blockW, (r%t.blockH)*t.blockW + c%t.blockW
+}
+
+// 解压并换算一个条带或分块；分块总是完整的 blockW×blockH，最后一个条带可能不足 blockH 行
+func (t *tiffTile) readBlock(block int) ([]float32, error) {
+	rows := t.blockH
+	if !t.tiled {
+		rows = min(t.blockH, t.height-block*t.blockH)
+	}
+	pixels := t.blockW * rows
+	out := make([]float32, pixels)
+	offset, count := t.offsets[block], t.counts[block]
+	// 稀疏文件中没有写入的块
+	if offset == 0 || count == 0 {
+		for i := range out {
+			out[i] = float32(math.NaN())
+		}
+		return out, nil
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	raw := make([]byte, count)
+	if _, err := f.ReadAt(raw, int64(offset)); err != nil {
+		return nil, fmt.Errorf("%s: %w", t.path, err)
+	}
+	rowBytes := t.blockW * t.samples * t.bps
+	data := make([]byte, rowBytes*rows)
+	switch t.compression {
+	case 1:
+		copy(data, raw)
+	case 5:
+		err = readFullBlock(lzw.NewReader(bytes.NewReader(raw), lzw.MSB, 8), data)
+	case 8, 32946:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(bytes.NewReader(raw)); err == nil {
+			err = readFullBlock(zr, data)
+			zr.Close()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: block %d: %w", t.path, block, err)
+	}
+
+	for y := 0; y < rows; y++ {
+		row := data[y*rowBytes : (y+1)*rowBytes]
+		switch t.predictor {
+		case 2:
+			t.undoHorizontal(row)
+		case 3:
+			row = undoFloatPredictor(row, t.samples, t.bps)
+		}
+		for x := 0; x < t.blockW; x++ {
+			v := t.sample(row, x*t.samples)
+			if math.IsNaN(v) || t.isNodata(v) {
+				out[y*t.blockW+x] = float32(math.NaN())
+			} else {
+				out[y*t.blockW+x] = float32(v)
+			}
+		}
+	}
+	return out, nil
+}
+
+// GDAL_NODATA 按文本记录，float32 数据按 float32 比较
+func (t *tiffTile) isNodata(v float64) bool {
+	if !t.hasNodata {
+		return false
+	}
+	if t.sampleFormat == 3 && t.bps == 4 {
+		return float32(v) == float32(t.nodata)
+	}
+	return v == t.nodata
+}
+
+// 解压后的数据可能比预期长（分块末尾的填充），短了则是文件损坏
+func readFullBlock(r io.Reader, dst []byte) error {
+	if _, err := io.ReadFull(r, dst); err != nil {
+		return fmt.Errorf("decompress: %w", err)
+	}
+	return nil
+}
+
+// 预测器 2：同一通道的整数按行做了差分
+func (t *tiffTile) undoHorizontal(row []byte) {
+	step := t.samples * t.bps
+	for i := step; i+t.bps <= len(row); i += t.bps {
+		p, c := row[i-step:], row[i:]
+		switch t.bps {
+		case 1:
+			c[0] += p[0]
+		case 2:
+			t.order.PutUint16(c, t.order.Uint16(c)+t.order.Uint16(p))
+		case 4:
+			t.order.PutUint32(c, t.order.Uint32(c)+t.order.Uint32(p))
+		case 8:
+			t.order.PutUint64(c, t.order.Uint64(c)+t.order.Uint64(p))
+		}
+	}
+}
+
+// 预测器 3：浮点数按字节平面（高位在前）重排后逐字节差分；还原后每个值为大端，sample 按大端读取
+func undoFloatPredictor(row []byte, samples, bps int) []byte {
+	for i := samples; i < len(row); i++ {
+		row[i] += row[i-samples]
+	}
+	n := len(row) / bps
+	out := make([]byte, len(row))
+	for v := 0; v < n; v++ {
+		for b := 0; b < bps; b++ {
+			out[v*bps+b] = row[b*n+v]
+		}
+	}
+	return out
+}
+
+func (t *tiffTile) sample(row []byte, i int) float64 {
+	b := row[i*t.bps:]
+	order := t.order
+	if t.predictor == 3 {
+		order = binary.BigEndian
+	}
+	switch t.sampleFormat {
+	case 3:
+		if t.bps == 4 {
+			return float64(math.Float32frombits(order.Uint32(b)))
+		}
+		return math.Float64frombits(order.Uint64(b))
+	case 2:
+		switch t.bps {
+		case 1:
+			return float64(int8(b[0]))
+		case 2:
+			return float64(int16(order.Uint16(b)))
+		case 4:
+			return float64(int32(order.Uint32(b)))
+		default:
+			return float64(int64(order.Uint64(b)))
+		}
+	default:
+		switch t.bps {
+		case 1:
+			return float64(b[0])
+		case 2:
+			return float64(order.Uint16(b))
+		case 4:
+			return float64(order.Uint32(b))
+		default:
+			return float64(order.Uint64(b))
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// details.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 行政区的 GADM 属性列
+type AreaDetails struct {
+	GID        string `json:"code"`
+	Name       string `json:"name"`
+	ParentCode string `json:"parentCode"`
+	Level      string `json:"level"`
+	VarName    string `json:"varName,omitempty"`
+	NLName     string `json:"nlName,omitempty"`
+	Type       string `json:"type,omitempty"`
+	EngType    string `json:"engType,omitempty"`
+	HASC       string `json:"hasc,omitempty"`
+	CC         string `json:"cc,omitempty"`
+	ISO        string `json:"iso,omitempty"`
+	Country    string `json:"country,omitempty"`
+
+	// ?include= 时的外部属性，见 attributes.go
+	Attributes map[string]any `json:"attributes,omitempty"`
+	// 名称或区域来自修正层，见 overrides.go
+	Overridden bool `json:"overridden,omitempty"`
+}
+
+type DetailsRes struct {
+	Code int          `json:"code"`
+	Msg  string       `json:"msg"`
+	Data *AreaDetails `json:"data"`
+}
+
+/************* GID → 属性 *************/
+func (s *Server) detailsOf(ctx context.Context, GID string) (*AreaDetails, error) {
+	GID = strings.TrimSpace(GID)
+	if GID == "" {
+		return nil, fmt.Errorf("gid required")
+	}
+	// 修正层新增的区域只有名称和上级
+	if a := s.overrides.byGID[GID]; a != nil && a.added {
+		return &AreaDetails{GID: GID, Name: s.overrides.names[GID], ParentCode: a.gids[a.level-1],
+			Level: levelNameMap()[a.level], Overridden: true}, nil
+	}
+	GID, err := s.resolveCode(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &AreaDetails{GID: GID, Level: levelNameMap()[level]}
+	parentCol := ""
+	if level > 0 {
+		parentCol = fmt.Sprintf("GID_%d", level-1)
+	}
+	// 各层可用的列不同（如 level 0 没有 TYPE_0，level 5 没有 HASC_5），缺失的列跳过
+	fields := []struct {
+		col  string
+		dest *string
+	}{
+		{fmt.Sprintf("NAME_%d", level), &d.Name},
+		{parentCol, &d.ParentCode},
+		{fmt.Sprintf("VARNAME_%d", level), &d.VarName},
+		{fmt.Sprintf("NL_NAME_%d", level), &d.NLName},
+		{fmt.Sprintf("TYPE_%d", level), &d.Type},
+		{fmt.Sprintf("ENGTYPE_%d", level), &d.EngType},
+		{fmt.Sprintf("HASC_%d", level), &d.HASC},
+		{fmt.Sprintf("CC_%d", level), &d.CC},
+		{fmt.Sprintf("ISO_%d", level), &d.ISO},
+		{"COUNTRY", &d.Country},
+	}
+
+	cols := make([]string, 0, len(fields))
+	dest := make([]any, 0, len(fields))
+	for _, f := range fields {
+		if f.col == "" || !s.columns[f.col] {
+			continue
+		}
+		cols = append(cols, fmt.Sprintf("IFNULL(%s, '')", f.col))
+		dest = append(dest, f.dest)
+	}
+
+	where, arg := s.gidWhere(GID, level)
+	sqlStr := fmt.Sprintf(`SELECT %s FROM %s WHERE %s LIMIT 1`,
+		strings.Join(cols, ", "), s.table, where)
+	if err := s.db.QueryRowContext(ctx, sqlStr, arg).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("gid not found")
+		}
+		return nil, err
+	}
+	// 国家层的 GID_0 就是 ISO 3166-1 alpha-3
+	if level == 0 && d.ISO == "" {
+		d.ISO = GID
+	}
+	if name, ok := s.overrides.names[GID]; ok {
+		d.Name, d.Overridden = name, true
+	}
+	if a := s.overrides.byGID[GID]; a != nil {
+		d.Overridden = true
+	}
+	return d, nil
+}
+
+// 获取行政区域的 GADM 属性
+func (s *Server) handleDetails(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	include, err := s.parseInclude(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	item, err := s.detailsOf(r.Context(), code)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "details", err)
+		return
+	}
+	if len(include) > 0 {
+		item.Attributes = s.attributes.of(item.GID, include)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, DetailsRes{
+		Code: 200,
+		Msg:  "success",
+		Data: item,
+	})
+}
@@ -0,0 +1,149 @@
+// diag.go
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// 运行时诊断：生产环境的 CPU、分配热点直接在线上采样，不用在本地复现。
+// 设置 DEBUG_ADDR（如 127.0.0.1:6060）后在该地址另开一个监听，提供 net/http/pprof 和 expvar（/debug/vars）；
+// 未设置时不监听。不挂在对外的 ADDR 上，只应绑定本机或内网地址。
+// expvar 中除运行时自带的 memstats、cmdline 外，有各缓存的命中率和反查、各接口的耗时分布，
+// 计数为整个进程累计（多数据集、热更新前后的版本合计）
+var (
+	geomCacheStats     = publishCacheStats("geom_cache")
+	coarseCacheStats   = publishCacheStats("coarse_cache")
+	resultCacheStats   = publishCacheStats("result_cache")
+	childrenCacheStats = publishCacheStats("children_cache")
+	// 命中为点落在内部格子里，见 cells.go
+	cellIndexStats = publishCacheStats("cell_index")
+	// 本地 DEM 瓦片的块缓存，见 dem.go
+	demCacheStats = publishCacheStats("dem_cache")
+	// 未命中结果缓存的反查（候选查询、解码、点面判断）
+	reverseTiming = publishTiming("reverse")
+	httpTimings   = map[string]*timingStats{}
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("http", expvar.Func(func() any {
+		out := make(map[string]any, len(httpTimings))
+		for pattern, t := range httpTimings {
+			if t.count.Load() > 0 {
+				out[pattern] = t.snapshot()
+			}
+		}
+		return out
+	}))
+}
+
+type cacheStats struct {
+	hits, misses atomic.Int64
+}
+
+func publishCacheStats(name string) *cacheStats {
+	st := new(cacheStats)
+	expvar.Publish(name, expvar.Func(func() any {
+		hits, misses := st.hits.Load(), st.misses.Load()
+		rate := 0.0
+		if hits+misses > 0 {
+			rate = float64(hits) / float64(hits+misses)
+		}
+		return map[string]any{"hits": hits, "misses": misses, "hitRate": rate}
+	}))
+	return st
+}
+
+// 缓存关闭时 st 为 nil，不计数
+func (st *cacheStats) record(hit bool) {
+	switch {
+	case st == nil:
+	case hit:
+		st.hits.Add(1)
+	default:
+		st.misses.Add(1)
+	}
+}
+
+// 耗时分布：次数、平均值，以及不超过各档的累计次数
+var timingBuckets = [...]struct {
+	label string
+	max   time.Duration
+}{
+	{"le100us", 100 * time.Microsecond},
+	{"le1ms", time.Millisecond},
+	{"le10ms", 10 * time.Millisecond},
+	{"le100ms", 100 * time.Millisecond},
+	{"le1s", time.Second},
+}
+
+type timingStats struct {
+	count   atomic.Int64
+	totalUs atomic.Int64
+	buckets [len(timingBuckets)]atomic.Int64
+}
+
+func publishTiming(name string) *timingStats {
+	t := new(timingStats)
+	expvar.Publish(name, expvar.Func(func() any { return t.snapshot() }))
+	return t
+}
+
+func (t *timingStats) observe(d time.Duration) {
+	t.count.Add(1)
+	t.totalUs.Add(d.Microseconds())
+	for i, b := range timingBuckets {
+		if d <= b.max {
+			t.buckets[i].Add(1)
+		}
+	}
+}
+
+func (t *timingStats) snapshot() map[string]any {
+	count := t.count.Load()
+	out := map[string]any{"count": count, "meanUs": 0.0}
+	if count > 0 {
+		out["meanUs"] = float64(t.totalUs.Load()) / float64(count)
+	}
+	for i, b := range timingBuckets {
+		out[b.label] = t.buckets[i].Load()
+	}
+	return out
+}
+
+// 记录接口的耗时（流式响应为写完的时间），在开始监听前对所有路由调用
+func timed(pattern string, h http.HandlerFunc) http.HandlerFunc {
+	t := httpTimings[pattern]
+	if t == nil {
+		t = new(timingStats)
+		httpTimings[pattern] = t
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		defer func() { t.observe(time.Since(start)) }()
+		h(w, r)
+	}
+}
+
+// DEBUG_ADDR 上的 pprof 和 expvar；version 返回当前数据集版本
+func serveDiagnostics(addr string, version func() string) {
+	expvar.Publish("dataset_version", expvar.Func(func() any { return version() }))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	log.Println("diagnostics listening on http://" + addr + "/debug/pprof/")
+	go func() {
+		// 诊断端口出错不影响服务
+		log.Println("diagnostics error:", http.ListenAndServe(addr, mux))
+	}()
+}
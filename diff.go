@@ -0,0 +1,293 @@
+// diff.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// 一个只读的 GADM GeoPackage
+type dataset struct {
+	path    string
+	db      *sql.DB
+	table   string
+	geomCol string
+	rtree   string
+}
+
+func openDataset(path, table, geomCol string) (*dataset, error) {
+	dsn := fmt.Sprintf("file:%s?mode=ro&cache=shared&_busy_timeout=5000&immutable=1", path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+	if _, err := tableColumns(db, table); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &dataset{path: path, db: db, table: table, geomCol: geomCol, rtree: fmt.Sprintf("rtree_%s_%s", table, geomCol)}, nil
+}
+
+// 一个行政区的指纹：名称路径 + 所有叶子外接矩形的并集
+type areaFingerprint struct {
+	GID, Name, Parent string
+	namePath          string
+	bound             orb.Bound
+	leaves            int
+}
+
+func (d *dataset) areas(ctx context.Context, level int, country string) (map[string]*areaFingerprint, error) {
+	path := make([]string, 0, level+1)
+	for lvl := 0; lvl <= level; lvl++ {
+		path = append(path, fmt.Sprintf("LOWER(TRIM(a.NAME_%d))", lvl))
+	}
+	parentCol := "''"
+	if level > 0 {
+		parentCol = fmt.Sprintf("a.GID_%d", level-1)
+	}
+	sqlStr := fmt.Sprintf(`
+SELECT a.GID_%d, MAX(a.NAME_%d), MAX(%s), MAX(%s),
+       MIN(r.minx), MAX(r.maxx), MIN(r.miny), MAX(r.maxy), COUNT(*)
+FROM %s AS a
+JOIN %s AS r ON a.rowid = r.id
+WHERE a.GID_%d <> '' AND (? = '' OR a.GID_0 = ?)
+GROUP BY a.GID_%d;`,
+		level, level, parentCol, strings.Join(path, " || '/' || "), d.table, d.rtree, level, level)
+
+	rows, err := d.db.QueryContext(ctx, sqlStr, country, country)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]*areaFingerprint)
+	for rows.Next() {
+		var (
+			a            areaFingerprint
+			minx, maxx   float64
+			miny, maxy   float64
+			name, parent sql.NullString
+		)
+		if err := rows.Scan(&a.GID, &name, &parent, &a.namePath, &minx, &maxx, &miny, &maxy, &a.leaves); err != nil {
+			return nil, err
+		}
+		a.Name, a.Parent = name.String, parent.String
+		a.bound = orb.Bound{Min: orb.Point{minx, miny}, Max: orb.Point{maxx, maxy}}
+		out[a.GID] = &a
+	}
+	return out, rows.Err()
+}
+
+type DiffEntry struct {
+	// added | removed | renamed | recoded | boundary
+	Change  string `json:"change"`
+	Level   int    `json:"level"`
+	OldGID  string `json:"oldCode,omitempty"`
+	NewGID  string `json:"newCode,omitempty"`
+	OldName string `json:"oldName,omitempty"`
+	NewName string `json:"newName,omitempty"`
+	// 外接矩形最大的边移动量（度），仅 boundary / recoded
+	ShiftDeg float64 `json:"shiftDeg,omitempty"`
+}
+
+type DiffResult struct {
+	Summary map[string]int `json:"summary"`
+	List    []DiffEntry    `json:"list"`
+}
+
+type DiffRes struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data *DiffResult `json:"data"`
+}
+
+func boundShift(a, b orb.Bound) float64 {
+	return math.Max(
+		math.Max(math.Abs(a.Min.Lon()-b.Min.Lon()), math.Abs(a.Max.Lon()-b.Max.Lon())),
+		math.Max(math.Abs(a.Min.Lat()-b.Min.Lat()), math.Abs(a.Max.Lat()-b.Max.Lat())))
+}
+
+// 比较同一层级的两个版本：
+// GID 相同则比较名称和外接矩形；只在一边出现的 GID 再按名称路径配对，外接矩形相交的视为换了代码
+func diffLevel(oldAreas, newAreas map[string]*areaFingerprint, level int, tolDeg float64) []DiffEntry {
+	var out []DiffEntry
+	removed := make(map[string][]*areaFingerprint)
+	for gid, o := range oldAreas {
+		n, ok := newAreas[gid]
+		if !ok {
+			removed[o.namePath] = append(removed[o.namePath], o)
+			continue
+		}
+		if o.Name != n.Name {
+			out = append(out, DiffEntry{Change: "renamed", Level: level, OldGID: gid, NewGID: gid, OldName: o.Name, NewName: n.Name})
+		}
+		if d := boundShift(o.bound, n.bound); d > tolDeg {
+			out = append(out, DiffEntry{Change: "boundary", Level: level, OldGID: gid, NewGID: gid, OldName: o.Name, NewName: n.Name, ShiftDeg: roundShift(d)})
+		}
+	}
+
+	added := make([]*areaFingerprint, 0)
+	for gid, n := range newAreas {
+		if _, ok := oldAreas[gid]; !ok {
+			added = append(added, n)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].GID < added[j].GID })
+	for _, n := range added {
+		var match *areaFingerprint
+		cands := removed[n.namePath]
+		for i, o := range cands {
+			if o.bound.Intersects(n.bound) {
+				match = o
+				removed[n.namePath] = append(cands[:i:i], cands[i+1:]...)
+				break
+			}
+		}
+		if match == nil {
+			out = append(out, DiffEntry{Change: "added", Level: level, NewGID: n.GID, NewName: n.Name})
+			continue
+		}
+		out = append(out, DiffEntry{Change: "recoded", Level: level, OldGID: match.GID, NewGID: n.GID, OldName: match.Name, NewName: n.Name, ShiftDeg: roundShift(boundShift(match.bound, n.bound))})
+	}
+	for _, list := range removed {
+		for _, o := range list {
+			out = append(out, DiffEntry{Change: "removed", Level: level, OldGID: o.GID, OldName: o.Name})
+		}
+	}
+	return out
+}
+
+func roundShift(d float64) float64 {
+	return math.Round(d*1e6) / 1e6
+}
+
+/************* 两个数据集版本的差异 *************/
+func diffDatasets(ctx context.Context, oldDS, newDS *dataset, levels []int, country string, tolDeg float64) (*DiffResult, error) {
+	res := &DiffResult{Summary: make(map[string]int), List: make([]DiffEntry, 0)}
+	for _, lvl := range levels {
+		oldAreas, err := oldDS.areas(ctx, lvl, country)
+		if err != nil {
+			return nil, fmt.Errorf("old dataset: %w", err)
+		}
+		newAreas, err := newDS.areas(ctx, lvl, country)
+		if err != nil {
+			return nil, fmt.Errorf("new dataset: %w", err)
+		}
+		res.List = append(res.List, diffLevel(oldAreas, newAreas, lvl, tolDeg)...)
+	}
+	sort.SliceStable(res.List, func(i, j int) bool {
+		a, b := res.List[i], res.List[j]
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		return a.OldGID+a.NewGID < b.OldGID+b.NewGID
+	})
+	for _, e := range res.List {
+		res.Summary[e.Change]++
+	}
+	return res, nil
+}
+
+func parseDiffLevels(v string) ([]int, error) {
+	if strings.TrimSpace(v) == "" {
+		return []int{0, 1, 2, 3, 4, 5}, nil
+	}
+	lvl, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || lvl < 0 || lvl > 5 {
+		return nil, fmt.Errorf("invalid level, use 0..5")
+	}
+	return []int{lvl}, nil
+}
+
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	if s.prev == nil {
+		writeErrorJSON(w, http.StatusServiceUnavailable, 503, "previous dataset not configured, set GPKG_PREV_PATH")
+		return
+	}
+	q := r.URL.Query()
+	levels, err := parseDiffLevels(q.Get("level"))
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	country := strings.ToUpper(strings.TrimSpace(q.Get("country")))
+	tol := queryFloat(r, "tolerance", 0.0001, 0, 1)
+	page := parsePage(r, 10000)
+
+	cur := &dataset{db: s.db, table: s.table, geomCol: s.geomCol, rtree: s.rtreeTable}
+	res, err := diffDatasets(r.Context(), s.prev, cur, levels, country, tol)
+	if err != nil {
+		writeQueryError(w, "diff", err)
+		return
+	}
+	res.List = pageSlice(res.List, page)
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, DiffRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
+
+// 命令行：gpkg-reverse diff -old gadm36.gpkg -new gadm_410.gpkg [-level 2] [-country IDN] > diff.csv
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	oldPath := fs.String("old", "", "old GeoPackage path")
+	oldTable := fs.String("old-table", env("GPKG_PREV_TABLE", "gadm"), "old table name")
+	newPath := fs.String("new", env("GPKG_PATH", "data/gadm_410.gpkg"), "new GeoPackage path")
+	newTable := fs.String("new-table", env("GPKG_TABLE", "gadm_410"), "new table name")
+	geomCol := fs.String("geom", env("GPKG_GEOM_COL", "geom"), "geometry column")
+	level := fs.String("level", "", "only compare this level (0..5)")
+	country := fs.String("country", "", "only compare this GID_0")
+	tol := fs.Float64("tolerance", 0.0001, "bbox shift in degrees reported as boundary change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *oldPath == "" {
+		return fmt.Errorf("-old required")
+	}
+	levels, err := parseDiffLevels(*level)
+	if err != nil {
+		return err
+	}
+	oldDS, err := openDataset(*oldPath, *oldTable, *geomCol)
+	if err != nil {
+		return err
+	}
+	defer oldDS.db.Close()
+	newDS, err := openDataset(*newPath, *newTable, *geomCol)
+	if err != nil {
+		return err
+	}
+	defer newDS.db.Close()
+
+	res, err := diffDatasets(context.Background(), oldDS, newDS, levels, strings.ToUpper(*country), *tol)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(os.Stdout)
+	_ = cw.Write([]string{"change", "level", "old_code", "new_code", "old_name", "new_name", "shift_deg"})
+	for _, e := range res.List {
+		_ = cw.Write([]string{e.Change, strconv.Itoa(e.Level), e.OldGID, e.NewGID, e.OldName, e.NewName, strconv.FormatFloat(e.ShiftDeg, 'f', -1, 64)})
+	}
+	cw.Flush()
+	for _, k := range []string{"added", "removed", "renamed", "recoded", "boundary"} {
+		log.Printf("%s: %d", k, res.Summary[k])
+	}
+	return cw.Error()
+}
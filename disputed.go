@@ -0,0 +1,169 @@
+// disputed.go
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 争议地区的视角：产品在不同法域上线，同一块争议地区要按当地的法律要求归属不同的国家，
+// 类似地图服务商的 worldview / region 参数。GADM 把争议地区单独编码（如 Z01…Z09），
+// 即 worldwide 视角；DISPUTED_VIEWS_PATH 为 CSV，每行一条 view,gid,claimant：
+//
+//	view,gid,claimant
+//	IN,Z01,IND
+//	CN,Z01,CHN
+//
+// 在 view 视角下，层级链中的 gid 及其上级换成 claimant 的层级链，gid 以下的层级不变；
+// claimant 与 gid 须在同一层级。?view= 选择视角，默认为 DISPUTED_VIEW（未设置时为 worldwide）
+const worldwideView = "worldwide"
+
+// claimant 在各名称模式下的层级链
+type disputedClaim struct {
+	chain [nameModes][]ChildrenItem
+}
+
+type disputedViews struct {
+	def      string
+	views    map[string]map[string]*disputedClaim // view → 争议地区 GID
+	disputed map[string]bool                      // 任一视角中出现的争议地区
+}
+
+func viewName(v string) string {
+	v = strings.TrimSpace(v)
+	if strings.EqualFold(v, worldwideView) {
+		return worldwideView
+	}
+	return strings.ToUpper(v)
+}
+
+func (s *Server) loadDisputedViews(path, def string) (*disputedViews, error) {
+	dv := &disputedViews{
+		def:      viewName(def),
+		views:    make(map[string]map[string]*disputedClaim),
+		disputed: make(map[string]bool),
+	}
+	if dv.def == "" {
+		dv.def = worldwideView
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := s.readDisputedViews(dv, f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if _, ok := dv.views[dv.def]; !ok && dv.def != worldwideView {
+		return nil, fmt.Errorf("DISPUTED_VIEW %s not in DISPUTED_VIEWS_PATH", dv.def)
+	}
+	return dv, nil
+}
+
+func (s *Server) readDisputedViews(dv *disputedViews, r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 && strings.EqualFold(strings.TrimPrefix(rows[0][0], "\ufeff"), "view") {
+		rows = rows[1:]
+	}
+	for i, row := range rows {
+		view, gid, claimant := viewName(row[0]), strings.TrimSpace(row[1]), strings.TrimSpace(row[2])
+		if view == "" || view == worldwideView {
+			return fmt.Errorf("line %d: invalid view %q", i+2, row[0])
+		}
+		level, err := s.detectLevel(context.Background(), gid)
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", i+2, gid, err)
+		}
+		claim := &disputedClaim{}
+		where, arg := s.gidWhere(claimant, level)
+		for mode := nameMode(0); mode < nameModes; mode++ {
+			sqlStr := fmt.Sprintf(`SELECT %s FROM %s WHERE %s LIMIT 1`, s.pathColumns(level, mode), s.table, where)
+			if claim.chain[mode], err = scanPath(s.db.QueryRow(sqlStr, arg), level); err != nil {
+				return fmt.Errorf("line %d: claimant %s not found at level %d of %s", i+2, claimant, level, gid)
+			}
+		}
+		if dv.views[view] == nil {
+			dv.views[view] = make(map[string]*disputedClaim)
+		}
+		if _, dup := dv.views[view][gid]; dup {
+			return fmt.Errorf("line %d: %s listed twice for view %s", i+2, gid, view)
+		}
+		dv.views[view][gid] = claim
+		dv.disputed[gid] = true
+	}
+	return nil
+}
+
+// ?view=，未指定时为默认视角
+func (dv *disputedViews) parse(r *http.Request) (string, error) {
+	view := viewName(r.URL.Query().Get("view"))
+	if view == "" {
+		return dv.def, nil
+	}
+	if _, ok := dv.views[view]; !ok && view != worldwideView {
+		return "", fmt.Errorf("invalid view, use %s", strings.Join(dv.names(), ", "))
+	}
+	return view, nil
+}
+
+func (dv *disputedViews) names() []string {
+	names := make([]string, 0, len(dv.views)+1)
+	for view := range dv.views {
+		names = append(names, view)
+	}
+	sort.Strings(names)
+	return append([]string{worldwideView}, names...)
+}
+
+// 按视角改写反查结果的层级链，结果中含争议地区时标记 disputed
+func (dv *disputedViews) apply(a *AdminLevels, view string, mode nameMode) {
+	if len(dv.disputed) == 0 {
+		return
+	}
+	gids := []*string{&a.GID0, &a.GID1, &a.GID2, &a.GID3, &a.GID4, &a.GID5}
+	names := []*string{&a.Name0, &a.Name1, &a.Name2, &a.Name3, &a.Name4, &a.Name5}
+	rules := dv.views[view]
+	// 从最深的层级往上找，越深的规则越具体
+	for lvl := 5; lvl >= 0; lvl-- {
+		gid := *gids[lvl]
+		if gid == "" || !dv.disputed[gid] {
+			continue
+		}
+		a.Disputed = true
+		claim := rules[gid]
+		if claim == nil {
+			continue
+		}
+		for i, item := range claim.chain[mode] {
+			*gids[i], *names[i] = item.GID, item.Name
+		}
+		g := make([]string, len(gids))
+		n := make([]string, len(names))
+		for i := range gids {
+			g[i], n[i] = *gids[i], *names[i]
+		}
+		flags := make(map[string]bool)
+		for _, item := range a.List {
+			flags[item.GID] = item.Overridden
+		}
+		a.List = chainOf(g, n)
+		for i := range a.List {
+			a.List[i].Overridden = flags[a.List[i].GID]
+		}
+		return
+	}
+}
@@ -0,0 +1,285 @@
+// elevation.go
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// 批量海拔：POST /elevation/batch 一次查多个行政区（按中心点）或任意坐标。
+// 行政区先读海拔缓存，其余的与坐标一起按批请求海拔来源（谷歌一次最多 512 个点，见 warm.go），
+// 行政区的结果写入缓存；坐标不缓存。原先每个中心点都要单独请求一次谷歌
+const maxElevationItems = 5000
+
+// 接口是公开的，谷歌按点计费，限制未缓存的点（行政区和坐标都算）：
+//
+//	ELEVATION_BATCH_MAX_FETCH  不带 Authorization: Bearer ADMIN_TOKEN 时每次请求最多查询海拔来源的点数，
+//	                           超过时整个请求返回 403、不查询；谷歌默认 100，本地 DEM 默认 0 不限
+//	ELEVATION_BATCH_RATE       所有请求合计每秒最多请求海拔来源几次（每次最多 512 个点），超过时排队等待；
+//	                           谷歌默认 1，本地 DEM 默认 0 不限
+type elevationLimit struct {
+	maxFetch   int
+	adminToken string
+	// 两次请求的最小间隔，0 不限
+	every time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// 多个数据集（GPKG_DIR）和热更新前后共用，速率按整个进程计算
+var loadElevationLimit = sync.OnceValues(func() (*elevationLimit, error) {
+	src, err := loadElevationSource()
+	if err != nil {
+		return nil, err
+	}
+	defaultMax, defaultRate := "0", "0"
+	if _, ok := src.(googleElevation); ok {
+		defaultMax, defaultRate = "100", "1"
+	}
+	l := &elevationLimit{adminToken: env("ADMIN_TOKEN", "")}
+	if l.maxFetch, err = strconv.Atoi(env("ELEVATION_BATCH_MAX_FETCH", defaultMax)); err != nil || l.maxFetch < 0 {
+		return nil, fmt.Errorf("invalid ELEVATION_BATCH_MAX_FETCH")
+	}
+	rate, err := strconv.ParseFloat(env("ELEVATION_BATCH_RATE", defaultRate), 64)
+	if err != nil || rate < 0 {
+		return nil, fmt.Errorf("invalid ELEVATION_BATCH_RATE")
+	}
+	if rate > 0 {
+		l.every = time.Duration(float64(time.Second) / rate)
+	}
+	return l, nil
+})
+
+// 该请求最多查询的点数，0 不限
+func (l *elevationLimit) maxFetchFor(r *http.Request) int {
+	if l.adminToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(l.adminToken)) == 1 {
+			return 0
+		}
+	}
+	return l.maxFetch
+}
+
+// 等到可以再请求一次海拔来源
+func (l *elevationLimit) wait(ctx context.Context) error {
+	if l.every <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.every)
+	l.mu.Unlock()
+	if !at.After(now) {
+		return nil
+	}
+	t := time.NewTimer(at.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type ElevationPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type ElevationBatchRequest struct {
+	Codes  []string         `json:"codes"`
+	Points []ElevationPoint `json:"points"`
+}
+
+type ElevationItem struct {
+	// 按坐标查询时为空
+	GID       string  `json:"code,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// 没有数据（本地 DEM 未覆盖）时为 null
+	Elevation *float64 `json:"elevation"`
+	Cached    bool     `json:"cached"`
+}
+
+type ElevationBatchResult struct {
+	// 行政区按请求顺序，重复的只返回一次
+	List []ElevationItem `json:"list"`
+	// 与请求中的 points 一一对应
+	Points []ElevationItem `json:"points"`
+	// 找不到的 code，按请求顺序
+	Missing []string `json:"missing"`
+	// 本次请求海拔来源的次数
+	Requests int `json:"requests"`
+}
+
+type ElevationBatchRes struct {
+	Code int                   `json:"code"`
+	Msg  string                `json:"msg"`
+	Data *ElevationBatchResult `json:"data"`
+}
+
+// 海拔来源出错；此前各批已查到的行政区海拔已写入缓存
+var errElevationProvider = errors.New("elevation provider error")
+
+// 未缓存的点超过 ELEVATION_BATCH_MAX_FETCH
+var errElevationFetchLimit = errors.New("too many uncached elevations")
+
+// maxFetch 为最多查询海拔来源的点数，0 不限
+func (s *Server) elevationBatch(ctx context.Context, codes []string, points []ElevationPoint, maxFetch int) (*ElevationBatchResult, error) {
+	res := &ElevationBatchResult{
+		List:    make([]ElevationItem, 0, len(codes)),
+		Points:  make([]ElevationItem, len(points)),
+		Missing: make([]string, 0),
+	}
+	// 待查询的项，指向 res 中的位置
+	var pending []*ElevationItem
+
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		item, err := s.latlngOf(ctx, code)
+		if err != nil {
+			if strings.Contains(err.Error(), "gid not found") {
+				res.Missing = append(res.Missing, code)
+				continue
+			}
+			return nil, err
+		}
+		// HASC 等别名解析后可能与前面的重复
+		if item.GID != code {
+			if seen[item.GID] {
+				continue
+			}
+			seen[item.GID] = true
+		}
+		res.List = append(res.List, ElevationItem{GID: item.GID, Latitude: item.Latitude, Longitude: item.Longitude})
+	}
+	for i := range res.List {
+		it := &res.List[i]
+		elevation, err := s.getElevation(it.GID)
+		if err == nil {
+			it.Elevation, it.Cached = &elevation, true
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		pending = append(pending, it)
+	}
+	for i, p := range points {
+		res.Points[i] = ElevationItem{Latitude: p.Latitude, Longitude: p.Longitude}
+		pending = append(pending, &res.Points[i])
+	}
+	if maxFetch > 0 && len(pending) > maxFetch {
+		return nil, fmt.Errorf("%w: %d, at most %d without ADMIN_TOKEN", errElevationFetchLimit, len(pending), maxFetch)
+	}
+
+	for start := 0; start < len(pending); start += maxElevationBatch {
+		chunk := pending[start:min(start+maxElevationBatch, len(pending))]
+		pts := make([]orb.Point, len(chunk))
+		for i, it := range chunk {
+			pts[i] = orb.Point{it.Longitude, it.Latitude}
+		}
+		if err := s.elevationLimit.wait(ctx); err != nil {
+			return nil, err
+		}
+		elevations, err := s.elevationSrc.elevations(pts)
+		res.Requests++
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errElevationProvider, err)
+		}
+		if err := s.saveElevations(chunk, elevations); err != nil {
+			return nil, err
+		}
+		for i, it := range chunk {
+			if !math.IsNaN(elevations[i]) {
+				it.Elevation = &elevations[i]
+			}
+		}
+	}
+	return res, nil
+}
+
+// 一批行政区的海拔写入缓存（坐标和没有数据的跳过）
+func (s *Server) saveElevations(items []*ElevationItem, elevations []float64) error {
+	tx, err := s.elevationDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, it := range items {
+		if it.GID == "" || math.IsNaN(elevations[i]) {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO elevations (gid, elevation) VALUES (?, ?);`, it.GID, elevations[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Server) handleElevationBatch(w http.ResponseWriter, r *http.Request) {
+	var req ElevationBatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid json body")
+		return
+	}
+	if len(req.Codes) == 0 && len(req.Points) == 0 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "codes or points required")
+		return
+	}
+	if len(req.Codes)+len(req.Points) > maxElevationItems {
+		writeErrorJSON(w, http.StatusBadRequest, 400, fmt.Sprintf("too many codes and points, max %d", maxElevationItems))
+		return
+	}
+	for i, p := range req.Points {
+		if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+			writeErrorJSON(w, http.StatusBadRequest, 400, fmt.Sprintf("points[%d]: lat/lon out of range", i))
+			return
+		}
+	}
+
+	res, err := s.elevationBatch(r.Context(), req.Codes, req.Points, s.elevationLimit.maxFetchFor(r))
+	if err != nil {
+		if errors.Is(err, errElevationFetchLimit) {
+			writeErrorJSON(w, http.StatusForbidden, 403, err.Error())
+			return
+		}
+		if errors.Is(err, errElevationProvider) {
+			log.Println("elevation batch error:", err)
+			writeErrorJSON(w, http.StatusBadGateway, 502, errElevationProvider.Error())
+			return
+		}
+		writeQueryError(w, "elevation batch", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ElevationBatchRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
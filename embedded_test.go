@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// 内置示例数据（GPKG_PATH=embedded，见 embedded.go）：
+// XAA.2.2_1 中间有湖 [-28.7,-28.3]×[1.3,1.7]；XBB.1.1_1 在 [-26.6,-26.2]×[0.8,1.2] 有离岛，与本岛隔着海峡
+func openEmbedded(t *testing.T, built bool) *Server {
+	t.Helper()
+	path, err := prepareEmbedded("gadm_410")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built {
+		out := filepath.Join(t.TempDir(), "embedded.sqlite")
+		if err := buildRuntime(path, out, "gadm_410", "geom", defaultInlineTolerance, nil, defaultCrosswalkSamples, 12); err != nil {
+			t.Fatal(err)
+		}
+		path = out
+	}
+	return openTestServer(t, datasetConfig{path: path, kind: embeddedPath, table: "gadm_410", geomCol: "geom"})
+}
+
+func openTestServer(t *testing.T, cfg datasetConfig) *Server {
+	t.Helper()
+	elevationDB, err := openElevationDB(filepath.Join(t.TempDir(), "elevations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := openServer(cfg, elevationDB, nil)
+	if err != nil {
+		elevationDB.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.close()
+		elevationDB.Close()
+	})
+	return s
+}
+
+// 经 apiRoutes 注册的路由请求一次，返回状态码和 data
+func getJSON(t *testing.T, s *Server, url string, data any) int {
+	t.Helper()
+	mux := http.NewServeMux()
+	for _, rt := range s.apiRoutes() {
+		mux.HandleFunc(rt.Pattern, rt.Handler)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	var body struct {
+		Code int             `json:"code"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s: %v: %s", url, err, rec.Body.String())
+	}
+	if rec.Code == http.StatusOK && data != nil {
+		if err := json.Unmarshal(body.Data, data); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestEmbeddedReverse(t *testing.T) {
+	cases := []struct {
+		name     string
+		lat, lon float64
+		// 空为找不到（404）
+		leaf string
+	}{
+		{"interior", 0.5, -29.5, "XAA.1.1_1"},
+		{"beside the lake", 1.5, -28.9, "XAA.2.2_1"},
+		{"in the lake", 1.5, -28.5, ""},
+		{"lake shore inside", 1.5, -28.71, "XAA.2.2_1"},
+		{"offshore island", 1.0, -26.4, "XBB.1.1_1"},
+		{"strait", 1.0, -26.1, ""},
+		{"mainland", 1.0, -25.7, "XBB.1.1_1"},
+		{"between countries", 1.0, -27.0, ""},
+		{"third district", 1.7, -24.5, "XBB.2.3_1"},
+	}
+	for _, built := range []bool{false, true} {
+		s := openEmbedded(t, built)
+		for _, c := range cases {
+			var res AdminLevels
+			code := getJSON(t, s, fmt.Sprintf("/reverse?latitude=%v&longitude=%v", c.lat, c.lon), &res)
+			switch {
+			case c.leaf == "" && code != http.StatusNotFound:
+				t.Errorf("built=%v %s: status %d, want 404", built, c.name, code)
+			case c.leaf != "" && (code != http.StatusOK || res.GID2 != c.leaf):
+				t.Errorf("built=%v %s: status %d, leaf %q, want %s", built, c.name, code, res.GID2, c.leaf)
+			}
+			if c.leaf == "" {
+				continue
+			}
+			// ?level= 只到上一层，结果是完整结果的截断（预处理的库走按层反查，见 levelreverse.go）
+			var top AdminLevels
+			if code := getJSON(t, s, fmt.Sprintf("/reverse?latitude=%v&longitude=%v&level=1", c.lat, c.lon), &top); code != http.StatusOK {
+				t.Errorf("built=%v %s level=1: status %d", built, c.name, code)
+			} else if top.GID1 != res.GID1 || top.GID0 != res.GID0 || top.GID2 != "" {
+				t.Errorf("built=%v %s level=1: %s/%s/%s, want %s/%s", built, c.name, top.GID0, top.GID1, top.GID2, res.GID0, res.GID1)
+			}
+		}
+	}
+}
+
+func TestEmbeddedChildren(t *testing.T) {
+	for _, built := range []bool{false, true} {
+		s := openEmbedded(t, built)
+		for parent, want := range map[string][]string{
+			"XAA":     {"XAA.1_1", "XAA.2_1"},
+			"XAA.2_1": {"XAA.2.1_1", "XAA.2.2_1"},
+			"XBB.2_1": {"XBB.2.1_1", "XBB.2.2_1", "XBB.2.3_1"},
+		} {
+			var res ChildrenItemList
+			if code := getJSON(t, s, "/children?parent_code="+parent, &res); code != http.StatusOK {
+				t.Errorf("built=%v %s: status %d", built, parent, code)
+				continue
+			}
+			var got []string
+			for _, item := range res.List {
+				got = append(got, item.GID)
+				if item.ParentCode != parent {
+					t.Errorf("built=%v %s: child %s has parent %s", built, parent, item.GID, item.ParentCode)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("built=%v %s: children %v, want %v", built, parent, got, want)
+			}
+		}
+	}
+}
+
+func TestEmbeddedLatlng(t *testing.T) {
+	for _, built := range []bool{false, true} {
+		s := openEmbedded(t, built)
+		for _, code := range []string{"XAA.2.2_1", "XBB.1.1_1", "XBB.1_1", "XBB"} {
+			var item LatlngItem
+			if status := getJSON(t, s, "/latlng?code="+code, &item); status != http.StatusOK {
+				t.Errorf("built=%v %s: status %d", built, code, status)
+				continue
+			}
+			if item.GID != code || item.Pole == nil {
+				t.Errorf("built=%v %s: got %+v", built, code, item)
+				continue
+			}
+			// 不可达极点一定在区域内：不在湖里、不在海峡里
+			res, err := s.reverse(context.Background(), item.Pole.Longitude, item.Pole.Latitude, nameLatin)
+			if err != nil {
+				t.Errorf("built=%v %s: pole %+v: %v", built, code, item.Pole, err)
+				continue
+			}
+			if !slices.ContainsFunc(res.List, func(c ChildrenItem) bool { return c.GID == code }) {
+				t.Errorf("built=%v %s: pole %+v falls in %s", built, code, item.Pole, res.List[len(res.List)-1].GID)
+			}
+		}
+		if status := getJSON(t, s, "/latlng?code=XCC.1_1", nil); status != http.StatusNotFound {
+			t.Errorf("built=%v unknown code: status %d, want 404", built, status)
+		}
+	}
+}
+
+// 测试点：覆盖整个数据集的网格，加上每条边两侧、离边很近（落在两段判断的容差带内外）的点
+func embeddedProbePoints() []orb.Point {
+	var pts []orb.Point
+	for lon := -30.2; lon <= -23.8; lon += 0.05 {
+		for lat := -0.2; lat <= 2.2; lat += 0.05 {
+			pts = append(pts, orb.Point{lon, lat})
+		}
+	}
+	xs := []float64{-30, -29, -28.7, -28.3, -28, -26.6, -26.2, -26, -25.5, -25, -24}
+	ys := []float64{0, 0.7, 0.8, 1, 1.2, 1.3, 1.4, 1.7, 2}
+	for _, d := range []float64{-0.0002, -0.00005, 0.00005, 0.0002} {
+		for _, x := range xs {
+			for lat := 0.05; lat < 2; lat += 0.1 {
+				pts = append(pts, orb.Point{x + d, lat})
+			}
+		}
+		for _, y := range ys {
+			for lon := -29.95; lon < -24; lon += 0.1 {
+				pts = append(pts, orb.Point{lon, y + d})
+			}
+		}
+	}
+	return pts
+}
+
+func leafAt(t *testing.T, s *Server, pt orb.Point) string {
+	t.Helper()
+	res, err := s.reverse(context.Background(), pt[0], pt[1], nameLatin)
+	if err != nil {
+		return ""
+	}
+	return res.List[len(res.List)-1].GID
+}
+
+// 预处理的库先用简化几何判断（容差带外直接采用）、再用格子索引，结果必须与直接用原始几何判断相同
+func TestBuiltMatchesRaw(t *testing.T) {
+	raw, built := openEmbedded(t, false), openEmbedded(t, true)
+	if built.coarseTolerance == 0 {
+		t.Fatal("built dataset has no coarse geometry")
+	}
+	if len(built.cells) == 0 {
+		t.Fatal("built dataset has no cell index")
+	}
+	for _, pt := range embeddedProbePoints() {
+		if got, want := leafAt(t, built, pt), leafAt(t, raw, pt); got != want {
+			t.Errorf("%v: built %q, raw %q", pt, got, want)
+		}
+	}
+}
+
+func TestCellIndexHits(t *testing.T) {
+	s := openEmbedded(t, true)
+	ctx := context.Background()
+	hits := 0
+	for _, pt := range embeddedProbePoints() {
+		c, err := s.cellCandidate(ctx, nameLatin, pt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c == nil {
+			continue
+		}
+		hits++
+		if !c.contains(pt) {
+			t.Errorf("%v: cell hit %s does not contain the point", pt, c.gids[2])
+		}
+		if want := leafAt(t, s, pt); c.gids[2] != want {
+			t.Errorf("%v: cell hit %s, reverse %s", pt, c.gids[2], want)
+		}
+	}
+	if hits == 0 {
+		t.Error("no cell index hits")
+	}
+	// 湖里、海峡里和贴着边界的点不在任何内部格子中
+	for _, pt := range []orb.Point{{-28.5, 1.5}, {-26.1, 1.0}, {-28.99995, 0.5}, {-28.70005, 1.5}} {
+		if _, ok := s.cells.lookup(pt); ok {
+			t.Errorf("%v: unexpected cell index hit", pt)
+		}
+	}
+}
+
+// 一组同心正方形叶子，外接矩形从小到大排序后第一个包含该点的是最内层的那个
+func nestedSquares(n int) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for k := 0; k < n; k++ {
+		h := 5 - 0.1*float64(k)
+		f := geojson.NewFeature(orb.Polygon{{{5 - h, 5 - h}, {5 + h, 5 - h}, {5 + h, 5 + h}, {5 - h, 5 + h}, {5 - h, 5 - h}}})
+		f.Properties["GID_0"] = "XCC"
+		f.Properties["NAME_0"] = "Nested"
+		f.Properties["GID_1"] = fmt.Sprintf("XCC.%d_1", k+1)
+		f.Properties["NAME_1"] = fmt.Sprintf("Square %d", k+1)
+		fc.Append(f)
+	}
+	return fc
+}
+
+// 并行判断候选时，结果仍是按外接矩形从小到大的第一个命中，与逐个判断相同
+func TestParallelFirstHit(t *testing.T) {
+	const n = 40
+	props := map[string]string{"GID_0": "GID_0", "NAME_0": "NAME_0", "GID_1": "GID_1", "NAME_1": "NAME_1"}
+	features, _, err := importFeatures(nestedSquares(n), props, nil, "", "EPSG:4326")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves, _ := importLeaves(features)
+	path := filepath.Join(t.TempDir(), "nested.gpkg")
+	if err := writeImport(path, "gadm_410", leaves, []string{"GID_0", "NAME_0", "GID_1", "NAME_1"}); err != nil {
+		t.Fatal(err)
+	}
+	s := openTestServer(t, datasetConfig{path: path, kind: "gadm", table: "gadm_410", geomCol: "geom"})
+
+	ctx := context.Background()
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		pt := orb.Point{rnd.Float64() * 10, rnd.Float64() * 10}
+		// 最内层包含该点的正方形：半边长 5-0.1k 大于点到中心的切比雪夫距离
+		d := math.Max(math.Abs(pt[0]-5), math.Abs(pt[1]-5))
+		k := -1
+		for j := 0; j < n; j++ {
+			if 5-0.1*float64(j) > d {
+				k = j
+			}
+		}
+		want := ""
+		if k >= 0 {
+			want = fmt.Sprintf("XCC.%d_1", k+1)
+		}
+		for _, workers := range []int{1, 8} {
+			s.workers = workers
+			c, err := s.firstContaining(ctx, nameLatin, pt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if c != nil {
+				got = c.gids[1]
+			}
+			if got != want {
+				t.Errorf("%v workers=%d: %q, want %q", pt, workers, got, want)
+			}
+		}
+	}
+}
+
+// /topojson 和 /export 的每个区域是合并后的轮廓：相邻叶子之间的边界不再出现，离岛仍是单独的多边形
+func TestDissolvedAreas(t *testing.T) {
+	s := openEmbedded(t, false)
+	want := map[string]int{"XAA.1_1": 1, "XAA.2_1": 1, "XBB.1_1": 2, "XBB.2_1": 1}
+	for _, code := range []string{"XAA", "XBB"} {
+		areas, err := s.areasAtLevel(context.Background(), code, 1, nameLatin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range areas {
+			if got := len(a.polys); got != want[a.item.GID] {
+				t.Errorf("topojson %s: %d polygons, want %d", a.item.GID, got, want[a.item.GID])
+			}
+		}
+		exported, err := s.exportAreas(context.Background(), code, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range exported {
+			if got := len(a.geom); got != want[a.gids[1]] {
+				t.Errorf("export %s: %d polygons, want %d", a.gids[1], got, want[a.gids[1]])
+			}
+		}
+	}
+}
@@ -0,0 +1,481 @@
+// export.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+)
+
+// 导出的一个行政区：GID_0..GID_N / NAME_0..NAME_N 与其合并后的多边形
+type exportArea struct {
+	gids  []string
+	names []string
+	geom  orb.MultiPolygon
+}
+
+// code 子树中第 level 层（可以就是 code 所在层）的所有行政区，按 GID 排序
+func (s *Server) exportAreas(ctx context.Context, code string, level int) ([]exportArea, error) {
+	codeLevel, err := s.detectLevel(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if level < codeLevel {
+		return nil, fmt.Errorf("level must not be above the level of code (%d)", codeLevel)
+	}
+	cols := make([]string, 0, 2*(level+1)+1)
+	for i := 0; i <= level; i++ {
+		cols = append(cols, fmt.Sprintf("IFNULL(GID_%d, '')", i))
+	}
+	for i := 0; i <= level; i++ {
+		cols = append(cols, fmt.Sprintf("IFNULL(NAME_%d, '')", i))
+	}
+	cols = append(cols, s.geomCol)
+	sqlStr := fmt.Sprintf(`
+SELECT %s
+FROM %s
+WHERE GID_%d = ? AND GID_%d <> ''
+ORDER BY GID_%d;`,
+		strings.Join(cols, ", "), s.table, codeLevel, level, level)
+
+	rows, err := s.db.QueryContext(ctx, sqlStr, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []exportArea
+	for rows.Next() {
+		vals := make([]string, 2*(level+1))
+		var blob []byte
+		dest := make([]any, 0, len(vals)+1)
+		for i := range vals {
+			dest = append(dest, &vals[i])
+		}
+		dest = append(dest, &blob)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		gids, names := vals[:level+1], vals[level+1:]
+		if n := len(out); n == 0 || out[n-1].gids[level] != gids[level] {
+			out = append(out, exportArea{gids: gids, names: names})
+		}
+		wkbBytes, _, err := gpkgToWKB(blob)
+		if err != nil {
+			continue
+		}
+		mp, err := decodeMultiPolygon(wkbBytes)
+		if err != nil {
+			continue
+		}
+		out[len(out)-1].geom = append(out[len(out)-1].geom, mp...)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// 叶子之间共用的边使 MultiPolygon 不合法，先合并（见 dissolve）；合并失败时保留各叶子
+	for i := range out {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		mp, _, err := dissolve(out[i].geom)
+		if err != nil {
+			log.Printf("export: dissolve %s: %v, leaves kept separate", out[i].gids[level], err)
+			continue
+		}
+		out[i].geom = mp
+	}
+	return out, nil
+}
+
+func exportColumns(level int) []string {
+	cols := make([]string, 0, 2*(level+1))
+	for i := 0; i <= level; i++ {
+		cols = append(cols, fmt.Sprintf("GID_%d", i))
+	}
+	for i := 0; i <= level; i++ {
+		cols = append(cols, fmt.Sprintf("NAME_%d", i))
+	}
+	return cols
+}
+
+func (a exportArea) values() []string {
+	return append(append([]string{}, a.gids...), a.names...)
+}
+
+/************* Shapefile（zip） *************/
+
+const wgs84PRJ = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// 100 字节的 .shp / .shx 文件头，长度单位为 16 位字
+func shpHeader(fileBytes int, b orb.Bound) []byte {
+	h := make([]byte, 100)
+	binary.BigEndian.PutUint32(h[0:], 9994)
+	binary.BigEndian.PutUint32(h[24:], uint32(fileBytes/2))
+	binary.LittleEndian.PutUint32(h[28:], 1000)
+	binary.LittleEndian.PutUint32(h[32:], 5) // Polygon
+	for i, v := range []float64{b.Min[0], b.Min[1], b.Max[0], b.Max[1]} {
+		binary.LittleEndian.PutUint64(h[36+8*i:], math.Float64bits(v))
+	}
+	return h
+}
+
+// Shapefile 要求外环顺时针、洞逆时针
+func shpPolygon(mp orb.MultiPolygon) []byte {
+	var rings []orb.Ring
+	for _, poly := range mp {
+		for i, ring := range poly {
+			want := orb.CW
+			if i > 0 {
+				want = orb.CCW
+			}
+			if ring.Orientation() != want {
+				ring = ring.Clone()
+				ring.Reverse()
+			}
+			rings = append(rings, ring)
+		}
+	}
+	nPoints := 0
+	for _, r := range rings {
+		nPoints += len(r)
+	}
+	b := mp.Bound()
+	buf := make([]byte, 44+4*len(rings)+16*nPoints)
+	binary.LittleEndian.PutUint32(buf[0:], 5)
+	for i, v := range []float64{b.Min[0], b.Min[1], b.Max[0], b.Max[1]} {
+		binary.LittleEndian.PutUint64(buf[4+8*i:], math.Float64bits(v))
+	}
+	binary.LittleEndian.PutUint32(buf[36:], uint32(len(rings)))
+	binary.LittleEndian.PutUint32(buf[40:], uint32(nPoints))
+	off, start := 44+4*len(rings), 0
+	for i, r := range rings {
+		binary.LittleEndian.PutUint32(buf[44+4*i:], uint32(start))
+		start += len(r)
+		for _, p := range r {
+			binary.LittleEndian.PutUint64(buf[off:], math.Float64bits(p[0]))
+			binary.LittleEndian.PutUint64(buf[off+8:], math.Float64bits(p[1]))
+			off += 16
+		}
+	}
+	return buf
+}
+
+// 按字节截断且不切断 UTF-8 字符
+func truncateUTF8(v string, n int) string {
+	if len(v) <= n {
+		return v
+	}
+	for n > 0 && !utf8.RuneStart(v[n]) {
+		n--
+	}
+	return v[:n]
+}
+
+// dBASE III 属性表，全部为字符字段，UTF-8 编码（见 .cpg）
+func writeDBF(w io.Writer, fields []string, records [][]string) error {
+	widths := make([]int, len(fields))
+	for i := range fields {
+		widths[i] = 1
+		for _, rec := range records {
+			if n := len(rec[i]); n > widths[i] {
+				widths[i] = min(n, 254)
+			}
+		}
+	}
+	recordLen := 1
+	for _, n := range widths {
+		recordLen += n
+	}
+	headerLen := 32 + 32*len(fields) + 1
+
+	now := time.Now()
+	h := make([]byte, 32)
+	h[0] = 0x03
+	h[1], h[2], h[3] = byte(now.Year()-1900), byte(now.Month()), byte(now.Day())
+	binary.LittleEndian.PutUint32(h[4:], uint32(len(records)))
+	binary.LittleEndian.PutUint16(h[8:], uint16(headerLen))
+	binary.LittleEndian.PutUint16(h[10:], uint16(recordLen))
+	var buf bytes.Buffer
+	buf.Write(h)
+	for i, name := range fields {
+		fd := make([]byte, 32)
+		copy(fd[:10], name)
+		fd[11] = 'C'
+		fd[16] = byte(widths[i])
+		buf.Write(fd)
+	}
+	buf.WriteByte(0x0D)
+	for _, rec := range records {
+		buf.WriteByte(' ')
+		for i, v := range rec {
+			v = truncateUTF8(v, widths[i])
+			buf.WriteString(v)
+			buf.WriteString(strings.Repeat(" ", widths[i]-len(v)))
+		}
+	}
+	buf.WriteByte(0x1A)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeShapefileZip(w io.Writer, base string, level int, areas []exportArea) error {
+	var shp, shx bytes.Buffer
+	bound := areas[0].geom.Bound()
+	records := make([][]string, 0, len(areas))
+	offset := 100
+	for i, a := range areas {
+		bound = bound.Union(a.geom.Bound())
+		content := shpPolygon(a.geom)
+		rh := make([]byte, 8)
+		binary.BigEndian.PutUint32(rh[0:], uint32(i+1))
+		binary.BigEndian.PutUint32(rh[4:], uint32(len(content)/2))
+		shp.Write(rh)
+		shp.Write(content)
+
+		ix := make([]byte, 8)
+		binary.BigEndian.PutUint32(ix[0:], uint32(offset/2))
+		binary.BigEndian.PutUint32(ix[4:], uint32(len(content)/2))
+		shx.Write(ix)
+		offset += 8 + len(content)
+		records = append(records, a.values())
+	}
+
+	zw := zip.NewWriter(w)
+	modified := time.Now()
+	files := []struct {
+		ext   string
+		write func(io.Writer) error
+	}{
+		{".shp", func(f io.Writer) error {
+			if _, err := f.Write(shpHeader(100+shp.Len(), bound)); err != nil {
+				return err
+			}
+			_, err := f.Write(shp.Bytes())
+			return err
+		}},
+		{".shx", func(f io.Writer) error {
+			if _, err := f.Write(shpHeader(100+shx.Len(), bound)); err != nil {
+				return err
+			}
+			_, err := f.Write(shx.Bytes())
+			return err
+		}},
+		{".dbf", func(f io.Writer) error { return writeDBF(f, exportColumns(level), records) }},
+		{".prj", func(f io.Writer) error { _, err := io.WriteString(f, wgs84PRJ); return err }},
+		{".cpg", func(f io.Writer) error { _, err := io.WriteString(f, "UTF-8"); return err }},
+	}
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: base + file.ext, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		if err := file.write(f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+/************* GeoPackage *************/
+
+// WKB 包上 GeoPackage 几何头（小端、带 xy 外接矩形、EPSG:4326）
+func wkbToGPKG(b []byte, bound orb.Bound) []byte {
+	h := make([]byte, 8+32)
+	h[0], h[1] = 'G', 'P'
+	h[3] = 0x01<<1 | 0x01 // envelope [minx, maxx, miny, maxy]，小端
+	binary.LittleEndian.PutUint32(h[4:], 4326)
+	for i, v := range []float64{bound.Min[0], bound.Max[0], bound.Min[1], bound.Max[1]} {
+		binary.LittleEndian.PutUint64(h[8+8*i:], math.Float64bits(v))
+	}
+	return append(h, b...)
+}
+
+// 在临时文件中写出只含一个要素表的 GeoPackage，返回文件路径，调用方负责删除
+func writeGeoPackage(table string, level int, areas []exportArea) (string, error) {
+	f, err := os.CreateTemp("", "export-*.gpkg")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	f.Close()
+	if err := fillGeoPackage(path, table, level, areas); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func fillGeoPackage(path, table string, level int, areas []exportArea) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	bound := areas[0].geom.Bound()
+	for _, a := range areas {
+		bound = bound.Union(a.geom.Bound())
+	}
+	cols := exportColumns(level)
+	colDefs := make([]string, len(cols))
+	for i, c := range cols {
+		colDefs[i] = c + " TEXT"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := createGeoPackageTable(tx, table, colDefs, bound); err != nil {
+		return err
+	}
+
+	ins, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" (geom, %s) VALUES (?%s)`,
+		table, strings.Join(cols, ", "), strings.Repeat(", ?", len(cols))))
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+	for _, a := range areas {
+		b, err := wkb.Marshal(a.geom, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		args := []any{wkbToGPKG(b, a.geom.Bound())}
+		for _, v := range a.values() {
+			args = append(args, v)
+		}
+		if _, err := ins.Exec(args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 建 GeoPackage 元数据表和一个 MULTIPOLYGON 要素表（fid、geom 加 colDefs）
+func createGeoPackageTable(tx *sql.Tx, table string, colDefs []string, bound orb.Bound) error {
+	stmts := []string{
+		`PRAGMA application_id = 1196444487`,
+		`PRAGMA user_version = 10300`,
+		`CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER PRIMARY KEY, organization TEXT NOT NULL, organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT)`,
+		`INSERT INTO gpkg_spatial_ref_sys VALUES ('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', NULL), ('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', NULL), ('WGS 84 geodetic', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433],AUTHORITY["EPSG","4326"]]', NULL)`,
+		`CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, identifier TEXT UNIQUE, description TEXT DEFAULT '', last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')), min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER)`,
+		`CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL, PRIMARY KEY (table_name, column_name))`,
+		fmt.Sprintf(`CREATE TABLE "%s" (fid INTEGER PRIMARY KEY AUTOINCREMENT, geom MULTIPOLYGON, %s)`, table, strings.Join(colDefs, ", ")),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO gpkg_contents (table_name, data_type, identifier, min_x, min_y, max_x, max_y, srs_id) VALUES (?, 'features', ?, ?, ?, ?, ?, 4326)`,
+		table, table, bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO gpkg_geometry_columns VALUES (?, 'geom', 'MULTIPOLYGON', 4326, 0, 0)`, table)
+	return err
+}
+
+/************* /export *************/
+
+// 导出 code 子树第 level 层的 Shapefile（zip）、GeoPackage 或 FlatGeobuf。
+// Shapefile 边写边输出；GeoPackage 有意不流式输出，先在临时文件中写完再整体发送
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	code := strings.TrimSpace(q.Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(q.Get("level")))
+	if err != nil || level < 0 || level > 5 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(q.Get("format")))
+	if format != "shp" && format != "gpkg" && format != "fgb" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid format, use shp, gpkg or fgb")
+		return
+	}
+
+	areas, err := s.exportAreas(r.Context(), code, level)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "gid not found"):
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+		case strings.HasPrefix(err.Error(), "level must"):
+			writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		default:
+			writeQueryError(w, "export", err)
+		}
+		return
+	}
+	if len(areas) == 0 {
+		writeErrorJSON(w, http.StatusNotFound, 404, fmt.Sprintf("no areas at level %d", level))
+		return
+	}
+
+	base := fmt.Sprintf("%s_%d", strings.ReplaceAll(code, ".", "_"), level)
+	switch format {
+	case "fgb":
+		features := make([]fgbFeature, len(areas))
+		for i, a := range areas {
+			features[i] = fgbFeature{props: append(append([]string{}, a.gids...), a.names...), geom: a.geom}
+		}
+		serveFlatGeobuf(w, r, base, encodeFlatGeobuf(base, exportColumns(level), features))
+	case "shp":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+base+`.zip"`)
+		w.WriteHeader(http.StatusOK)
+		if err := writeShapefileZip(w, base, level, areas); err != nil {
+			log.Println("export shp error:", err)
+		}
+	case "gpkg":
+		// GeoPackage 由 SQLite 写出，必须先落到临时文件，写完后才能输出（不像 shp 边写边输出）；
+		// 文件大小已知，带上 Content-Length
+		path, err := writeGeoPackage(base, level, areas)
+		if err != nil {
+			log.Println("export gpkg error:", err)
+			writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+			return
+		}
+		defer os.Remove(path)
+		f, err := os.Open(path)
+		if err != nil {
+			log.Println("export gpkg error:", err)
+			writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			log.Println("export gpkg error:", err)
+			writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/geopackage+sqlite3")
+		w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		w.Header().Set("Content-Disposition", `attachment; filename="`+base+`.gpkg"`)
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, f); err != nil {
+			log.Println("export gpkg write error:", err)
+		}
+	}
+}
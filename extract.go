@@ -0,0 +1,239 @@
+// extract.go
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 命令行：从全量 GeoPackage 中切出部分国家或子树，生成只含这些叶子的新库，部署区域实例时不用带上全球数据。
+//
+//	gpkg-reverse extract -in data/gadm_410.gpkg -out data/sea.gpkg -codes IDN,MYS,SGP
+//
+// 表结构、索引、触发器和 GeoPackage 元数据按原样复制，叶子表和它的 r-tree 只保留选中的行，
+// gpkg_contents 的范围和 gpkg_ogr_contents 的要素数按新数据更新
+
+func runExtract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	in := fs.String("in", env("GPKG_PATH", "data/gadm_410.gpkg"), "source GeoPackage path")
+	out := fs.String("out", "", "output GeoPackage path")
+	codes := fs.String("codes", "", "comma separated GIDs at any level, e.g. IDN,MYS,SGP or IDN.8_1")
+	table := fs.String("table", env("GPKG_TABLE", "gadm_410"), "table name")
+	geomCol := fs.String("geom", env("GPKG_GEOM_COL", "geom"), "geometry column")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" || *codes == "" {
+		return fmt.Errorf("-out and -codes required")
+	}
+	if abs, err := filepath.Abs(*in); err == nil {
+		if absOut, err := filepath.Abs(*out); err == nil && abs == absOut {
+			return fmt.Errorf("-out must differ from -in")
+		}
+	}
+	var list []string
+	for _, c := range strings.Split(*codes, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			list = append(list, c)
+		}
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("-codes required")
+	}
+	start := time.Now()
+	n, err := extractSubset(*in, *out, *table, *geomCol, list)
+	if err != nil {
+		return err
+	}
+	log.Printf("extract: %d leaves of %s from %s -> %s in %s", n, strings.Join(list, ","), *in, *out, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// 先写临时文件再改名，失败时不留下半个库
+func extractSubset(in, out, table, geomCol string, codes []string) (n int64, err error) {
+	if _, err := os.Stat(in); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return 0, err
+	}
+	tmp := out + ".tmp"
+	os.Remove(tmp)
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	db, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	// ATTACH 只对当前连接有效
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`ATTACH DATABASE ? AS src;`, fmt.Sprintf("file:%s?mode=ro&immutable=1", in)); err != nil {
+		return 0, err
+	}
+
+	// 预处理的库中每层的汇总几何按整个区域算好，切出子树后不再正确；从原始库切出后再 build
+	var built int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM src.sqlite_master WHERE name = ?;`, gidsTableName(table)).Scan(&built); err != nil {
+		return 0, err
+	}
+	if built > 0 {
+		return 0, fmt.Errorf("%s was made by build, extract from the source GeoPackage and run build on the result", in)
+	}
+	where, params, err := extractFilter(db, table, codes)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", in, err)
+	}
+
+	objects, err := extractObjects(db)
+	if err != nil {
+		return 0, err
+	}
+	rtree := fmt.Sprintf("rtree_%s_%s", table, geomCol)
+	// 先建表、复制数据，再建索引和触发器，GeoPackage 的 r-tree 触发器不会在复制时再插一遍
+	for _, o := range objects {
+		if o.typ != "table" {
+			continue
+		}
+		if _, err := db.Exec(o.sql); err != nil {
+			return 0, fmt.Errorf("%s: %w", o.name, err)
+		}
+		copySQL := fmt.Sprintf(`INSERT INTO main."%[1]s" SELECT * FROM src."%[1]s";`, o.name)
+		args := []any(nil)
+		switch o.name {
+		case table:
+			copySQL = fmt.Sprintf(`INSERT INTO main."%[1]s" SELECT * FROM src."%[1]s" WHERE %[2]s;`, o.name, where)
+			args = params
+		case rtree:
+			copySQL = fmt.Sprintf(`INSERT INTO main."%[1]s" SELECT * FROM src."%[1]s" WHERE id IN (SELECT rowid FROM main."%[2]s");`, o.name, table)
+		}
+		res, err := db.Exec(copySQL, args...)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", o.name, err)
+		}
+		if o.name == table {
+			n, _ = res.RowsAffected()
+		}
+	}
+	for _, o := range objects {
+		if o.typ == "table" {
+			continue
+		}
+		if _, err := db.Exec(o.sql); err != nil {
+			return 0, fmt.Errorf("%s: %w", o.name, err)
+		}
+	}
+	if err := extractMetadata(db, table, rtree, n); err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec(`DETACH DATABASE src;`); err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec(`ANALYZE;`); err != nil {
+		return 0, err
+	}
+	db.Close()
+	return n, os.Rename(tmp, out)
+}
+
+// 每个 code 所在层级的 GID_n = ? 条件，用 OR 连接；找不到的 code 报错
+func extractFilter(db *sql.DB, table string, codes []string) (string, []any, error) {
+	var (
+		conds  []string
+		params []any
+	)
+	for _, code := range codes {
+		level := -1
+		for lvl := 0; lvl <= 5 && level < 0; lvl++ {
+			var one int
+			err := db.QueryRow(fmt.Sprintf(`SELECT 1 FROM src."%s" WHERE GID_%d = ? LIMIT 1;`, table, lvl), code).Scan(&one)
+			switch {
+			case err == nil:
+				level = lvl
+			case !errors.Is(err, sql.ErrNoRows):
+				return "", nil, err
+			}
+		}
+		if level < 0 {
+			return "", nil, fmt.Errorf("code %s not found", code)
+		}
+		conds = append(conds, fmt.Sprintf("GID_%d = ?", level))
+		params = append(params, code)
+	}
+	return strings.Join(conds, " OR "), params, nil
+}
+
+type sqliteObject struct {
+	typ, name, sql string
+}
+
+// 源库中要复制的对象，按创建顺序；sqlite 内部表和 r-tree 的影子表随虚表自动创建，跳过
+func extractObjects(db *sql.DB) ([]sqliteObject, error) {
+	rows, err := db.Query(`SELECT type, name, sql FROM src.sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY rowid;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var (
+		objects []sqliteObject
+		virtual = make(map[string]bool)
+	)
+	for rows.Next() {
+		var o sqliteObject
+		if err := rows.Scan(&o.typ, &o.name, &o.sql); err != nil {
+			return nil, err
+		}
+		if o.typ == "table" && strings.HasPrefix(strings.ToUpper(o.sql), "CREATE VIRTUAL TABLE") {
+			virtual[o.name] = true
+		}
+		objects = append(objects, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := objects[:0]
+	for _, o := range objects {
+		shadow := false
+		for _, suffix := range []string{"_node", "_parent", "_rowid"} {
+			if base, ok := strings.CutSuffix(o.name, suffix); ok && virtual[base] {
+				shadow = true
+			}
+		}
+		if !shadow {
+			out = append(out, o)
+		}
+	}
+	return out, nil
+}
+
+// GeoPackage 元数据中的范围和要素数；源文件不是 GeoPackage 时没有这些表，跳过
+func extractMetadata(db *sql.DB, table, rtree string, n int64) error {
+	if cols, err := tableColumns(db, "gpkg_contents"); err == nil && len(cols) > 0 {
+		if rt, err := tableColumns(db, rtree); err == nil && len(rt) > 0 {
+			_, err := db.Exec(fmt.Sprintf(`UPDATE main.gpkg_contents SET
+  min_x = (SELECT MIN(minx) FROM main."%[1]s"), min_y = (SELECT MIN(miny) FROM main."%[1]s"),
+  max_x = (SELECT MAX(maxx) FROM main."%[1]s"), max_y = (SELECT MAX(maxy) FROM main."%[1]s"),
+  last_change = strftime('%%Y-%%m-%%dT%%H:%%M:%%fZ', 'now')
+WHERE table_name = ?;`, rtree), table)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if cols, err := tableColumns(db, "gpkg_ogr_contents"); err == nil && len(cols) > 0 {
+		if _, err := db.Exec(`UPDATE main.gpkg_ogr_contents SET feature_count = ? WHERE table_name = ?;`, n, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+// flatgeobuf.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// FlatGeobuf 输出：magic + 头 + 打包 Hilbert R 树索引 + 要素。
+// 客户端（OpenLayers / Leaflet 的 fgb 插件）先用 Range 读头和索引，再只取视野内的要素
+
+var fgbMagic = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+// R 树每个节点的子节点数，同参考实现的默认值
+const fgbNodeSize = 16
+
+const (
+	fgbPolygon      = 3
+	fgbMultiPolygon = 6
+	fgbColumnString = 11
+)
+
+// 一个要素：属性按列顺序，全部为字符串
+type fgbFeature struct {
+	props []string
+	geom  orb.MultiPolygon
+}
+
+/************* 最小的 FlatBuffers 写入器 *************/
+
+// 从前往后写：子对象放在引用它的表之后，写出后回填偏移；对齐以含长度前缀的缓冲区开头为准
+type fbBuilder struct {
+	buf []byte
+}
+
+// 表字段：scalar 为小端标量，ref 非 nil 时字段为指向 ref 写出对象的偏移
+type fbField struct {
+	scalar []byte
+	ref    func() int
+}
+
+func fbScalar(v any) *fbField {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, v)
+	return &fbField{scalar: buf.Bytes()}
+}
+
+func fbRef(ref func() int) *fbField {
+	return &fbField{ref: ref}
+}
+
+// 补零直到 len+extra 是 align 的倍数
+func (b *fbBuilder) pad(align, extra int) {
+	for (len(b.buf)+extra)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) u16(v int) {
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(v))
+}
+
+func (b *fbBuilder) u32(v uint32) {
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, v)
+}
+
+func (b *fbBuilder) f64(v float64) {
+	b.buf = binary.LittleEndian.AppendUint64(b.buf, math.Float64bits(v))
+}
+
+func (b *fbBuilder) patch(at, target int) {
+	binary.LittleEndian.PutUint32(b.buf[at:], uint32(target-at))
+}
+
+// 写出一张表（fields 下标为字段 id，nil 为缺省），返回表的位置
+func (b *fbBuilder) table(fields []*fbField) int {
+	type slot struct{ id, size, off int }
+	var slots []slot
+	for id, f := range fields {
+		if f == nil {
+			continue
+		}
+		size := 4
+		if f.ref == nil {
+			size = len(f.scalar)
+		}
+		slots = append(slots, slot{id: id, size: size})
+	}
+	// 大字段在前，各字段按自身大小对齐
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].size > slots[j].size })
+	off := 4
+	for i := range slots {
+		for off%slots[i].size != 0 {
+			off++
+		}
+		slots[i].off = off
+		off += slots[i].size
+	}
+	offsets := make([]int, len(fields))
+	for _, sl := range slots {
+		offsets[sl.id] = sl.off
+	}
+
+	b.pad(2, 0)
+	vt := len(b.buf)
+	b.u16(4 + 2*len(fields))
+	b.u16(off)
+	for _, o := range offsets {
+		b.u16(o)
+	}
+	b.pad(8, 0)
+	t := len(b.buf)
+	b.buf = append(b.buf, make([]byte, off)...)
+	binary.LittleEndian.PutUint32(b.buf[t:], uint32(int32(t-vt)))
+	for _, sl := range slots {
+		if f := fields[sl.id]; f.ref == nil {
+			copy(b.buf[t+sl.off:], f.scalar)
+		}
+	}
+	for _, sl := range slots {
+		if f := fields[sl.id]; f.ref != nil {
+			b.patch(t+sl.off, f.ref())
+		}
+	}
+	return t
+}
+
+func (b *fbBuilder) vector(n, elemSize int, write func()) int {
+	b.pad(max(4, elemSize), 4)
+	pos := len(b.buf)
+	b.u32(uint32(n))
+	write()
+	return pos
+}
+
+func (b *fbBuilder) str(s string) int {
+	b.pad(4, 0)
+	pos := len(b.buf)
+	b.u32(uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+func (b *fbBuilder) tables(n int, table func(i int) int) int {
+	b.pad(4, 0)
+	pos := len(b.buf)
+	b.u32(uint32(n))
+	b.buf = append(b.buf, make([]byte, 4*n)...)
+	for i := 0; i < n; i++ {
+		b.patch(pos+4+4*i, table(i))
+	}
+	return pos
+}
+
+// 带 4 字节长度前缀的 FlatBuffer
+func fbSizePrefixed(root func(b *fbBuilder) int) []byte {
+	b := &fbBuilder{buf: make([]byte, 8)}
+	t := root(b)
+	binary.LittleEndian.PutUint32(b.buf[4:], uint32(t-4))
+	binary.LittleEndian.PutUint32(b.buf, uint32(len(b.buf)-4))
+	return b.buf
+}
+
+/************* 头与要素 *************/
+
+func fgbHeader(name string, columns []string, envelope orb.Bound, count int, nodeSize int) []byte {
+	return fbSizePrefixed(func(b *fbBuilder) int {
+		fields := make([]*fbField, 11)
+		fields[0] = fbRef(func() int { return b.str(name) })
+		fields[1] = fbRef(func() int {
+			return b.vector(4, 8, func() {
+				b.f64(envelope.Min[0])
+				b.f64(envelope.Min[1])
+				b.f64(envelope.Max[0])
+				b.f64(envelope.Max[1])
+			})
+		})
+		fields[2] = fbScalar(uint8(fgbMultiPolygon))
+		fields[7] = fbRef(func() int {
+			return b.tables(len(columns), func(i int) int {
+				return b.table([]*fbField{
+					fbRef(func() int { return b.str(columns[i]) }),
+					fbScalar(uint8(fgbColumnString)),
+				})
+			})
+		})
+		fields[8] = fbScalar(uint64(count))
+		fields[9] = fbScalar(uint16(nodeSize))
+		fields[10] = fbRef(func() int {
+			return b.table([]*fbField{
+				fbRef(func() int { return b.str("EPSG") }),
+				fbScalar(int32(4326)),
+			})
+		})
+		return b.table(fields)
+	})
+}
+
+// 单环多边形不写 ends，同参考实现
+func (b *fbBuilder) fgbPolygon(p orb.Polygon) int {
+	n := 0
+	ends := make([]uint32, 0, len(p))
+	for _, ring := range p {
+		n += len(ring)
+		ends = append(ends, uint32(n))
+	}
+	fields := make([]*fbField, 7)
+	if len(ends) > 1 {
+		fields[0] = fbRef(func() int {
+			return b.vector(len(ends), 4, func() {
+				for _, e := range ends {
+					b.u32(e)
+				}
+			})
+		})
+	}
+	fields[1] = fbRef(func() int {
+		return b.vector(2*n, 8, func() {
+			for _, ring := range p {
+				for _, pt := range ring {
+					b.f64(pt[0])
+					b.f64(pt[1])
+				}
+			}
+		})
+	})
+	fields[6] = fbScalar(uint8(fgbPolygon))
+	return b.table(fields)
+}
+
+func fgbEncodeFeature(f fgbFeature) []byte {
+	var props []byte
+	for i, v := range f.props {
+		props = binary.LittleEndian.AppendUint16(props, uint16(i))
+		props = binary.LittleEndian.AppendUint32(props, uint32(len(v)))
+		props = append(props, v...)
+	}
+	return fbSizePrefixed(func(b *fbBuilder) int {
+		geom := fbRef(func() int {
+			fields := make([]*fbField, 8)
+			fields[6] = fbScalar(uint8(fgbMultiPolygon))
+			fields[7] = fbRef(func() int {
+				return b.tables(len(f.geom), func(i int) int { return b.fgbPolygon(f.geom[i]) })
+			})
+			return b.table(fields)
+		})
+		return b.table([]*fbField{
+			geom,
+			fbRef(func() int {
+				return b.vector(len(props), 1, func() { b.buf = append(b.buf, props...) })
+			}),
+		})
+	})
+}
+
+/************* 打包 Hilbert R 树 *************/
+
+// 16 位坐标的 Hilbert 值，同参考实现
+func hilbertXY(x, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+	spread := func(v uint32) uint32 {
+		v = (v | (v << 8)) & 0x00FF00FF
+		v = (v | (v << 4)) & 0x0F0F0F0F
+		v = (v | (v << 2)) & 0x33333333
+		return (v | (v << 1)) & 0x55555555
+	}
+	return (spread(i1) << 1) | spread(i0)
+}
+
+type fgbNode struct {
+	bound  orb.Bound
+	offset uint64
+}
+
+// 各层节点在数组中的区间，根在最前、叶子在最后
+func fgbLevelBounds(n, nodeSize int) [][2]int {
+	levelNodes := []int{n}
+	total := n
+	for {
+		n = (n + nodeSize - 1) / nodeSize
+		total += n
+		levelNodes = append(levelNodes, n)
+		if n == 1 {
+			break
+		}
+	}
+	out := make([][2]int, len(levelNodes))
+	for i, size := range levelNodes {
+		total -= size
+		out[i] = [2]int{total, total + size}
+	}
+	return out
+}
+
+// 叶子的 offset 为要素在要素区中的字节偏移，内部节点的 offset 为第一个子节点的下标
+func fgbIndex(leaves []fgbNode, nodeSize int) []byte {
+	levels := fgbLevelBounds(len(leaves), nodeSize)
+	nodes := make([]fgbNode, levels[0][1])
+	copy(nodes[levels[0][0]:], leaves)
+	for i := 0; i < len(levels)-1; i++ {
+		pos, end, next := levels[i][0], levels[i][1], levels[i+1][0]
+		for pos < end {
+			node := fgbNode{bound: nodes[pos].bound, offset: uint64(pos)}
+			for j := 0; j < nodeSize && pos < end; j++ {
+				node.bound = node.bound.Union(nodes[pos].bound)
+				pos++
+			}
+			nodes[next] = node
+			next++
+		}
+	}
+	out := make([]byte, 0, 40*len(nodes))
+	for _, n := range nodes {
+		for _, v := range []float64{n.bound.Min[0], n.bound.Min[1], n.bound.Max[0], n.bound.Max[1]} {
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(v))
+		}
+		out = binary.LittleEndian.AppendUint64(out, n.offset)
+	}
+	return out
+}
+
+// 按 Hilbert 顺序排列要素并生成完整的 .fgb 文件
+func encodeFlatGeobuf(name string, columns []string, features []fgbFeature) []byte {
+	bounds := make([]orb.Bound, len(features))
+	var extent orb.Bound
+	for i, f := range features {
+		bounds[i] = f.geom.Bound()
+		if i == 0 {
+			extent = bounds[i]
+		} else {
+			extent = extent.Union(bounds[i])
+		}
+	}
+	order := make([]int, len(features))
+	keys := make([]uint32, len(features))
+	width, height := extent.Max[0]-extent.Min[0], extent.Max[1]-extent.Min[1]
+	for i, bd := range bounds {
+		order[i] = i
+		var x, y uint32
+		if width != 0 {
+			x = uint32(math.Floor(0xFFFF * ((bd.Min[0]+bd.Max[0])/2 - extent.Min[0]) / width))
+		}
+		if height != 0 {
+			y = uint32(math.Floor(0xFFFF * ((bd.Min[1]+bd.Max[1])/2 - extent.Min[1]) / height))
+		}
+		keys[i] = hilbertXY(x, y)
+	}
+	sort.SliceStable(order, func(a, b int) bool { return keys[order[a]] < keys[order[b]] })
+
+	nodeSize := fgbNodeSize
+	if len(features) == 0 {
+		nodeSize = 0
+	}
+	var body bytes.Buffer
+	leaves := make([]fgbNode, len(features))
+	for i, idx := range order {
+		leaves[i] = fgbNode{bound: bounds[idx], offset: uint64(body.Len())}
+		body.Write(fgbEncodeFeature(features[idx]))
+	}
+
+	var out bytes.Buffer
+	out.Write(fgbMagic)
+	out.Write(fgbHeader(name, columns, extent, len(features), nodeSize))
+	if nodeSize > 0 {
+		out.Write(fgbIndex(leaves, nodeSize))
+	}
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// ?format=fgb 的响应，支持 Range 以便客户端按索引分段读取
+func serveFlatGeobuf(w http.ResponseWriter, r *http.Request, base string, data []byte) {
+	w.Header().Set("Content-Type", "application/flatgeobuf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+base+`.fgb"`)
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	http.ServeContent(w, r, base+".fgb", time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,246 @@
+// fuzzy.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 模糊匹配的最低相似度（1 - 编辑距离/较长名称长度）
+const fuzzyMinScore = 0.6
+
+// 名称的三元组（首尾补空格，使开头/结尾字符权重更高）
+func trigrams(key string) []string {
+	padded := []rune("  " + key + " ")
+	if len(padded) < 3 {
+		return nil
+	}
+	seen := make(map[string]bool, len(padded))
+	out := make([]string, 0, len(padded))
+	for i := 0; i+3 <= len(padded); i++ {
+		t := string(padded[i : i+3])
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// 为名称索引中的每个行政区建立三元组倒排表
+func (idx *nameIndex) buildTrigrams() {
+	idx.trigrams = make(map[string][]int32)
+	for i, item := range idx.items {
+		for _, t := range trigrams(normalizeName(item.Name)) {
+			idx.trigrams[t] = append(idx.trigrams[t], int32(i))
+		}
+	}
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// 名称相似度，0..1
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(normalizeName(a)), []rune(normalizeName(b))
+	n := max(len(ra), len(rb))
+	if n == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(n)
+}
+
+type fuzzyHit struct {
+	item  *ChildrenItem
+	score float64
+}
+
+// 三元组召回候选，再用编辑距离精排
+func (idx *nameIndex) fuzzy(q string, limit int) []fuzzyHit {
+	grams := trigrams(normalizeName(q))
+	if len(grams) == 0 {
+		return nil
+	}
+	counts := make([]uint8, len(idx.items))
+	var touched []int32
+	for _, t := range grams {
+		for _, i := range idx.trigrams[t] {
+			if counts[i] == 0 {
+				touched = append(touched, i)
+			}
+			if counts[i] < 255 {
+				counts[i]++
+			}
+		}
+	}
+	// 至少共享三分之一的三元组才进入精排
+	minShared := uint8(max(1, len(grams)/3))
+	hits := make([]fuzzyHit, 0)
+	for _, i := range touched {
+		if counts[i] < minShared {
+			continue
+		}
+		item := idx.items[i]
+		if score := nameSimilarity(q, item.Name); score >= fuzzyMinScore {
+			hits = append(hits, fuzzyHit{item: item, score: score})
+		}
+	}
+	levelRank := levelRankMap()
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return levelRank[hits[i].item.Level] < levelRank[hits[j].item.Level]
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// 在内存中沿 ParentCode 还原完整层级路径
+func (idx *nameIndex) pathOf(item *ChildrenItem) []ChildrenItem {
+	var rev []ChildrenItem
+	for cur := item; cur != nil; cur = idx.byGID[cur.ParentCode] {
+		rev = append(rev, *cur)
+		if cur.ParentCode == "" {
+			break
+		}
+	}
+	path := make([]ChildrenItem, len(rev))
+	for i := range rev {
+		path[i] = rev[len(rev)-1-i]
+	}
+	return path
+}
+
+// 模糊搜索：末段名称容错匹配，上级名称同样按相似度过滤。
+// 名称索引只含 NAME 列，需要其他语言时由调用方对结果调用 localizeSearchItems。
+func (s *Server) searchFuzzy(q string) ([]SearchItem, error) {
+	idx := s.names.Load()
+	if idx == nil {
+		return nil, errNameIndexNotReady
+	}
+	segs := splitNamePath(q)
+	if len(segs) == 0 {
+		return []SearchItem{}, nil
+	}
+	name := segs[len(segs)-1]
+	ancestors := segs[:len(segs)-1]
+
+	out := make([]SearchItem, 0)
+	for _, hit := range idx.fuzzy(name, maxSearchMatches) {
+		path := idx.pathOf(hit.item)
+		if !matchAncestorsFunc(path[:len(path)-1], ancestors, func(a, b string) bool {
+			return nameSimilarity(a, b) >= fuzzyMinScore
+		}) {
+			continue
+		}
+		out = append(out, SearchItem{
+			GID:        hit.item.GID,
+			Name:       hit.item.Name,
+			ParentCode: hit.item.ParentCode,
+			Level:      hit.item.Level,
+			Path:       path,
+			Score:      float64(int(hit.score*1000)) / 1000,
+		})
+	}
+	return out, nil
+}
+
+// 按 mode 重新读取结果路径上每个行政区的名称，每层一次查询
+func (s *Server) localizeSearchItems(ctx context.Context, items []SearchItem, mode nameMode) error {
+	levelRank := levelRankMap()
+	var byLevel [6]map[string]bool
+	for _, item := range items {
+		for _, p := range item.Path {
+			lvl := levelRank[p.Level]
+			if byLevel[lvl] == nil {
+				byLevel[lvl] = make(map[string]bool)
+			}
+			byLevel[lvl][p.GID] = true
+		}
+	}
+	names := make(map[string]string)
+	for lvl, gids := range byLevel {
+		if len(gids) == 0 {
+			continue
+		}
+		// 在对照表中的按 rowid 取，其余按 GID_n（同 gidWhere）
+		var rowids, codes []any
+		for gid := range gids {
+			if ref, ok := s.gids[gid]; ok && ref.level == lvl {
+				rowids = append(rowids, ref.rowid)
+			} else {
+				codes = append(codes, gid)
+			}
+		}
+		var conds []string
+		if len(rowids) > 0 {
+			conds = append(conds, fmt.Sprintf("rowid IN (?%s)", strings.Repeat(", ?", len(rowids)-1)))
+		}
+		if len(codes) > 0 {
+			conds = append(conds, fmt.Sprintf("GID_%d IN (?%s)", lvl, strings.Repeat(", ?", len(codes)-1)))
+		}
+		args := append(rowids, codes...)
+		sqlStr := fmt.Sprintf(`SELECT GID_%d, %s FROM %s WHERE %s GROUP BY GID_%d`,
+			lvl, s.nameExpr(lvl, mode), s.table, strings.Join(conds, " OR "), lvl)
+		rows, err := s.db.QueryContext(ctx, sqlStr, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var gid, n string
+			if err := rows.Scan(&gid, &n); err != nil {
+				rows.Close()
+				return err
+			}
+			names[gid] = n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	for i := range items {
+		for j, p := range items[i].Path {
+			n, ok := names[p.GID]
+			if !ok {
+				return fmt.Errorf("gid not found: %s", p.GID)
+			}
+			items[i].Path[j].Name = n
+		}
+		items[i].Name = items[i].Path[len(items[i].Path)-1].Name
+	}
+	return nil
+}
+
+func isFuzzyMode(v string) (always, auto bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes":
+		return true, false
+	case "0", "false", "no":
+		return false, false
+	default:
+		return false, true
+	}
+}
@@ -0,0 +1,95 @@
+// geohash.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// 编码为 geohash，precision 为字符数（1..12）
+func encodeGeohash(lat, lon float64, precision int) string {
+	latLo, latHi := -90.0, 90.0
+	lonLo, lonHi := -180.0, 180.0
+	var sb strings.Builder
+	bit, ch, even := 0, 0, true
+	for sb.Len() < precision {
+		if even {
+			mid := (lonLo + lonHi) / 2
+			if lon >= mid {
+				ch = ch<<1 | 1
+				lonLo = mid
+			} else {
+				ch <<= 1
+				lonHi = mid
+			}
+		} else {
+			mid := (latLo + latHi) / 2
+			if lat >= mid {
+				ch = ch<<1 | 1
+				latLo = mid
+			} else {
+				ch <<= 1
+				latHi = mid
+			}
+		}
+		even = !even
+		if bit++; bit == 5 {
+			sb.WriteByte(geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return sb.String()
+}
+
+// 解码 geohash 为格子中心点；按格子大小保留小数位，避免返回虚假的精度
+func decodeGeohash(gh string) (lat, lon float64, err error) {
+	gh = strings.ToLower(strings.TrimSpace(gh))
+	if gh == "" || len(gh) > 12 {
+		return 0, 0, fmt.Errorf("invalid geohash, use 1..12 characters")
+	}
+	latLo, latHi := -90.0, 90.0
+	lonLo, lonHi := -180.0, 180.0
+	even := true
+	for i := 0; i < len(gh); i++ {
+		v := strings.IndexByte(geohashBase32, gh[i])
+		if v < 0 {
+			return 0, 0, fmt.Errorf("invalid geohash character %q", gh[i])
+		}
+		for mask := 16; mask > 0; mask >>= 1 {
+			if even {
+				mid := (lonLo + lonHi) / 2
+				if v&mask != 0 {
+					lonLo = mid
+				} else {
+					lonHi = mid
+				}
+			} else {
+				mid := (latLo + latHi) / 2
+				if v&mask != 0 {
+					latLo = mid
+				} else {
+					latHi = mid
+				}
+			}
+			even = !even
+		}
+	}
+	lat = roundInCell(latLo, latHi)
+	lon = roundInCell(lonLo, lonHi)
+	return lat, lon, nil
+}
+
+// 取格子中心，保留尽量少的小数位，但结果仍落在格子 [lo, hi) 内（重新编码得到同一个 geohash）
+func roundInCell(lo, hi float64) float64 {
+	c := (lo + hi) / 2
+	for places := 0; places <= 15; places++ {
+		f := math.Pow10(places)
+		if v := math.Round(c*f) / f; v >= lo && v < hi {
+			return v
+		}
+	}
+	return c
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestGeohashRoundTrip(t *testing.T) {
+	for _, gh := range []string{"qqguw3x", "qqgu", "w21z7", "s", "0", "zzzzzzzzzzzz", "qqguw3", "u4pruydqqvj"} {
+		lat, lon, err := decodeGeohash(gh)
+		if err != nil {
+			t.Fatalf("%s: %v", gh, err)
+		}
+		if got := encodeGeohash(lat, lon, len(gh)); got != gh {
+			t.Errorf("%s decoded to (%v, %v), re-encodes as %s", gh, lat, lon, got)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		lat, lon := rnd.Float64()*180-90, rnd.Float64()*360-180
+		precision := 1 + rnd.Intn(12)
+		gh := encodeGeohash(lat, lon, precision)
+		dlat, dlon, err := decodeGeohash(gh)
+		if err != nil {
+			t.Fatalf("%s: %v", gh, err)
+		}
+		if got := encodeGeohash(dlat, dlon, precision); got != gh {
+			t.Fatalf("%s decoded to (%v, %v), re-encodes as %s", gh, dlat, dlon, got)
+		}
+	}
+}
+
+func TestGeohashDecodePrecision(t *testing.T) {
+	// 只保留落在格子内所需的小数位
+	lat, lon, err := decodeGeohash("qqguw3x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lat != -6.231 || lon != 106.808 {
+		t.Errorf("qqguw3x = (%v, %v)", lat, lon)
+	}
+}
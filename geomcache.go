@@ -0,0 +1,76 @@
+// geomcache.go
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/paulmach/orb"
+)
+
+// 解码后的叶子多边形缓存（LRU，按 rowid）：同一个区的连续请求跳过 gpkg → WKB → orb 的解码，
+// 聚集的流量下解码是 CPU 的大头。点面判断的索引（见 polyindex.go）一起缓存。
+// 按顶点数计容量（每个顶点 16 字节，不含索引），GEOM_CACHE_MB 设置，0 为不缓存。
+// 缓存的多边形被多个请求共用，只读，需要修改时先 Clone
+type geomCache struct {
+	mu        sync.Mutex
+	maxPoints int
+	points    int
+	order     *list.List
+	items     map[int64]*list.Element
+	stats     *cacheStats
+}
+
+type geomEntry struct {
+	rowid  int64
+	geom   orb.MultiPolygon
+	index  *shapeIndex
+	points int
+}
+
+func newGeomCache(maxMB int, stats *cacheStats) *geomCache {
+	return &geomCache{maxPoints: maxMB << 20 / 16, order: list.New(), items: make(map[int64]*list.Element), stats: stats}
+}
+
+func (c *geomCache) get(rowid int64) (orb.MultiPolygon, *shapeIndex, bool) {
+	if c.maxPoints <= 0 {
+		return nil, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[rowid]
+	c.stats.record(ok)
+	if !ok {
+		return nil, nil, false
+	}
+	c.order.MoveToFront(e)
+	ent := e.Value.(*geomEntry)
+	return ent.geom, ent.index, true
+}
+
+func (c *geomCache) put(rowid int64, geom orb.MultiPolygon, index *shapeIndex) {
+	points := 0
+	for _, p := range geom {
+		for _, r := range p {
+			points += len(r)
+		}
+	}
+	// 超过容量一半的多边形不缓存，免得把其他的全部挤掉
+	if points > c.maxPoints/2 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[rowid]; ok {
+		return
+	}
+	c.items[rowid] = c.order.PushFront(&geomEntry{rowid: rowid, geom: geom, index: index, points: points})
+	c.points += points
+	for c.points > c.maxPoints {
+		last := c.order.Back()
+		c.order.Remove(last)
+		ent := last.Value.(*geomEntry)
+		delete(c.items, ent.rowid)
+		c.points -= ent.points
+	}
+}
@@ -0,0 +1,286 @@
+// geometry.go
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/planar"
+	sf "github.com/peterstace/simplefeatures/geom"
+)
+
+const earthRadiusM = 6371008.8
+
+type segment [2]orb.Point
+
+// 多边形所有环的边
+func ringEdges(mp orb.MultiPolygon) []segment {
+	var out []segment
+	for _, poly := range mp {
+		for _, ring := range poly {
+			for i := 1; i < len(ring); i++ {
+				out = append(out, segment{ring[i-1], ring[i]})
+			}
+		}
+	}
+	return out
+}
+
+// 拼接后的叶子多边形去掉相邻区域共用的边，剩下的就是整个行政区的外边界。
+// GADM 相邻多边形共用顶点，同一条边会以相反方向各出现一次。
+func boundaryEdges(mp orb.MultiPolygon) []segment {
+	edges := ringEdges(mp)
+	count := make(map[segment]int, len(edges))
+	for _, e := range edges {
+		count[edgeKey(e)]++
+	}
+	out := edges[:0]
+	for _, e := range edges {
+		if count[edgeKey(e)] == 1 {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func edgeKey(e segment) segment {
+	a, b := e[0], e[1]
+	if b[0] < a[0] || (b[0] == a[0] && b[1] < a[1]) {
+		a, b = b, a
+	}
+	return segment{a, b}
+}
+
+// 点 pt 到多边形边界（所有环）的最短球面距离，单位米
+func distanceToBoundaryM(mp orb.MultiPolygon, pt orb.Point) float64 {
+	return distanceToEdgesM(ringEdges(mp), pt)
+}
+
+func distanceToEdgesM(edges []segment, pt orb.Point) float64 {
+	best := math.Inf(1)
+	for _, e := range edges {
+		if d := segmentDistanceM(pt, e[0], e[1]); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// 球面上点 p 到大圆弧 ab 的距离（cross-track，超出弧段时取端点距离）
+func segmentDistanceM(p, a, b orb.Point) float64 {
+	d13 := angularDistance(a, p)
+	d12 := angularDistance(a, b)
+	if d12 == 0 || d13 == 0 {
+		return d13 * earthRadiusM
+	}
+	delta := bearing(a, p) - bearing(a, b)
+	if math.Cos(delta) < 0 {
+		return d13 * earthRadiusM
+	}
+	dxt := math.Asin(math.Sin(d13) * math.Sin(delta))
+	dat := math.Acos(math.Max(-1, math.Min(1, math.Cos(d13)/math.Cos(dxt))))
+	if dat > d12 {
+		return angularDistance(b, p) * earthRadiusM
+	}
+	return math.Abs(dxt) * earthRadiusM
+}
+
+// 两点间的大圆角距离（弧度，haversine）
+func angularDistance(p1, p2 orb.Point) float64 {
+	lat1, lat2 := deg2rad(p1.Lat()), deg2rad(p2.Lat())
+	dLat := lat2 - lat1
+	dLon := deg2rad(p2.Lon() - p1.Lon())
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * math.Asin(math.Sqrt(math.Min(1, h)))
+}
+
+// 初始方位角（弧度）
+func bearing(p1, p2 orb.Point) float64 {
+	lat1, lat2 := deg2rad(p1.Lat()), deg2rad(p2.Lat())
+	dLon := deg2rad(p2.Lon() - p1.Lon())
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Atan2(y, x)
+}
+
+func deg2rad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+// 以 pt 为中心、半径 meters 的外接经纬度框
+func boundAroundPoint(pt orb.Point, meters float64) orb.Bound {
+	dLat := meters / earthRadiusM * 180 / math.Pi
+	dLon := 180.0
+	if c := math.Cos(deg2rad(pt.Lat())); c > 1e-6 {
+		dLon = math.Min(180, dLat/c)
+	}
+	return orb.Bound{
+		Min: orb.Point{pt.Lon() - dLon, math.Max(-90, pt.Lat()-dLat)},
+		Max: orb.Point{pt.Lon() + dLon, math.Min(90, pt.Lat()+dLat)},
+	}
+}
+
+/************* 合并叶子 *************/
+
+// 把同一行政区的叶子合并成一个几何，去掉叶子之间的内部边界；共用的边界在合并前后不变，
+// 简化应在合并之后，否则相邻叶子各自简化的共用边界对不上，输出中留下缝隙和重叠。
+// 叶子不合法（自相交等）导致合并失败时先修复再合并，repaired 为 true：修复会改变不合法的部分，
+// 结果只用于输出，不再与叶子的点面判断等价
+func dissolve(mp orb.MultiPolygon) (out orb.MultiPolygon, repaired bool, err error) {
+	if len(mp) <= 1 {
+		return mp, false, nil
+	}
+	g, err := toSF(mp, false)
+	if err != nil {
+		return nil, false, err
+	}
+	u, err := sf.UnaryUnion(g)
+	if err != nil {
+		if g, err = repairPolygons(mp); err != nil {
+			return nil, false, err
+		}
+		if u, err = sf.UnaryUnion(g); err != nil {
+			return nil, false, err
+		}
+		repaired = true
+	}
+	og, err := wkb.Unmarshal(u.AsBinary())
+	if err != nil {
+		return nil, false, err
+	}
+	if out = polygonsOf(og); len(out) == 0 {
+		return nil, false, fmt.Errorf("dissolve: empty result")
+	}
+	return out, repaired, nil
+}
+
+// 逐个多边形做零宽缓冲（buffer(0)），得到合法的几何：自相交的环拆开，重复的点和退化的部分去掉
+func repairPolygons(mp orb.MultiPolygon) (sf.Geometry, error) {
+	parts := make([]sf.Geometry, 0, len(mp))
+	for _, p := range mp {
+		g, err := toSF(orb.MultiPolygon{p}, false)
+		if err != nil {
+			return sf.Geometry{}, err
+		}
+		if g, err = sf.Buffer(g, 0); err != nil {
+			return sf.Geometry{}, err
+		}
+		parts = append(parts, g)
+	}
+	return sf.NewGeometryCollection(parts).AsGeometry(), nil
+}
+
+// 几何中的面，线和点丢掉
+func polygonsOf(g orb.Geometry) orb.MultiPolygon {
+	switch g := g.(type) {
+	case orb.Polygon:
+		return orb.MultiPolygon{g}
+	case orb.MultiPolygon:
+		return g
+	case orb.Collection:
+		var out orb.MultiPolygon
+		for _, c := range g {
+			out = append(out, polygonsOf(c)...)
+		}
+		return out
+	}
+	return nil
+}
+
+/************* 不可达极点 *************/
+
+// 区域内离外边界最远的点（polylabel），一定落在区域内，适合放标注；
+// 凹形或由多块组成的区域质心可能落在区域外。按平面度数计算，精度为外接矩形长边的 1/1000
+func poleOfInaccessibility(mp orb.MultiPolygon) orb.Point {
+	centroid, _ := planar.CentroidArea(mp)
+	b := mp.Bound()
+	w, h := b.Max[0]-b.Min[0], b.Max[1]-b.Min[1]
+	if w <= 0 || h <= 0 {
+		return centroid
+	}
+	precision := math.Max(w, h) / 1000
+	// 细长的区域初始格子按长边放大，避免格子过多
+	cellSize := math.Max(math.Min(w, h), math.Max(w, h)/64)
+	edges := boundaryEdges(mp)
+	newCell := func(c orb.Point, half float64) *poleCell {
+		d := planarDistanceToEdges(edges, c)
+		if !planar.MultiPolygonContains(mp, c) {
+			d = -d
+		}
+		return &poleCell{c: c, half: half, d: d, max: d + half*math.Sqrt2}
+	}
+
+	var q poleQueue
+	for x := b.Min[0]; x < b.Max[0]; x += cellSize {
+		for y := b.Min[1]; y < b.Max[1]; y += cellSize {
+			heap.Push(&q, newCell(orb.Point{x + cellSize/2, y + cellSize/2}, cellSize/2))
+		}
+	}
+	best := newCell(centroid, 0)
+	if c := newCell(b.Center(), 0); c.d > best.d {
+		best = c
+	}
+	for q.Len() > 0 {
+		cell := heap.Pop(&q).(*poleCell)
+		if cell.d > best.d {
+			best = cell
+		}
+		if cell.max-best.d <= precision {
+			continue
+		}
+		half := cell.half / 2
+		for _, off := range [4][2]float64{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}} {
+			heap.Push(&q, newCell(orb.Point{cell.c[0] + off[0]*half, cell.c[1] + off[1]*half}, half))
+		}
+	}
+	if best.d <= 0 {
+		return centroid
+	}
+	return best.c
+}
+
+// 候选格子：中心 c、半边长 half、中心到边界的有符号距离 d（区域外为负）、格内可能的最大距离 max
+type poleCell struct {
+	c      orb.Point
+	half   float64
+	d, max float64
+}
+
+// 按 max 从大到小出队
+type poleQueue []*poleCell
+
+func (q poleQueue) Len() int           { return len(q) }
+func (q poleQueue) Less(i, j int) bool { return q[i].max > q[j].max }
+func (q poleQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *poleQueue) Push(x any)        { *q = append(*q, x.(*poleCell)) }
+func (q *poleQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// 点到各边的最短平面距离（度）
+func planarDistanceToEdges(edges []segment, p orb.Point) float64 {
+	best := math.Inf(1)
+	for _, e := range edges {
+		a, b := e[0], e[1]
+		dx, dy := b[0]-a[0], b[1]-a[1]
+		x, y := a[0], a[1]
+		if dx != 0 || dy != 0 {
+			t := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy)
+			if t > 1 {
+				x, y = b[0], b[1]
+			} else if t > 0 {
+				x, y = a[0]+dx*t, a[1]+dy*t
+			}
+		}
+		if d := (p[0]-x)*(p[0]-x) + (p[1]-y)*(p[1]-y); d < best {
+			best = d
+		}
+	}
+	return math.Sqrt(best)
+}
@@ -0,0 +1,54 @@
+// gidindex.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// 进程内的 GID 对照表：启动时扫一遍叶子表，记下每个 GID 所在的层和第一行的 rowid。
+// 按 GID 查询的接口（/children、/latlng、/details 等）先确定层级，原来要按层逐个查询（预处理的库查 _gids 表），
+// 现在直接查表；不存在的 GID 不碰数据库。随后取该区域的一行（名称、上级等）时按 rowid 取，不再按 GID_n 扫描。
+// 同一个 GID 出现在多层时取最低的一层，与逐层查询的结果相同。
+// GADM 全量约 40 万个 GID，约 30 MB。GID_INDEX=0 时照旧查询数据库；热更新时随新版本重建
+type gidRef struct {
+	rowid int64
+	level int
+}
+
+type gidIndex map[string]gidRef
+
+func loadGIDIndex(db *sql.DB, table string) (gidIndex, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT rowid, GID_0, GID_1, GID_2, GID_3, GID_4, GID_5 FROM %s ORDER BY rowid;`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	idx := make(gidIndex)
+	var (
+		rowid int64
+		gids  [6]sql.NullString
+	)
+	for rows.Next() {
+		if err := rows.Scan(&rowid, &gids[0], &gids[1], &gids[2], &gids[3], &gids[4], &gids[5]); err != nil {
+			return nil, err
+		}
+		for lvl, g := range gids {
+			if !g.Valid || g.String == "" {
+				continue
+			}
+			if ref, ok := idx[g.String]; !ok || lvl < ref.level {
+				idx[g.String] = gidRef{rowid: rowid, level: lvl}
+			}
+		}
+	}
+	return idx, rows.Err()
+}
+
+// 按 GID 取该区域一行的 WHERE 条件及参数：在对照表中且层级相同时按 rowid，否则按 GID_n
+func (s *Server) gidWhere(gid string, level int) (string, any) {
+	if ref, ok := s.gids[gid]; ok && ref.level == level {
+		return "rowid = ?", ref.rowid
+	}
+	return fmt.Sprintf("GID_%d = ?", level), gid
+}
@@ -3,6 +3,24 @@ module gpkg-reverse
 go 1.22
 
 require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/golang/geo v0.0.0-20230421003525-6adc56603217
+	github.com/graphql-go/graphql v0.8.1
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/paulmach/orb v0.11.1
+	github.com/peterstace/simplefeatures v0.59.0
+	github.com/uber/h3-go/v4 v4.3.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	golang.org/x/crypto v0.33.0
+	golang.org/x/image v0.24.0
+	golang.org/x/text v0.22.0
+	google.golang.org/protobuf v1.36.7
+)
+
+require (
+	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/paulmach/protoscan v0.2.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	go.mongodb.org/mongo-driver v1.11.4 // indirect
+	golang.org/x/net v0.21.0 // indirect
 )
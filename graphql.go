@@ -0,0 +1,384 @@
+// graphql.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/kinds"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/paulmach/orb/geojson"
+)
+
+// 查询嵌套深度上限，防止 parent { children { parent ... } } 无限展开
+const maxGraphQLDepth = 12
+
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// 任意 JSON 值，用于 GeoJSON 几何
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "任意 JSON 值",
+	Serialize:   func(v any) any { return v },
+})
+
+func (s *Server) graphqlSchema() (graphql.Schema, error) {
+	centroidType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Centroid",
+		Fields: graphql.Fields{
+			"latitude":  &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"longitude": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			// 只读海拔缓存，未缓存时为 0
+			"elevation": &graphql.Field{Type: graphql.Float},
+		},
+	})
+	bboxType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BBox",
+		Fields: graphql.Fields{
+			"minLon": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"minLat": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"maxLon": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"maxLat": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		},
+	})
+	timezoneType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Timezone",
+		Fields: graphql.Fields{
+			"id":            &graphql.Field{Type: graphql.String},
+			"abbreviation":  &graphql.Field{Type: graphql.String},
+			"utcOffset":     &graphql.Field{Type: graphql.String},
+			"offsetSeconds": &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	var areaType *graphql.Object
+	areaType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "AdminArea",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"code":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"name":       &graphql.Field{Type: graphql.String},
+				"parentCode": &graphql.Field{Type: graphql.String},
+				"level":      &graphql.Field{Type: graphql.String},
+				"parent": &graphql.Field{
+					Type: areaType,
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						item := p.Source.(ChildrenItem)
+						if item.ParentCode == "" {
+							return nil, nil
+						}
+						return s.graphqlArea(p.Context, item.ParentCode)
+					},
+				},
+				"ancestors": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(areaType))),
+					Description: "国家 → … → 上一级，不含自身",
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						chain, err := s.ancestorsOf(p.Context, p.Source.(ChildrenItem).GID)
+						if err != nil {
+							return nil, err
+						}
+						return chain[:len(chain)-1], nil
+					},
+				},
+				"children": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(areaType))),
+					Args: graphql.FieldConfigArgument{
+						"limit":      &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+						"offset":     &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+						"sort":       &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "name"},
+						"desc":       &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+						"namePrefix": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					},
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						cq := ChildrenQuery{
+							Page:       Page{Limit: p.Args["limit"].(int), Offset: p.Args["offset"].(int)},
+							Sort:       p.Args["sort"].(string),
+							Desc:       p.Args["desc"].(bool),
+							NamePrefix: p.Args["namePrefix"].(string),
+						}
+						if cq.Sort != "name" && cq.Sort != "code" {
+							return nil, fmt.Errorf("invalid sort, use name or code")
+						}
+						if cq.Limit < 0 || cq.Limit > 5000 || cq.Offset < 0 {
+							return nil, fmt.Errorf("invalid limit/offset")
+						}
+						items, _, err := s.childrenOf(p.Context, p.Source.(ChildrenItem).GID, cq)
+						if err != nil && strings.Contains(err.Error(), "not found") {
+							return []ChildrenItem{}, nil
+						}
+						return items, err
+					},
+				},
+				"centroid": &graphql.Field{
+					Type: centroidType,
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						item, err := s.latlngOf(p.Context, p.Source.(ChildrenItem).GID)
+						if err != nil {
+							return nil, err
+						}
+						elevation, err := s.getElevation(item.GID)
+						if err != nil && !errors.Is(err, sql.ErrNoRows) {
+							return nil, err
+						}
+						item.Elevation = elevation
+						return item, nil
+					},
+				},
+				"bbox": &graphql.Field{
+					Type: bboxType,
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return s.bboxOf(p.Context, p.Source.(ChildrenItem).GID)
+					},
+				},
+				"boundary": &graphql.Field{
+					Type:        jsonScalar,
+					Description: "GeoJSON 几何；format 为 WKT 时返回 WKT 字符串",
+					Args: graphql.FieldConfigArgument{
+						"tolerance": &graphql.ArgumentConfig{Type: graphql.Float, DefaultValue: 0.0},
+						"format":    &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "GEOJSON"},
+					},
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						tolerance := p.Args["tolerance"].(float64)
+						if tolerance < 0 || tolerance > 1 {
+							return nil, fmt.Errorf("invalid tolerance, use 0..1")
+						}
+						shape, err := s.simplifiedShapeOf(p.Context, p.Source.(ChildrenItem).GID, tolerance)
+						if err != nil {
+							return nil, err
+						}
+						g := &OutputGeometry{Geom: shape.Geom}
+						switch strings.ToUpper(p.Args["format"].(string)) {
+						case "GEOJSON":
+							return geojson.NewGeometry(g.Geom), nil
+						case "WKT":
+							g.WKT = true
+							return g, nil
+						}
+						return nil, fmt.Errorf("invalid format, use GEOJSON or WKT")
+					},
+				},
+			}
+		}),
+	})
+
+	reverseType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ReverseResult",
+		Fields: graphql.Fields{
+			"area": &graphql.Field{
+				Type:        areaType,
+				Description: "命中的最末级行政区",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					list := p.Source.(*AdminLevels).List
+					if len(list) == 0 {
+						return nil, nil
+					}
+					return list[len(list)-1], nil
+				},
+			},
+			"path": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(areaType))),
+				Description: "国家 → … → 最末级",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(*AdminLevels).List, nil
+				},
+			},
+			"timezone": &graphql.Field{Type: timezoneType},
+			"distanceM": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "落在缝隙中、按最近行政区兜底时点到边界的距离（米）",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(*AdminLevels).DistanceM, nil
+				},
+			},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"adminArea": &graphql.Field{
+				Type: areaType,
+				Args: graphql.FieldConfigArgument{
+					"code": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return s.graphqlArea(p.Context, p.Args["code"].(string))
+				},
+			},
+			"reverse": &graphql.Field{
+				Type: reverseType,
+				Args: graphql.FieldConfigArgument{
+					"lat":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Float)},
+					"lon":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Float)},
+					"level": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 5},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					lat, lon := p.Args["lat"].(float64), p.Args["lon"].(float64)
+					level := p.Args["level"].(int)
+					if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+						return nil, fmt.Errorf("lat/lon out of range")
+					}
+					if level < 0 || level > 5 {
+						return nil, fmt.Errorf("invalid level, use 0..5")
+					}
+					res, err := s.reverseLevel(p.Context, lon, lat, nameLatin, level)
+					if errors.Is(err, sql.ErrNoRows) {
+						res, err = s.nearest(p.Context, lon, lat, s.nearestMaxM, nameLatin)
+					}
+					if errors.Is(err, sql.ErrNoRows) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					s.views.apply(res, s.views.def, nameLatin)
+					res.Timezone = s.timezoneOf(res.GID0, res.GID1)
+					res.truncate(level)
+					return res, nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// 按 GID（或 HASC）取行政区，找不到时返回 nil
+func (s *Server) graphqlArea(ctx context.Context, code string) (any, error) {
+	GID, err := s.resolveCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := s.ancestorsOf(ctx, GID)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return chain[len(chain)-1], nil
+}
+
+/************* 嵌套深度检查 *************/
+func graphqlDepth(doc *ast.Document) int {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[f.Name.Value] = f
+		}
+	}
+	var depthOf func(set *ast.SelectionSet, seen map[string]bool) int
+	depthOf = func(set *ast.SelectionSet, seen map[string]bool) int {
+		if set == nil {
+			return 0
+		}
+		max := 0
+		for _, sel := range set.Selections {
+			d := 0
+			switch sel := sel.(type) {
+			case *ast.Field:
+				d = 1 + depthOf(sel.SelectionSet, seen)
+			case *ast.InlineFragment:
+				d = depthOf(sel.SelectionSet, seen)
+			case *ast.FragmentSpread:
+				name := sel.Name.Value
+				if f, ok := fragments[name]; ok && !seen[name] {
+					seen[name] = true
+					d = depthOf(f.SelectionSet, seen)
+					delete(seen, name)
+				}
+			}
+			if d > max {
+				max = d
+			}
+		}
+		return max
+	}
+	max := 0
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok && op.Kind == kinds.OperationDefinition {
+			if d := depthOf(op.SelectionSet, map[string]bool{}); d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
+
+// GraphQL 结果自带 {data, errors}，不再包响应外壳
+func writeGraphQL(w http.ResponseWriter, status int, res *graphql.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func graphqlError(msg string) *graphql.Result {
+	return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New(msg))}
+}
+
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, graphqlError("invalid variables"))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeGraphQL(w, http.StatusBadRequest, graphqlError("invalid json body"))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeGraphQL(w, http.StatusMethodNotAllowed, graphqlError("method not allowed"))
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeGraphQL(w, http.StatusBadRequest, graphqlError("query required"))
+		return
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query)})})
+	if err != nil {
+		writeGraphQL(w, http.StatusBadRequest, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
+	}
+	if d := graphqlDepth(doc); d > maxGraphQLDepth {
+		writeGraphQL(w, http.StatusBadRequest, graphqlError(fmt.Sprintf("query too deep (%d), max %d", d, maxGraphQLDepth)))
+		return
+	}
+
+	res := graphql.Do(graphql.Params{
+		Schema:         s.gqlSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        r.Context(),
+	})
+	// 只记录解析字段时的错误，校验错误是客户端的问题
+	for _, e := range res.Errors {
+		if len(e.Path) > 0 && !strings.HasPrefix(e.Message, "invalid") && !strings.HasSuffix(e.Message, "out of range") {
+			log.Println("graphql error:", e.Message)
+		}
+	}
+	writeGraphQL(w, http.StatusOK, res)
+}
@@ -0,0 +1,163 @@
+// h3.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/uber/h3-go/v4"
+)
+
+// H3 六边形：/h3 列出覆盖行政区的六边形，点查询（/reverse 等）接受 ?h3= 六边形 id，取其中心点。
+// 分析系统按 H3 聚合时，行政区与六边形两个方向的换算都不用再自己做
+const maxH3Cells = 200000
+
+type H3Result struct {
+	GID         string   `json:"code"`
+	Resolution  int      `json:"resolution"`
+	Containment string   `json:"containment"`
+	Count       int      `json:"count"`
+	Cells       []string `json:"cells"`
+}
+
+type H3Res struct {
+	Code int       `json:"code"`
+	Msg  string    `json:"msg"`
+	Data *H3Result `json:"data"`
+}
+
+// center：六边形中心在区域内（相邻行政区的六边形互不重复，适合聚合）；
+// full：整个六边形在区域内；overlap：与区域有重叠（完整覆盖，相邻行政区会共用边界上的六边形）
+var h3Containments = map[string]h3.ContainmentMode{
+	"center":  h3.ContainmentCenter,
+	"full":    h3.ContainmentFull,
+	"overlap": h3.ContainmentOverlapping,
+}
+
+var errTooManyH3Cells = fmt.Errorf("too many cells, at most %d, use a lower resolution", maxH3Cells)
+
+// 六边形 id（十六进制，可带 0x）的中心点
+func decodeH3(s string) (lat, lon float64, err error) {
+	c := h3.Cell(h3.IndexFromString(strings.TrimSpace(s)))
+	if !c.IsValid() {
+		return 0, 0, fmt.Errorf("invalid h3 cell")
+	}
+	ll, err := h3.CellToLatLng(c)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid h3 cell")
+	}
+	return ll.Lat, ll.Lng, nil
+}
+
+func h3Loop(r orb.Ring) h3.GeoLoop {
+	// H3 的环不需要首尾重复
+	if len(r) > 1 && r[0] == r[len(r)-1] {
+		r = r[:len(r)-1]
+	}
+	loop := make(h3.GeoLoop, len(r))
+	for i, p := range r {
+		loop[i] = h3.LatLng{Lat: p.Lat(), Lng: p.Lon()}
+	}
+	return loop
+}
+
+// 覆盖多边形的六边形，去重后按 id 排序。先按面积估算个数，明显超过上限时不计算
+func h3Cells(mp orb.MultiPolygon, resolution int, mode h3.ContainmentMode) ([]h3.Cell, error) {
+	cellArea, err := h3.HexagonAreaAvgM2(resolution)
+	if err != nil {
+		return nil, err
+	}
+	if geo.Area(mp)/cellArea > maxH3Cells {
+		return nil, errTooManyH3Cells
+	}
+	seen := make(map[h3.Cell]bool)
+	var out []h3.Cell
+	for _, p := range mp {
+		if len(p) == 0 || len(p[0]) < 4 {
+			continue
+		}
+		poly := h3.GeoPolygon{GeoLoop: h3Loop(p[0])}
+		for _, hole := range p[1:] {
+			poly.Holes = append(poly.Holes, h3Loop(hole))
+		}
+		cells, err := h3.PolygonToCellsExperimental(poly, resolution, mode, maxH3Cells+1)
+		if errors.Is(err, h3.ErrMemoryBounds) {
+			return nil, errTooManyH3Cells
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cells {
+			if c != 0 && !seen[c] {
+				seen[c] = true
+				out = append(out, c)
+			}
+		}
+		if len(out) > maxH3Cells {
+			return nil, errTooManyH3Cells
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+func (s *Server) handleH3(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	code := strings.TrimSpace(q.Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	resolution := queryInt(r, "resolution", 7, 0, h3.MaxResolution)
+	containment := strings.ToLower(strings.TrimSpace(q.Get("containment")))
+	if containment == "" {
+		containment = "center"
+	}
+	mode, ok := h3Containments[containment]
+	if !ok {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid containment, use center, full or overlap")
+		return
+	}
+
+	shape, err := s.shapeOf(r.Context(), code)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "h3", err)
+		return
+	}
+	cells, err := h3Cells(shape.Geom, resolution, mode)
+	if err != nil {
+		if errors.Is(err, errTooManyH3Cells) {
+			writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+			return
+		}
+		log.Println("h3 error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	ids := make([]string, len(cells))
+	for i, c := range cells {
+		ids[i] = c.String()
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, H3Res{
+		Code: 200,
+		Msg:  "success",
+		Data: &H3Result{
+			GID:         shape.Item.GID,
+			Resolution:  resolution,
+			Containment: containment,
+			Count:       len(ids),
+			Cells:       ids,
+		},
+	})
+}
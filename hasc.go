@@ -0,0 +1,37 @@
+// hasc.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// HASC 代码形如 ID.JB.BD：两位国家码 + 每层一段；GADM 的 GID 都带 "_"，不会与之混淆
+var hascPattern = regexp.MustCompile(`^[A-Za-z]{2}(\.[A-Za-z0-9]+)+$`)
+
+// 把 HASC 代码解析为 GID；不是 HASC 或未找到时原样返回
+func (s *Server) resolveCode(ctx context.Context, code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if !hascPattern.MatchString(code) {
+		return code, nil
+	}
+	lvl := strings.Count(code, ".")
+	col := fmt.Sprintf("HASC_%d", lvl)
+	if lvl > 5 || !s.columns[col] {
+		return code, nil
+	}
+	var gid string
+	sqlStr := fmt.Sprintf(`SELECT GID_%d FROM %s WHERE %s = ? COLLATE NOCASE LIMIT 1`, lvl, s.table, col)
+	err := s.db.QueryRowContext(ctx, sqlStr, code).Scan(&gid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return code, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return gid, nil
+}
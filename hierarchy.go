@@ -0,0 +1,418 @@
+// hierarchy.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GID_0..GID_lvl, NAME_0..NAME_lvl 的查询列
+func (s *Server) pathColumns(lvl int, mode nameMode) string {
+	cols := make([]string, 0, 2*(lvl+1))
+	for i := 0; i <= lvl; i++ {
+		cols = append(cols, fmt.Sprintf("IFNULL(GID_%d, '')", i))
+	}
+	for i := 0; i <= lvl; i++ {
+		cols = append(cols, s.nameExpr(i, mode))
+	}
+	return strings.Join(cols, ", ")
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// 读取 pathColumns(lvl) 对应的一行，返回层级链
+func scanPath(row rowScanner, lvl int) ([]ChildrenItem, error) {
+	gids := make([]string, lvl+1)
+	names := make([]string, lvl+1)
+	dest := make([]any, 0, 2*(lvl+1))
+	for i := range gids {
+		dest = append(dest, &gids[i])
+	}
+	for i := range names {
+		dest = append(dest, &names[i])
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return chainOf(gids, names), nil
+}
+
+/************* Ancestors（子→父链） *************/
+func (s *Server) ancestorsOf(ctx context.Context, GID string) ([]ChildrenItem, error) {
+	GID = strings.TrimSpace(GID)
+	if GID == "" {
+		return nil, fmt.Errorf("gid required")
+	}
+	// 修正层新增的区域（加载修正层时 overrides 还为空）
+	if s.overrides != nil {
+		if a := s.overrides.byGID[GID]; a != nil && a.added {
+			path := chainOf(a.gids[:a.level+1], a.names[:a.level+1])
+			s.overrides.renameItems(path, nameLatin)
+			return path, nil
+		}
+	}
+
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+
+	where, arg := s.gidWhere(GID, level)
+	sqlStr := fmt.Sprintf(`SELECT %s FROM %s WHERE %s LIMIT 1`,
+		s.pathColumns(level, nameLatin), s.table, where)
+	path, err := scanPath(s.db.QueryRowContext(ctx, sqlStr, arg), level)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("gid not found")
+		}
+		return nil, err
+	}
+	if s.overrides != nil {
+		s.overrides.renameItems(path, nameLatin)
+	}
+	return path, nil
+}
+
+// 获取行政区域的完整上级链（国家 → … → 自身）
+func (s *Server) handleAncestors(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	items, err := s.ancestorsOf(r.Context(), code)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "ancestors", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, ChildrenRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &ChildrenItemList{List: items, Total: len(items)},
+	})
+}
+
+// 子树节点
+type TreeNode struct {
+	GID        string      `json:"code"`
+	Name       string      `json:"name"`
+	ParentCode string      `json:"parentCode"`
+	Level      string      `json:"level"`
+	Children   []*TreeNode `json:"children,omitempty"`
+}
+
+type TreeRes struct {
+	Code int       `json:"code"`
+	Msg  string    `json:"msg"`
+	Data *TreeNode `json:"data"`
+}
+
+/************* Tree（递归子树） *************/
+func (s *Server) treeOf(ctx context.Context, GID string, depth int) (*TreeNode, error) {
+	var root *TreeNode
+	err := s.walkTree(ctx, GID, depth, func(node, parent *TreeNode) error {
+		if parent == nil {
+			root = node
+		} else {
+			parent.Children = append(parent.Children, node)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// 一次查询取出整棵子树，按先序（父节点在前、同层按名称，同名按代码）逐个回调新节点，
+// 每个节点的子树连续给出，只需记住当前路径；visit 收到的 node 不含 Children，parent 为 nil 表示根节点
+func (s *Server) walkTree(ctx context.Context, GID string, depth int, visit func(node, parent *TreeNode) error) error {
+	GID = strings.TrimSpace(GID)
+	if GID == "" {
+		return fmt.Errorf("gid required")
+	}
+
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return err
+	}
+	maxLevel := level + depth
+	if maxLevel > 5 {
+		maxLevel = 5
+	}
+
+	parentCol := "''"
+	if level > 0 {
+		parentCol = fmt.Sprintf("IFNULL(GID_%d, '')", level-1)
+	}
+	cols := []string{parentCol}
+	order := make([]string, 0, maxLevel-level+1)
+	for i := level; i <= maxLevel; i++ {
+		cols = append(cols, fmt.Sprintf("IFNULL(GID_%d, '')", i), fmt.Sprintf("IFNULL(NAME_%d, '')", i))
+		order = append(order, fmt.Sprintf("NAME_%d COLLATE NOCASE", i), fmt.Sprintf("GID_%d", i))
+	}
+	sqlStr := fmt.Sprintf(`
+SELECT DISTINCT %s
+FROM %s
+WHERE GID_%d = ?
+ORDER BY %s;`,
+		strings.Join(cols, ", "), s.table, level, strings.Join(order, ", "))
+
+	rows, err := s.db.QueryContext(ctx, sqlStr, GID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	levelName := levelNameMap()
+	// path[i] 为当前路径上第 level+i 层的节点
+	path := make([]*TreeNode, 0, maxLevel-level+1)
+	for rows.Next() {
+		var rootParent string
+		vals := make([]string, 2*(maxLevel-level+1))
+		dest := []any{&rootParent}
+		for i := range vals {
+			dest = append(dest, &vals[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		var parent *TreeNode
+		for i := 0; i <= maxLevel-level; i++ {
+			gid, name := vals[2*i], vals[2*i+1]
+			if gid == "" {
+				break
+			}
+			if i >= len(path) || path[i].GID != gid {
+				node := &TreeNode{GID: gid, Name: name, ParentCode: rootParent, Level: levelName[level+i]}
+				path = append(path[:i], node)
+				if parent != nil {
+					node.ParentCode = parent.GID
+				}
+				if err := visit(node, parent); err != nil {
+					return err
+				}
+			}
+			parent = path[i]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		return fmt.Errorf("gid not found")
+	}
+	return nil
+}
+
+// 获取行政区域的子树（children of children），depth 为向下展开的层数
+func (s *Server) handleTree(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		code = env("GPKG_PARENT_CODE", "IDN")
+	}
+	depth := queryInt(r, "depth", 1, 1, 5)
+	if wantsNDJSON(r) {
+		s.streamTree(r.Context(), w, code, depth)
+		return
+	}
+	if !wantsCSV(r) && streamsJSON(w, TreeRes{}) {
+		w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+		s.streamTreeJSON(r.Context(), w, code, depth)
+		return
+	}
+	tree, err := s.treeOf(r.Context(), code, depth)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "tree", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	if wantsCSV(r) {
+		writeTreeCSV(w, tree)
+		return
+	}
+	writeJSON(w, http.StatusOK, TreeRes{
+		Code: 200,
+		Msg:  "success",
+		Data: tree,
+	})
+}
+
+// 嵌套的子树边遍历边输出（见 stream.go），与 treeOf 后整体编码的结果相同。
+// 节点输出时还不知道有没有下级，先不闭合，等下一个节点到来时再补上 "children":[ 或 }
+func (s *Server) streamTreeJSON(ctx context.Context, w http.ResponseWriter, code string, depth int) {
+	st := newJSONStream(w, formatContentTypes[formatJSON], !isBare(w))
+	type open struct {
+		node        *TreeNode
+		hasChildren bool
+	}
+	var stack []open
+	pop := func() error {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if top.hasChildren {
+			return st.raw("]}")
+		}
+		return st.raw("}")
+	}
+	err := s.walkTree(ctx, code, depth, func(node, parent *TreeNode) error {
+		if parent == nil {
+			st.open()
+		} else {
+			for stack[len(stack)-1].node != parent {
+				if err := pop(); err != nil {
+					return err
+				}
+			}
+			sep := ","
+			if top := &stack[len(stack)-1]; !top.hasChildren {
+				sep, top.hasChildren = `,"children":[`, true
+			}
+			if err := st.raw(sep); err != nil {
+				return err
+			}
+		}
+		// 不含 Children 的节点编码后去掉末尾的 }
+		b, err := json.Marshal(node)
+		if err != nil {
+			return err
+		}
+		stack = append(stack, open{node: node})
+		return st.raw(string(b[:len(b)-1]))
+	})
+	for err == nil && len(stack) > 0 {
+		err = pop()
+	}
+	if err != nil {
+		st.fail("tree", err)
+		return
+	}
+	st.close()
+}
+
+type LevelInfo struct {
+	Level int    `json:"level"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type LevelsResult struct {
+	GID      string      `json:"code"`
+	MaxLevel int         `json:"maxLevel"`
+	Levels   []LevelInfo `json:"levels"`
+}
+
+type LevelsRes struct {
+	Code int           `json:"code"`
+	Msg  string        `json:"msg"`
+	Data *LevelsResult `json:"data"`
+}
+
+/************* 层级深度（GADM 各国深度不同） *************/
+func (s *Server) levelsOf(ctx context.Context, GID string) (*LevelsResult, error) {
+	GID = strings.TrimSpace(GID)
+	if GID == "" {
+		return nil, fmt.Errorf("gid required")
+	}
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+
+	levelName := levelNameMap()
+	res := &LevelsResult{
+		GID:      GID,
+		MaxLevel: level,
+		Levels:   []LevelInfo{{Level: level, Name: levelName[level], Count: 1}},
+	}
+	for lvl := level + 1; lvl <= 5; lvl++ {
+		sqlStr := fmt.Sprintf(`SELECT COUNT(DISTINCT GID_%d) FROM %s WHERE GID_%d = ? AND GID_%d <> '';`,
+			lvl, s.table, level, lvl)
+		var n int
+		if err := s.db.QueryRowContext(ctx, sqlStr, GID).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		res.MaxLevel = lvl
+		res.Levels = append(res.Levels, LevelInfo{Level: lvl, Name: levelName[lvl], Count: n})
+	}
+	return res, nil
+}
+
+// 获取某个国家（或任意 GID）下可用的层级及各层数量
+func (s *Server) handleLevels(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		code = env("GPKG_PARENT_CODE", "IDN")
+	}
+	res, err := s.levelsOf(r.Context(), code)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "levels", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, LevelsRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
+
+// /reverse?include_levels=1 时每层一条，带各层自己的中心点和外接矩形
+type LevelDetail struct {
+	GID        string     `json:"code"`
+	Name       string     `json:"name"`
+	ParentCode string     `json:"parentCode"`
+	Level      string     `json:"level"`
+	Depth      int        `json:"depth"`
+	Latitude   float64    `json:"latitude"`
+	Longitude  float64    `json:"longitude"`
+	BBox       [4]float64 `json:"bbox"` // minLon, minLat, maxLon, maxLat
+}
+
+func (s *Server) attachLevelDetails(ctx context.Context, res *AdminLevels) error {
+	res.Levels = make([]LevelDetail, 0, len(res.List))
+	for depth, item := range res.List {
+		ll, err := s.latlngOf(ctx, item.GID)
+		if err != nil {
+			return err
+		}
+		bb, err := s.bboxOf(ctx, item.GID)
+		if err != nil {
+			return err
+		}
+		res.Levels = append(res.Levels, LevelDetail{
+			GID:        item.GID,
+			Name:       item.Name,
+			ParentCode: item.ParentCode,
+			Level:      item.Level,
+			Depth:      depth,
+			Latitude:   ll.Latitude,
+			Longitude:  ll.Longitude,
+			BBox:       [4]float64{bb.MinLon, bb.MinLat, bb.MaxLon, bb.MaxLat},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// httpserver.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTP 监听的超时与限制：http.ListenAndServe 默认不设超时，慢速或不再读取的客户端会一直占着连接和 goroutine。
+//
+//	HTTP_READ_HEADER_TIMEOUT  读完请求头（默认 10s）
+//	HTTP_READ_TIMEOUT         读完整个请求，含请求体（默认 60s）
+//	HTTP_WRITE_TIMEOUT        从读完请求头到写完响应（默认 120s）
+//	HTTP_IDLE_TIMEOUT         keep-alive 连接的空闲时间（默认 120s）
+//	HTTP_MAX_HEADER_BYTES     请求头大小（默认 64 KiB）
+//	REQUEST_TIMEOUT           每个请求的处理时间，到期后取消其数据库查询（默认 60s）
+//
+// 时长为 0 时不限制。上传、导出、SSE 等长时间的路由（apiRoute.LongLived）不受读写超时和 REQUEST_TIMEOUT 限制，
+// 只在客户端断开时取消
+type httpLimits struct {
+	readHeader, read, write, idle time.Duration
+	request                       time.Duration
+	maxHeaderBytes                int
+}
+
+func loadHTTPLimits() (httpLimits, error) {
+	var l httpLimits
+	for _, d := range []struct {
+		key, def string
+		dst      *time.Duration
+	}{
+		{"HTTP_READ_HEADER_TIMEOUT", "10s", &l.readHeader},
+		{"HTTP_READ_TIMEOUT", "60s", &l.read},
+		{"HTTP_WRITE_TIMEOUT", "120s", &l.write},
+		{"HTTP_IDLE_TIMEOUT", "120s", &l.idle},
+		{"REQUEST_TIMEOUT", "60s", &l.request},
+	} {
+		v, err := time.ParseDuration(env(d.key, d.def))
+		if err != nil || v < 0 {
+			return l, fmt.Errorf("invalid %s", d.key)
+		}
+		*d.dst = v
+	}
+	n, err := strconv.Atoi(env("HTTP_MAX_HEADER_BYTES", "65536"))
+	if err != nil || n < 1 {
+		return l, fmt.Errorf("invalid HTTP_MAX_HEADER_BYTES")
+	}
+	l.maxHeaderBytes = n
+	return l, nil
+}
+
+func (l httpLimits) server(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: l.readHeader,
+		ReadTimeout:       l.read,
+		WriteTimeout:      l.write,
+		IdleTimeout:       l.idle,
+		MaxHeaderBytes:    l.maxHeaderBytes,
+	}
+}
+
+// 普通路由的请求上下文加上 REQUEST_TIMEOUT；长时间的路由去掉该连接的读写超时
+func (l httpLimits) bound(rt apiRoute) http.HandlerFunc {
+	h := rt.Handler
+	if rt.LongLived {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			// 不支持时（如被不可展开的 ResponseWriter 包装）照旧受超时限制
+			_ = rc.SetReadDeadline(time.Time{})
+			_ = rc.SetWriteDeadline(time.Time{})
+			h(w, r)
+		}
+	}
+	if l.request <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), l.request)
+		defer cancel()
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// 查询出错时的响应：被 REQUEST_TIMEOUT 中止时为 503，客户端已断开时不再记日志，其余为 500
+func writeQueryError(w http.ResponseWriter, what string, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		writeErrorJSON(w, http.StatusServiceUnavailable, 503, "request timeout")
+	case errors.Is(err, context.Canceled):
+		writeErrorJSON(w, http.StatusServiceUnavailable, 503, "request canceled")
+	default:
+		log.Println(what+" error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+	}
+}
@@ -0,0 +1,422 @@
+// import.go
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// 命令行：把 GeoJSON FeatureCollection 或 Shapefile（如地方政府提供的边界）导入成与 gadm_410 相同结构的 GeoPackage，
+// 之后用 GPKG_PATH 加载或放进 GPKG_DIR。
+//
+//	gpkg-reverse import [geojson] -in kecamatan.geojson -out data/bandung.gpkg \
+//	    -set GID_0=IDN,NAME_0=Indonesia -map GID_1=kode_kab,NAME_1=nama_kab,GID_2=kode_kec,NAME_2=nama_kec
+//	gpkg-reverse import shp -in kecamatan.zip -out data/bandung.gpkg -config mapping.json
+
+// 可以映射的列：各层的 GID / 名称 / 属性，以及 COUNTRY
+var importColumnRe = regexp.MustCompile(`^((GID|NAME|VARNAME|NL_NAME|TYPE|ENGTYPE|HASC|CC|ISO)_[0-5]|COUNTRY)$`)
+
+type importFeature struct {
+	level  int
+	values map[string]string
+	geom   orb.MultiPolygon
+}
+
+// -config 指定的字段映射文件，与同名命令行参数含义相同，命令行参数优先：
+//
+//	{"map": {"GID_1": "KODE_KAB", "NAME_1": "NAMA_KAB"}, "set": {"GID_0": "IDN"}, "levelProp": "", "crs": "EPSG:23834", "encoding": "windows-1252"}
+type importConfig struct {
+	Map       map[string]string `json:"map"`
+	Set       map[string]string `json:"set"`
+	LevelProp string            `json:"levelProp"`
+	CRS       string            `json:"crs"`
+	Encoding  string            `json:"encoding"`
+	Table     string            `json:"table"`
+}
+
+func runImport(args []string) error {
+	format := "geojson"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		format, args = args[0], args[1:]
+	}
+	if format != "geojson" && format != "shp" {
+		return fmt.Errorf("unknown format %q, use geojson or shp", format)
+	}
+	fs := flag.NewFlagSet("import "+format, flag.ExitOnError)
+	in := fs.String("in", "", "input path: GeoJSON (- for stdin), or .shp / .zip for shp")
+	out := fs.String("out", "", "output GeoPackage path")
+	configPath := fs.String("config", "", "JSON field-mapping file with map, set, levelProp, crs, encoding, table")
+	table := fs.String("table", "", "output table name, default GPKG_TABLE")
+	mapping := fs.String("map", "", "column=property pairs, e.g. GID_1=kode_kab,NAME_1=nama_kab")
+	constants := fs.String("set", "", "column=value pairs applied to every feature, e.g. GID_0=IDN,NAME_0=Indonesia")
+	levelProp := fs.String("level-prop", "", "property holding the feature level (0..5), default the deepest mapped GID")
+	crs := fs.String("crs", "", "source CRS such as EPSG:32748, default from the GeoJSON crs member or .prj, else EPSG:4326")
+	encoding := fs.String("encoding", "", "shp: .dbf text encoding such as windows-1252, default from .cpg")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" || *out == "" {
+		return fmt.Errorf("-in and -out required")
+	}
+
+	var cfg importConfig
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("%s: %w", *configPath, err)
+		}
+	}
+	props, err := importColumns(cfg.Map, *mapping)
+	if err != nil {
+		return fmt.Errorf("map: %w", err)
+	}
+	fixed, err := importColumns(cfg.Set, *constants)
+	if err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	if props["GID_0"] == "" && fixed["GID_0"] == "" {
+		return fmt.Errorf("GID_0 must be mapped with map or set")
+	}
+	pick := func(flagValue, configValue, def string) string {
+		if flagValue != "" {
+			return flagValue
+		}
+		if configValue != "" {
+			return configValue
+		}
+		return def
+	}
+
+	var (
+		fc     *geojson.FeatureCollection
+		srcCRS string
+	)
+	switch format {
+	case "geojson":
+		fc, err = readGeoJSONFile(*in)
+		if err == nil {
+			srcCRS = geojsonCRS(fc)
+		}
+	case "shp":
+		fc, srcCRS, err = readShapefile(*in, pick(*encoding, cfg.Encoding, ""))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", *in, err)
+	}
+	sourceCRS := pick(*crs, cfg.CRS, pick(srcCRS, "", "EPSG:4326"))
+	if _, _, err := parseCRS(sourceCRS); err != nil {
+		return err
+	}
+
+	features, skipped, err := importFeatures(fc, props, fixed, pick(*levelProp, cfg.LevelProp, ""), sourceCRS)
+	if err != nil {
+		return err
+	}
+	leaves, parents := importLeaves(features)
+	if len(leaves) == 0 {
+		return fmt.Errorf("no polygon features in %s", *in)
+	}
+	var extra []string
+	for col := range props {
+		extra = append(extra, col)
+	}
+	for col := range fixed {
+		if props[col] == "" {
+			extra = append(extra, col)
+		}
+	}
+	outTable := pick(*table, cfg.Table, env("GPKG_TABLE", "gadm_410"))
+	if err := writeImport(*out, outTable, leaves, extra); err != nil {
+		return err
+	}
+	log.Printf("imported %d features into %s (table %s, from %s); skipped %d non-polygon, %d parent features",
+		len(leaves), *out, outTable, sourceCRS, skipped, parents)
+	return nil
+}
+
+// 配置文件中的映射加上命令行的 "GID_1=kode_kab,NAME_1=nama_kab"，列名统一大写
+func importColumns(base map[string]string, pairs string) (map[string]string, error) {
+	out := make(map[string]string)
+	add := func(col, val string) error {
+		col = strings.ToUpper(strings.TrimSpace(col))
+		if !importColumnRe.MatchString(col) {
+			return fmt.Errorf("invalid column %q, use columns like GID_1, NAME_1, HASC_1", col)
+		}
+		out[col] = strings.TrimSpace(val)
+		return nil
+	}
+	for col, val := range base {
+		if err := add(col, val); err != nil {
+			return nil, err
+		}
+	}
+	for _, pair := range splitList(pairs) {
+		col, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pair %q, use COLUMN=value", pair)
+		}
+		if err := add(col, val); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func readGeoJSONFile(path string) (*geojson.FeatureCollection, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return geojson.UnmarshalFeatureCollection(data)
+}
+
+// 旧式 GeoJSON 的 "crs": {"type": "name", "properties": {"name": "urn:ogc:def:crs:EPSG::32748"}}
+func geojsonCRS(fc *geojson.FeatureCollection) string {
+	crs, _ := fc.ExtraMembers["crs"].(map[string]any)
+	props, _ := crs["properties"].(map[string]any)
+	name, _ := props["name"].(string)
+	if name == "" {
+		return ""
+	}
+	if strings.HasSuffix(name, "CRS84") {
+		return "EPSG:4326"
+	}
+	return "EPSG:" + name[strings.LastIndex(name, ":")+1:]
+}
+
+func importFeatures(fc *geojson.FeatureCollection, props, fixed map[string]string, levelProp, crs string) ([]importFeature, int, error) {
+	var (
+		features []importFeature
+		skipped  int
+	)
+	for i, f := range fc.Features {
+		var mp orb.MultiPolygon
+		switch g := f.Geometry.(type) {
+		case orb.Polygon:
+			mp = orb.MultiPolygon{g}
+		case orb.MultiPolygon:
+			mp = g
+		default:
+			skipped++
+			continue
+		}
+		values := make(map[string]string, len(props)+len(fixed))
+		for col, v := range fixed {
+			values[col] = v
+		}
+		for col, prop := range props {
+			values[col] = importValue(importProp(f.Properties, prop))
+		}
+
+		level := -1
+		if levelProp != "" {
+			n, err := strconv.Atoi(importValue(importProp(f.Properties, levelProp)))
+			if err != nil || n < 0 || n > 5 {
+				return nil, 0, fmt.Errorf("feature %d: %s must be 0..5", i, levelProp)
+			}
+			level = n
+		} else {
+			for lvl := 0; lvl <= 5; lvl++ {
+				if values[fmt.Sprintf("GID_%d", lvl)] != "" {
+					level = lvl
+				}
+			}
+		}
+		if level < 0 {
+			return nil, 0, fmt.Errorf("feature %d: GID_0 is empty", i)
+		}
+		for lvl := 0; lvl <= 5; lvl++ {
+			gid, name := fmt.Sprintf("GID_%d", lvl), fmt.Sprintf("NAME_%d", lvl)
+			switch {
+			case lvl > level:
+				values[gid], values[name] = "", ""
+			case values[gid] == "":
+				return nil, 0, fmt.Errorf("feature %d: %s is empty", i, gid)
+			case values[name] == "":
+				// 没有名称时用代码，避免列表里出现空名称
+				values[name] = values[gid]
+			}
+		}
+
+		if crs != "EPSG:4326" {
+			if err := reprojectMultiPolygon(mp, crs); err != nil {
+				return nil, 0, err
+			}
+		}
+		features = append(features, importFeature{level: level, values: values, geom: mp})
+	}
+	return features, skipped, nil
+}
+
+// 属性名先精确匹配，再忽略大小写（Shapefile 字段名通常是大写）
+func importProp(props geojson.Properties, name string) any {
+	if v, ok := props[name]; ok {
+		return v
+	}
+	for k, v := range props {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func importValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+}
+
+func reprojectMultiPolygon(mp orb.MultiPolygon, crs string) error {
+	for _, poly := range mp {
+		for _, ring := range poly {
+			for i, p := range ring {
+				lat, lon, err := toWGS84(crs, p[0], p[1])
+				if err != nil {
+					return err
+				}
+				ring[i] = orb.Point{lon, lat}
+			}
+		}
+	}
+	return nil
+}
+
+// 表中只存叶子：文件同时含上下级时（如省和区县），有下级的要素不导入，
+// 否则同一点会同时命中上级和下级多边形
+func importLeaves(features []importFeature) ([]importFeature, int) {
+	parents := make(map[string]bool)
+	for _, f := range features {
+		for lvl := 0; lvl < f.level; lvl++ {
+			parents[fmt.Sprintf("%d/%s", lvl, f.values[fmt.Sprintf("GID_%d", lvl)])] = true
+		}
+	}
+	leaves := features[:0]
+	for _, f := range features {
+		if !parents[fmt.Sprintf("%d/%s", f.level, f.values[fmt.Sprintf("GID_%d", f.level)])] {
+			leaves = append(leaves, f)
+		}
+	}
+	return leaves, len(features) - len(leaves)
+}
+
+// 先写临时文件再改名，服务开着 RELOAD_WATCH_INTERVAL 时不会读到写了一半的文件
+func writeImport(out, table string, features []importFeature, extra []string) (err error) {
+	tmp := out + ".tmp"
+	os.Remove(tmp)
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	db, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cols := make([]string, 0, 12+len(extra))
+	for lvl := 0; lvl <= 5; lvl++ {
+		cols = append(cols, fmt.Sprintf("GID_%d", lvl), fmt.Sprintf("NAME_%d", lvl))
+	}
+	sort.Strings(extra)
+	for _, col := range extra {
+		if !strings.HasPrefix(col, "GID_") && !strings.HasPrefix(col, "NAME_") {
+			cols = append(cols, col)
+		}
+	}
+	colDefs := make([]string, len(cols))
+	for i, col := range cols {
+		colDefs[i] = col + " TEXT NOT NULL DEFAULT ''"
+	}
+	bound := features[0].geom.Bound()
+	maxLevel := 0
+	for _, f := range features {
+		bound = bound.Union(f.geom.Bound())
+		maxLevel = max(maxLevel, f.level)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := createGeoPackageTable(tx, table, colDefs, bound); err != nil {
+		return err
+	}
+	ins, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" (geom, %s) VALUES (?%s)`,
+		table, strings.Join(cols, ", "), strings.Repeat(", ?", len(cols))))
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+	for _, f := range features {
+		b, err := wkb.Marshal(f.geom, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		args := []any{wkbToGPKG(b, f.geom.Bound())}
+		for _, col := range cols {
+			args = append(args, f.values[col])
+		}
+		if _, err := ins.Exec(args...); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := buildRTree(db, table, "geom"); err != nil {
+		return err
+	}
+	// 与 README 中给 GADM 建的索引相同
+	for lvl := 0; lvl <= maxLevel; lvl++ {
+		stmt := fmt.Sprintf(`CREATE INDEX "idx_%[1]s_gid%[2]d" ON "%[1]s" (GID_%[2]d, GID_%[3]d, NAME_%[3]d);`, table, lvl, lvl+1)
+		if lvl == 5 {
+			stmt = fmt.Sprintf(`CREATE INDEX "idx_%[1]s_gid5" ON "%[1]s" (GID_5);`, table)
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`ANALYZE;`); err != nil {
+		return err
+	}
+	db.Close()
+	return os.Rename(tmp, out)
+}
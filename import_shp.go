@@ -0,0 +1,390 @@
+// import_shp.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Shapefile 读取（import shp）：.shp 多边形 + .dbf 属性，.prj 识别坐标系，.cpg 指定属性编码。
+// 输入可以是 .shp（同目录下找同名文件）或包含一套 Shapefile 的 zip
+
+// 读取 Shapefile 并转成 FeatureCollection；crs 为从 .prj 识别出的坐标系，没有 .prj 时为空
+func readShapefile(path, encoding string) (*geojson.FeatureCollection, string, error) {
+	open, err := shapefileParts(path)
+	if err != nil {
+		return nil, "", err
+	}
+	shp, err := open(".shp")
+	if err != nil {
+		return nil, "", err
+	}
+	dbf, err := open(".dbf")
+	if err != nil {
+		return nil, "", err
+	}
+	if encoding == "" {
+		if cpg, err := open(".cpg"); err == nil {
+			encoding = strings.TrimSpace(string(cpg))
+		}
+	}
+	decode, err := dbfDecoder(encoding)
+	if err != nil {
+		return nil, "", err
+	}
+	crs := ""
+	if prj, err := open(".prj"); err == nil {
+		if crs, err = prjEPSG(string(prj)); err != nil {
+			return nil, "", err
+		}
+	}
+
+	geoms, err := parseSHP(shp)
+	if err != nil {
+		return nil, "", fmt.Errorf(".shp: %w", err)
+	}
+	records, err := parseDBF(dbf, decode)
+	if err != nil {
+		return nil, "", fmt.Errorf(".dbf: %w", err)
+	}
+	if len(records) != len(geoms) {
+		return nil, "", fmt.Errorf(".shp has %d records but .dbf has %d", len(geoms), len(records))
+	}
+	fc := geojson.NewFeatureCollection()
+	for i, g := range geoms {
+		// dBASE 中标记删除的记录和空几何跳过
+		if records[i] == nil || g == nil {
+			continue
+		}
+		f := geojson.NewFeature(g)
+		f.Properties = records[i]
+		fc.Append(f)
+	}
+	return fc, crs, nil
+}
+
+// 按扩展名读取同一套 Shapefile 中的文件，不存在时返回 fs.ErrNotExist
+func shapefileParts(path string) (func(ext string) ([]byte, error), error) {
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		base := strings.TrimSuffix(path, filepath.Ext(path))
+		return func(ext string) ([]byte, error) {
+			b, err := os.ReadFile(base + ext)
+			if errors.Is(err, fs.ErrNotExist) {
+				b, err = os.ReadFile(base + strings.ToUpper(ext))
+			}
+			return b, err
+		}, nil
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var bases []string
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		ext := strings.ToLower(filepath.Ext(f.Name))
+		base := strings.TrimSuffix(f.Name, filepath.Ext(f.Name))
+		if ext == ".shp" {
+			bases = append(bases, base)
+		}
+		files[strings.ToLower(base)+ext] = f
+	}
+	if len(bases) != 1 {
+		return nil, fmt.Errorf("zip must contain exactly one .shp, found %d", len(bases))
+	}
+	// zip 关闭前把需要的文件读进内存
+	parts := make(map[string][]byte)
+	for _, ext := range []string{".shp", ".dbf", ".prj", ".cpg"} {
+		f := files[strings.ToLower(bases[0])+ext]
+		if f == nil {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		parts[ext] = b
+	}
+	return func(ext string) ([]byte, error) {
+		if b, ok := parts[ext]; ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("%s%s: %w", bases[0], ext, fs.ErrNotExist)
+	}, nil
+}
+
+// .shp：只支持 Polygon / PolygonZ / PolygonM，Z 和 M 丢弃；空记录为 nil
+func parseSHP(data []byte) ([]orb.Geometry, error) {
+	if len(data) < 100 || binary.BigEndian.Uint32(data) != 9994 {
+		return nil, errors.New("not a shapefile")
+	}
+	switch typ := binary.LittleEndian.Uint32(data[32:]); typ {
+	case 5, 15, 25:
+	default:
+		return nil, fmt.Errorf("shape type %d not supported, only polygons", typ)
+	}
+	var geoms []orb.Geometry
+	for off := 100; off+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[off+4:])) * 2
+		if off+8+n > len(data) {
+			return nil, fmt.Errorf("record %d truncated", len(geoms)+1)
+		}
+		mp, err := shpRecordPolygon(data[off+8 : off+8+n])
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(geoms)+1, err)
+		}
+		if mp == nil {
+			geoms = append(geoms, nil)
+		} else {
+			geoms = append(geoms, mp)
+		}
+		off += 8 + n
+	}
+	return geoms, nil
+}
+
+// 外环顺时针、洞逆时针（与 shpPolygon 写出的相同）；洞归到包含它的外环，
+// 找不到外环的（方向写反的文件）当作单独的多边形
+func shpRecordPolygon(b []byte) (orb.MultiPolygon, error) {
+	if len(b) < 4 || binary.LittleEndian.Uint32(b) == 0 {
+		return nil, nil
+	}
+	if len(b) < 44 {
+		return nil, errors.New("polygon record too short")
+	}
+	numParts := int(binary.LittleEndian.Uint32(b[36:]))
+	numPoints := int(binary.LittleEndian.Uint32(b[40:]))
+	pointsAt := 44 + 4*numParts
+	if numParts < 0 || numPoints < 0 || pointsAt+16*numPoints > len(b) {
+		return nil, errors.New("polygon record truncated")
+	}
+	var (
+		mp    orb.MultiPolygon
+		holes []orb.Ring
+	)
+	for i := 0; i < numParts; i++ {
+		start := int(binary.LittleEndian.Uint32(b[44+4*i:]))
+		end := numPoints
+		if i+1 < numParts {
+			end = int(binary.LittleEndian.Uint32(b[44+4*(i+1):]))
+		}
+		if start < 0 || start > end || end > numPoints {
+			return nil, errors.New("invalid part index")
+		}
+		ring := make(orb.Ring, 0, end-start)
+		for j := start; j < end; j++ {
+			p := b[pointsAt+16*j:]
+			ring = append(ring, orb.Point{
+				math.Float64frombits(binary.LittleEndian.Uint64(p)),
+				math.Float64frombits(binary.LittleEndian.Uint64(p[8:])),
+			})
+		}
+		if len(ring) < 4 {
+			continue
+		}
+		if ring.Orientation() == orb.CW {
+			mp = append(mp, orb.Polygon{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	for _, h := range holes {
+		placed := false
+		for i := range mp {
+			if planar.RingContains(mp[i][0], h[0]) {
+				mp[i] = append(mp[i], h)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			mp = append(mp, orb.Polygon{h})
+		}
+	}
+	return mp, nil
+}
+
+// .dbf：字符型为字符串，数值型为 float64（空值为 nil），逻辑型为 bool；标记删除的记录为 nil
+func parseDBF(data []byte, decode func([]byte) string) ([]geojson.Properties, error) {
+	if len(data) < 32 {
+		return nil, errors.New("not a dBASE file")
+	}
+	n := int(binary.LittleEndian.Uint32(data[4:]))
+	headerLen := int(binary.LittleEndian.Uint16(data[8:]))
+	recordLen := int(binary.LittleEndian.Uint16(data[10:]))
+	if headerLen > len(data) {
+		return nil, errors.New("header truncated")
+	}
+	type field struct {
+		name string
+		typ  byte
+		size int
+	}
+	var fields []field
+	size := 1
+	for off := 32; off+32 <= headerLen && data[off] != 0x0D; off += 32 {
+		fd := data[off : off+32]
+		name := fd[:11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		fields = append(fields, field{name: strings.TrimSpace(decode(name)), typ: fd[11], size: int(fd[16])})
+		size += int(fd[16])
+	}
+	if size > recordLen {
+		return nil, fmt.Errorf("fields need %d bytes but records have %d", size, recordLen)
+	}
+
+	records := make([]geojson.Properties, n)
+	for i := range records {
+		off := headerLen + i*recordLen
+		if off+recordLen > len(data) {
+			return nil, fmt.Errorf("record %d truncated", i+1)
+		}
+		rec := data[off : off+recordLen]
+		if rec[0] == '*' {
+			continue
+		}
+		props := make(geojson.Properties, len(fields))
+		pos := 1
+		for _, f := range fields {
+			raw := rec[pos : pos+f.size]
+			pos += f.size
+			props[f.name] = dbfValue(f.typ, raw, decode)
+		}
+		records[i] = props
+	}
+	return records, nil
+}
+
+func dbfValue(typ byte, raw []byte, decode func([]byte) string) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	switch typ {
+	case 'N', 'F':
+		v := strings.TrimSpace(string(raw))
+		if v == "" || strings.Trim(v, "*") == "" {
+			return nil
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+		return v
+	case 'L':
+		switch raw[0] {
+		case 'T', 't', 'Y', 'y':
+			return true
+		case 'F', 'f', 'N', 'n':
+			return false
+		}
+		return nil
+	default:
+		return strings.TrimSpace(decode(bytes.TrimRight(raw, "\x00")))
+	}
+}
+
+// .cpg / -encoding 指定的编码；未指定时合法的 UTF-8 原样使用，否则按 Windows-1252
+func dbfDecoder(label string) (func([]byte) string, error) {
+	label = strings.TrimSpace(label)
+	if _, err := strconv.Atoi(label); err == nil {
+		label = "windows-" + label // .cpg 常写成 1252、1251 这样的代码页编号
+	}
+	switch strings.ToUpper(strings.ReplaceAll(label, "-", "")) {
+	case "":
+		return func(b []byte) string {
+			if utf8.Valid(b) {
+				return string(b)
+			}
+			s, _ := charmap.Windows1252.NewDecoder().Bytes(b)
+			return string(s)
+		}, nil
+	case "UTF8":
+		return func(b []byte) string { return string(b) }, nil
+	}
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q", label)
+	}
+	return func(b []byte) string {
+		s, err := enc.NewDecoder().Bytes(b)
+		if err != nil {
+			return string(b)
+		}
+		return string(s)
+	}, nil
+}
+
+var (
+	prjAuthorityRe = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"?(\d+)"?\]`)
+	prjUTMRe       = regexp.MustCompile(`UTM[_ ]ZONE[_ ](\d{1,2})([NS])`)
+	prjTM3Re       = regexp.MustCompile(`TM[-_ ]?3[^0-9]*(\d{2})[._](\d)`)
+)
+
+// .prj（WKT）对应的 EPSG 代码，只识别 crs.go 支持的坐标系；
+// 地理坐标系一律按 EPSG:4326（DGN95 与 WGS84 差异在米级以下）
+func prjEPSG(wkt string) (string, error) {
+	wkt = strings.TrimSpace(wkt)
+	upper := strings.ToUpper(wkt)
+	if !strings.HasPrefix(upper, "PROJCS") {
+		return "EPSG:4326", nil
+	}
+	// 最外层（PROJCS）的 AUTHORITY 在最后
+	if m := prjAuthorityRe.FindAllStringSubmatch(wkt, -1); len(m) > 0 {
+		return "EPSG:" + m[len(m)-1][1], nil
+	}
+	dgn := strings.Contains(upper, "DGN")
+	switch {
+	case strings.Contains(upper, "MERCATOR_AUXILIARY_SPHERE") || strings.Contains(upper, "PSEUDO"):
+		return "EPSG:3857", nil
+	case prjUTMRe.MatchString(upper):
+		m := prjUTMRe.FindStringSubmatch(upper)
+		zone, _ := strconv.Atoi(m[1])
+		switch {
+		case dgn && m[2] == "N":
+			return fmt.Sprintf("EPSG:%d", 23866+zone-46), nil
+		case dgn:
+			return fmt.Sprintf("EPSG:%d", 23877+zone-47), nil
+		case m[2] == "N":
+			return fmt.Sprintf("EPSG:%d", 32600+zone), nil
+		default:
+			return fmt.Sprintf("EPSG:%d", 32700+zone), nil
+		}
+	case dgn && prjTM3Re.MatchString(upper):
+		// 46.2 为 23830，之后每个子带加一
+		m := prjTM3Re.FindStringSubmatch(upper)
+		zone, _ := strconv.Atoi(m[1])
+		sub, _ := strconv.Atoi(m[2])
+		return fmt.Sprintf("EPSG:%d", 23830+(zone-46)*2+sub-2), nil
+	}
+	name := wkt
+	if i := strings.Index(wkt, `"`); i >= 0 {
+		name = strings.SplitN(wkt[i+1:], `"`, 2)[0]
+	}
+	return "", fmt.Errorf("unrecognized projection %q in .prj, set -crs", name)
+}
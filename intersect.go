@@ -0,0 +1,224 @@
+// intersect.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/geojson"
+	sf "github.com/peterstace/simplefeatures/geom"
+)
+
+// 单次 /intersect 最多参与计算的叶子多边形数
+const maxIntersectLeaves = 20000
+
+type IntersectRequest struct {
+	Geometry json.RawMessage `json:"geometry"`
+	Level    *int            `json:"level"`
+}
+
+// 与输入多边形重叠的行政区
+type IntersectItem struct {
+	GID        string  `json:"code"`
+	Name       string  `json:"name"`
+	ParentCode string  `json:"parentCode"`
+	Level      string  `json:"level"`
+	OverlapKm2 float64 `json:"overlapKm2"`
+	// 重叠面积占该行政区面积的比例
+	AreaFraction float64 `json:"areaFraction"`
+	// 重叠面积占输入多边形面积的比例
+	InputFraction float64 `json:"inputFraction"`
+}
+
+type IntersectResult struct {
+	List     []IntersectItem `json:"list"`
+	InputKm2 float64         `json:"inputKm2"`
+	// 有叶子无法求交（几何修复后仍失败）时为 true，这些叶子所属行政区的 GID 在 skipped 中，重叠面积偏小或未列出
+	Partial bool     `json:"partial,omitempty"`
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+type IntersectRes struct {
+	Code int              `json:"code"`
+	Msg  string           `json:"msg"`
+	Data *IntersectResult `json:"data"`
+}
+
+// 解析 GeoJSON Polygon / MultiPolygon（也接受包着它们的 Feature）
+func parsePolygonGeometry(raw json.RawMessage) (orb.MultiPolygon, error) {
+	var g orb.Geometry
+	if f, err := geojson.UnmarshalFeature(raw); err == nil && f.Geometry != nil {
+		g = f.Geometry
+	} else {
+		gg, err := geojson.UnmarshalGeometry(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geometry: %w", err)
+		}
+		g = gg.Geometry()
+	}
+	switch gg := g.(type) {
+	case orb.Polygon:
+		return orb.MultiPolygon{gg}, nil
+	case orb.MultiPolygon:
+		return gg, nil
+	}
+	return nil, fmt.Errorf("geometry must be a Polygon or MultiPolygon")
+}
+
+// orb 几何转 simplefeatures，GADM 的边界不总是严格合法，叶子多边形不做校验
+func toSF(mp orb.MultiPolygon, validate bool) (sf.Geometry, error) {
+	b, err := wkb.Marshal(mp)
+	if err != nil {
+		return sf.Geometry{}, err
+	}
+	if validate {
+		return sf.UnmarshalWKB(b)
+	}
+	return sf.UnmarshalWKB(b, sf.NoValidate{})
+}
+
+// 球面面积（平方米）
+func sfArea(g sf.Geometry) (float64, error) {
+	og, err := wkb.Unmarshal(g.AsBinary())
+	if err != nil {
+		return 0, err
+	}
+	return geo.Area(og), nil
+}
+
+// 叶子与输入多边形的交；GADM 的叶子不总是合法，求交失败时按零宽缓冲修复后再试一次
+func intersectLeaf(in sf.Geometry, mp orb.MultiPolygon) (sf.Geometry, error) {
+	leaf, err := toSF(mp, false)
+	if err != nil {
+		return sf.Geometry{}, err
+	}
+	inter, err := sf.Intersection(in, leaf)
+	if err == nil {
+		return inter, nil
+	}
+	if leaf, err = sf.Buffer(leaf, 0); err != nil {
+		return sf.Geometry{}, err
+	}
+	return sf.Intersection(in, leaf)
+}
+
+/************* 自定义多边形与行政区求交 *************/
+func (s *Server) intersect(ctx context.Context, input orb.MultiPolygon, level int, mode nameMode) (*IntersectResult, error) {
+	in, err := toSF(input, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid polygon: %w", err)
+	}
+	inputM2 := geo.Area(input)
+	if inputM2 <= 0 {
+		return nil, fmt.Errorf("invalid polygon: empty area")
+	}
+
+	rows, err := s.levelRowsIn(input.Bound(), level, mode)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > maxIntersectLeaves {
+		return nil, fmt.Errorf("invalid polygon: covers too many areas, use a higher level or a smaller polygon")
+	}
+
+	overlap := make(map[string]float64)
+	items := make(map[string]ChildrenItem)
+	skipped := make(map[string]bool)
+	for _, row := range rows {
+		mp, err := s.leafGeom(row.rowid)
+		if err != nil {
+			continue
+		}
+		inter, err := intersectLeaf(in, mp)
+		if err != nil {
+			log.Printf("intersect: skip leaf %d of %s: %v", row.rowid, row.item.GID, err)
+			skipped[row.item.GID] = true
+			continue
+		}
+		m2, err := sfArea(inter)
+		if err != nil || m2 <= 0 {
+			continue
+		}
+		overlap[row.item.GID] += m2
+		items[row.item.GID] = row.item
+	}
+
+	res := &IntersectResult{
+		List:     make([]IntersectItem, 0, len(overlap)),
+		InputKm2: math.Round(inputM2/1e3) / 1e3,
+	}
+	for gid := range skipped {
+		res.Skipped = append(res.Skipped, gid)
+	}
+	sort.Strings(res.Skipped)
+	res.Partial = len(res.Skipped) > 0
+	for gid, m2 := range overlap {
+		// 行政区可能有落在输入外接矩形之外的部分，总面积取整个区域的面积（预先算好的，见 areaM2）
+		total, err := s.areaM2(ctx, gid)
+		if err != nil {
+			return nil, err
+		}
+		item := items[gid]
+		res.List = append(res.List, IntersectItem{
+			GID:           item.GID,
+			Name:          item.Name,
+			ParentCode:    item.ParentCode,
+			Level:         item.Level,
+			OverlapKm2:    math.Round(m2/1e3) / 1e3,
+			AreaFraction:  math.Round(math.Min(1, m2/total)*1e4) / 1e4,
+			InputFraction: math.Round(math.Min(1, m2/inputM2)*1e4) / 1e4,
+		})
+	}
+	sort.Slice(res.List, func(i, j int) bool {
+		if res.List[i].OverlapKm2 != res.List[j].OverlapKm2 {
+			return res.List[i].OverlapKm2 > res.List[j].OverlapKm2
+		}
+		return res.List[i].GID < res.List[j].GID
+	})
+	return res, nil
+}
+
+func (s *Server) handleIntersect(w http.ResponseWriter, r *http.Request) {
+	var req IntersectRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRouteBody)).Decode(&req); err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid json body")
+		return
+	}
+	if len(req.Geometry) == 0 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "geometry required")
+		return
+	}
+	if req.Level == nil || *req.Level < 0 || *req.Level > 5 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+		return
+	}
+	input, err := parsePolygonGeometry(req.Geometry)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+
+	res, err := s.intersect(r.Context(), input, *req.Level, parseNameMode(r))
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid polygon") {
+			writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+			return
+		}
+		writeQueryError(w, "intersect", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, IntersectRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
@@ -0,0 +1,290 @@
+// iso.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 随程序打包的 ISO 3166-1 / 3166-2 代码表（来自 Debian iso-codes）
+//
+//go:embed iso/iso3166-1.csv iso/iso3166-2.csv
+var isoFS embed.FS
+
+type isoCountry struct {
+	Alpha2, Alpha3, Numeric, Name string
+}
+
+type isoSubdivision struct {
+	Code, Name, Type, Parent string
+}
+
+type isoTables struct {
+	byAlpha2     map[string]*isoCountry
+	byAlpha3     map[string]*isoCountry
+	byNumeric    map[string]*isoCountry
+	subdivisions map[string]*isoSubdivision
+}
+
+var loadISOTables = sync.OnceValues(func() (*isoTables, error) {
+	t := &isoTables{
+		byAlpha2:     make(map[string]*isoCountry),
+		byAlpha3:     make(map[string]*isoCountry),
+		byNumeric:    make(map[string]*isoCountry),
+		subdivisions: make(map[string]*isoSubdivision),
+	}
+	rows, err := readEmbeddedCSV("iso/iso3166-1.csv")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		c := &isoCountry{Alpha2: r[0], Alpha3: r[1], Numeric: r[2], Name: r[3]}
+		t.byAlpha2[c.Alpha2] = c
+		t.byAlpha3[c.Alpha3] = c
+		t.byNumeric[c.Numeric] = c
+	}
+	rows, err = readEmbeddedCSV("iso/iso3166-2.csv")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		t.subdivisions[r[0]] = &isoSubdivision{Code: r[0], Name: r[1], Type: r[2], Parent: r[3]}
+	}
+	return t, nil
+})
+
+// 读取 CSV 并去掉表头
+func readEmbeddedCSV(name string) ([][]string, error) {
+	f, err := isoFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(rows) > 0 {
+		rows = rows[1:]
+	}
+	return rows, nil
+}
+
+// 可选的自定义对照表（ISO_CROSSWALK_PATH，两列 iso,gid），优先于 GADM 的 ISO_1 列
+type isoCrosswalk struct {
+	// ISO 代码 → GID，同一代码出现多次时取最后一行
+	gidOf map[string]string
+	// GID → ISO 3166-2 代码，多个代码对应同一 GID 时取文件中最先出现的，结果不随 map 的遍历顺序变化
+	isoOf map[string]string
+}
+
+func loadISOCrosswalk(path string) (isoCrosswalk, error) {
+	out := isoCrosswalk{gidOf: make(map[string]string), isoOf: make(map[string]string)}
+	if path == "" {
+		return out, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	rows, err := r.ReadAll()
+	if err != nil {
+		return out, err
+	}
+	for _, row := range rows {
+		iso := strings.ToUpper(strings.TrimSpace(row[0]))
+		if iso == "" || strings.EqualFold(iso, "iso") {
+			continue
+		}
+		out.gidOf[iso] = strings.TrimSpace(row[1])
+	}
+	// 按文件顺序再过一遍，被后面的行改指别的 GID 的代码不算
+	for _, row := range rows {
+		iso := strings.ToUpper(strings.TrimSpace(row[0]))
+		gid := out.gidOf[iso]
+		if _, ok := out.isoOf[gid]; !ok && gid != "" && strings.Contains(iso, "-") {
+			out.isoOf[gid] = iso
+		}
+	}
+	return out, nil
+}
+
+type IsoItem struct {
+	GID         string `json:"code"`
+	Name        string `json:"name"`
+	ParentCode  string `json:"parentCode"`
+	Level       string `json:"level"`
+	Alpha2      string `json:"alpha2,omitempty"`
+	Alpha3      string `json:"alpha3,omitempty"`
+	Subdivision string `json:"subdivision,omitempty"`
+}
+
+type IsoItemList struct {
+	List []IsoItem `json:"list"`
+}
+
+type IsoRes struct {
+	Code int          `json:"code"`
+	Msg  string       `json:"msg"`
+	Data *IsoItemList `json:"data"`
+}
+
+/************* ISO 3166 → GID *************/
+func (s *Server) gidsOfISO(ctx context.Context, code string) ([]IsoItem, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return nil, fmt.Errorf("iso code required")
+	}
+	tables, err := loadISOTables()
+	if err != nil {
+		return nil, err
+	}
+
+	var gids []string
+	if gid, ok := s.isoCrosswalk.gidOf[code]; ok {
+		gids = append(gids, gid)
+	} else if country, sub, ok := strings.Cut(code, "-"); !ok {
+		// ISO 3166-1：alpha-2、alpha-3 或数字代码，GADM 的 GID_0 即 alpha-3
+		c := tables.byAlpha3[code]
+		if c == nil {
+			c = tables.byAlpha2[code]
+		}
+		if c == nil {
+			c = tables.byNumeric[code]
+		}
+		if c != nil {
+			gids = append(gids, c.Alpha3)
+		}
+	} else {
+		// ISO 3166-2：先查 GADM 的 ISO_1 列，没有时按代码表里的名称匹配
+		if s.columns["ISO_1"] {
+			gids, err = s.distinctGIDs(ctx, fmt.Sprintf(`SELECT DISTINCT GID_1 FROM %s WHERE ISO_1 = ? AND GID_1 <> ''`, s.table), code)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c := tables.byAlpha2[country]
+		if sd := tables.subdivisions[code]; len(gids) == 0 && sd != nil && c != nil && sub != "" {
+			for lvl := 1; lvl <= 2 && len(gids) == 0; lvl++ {
+				gids, err = s.distinctGIDs(ctx, fmt.Sprintf(`SELECT DISTINCT GID_%d FROM %s WHERE GID_0 = ? AND NAME_%d = ? COLLATE NOCASE AND GID_%d <> ''`,
+					lvl, s.table, lvl, lvl), c.Alpha3, sd.Name)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	out := make([]IsoItem, 0, len(gids))
+	for _, gid := range gids {
+		item, err := s.isoOf(ctx, gid)
+		if err != nil {
+			if strings.Contains(err.Error(), "gid not found") {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, *item)
+	}
+	return out, nil
+}
+
+func (s *Server) distinctGIDs(ctx context.Context, sqlStr string, args ...any) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var gid string
+		if err := rows.Scan(&gid); err != nil {
+			return nil, err
+		}
+		out = append(out, gid)
+	}
+	return out, rows.Err()
+}
+
+/************* GID → ISO 3166 *************/
+func (s *Server) isoOf(ctx context.Context, GID string) (*IsoItem, error) {
+	path, err := s.ancestorsOf(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := loadISOTables()
+	if err != nil {
+		return nil, err
+	}
+	self := path[len(path)-1]
+	item := &IsoItem{
+		GID:        self.GID,
+		Name:       self.Name,
+		ParentCode: self.ParentCode,
+		Level:      self.Level,
+		Alpha3:     path[0].GID,
+	}
+	if c := tables.byAlpha3[item.Alpha3]; c != nil {
+		item.Alpha2 = c.Alpha2
+	}
+	item.Subdivision = s.isoCrosswalk.isoOf[item.GID]
+	if item.Subdivision == "" && len(path) == 2 && s.columns["ISO_1"] {
+		var iso sql.NullString
+		err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT ISO_1 FROM %s WHERE GID_1 = ? LIMIT 1`, s.table), item.GID).Scan(&iso)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if iso.String != "NA" {
+			item.Subdivision = iso.String
+		}
+	}
+	return item, nil
+}
+
+// ISO 3166 与 GADM GID 互查：?code=ID-JB 或 ?gid=IDN.9_1
+func (s *Server) handleISO(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var (
+		items []IsoItem
+		err   error
+	)
+	if gid := strings.TrimSpace(q.Get("gid")); gid != "" {
+		var item *IsoItem
+		if item, err = s.isoOf(r.Context(), gid); err == nil {
+			items = []IsoItem{*item}
+		}
+	} else if code := strings.TrimSpace(q.Get("code")); code != "" {
+		items, err = s.gidsOfISO(r.Context(), code)
+		if err == nil && len(items) == 0 {
+			err = fmt.Errorf("gid not found")
+		}
+	} else {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code or gid required")
+		return
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "iso", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, IsoRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &IsoItemList{List: items},
+	})
+}
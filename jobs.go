@@ -0,0 +1,652 @@
+// jobs.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// 异步批量反查：上传的点先落盘，后台逐行反查，结果写入文件；进度通过 SSE 推送
+
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+type JobInfo struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// 输入 / 输出格式：csv 或 ndjson
+	Format string `json:"format"`
+	Level  int    `json:"level"`
+	// 上传文件中的非空行数（不含 CSV 表头），完成后改为实际处理的行数
+	Total      int64      `json:"total"`
+	Processed  int64      `json:"processed"`
+	Failed     int64      `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ResultURL  string     `json:"resultUrl,omitempty"`
+}
+
+type JobRes struct {
+	Code int      `json:"code"`
+	Msg  string   `json:"msg"`
+	Data *JobInfo `json:"data"`
+}
+
+type batchJob struct {
+	mu     sync.Mutex
+	info   JobInfo
+	mode   nameMode
+	input  string
+	output string
+	cancel context.CancelFunc
+
+	processed atomic.Int64
+	failed    atomic.Int64
+}
+
+func (j *batchJob) snapshot() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := j.info
+	info.Processed = j.processed.Load()
+	info.Failed = j.failed.Load()
+	return info
+}
+
+type jobStore struct {
+	dir       string
+	maxUpload int64
+	ttl       time.Duration
+	// 同时运行的任务数
+	slots chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*batchJob
+}
+
+func newJobStore(dir string, maxUpload int64, concurrency int, ttl time.Duration) (*jobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	js := &jobStore{
+		dir:       dir,
+		maxUpload: maxUpload,
+		ttl:       ttl,
+		slots:     make(chan struct{}, concurrency),
+		jobs:      make(map[string]*batchJob),
+	}
+	go js.expire()
+	return js, nil
+}
+
+func (js *jobStore) get(id string) *batchJob {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.jobs[id]
+}
+
+func (js *jobStore) remove(id string) {
+	js.mu.Lock()
+	j := js.jobs[id]
+	delete(js.jobs, id)
+	js.mu.Unlock()
+	if j != nil {
+		os.Remove(j.input)
+		os.Remove(j.output)
+	}
+}
+
+// 完成超过 ttl 的任务连同结果文件一起删除
+func (js *jobStore) expire() {
+	for range time.Tick(10 * time.Minute) {
+		var expired []string
+		js.mu.Lock()
+		for id, j := range js.jobs {
+			info := j.snapshot()
+			if info.FinishedAt != nil && time.Since(*info.FinishedAt) > js.ttl {
+				expired = append(expired, id)
+			}
+		}
+		js.mu.Unlock()
+		for _, id := range expired {
+			js.remove(id)
+		}
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 统计写入的非空行数
+type lineCounter struct {
+	lines int64
+	atBOL bool
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		switch {
+		case b == '\n':
+			c.atBOL = true
+		case c.atBOL && b != '\r':
+			c.lines++
+			c.atBOL = false
+		}
+	}
+	return len(p), nil
+}
+
+// format=csv|ndjson，缺省时按 Content-Type 判断
+func jobInputFormat(r *http.Request) (string, error) {
+	if f := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); f != "" {
+		if f == "csv" || f == "ndjson" {
+			return f, nil
+		}
+		return "", fmt.Errorf("invalid format, use csv or ndjson")
+	}
+	ct := strings.ToLower(r.Header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(ct, "text/csv"):
+		return "csv", nil
+	case strings.HasPrefix(ct, "application/x-ndjson"), strings.HasPrefix(ct, "application/jsonl"),
+		strings.HasPrefix(ct, "application/ndjson"):
+		return "ndjson", nil
+	}
+	return "", fmt.Errorf("unsupported content type, use text/csv or application/x-ndjson (or format=)")
+}
+
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	format, err := jobInputFormat(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	level := 5
+	if v := strings.TrimSpace(r.URL.Query().Get("level")); v != "" {
+		if level, err = strconv.Atoi(v); err != nil || level < 0 || level > 5 {
+			writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+			return
+		}
+	}
+
+	id := newJobID()
+	j := &batchJob{
+		info:   JobInfo{ID: id, Status: jobQueued, Format: format, Level: level, CreatedAt: time.Now().UTC()},
+		mode:   parseNameMode(r),
+		input:  filepath.Join(s.jobs.dir, id+".input"),
+		output: filepath.Join(s.jobs.dir, id+".result"),
+	}
+	f, err := os.Create(j.input)
+	if err != nil {
+		log.Println("job upload error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	lc := &lineCounter{atBOL: true}
+	_, err = io.Copy(io.MultiWriter(f, lc), http.MaxBytesReader(w, r.Body, s.jobs.maxUpload))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(j.input)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeErrorJSON(w, http.StatusRequestEntityTooLarge, 413, fmt.Sprintf("upload too large, max %d bytes", s.jobs.maxUpload))
+			return
+		}
+		writeErrorJSON(w, http.StatusBadRequest, 400, "failed to read upload")
+		return
+	}
+	j.info.Total = lc.lines
+	if format == "csv" && j.info.Total > 0 {
+		j.info.Total-- // 表头
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	s.jobs.mu.Lock()
+	s.jobs.jobs[id] = j
+	s.jobs.mu.Unlock()
+	release := s.hold()
+	go func() {
+		defer release()
+		s.runJob(ctx, j)
+	}()
+
+	info := j.snapshot()
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, JobRes{Code: 200, Msg: "success", Data: &info})
+}
+
+func (s *Server) jobOf(w http.ResponseWriter, r *http.Request) *batchJob {
+	j := s.jobs.get(r.PathValue("id"))
+	if j == nil {
+		writeErrorJSON(w, http.StatusNotFound, 404, "job not found")
+	}
+	return j
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	j := s.jobOf(w, r)
+	if j == nil {
+		return
+	}
+	info := j.snapshot()
+	writeJSON(w, http.StatusOK, JobRes{Code: 200, Msg: "success", Data: &info})
+}
+
+// 取消未完成的任务，并删除任务及其文件
+func (s *Server) handleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	j := s.jobOf(w, r)
+	if j == nil {
+		return
+	}
+	j.cancel()
+	s.jobs.remove(j.info.ID)
+	info := j.snapshot()
+	writeJSON(w, http.StatusOK, JobRes{Code: 200, Msg: "success", Data: &info})
+}
+
+func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	j := s.jobOf(w, r)
+	if j == nil {
+		return
+	}
+	info := j.snapshot()
+	if info.Status != jobDone {
+		writeErrorJSON(w, http.StatusConflict, 409, "job is "+info.Status)
+		return
+	}
+	f, err := os.Open(j.output)
+	if err != nil {
+		log.Println("job result error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	defer f.Close()
+	ext, ct := "ndjson", "application/x-ndjson"
+	if info.Format == "csv" {
+		ext, ct = "csv", "text/csv; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, info.ID, ext))
+	// 支持 Range，大结果可断点续传
+	http.ServeContent(w, r, "", *info.FinishedAt, f)
+}
+
+// 每秒推送一次进度（有变化时），任务结束时推送最终状态后关闭
+func (s *Server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	j := s.jobOf(w, r)
+	if j == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	send := func(event string, info JobInfo) error {
+		data, _ := json.Marshal(info)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last JobInfo
+	idle := 0
+	for {
+		info := j.snapshot()
+		switch info.Status {
+		case jobDone, jobFailed, jobCanceled:
+			_ = send(info.Status, info)
+			return
+		}
+		if info != last {
+			if send("progress", info) != nil {
+				return
+			}
+			last, idle = info, 0
+		} else if idle++; idle >= 15 {
+			// 保活注释，防止代理断开空闲连接
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			idle = 0
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/************* 任务执行 *************/
+
+func (j *batchJob) setStatus(status, errMsg string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now().UTC()
+	j.info.Status = status
+	j.info.Error = errMsg
+	switch status {
+	case jobRunning:
+		j.info.StartedAt = &now
+	case jobDone, jobFailed, jobCanceled:
+		j.info.FinishedAt = &now
+		if status == jobDone {
+			j.info.Total = j.processed.Load()
+			j.info.ResultURL = "/jobs/" + j.info.ID + "/result"
+		}
+	}
+}
+
+func (s *Server) runJob(ctx context.Context, j *batchJob) {
+	select {
+	case s.jobs.slots <- struct{}{}:
+		defer func() { <-s.jobs.slots }()
+	case <-ctx.Done():
+		j.setStatus(jobCanceled, "")
+		return
+	}
+	j.setStatus(jobRunning, "")
+	err := s.processJob(ctx, j)
+	// 输入文件处理完即可删除，结果保留到过期
+	os.Remove(j.input)
+	switch {
+	case err == nil:
+		j.setStatus(jobDone, "")
+	case errors.Is(err, context.Canceled):
+		j.setStatus(jobCanceled, "")
+		os.Remove(j.output)
+	default:
+		log.Printf("job %s error: %v", j.info.ID, err)
+		j.setStatus(jobFailed, err.Error())
+		os.Remove(j.output)
+	}
+}
+
+// 输入的一行
+type jobPoint struct {
+	ID        string
+	Latitude  float64
+	Longitude float64
+	// 无法解析时的错误
+	Err string
+}
+
+// 结果的一行；Path 为国家 → … → 命中层级
+type JobResultRow struct {
+	ID        string         `json:"id,omitempty"`
+	Latitude  float64        `json:"latitude"`
+	Longitude float64        `json:"longitude"`
+	Code      string         `json:"code,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Level     string         `json:"level,omitempty"`
+	Path      []ChildrenItem `json:"path,omitempty"`
+	DistanceM float64        `json:"distance_m,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+func (row *JobResultRow) csvRecord() []string {
+	rec := []string{row.ID, strconv.FormatFloat(row.Latitude, 'f', -1, 64), strconv.FormatFloat(row.Longitude, 'f', -1, 64),
+		row.Code, row.Name, row.Level}
+	for i := 0; i <= 5; i++ {
+		if i < len(row.Path) {
+			rec = append(rec, row.Path[i].GID, row.Path[i].Name)
+		} else {
+			rec = append(rec, "", "")
+		}
+	}
+	dist := ""
+	if row.DistanceM > 0 {
+		dist = strconv.FormatFloat(row.DistanceM, 'f', 1, 64)
+	}
+	return append(rec, dist, row.Error)
+}
+
+var jobCSVHeader = []string{"id", "latitude", "longitude", "code", "name", "level",
+	"level0Code", "level0Name", "level1Code", "level1Name", "level2Code", "level2Name",
+	"level3Code", "level3Name", "level4Code", "level4Name", "level5Code", "level5Name",
+	"distance_m", "error"}
+
+func (s *Server) processJob(ctx context.Context, j *batchJob) error {
+	in, err := os.Open(j.input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(j.output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bw := bufio.NewWriterSize(out, 1<<16)
+
+	var write func(row *JobResultRow) error
+	var flush func() error
+	if j.info.Format == "csv" {
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(jobCSVHeader); err != nil {
+			return err
+		}
+		write = func(row *JobResultRow) error { return cw.Write(row.csvRecord()) }
+		flush = func() error { cw.Flush(); return cw.Error() }
+	} else {
+		enc := json.NewEncoder(bw)
+		write = func(row *JobResultRow) error { return enc.Encode(row) }
+		flush = func() error { return nil }
+	}
+
+	// 与 /aggregate 相同：历史轨迹点大多聚集，先查最近命中的几个叶子多边形
+	const recentSize = 8
+	var recent []*AdminLevels
+	err = readJobPoints(in, j.info.Format, func(p jobPoint) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		row := &JobResultRow{ID: p.ID, Latitude: p.Latitude, Longitude: p.Longitude, Error: p.Err}
+		if row.Error == "" {
+			hit, err := s.jobReverse(ctx, p, &recent, recentSize, j.mode)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				row.Error = "not found"
+			case err != nil:
+				return err
+			default:
+				path := hit.List[:min(len(hit.List), j.info.Level+1)]
+				last := path[len(path)-1]
+				row.Code, row.Name, row.Level, row.Path, row.DistanceM = last.GID, last.Name, last.Level, path, hit.DistanceM
+			}
+		}
+		if row.Error != "" {
+			j.failed.Add(1)
+		}
+		j.processed.Add(1)
+		return write(row)
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (s *Server) jobReverse(ctx context.Context, p jobPoint, recent *[]*AdminLevels, recentSize int, mode nameMode) (*AdminLevels, error) {
+	rlon, rlat := s.roundPoint(p.Longitude, p.Latitude)
+	for _, a := range *recent {
+		if a.contains(orb.Point{rlon, rlat}) {
+			return a, nil
+		}
+	}
+	a, err := s.reverse(ctx, p.Longitude, p.Latitude, mode)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 落在缝隙中的点按最近行政区兜底，不放进缓存
+		if a, err = s.nearest(ctx, p.Longitude, p.Latitude, s.nearestMaxM, mode); err == nil {
+			s.views.apply(a, s.views.def, mode)
+		}
+		return a, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.views.apply(a, s.views.def, mode)
+	*recent = append([]*AdminLevels{a}, (*recent)[:min(len(*recent), recentSize-1)]...)
+	return a, nil
+}
+
+func validJobPoint(p *jobPoint) {
+	if p.Err == "" && (p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180) {
+		p.Err = "lat/lon out of range"
+	}
+}
+
+// 逐行读取输入。CSV 需有表头，列名 lat/latitude、lon/lng/longitude，可选 id；
+// NDJSON 每行 {"id", "latitude"/"lat", "longitude"/"lon"/"lng"}
+func readJobPoints(r io.Reader, format string, emit func(jobPoint) error) error {
+	if format == "csv" {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.ReuseRecord = true
+		header, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid csv header: %w", err)
+		}
+		idCol, latCol, lonCol := -1, -1, -1
+		for i, h := range header {
+			switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))) {
+			case "id":
+				idCol = i
+			case "lat", "latitude":
+				latCol = i
+			case "lon", "lng", "longitude":
+				lonCol = i
+			}
+		}
+		if latCol < 0 || lonCol < 0 {
+			return fmt.Errorf("csv header must contain latitude and longitude columns")
+		}
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			var p jobPoint
+			switch {
+			case err != nil:
+				p.Err = "invalid csv row"
+			case max(idCol, latCol, lonCol) >= len(rec):
+				p.Err = "missing columns"
+			default:
+				if idCol >= 0 {
+					p.ID = rec[idCol]
+				}
+				var err1, err2 error
+				p.Latitude, err1 = strconv.ParseFloat(strings.TrimSpace(rec[latCol]), 64)
+				p.Longitude, err2 = strconv.ParseFloat(strings.TrimSpace(rec[lonCol]), 64)
+				if err1 != nil || err2 != nil {
+					p.Err = "invalid latitude/longitude values"
+				}
+			}
+			validJobPoint(&p)
+			if err := emit(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var v struct {
+			ID        json.RawMessage `json:"id"`
+			Lat       *float64        `json:"lat"`
+			Latitude  *float64        `json:"latitude"`
+			Lon       *float64        `json:"lon"`
+			Lng       *float64        `json:"lng"`
+			Longitude *float64        `json:"longitude"`
+		}
+		var p jobPoint
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			p.Err = "invalid json line"
+		} else {
+			if len(v.ID) > 0 {
+				if err := json.Unmarshal(v.ID, &p.ID); err != nil {
+					p.ID = string(v.ID)
+				}
+			}
+			lat := firstNonNil(v.Latitude, v.Lat)
+			lon := firstNonNil(v.Longitude, v.Lon, v.Lng)
+			if lat == nil || lon == nil {
+				p.Err = "latitude/longitude required"
+			} else {
+				p.Latitude, p.Longitude = *lat, *lon
+			}
+		}
+		validJobPoint(&p)
+		if err := emit(p); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func firstNonNil(vs ...*float64) *float64 {
+	for _, v := range vs {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+// jsonenc.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// 热点响应（/reverse、/children 及其外壳）的手写 JSON 编码：按字段直接追加到复用的缓冲区，
+// 不经 encoding/json 的反射，每个请求的分配从几十次降到几次，高负载下 GC 停顿减少。
+// 输出与 json.Encoder 逐字节相同（字段顺序、omitempty、HTML 转义、浮点格式、末尾换行）；
+// 不常用的字段（include_levels、include_geometry、overlays、外部属性）仍交给 encoding/json。
+// 编码出错（如 NaN）时退回 json.Encoder，由它返回同样的错误
+type jsonMessage interface {
+	appendJSON(b []byte) ([]byte, error)
+}
+
+// 超过 64 KiB 的缓冲区用完不放回，免得一次大响应长期占着内存
+const jsonBufferMax = 64 << 10
+
+var jsonBuffers = sync.Pool{New: func() any { b := make([]byte, 0, 1024); return &b }}
+
+// 编码到池中的缓冲区后一次写出；ok 为 false 时没有写出任何内容
+func writeJSONMessage(w io.Writer, m jsonMessage) (ok bool, err error) {
+	bp := jsonBuffers.Get().(*[]byte)
+	defer func() {
+		if cap(*bp) <= jsonBufferMax {
+			jsonBuffers.Put(bp)
+		}
+	}()
+	b, err := m.appendJSON((*bp)[:0])
+	if err != nil {
+		return false, nil
+	}
+	b = append(b, '\n')
+	*bp = b
+	_, err = w.Write(b)
+	return true, err
+}
+
+// 与 encoding/json 相同：转义控制字符、引号、反斜杠、<>&、U+2028/2029
+func appendJSONString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	mark := len(b)
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '\\', '"':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			// 非法 UTF-8 的替换方式随 Go 版本不同，交给 encoding/json
+			enc, _ := json.Marshal(s)
+			return append(b[:mark], enc...)
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// 与 encoding/json 相同：很小或很大的数用指数形式，指数不补零
+func appendJSONFloat(b []byte, f float64) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return b, fmt.Errorf("json: unsupported value: %v", f)
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b, nil
+}
+
+// 字段名连同前面的逗号（第一个字段除外）
+func appendJSONKey(b []byte, key string) []byte {
+	if b[len(b)-1] != '{' {
+		b = append(b, ',')
+	}
+	b = append(b, '"')
+	b = append(b, key...)
+	return append(b, '"', ':')
+}
+
+func appendJSONStringField(b []byte, key, v string, omitEmpty bool) []byte {
+	if omitEmpty && v == "" {
+		return b
+	}
+	return appendJSONString(appendJSONKey(b, key), v)
+}
+
+func appendJSONIntField(b []byte, key string, v int, omitEmpty bool) []byte {
+	if omitEmpty && v == 0 {
+		return b
+	}
+	return strconv.AppendInt(appendJSONKey(b, key), int64(v), 10)
+}
+
+func appendJSONBoolField(b []byte, key string, v bool) []byte {
+	if !v {
+		return b
+	}
+	return append(appendJSONKey(b, key), "true"...)
+}
+
+// 不常用的字段交给 encoding/json
+func appendJSONValueField(b []byte, key string, v any) ([]byte, error) {
+	enc, err := json.Marshal(v)
+	if err != nil {
+		return b, err
+	}
+	return append(appendJSONKey(b, key), enc...), nil
+}
+
+func appendJSONEnvelope(b []byte, code int, msg string, data jsonMessage) ([]byte, error) {
+	b = append(b, `{"code":`...)
+	b = strconv.AppendInt(b, int64(code), 10)
+	b = append(b, `,"msg":`...)
+	b = appendJSONString(b, msg)
+	b = append(b, `,"data":`...)
+	if data == nil {
+		b = append(b, "null"...)
+	} else {
+		var err error
+		if b, err = data.appendJSON(b); err != nil {
+			return b, err
+		}
+	}
+	return append(b, '}'), nil
+}
+
+func (t *TimezoneInfo) appendJSON(b []byte) ([]byte, error) {
+	b = append(b, '{')
+	b = appendJSONStringField(b, "id", t.ID, false)
+	b = appendJSONStringField(b, "abbreviation", t.Abbreviation, false)
+	b = appendJSONStringField(b, "utcOffset", t.UTCOffset, false)
+	b = appendJSONIntField(b, "offsetSeconds", t.OffsetSeconds, false)
+	return append(b, '}'), nil
+}
+
+func (c ChildrenItem) appendJSON(b []byte) ([]byte, error) {
+	b = append(b, '{')
+	b = appendJSONStringField(b, "code", c.GID, false)
+	b = appendJSONStringField(b, "name", c.Name, false)
+	b = appendJSONStringField(b, "parentCode", c.ParentCode, false)
+	b = appendJSONStringField(b, "level", c.Level, false)
+	if len(c.Attributes) > 0 {
+		var err error
+		if b, err = appendJSONValueField(b, "attributes", c.Attributes); err != nil {
+			return b, err
+		}
+	}
+	b = appendJSONBoolField(b, "overridden", c.Overridden)
+	return append(b, '}'), nil
+}
+
+// nil 为 null，与 encoding/json 相同
+func appendJSONItems(b []byte, items []ChildrenItem) ([]byte, error) {
+	if items == nil {
+		return append(b, "null"...), nil
+	}
+	b = append(b, '[')
+	for i, item := range items {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		var err error
+		if b, err = item.appendJSON(b); err != nil {
+			return b, err
+		}
+	}
+	return append(b, ']'), nil
+}
+
+func (a *AdminLevels) appendJSON(b []byte) ([]byte, error) {
+	var err error
+	b = append(b, '{')
+	b = appendJSONStringField(b, "level0Code", a.GID0, true)
+	b = appendJSONStringField(b, "level1Code", a.GID1, true)
+	b = appendJSONStringField(b, "level2Code", a.GID2, true)
+	b = appendJSONStringField(b, "level3Code", a.GID3, true)
+	b = appendJSONStringField(b, "level4Code", a.GID4, true)
+	b = appendJSONStringField(b, "level5Code", a.GID5, true)
+	b = appendJSONStringField(b, "level0Name", a.Name0, false)
+	b = appendJSONStringField(b, "level1Name", a.Name1, true)
+	b = appendJSONStringField(b, "level2Name", a.Name2, true)
+	b = appendJSONStringField(b, "level3Name", a.Name3, true)
+	b = appendJSONStringField(b, "level4Name", a.Name4, true)
+	b = appendJSONStringField(b, "level5Name", a.Name5, true)
+	if len(a.List) > 0 {
+		if b, err = appendJSONItems(appendJSONKey(b, "list"), a.List); err != nil {
+			return b, err
+		}
+	}
+	if a.DistanceM != 0 {
+		if b, err = appendJSONFloat(appendJSONKey(b, "distance_m"), a.DistanceM); err != nil {
+			return b, err
+		}
+	}
+	if a.Timezone != nil {
+		if b, err = a.Timezone.appendJSON(appendJSONKey(b, "timezone")); err != nil {
+			return b, err
+		}
+	}
+	if len(a.Levels) > 0 {
+		if b, err = appendJSONValueField(b, "levels", a.Levels); err != nil {
+			return b, err
+		}
+	}
+	if a.Geometry != nil {
+		if b, err = appendJSONValueField(b, "geometry", a.Geometry); err != nil {
+			return b, err
+		}
+	}
+	if len(a.Overlays) > 0 {
+		if b, err = appendJSONValueField(b, "overlays", a.Overlays); err != nil {
+			return b, err
+		}
+	}
+	b = appendJSONBoolField(b, "overridden", a.Overridden)
+	b = appendJSONBoolField(b, "disputed", a.Disputed)
+	return append(b, '}'), nil
+}
+
+func (l *ChildrenItemList) appendJSON(b []byte) ([]byte, error) {
+	b = append(b, `{"list":`...)
+	b, err := appendJSONItems(b, l.List)
+	if err != nil {
+		return b, err
+	}
+	b = appendJSONIntField(b, "total", l.Total, false)
+	b = appendJSONIntField(b, "limit", l.Limit, true)
+	b = appendJSONIntField(b, "offset", l.Offset, true)
+	return append(b, '}'), nil
+}
+
+func (r AdminLevelsRes) appendJSON(b []byte) ([]byte, error) {
+	if r.Data == nil {
+		return appendJSONEnvelope(b, r.Code, r.Msg, nil)
+	}
+	return appendJSONEnvelope(b, r.Code, r.Msg, r.Data)
+}
+
+func (r ChildrenRes) appendJSON(b []byte) ([]byte, error) {
+	if r.Data == nil {
+		return appendJSONEnvelope(b, r.Code, r.Msg, nil)
+	}
+	return appendJSONEnvelope(b, r.Code, r.Msg, r.Data)
+}
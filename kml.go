@@ -0,0 +1,168 @@
+// kml.go
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+type kmlRoot struct {
+	XMLName  xml.Name    `xml:"kml"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name"`
+	Styles     []kmlStyle     `xml:"Style"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlStyle struct {
+	ID        string  `xml:"id,attr"`
+	LineColor string  `xml:"LineStyle>color"` // aabbggrr
+	LineWidth float64 `xml:"LineStyle>width"`
+	Fill      int     `xml:"PolyStyle>fill"`
+}
+
+type kmlPlacemark struct {
+	ID       string      `xml:"id,attr"`
+	Name     string      `xml:"name"`
+	StyleURL string      `xml:"styleUrl"`
+	Data     []kmlData   `xml:"ExtendedData>Data"`
+	Geometry kmlMultiGeo `xml:"MultiGeometry"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlMultiGeo struct {
+	Polygons []kmlPolygon `xml:"Polygon"`
+}
+
+type kmlPolygon struct {
+	Outer string    `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	Inner []kmlRing `xml:"innerBoundaryIs"`
+}
+
+type kmlRing struct {
+	Coordinates string `xml:"LinearRing>coordinates"`
+}
+
+// 本区域红色粗线、下级黄色细线，均不填充，便于在 Google Earth 里看清影像
+var kmlStyles = []kmlStyle{
+	{ID: "area", LineColor: "ff0000ff", LineWidth: 3},
+	{ID: "child", LineColor: "ff00ffff", LineWidth: 1.5},
+}
+
+func kmlCoordinates(r orb.Ring) string {
+	var b strings.Builder
+	for i, p := range r {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(p.Lon(), 'f', -1, 64))
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatFloat(p.Lat(), 'f', -1, 64))
+	}
+	return b.String()
+}
+
+func kmlShape(shape *AreaShape, style string) kmlPlacemark {
+	pm := kmlPlacemark{
+		ID:       shape.Item.GID,
+		Name:     shape.Item.Name,
+		StyleURL: "#" + style,
+		Data: []kmlData{
+			{Name: "code", Value: shape.Item.GID},
+			{Name: "parentCode", Value: shape.Item.ParentCode},
+			{Name: "level", Value: shape.Item.Level},
+		},
+	}
+	for _, poly := range shape.Geom {
+		if len(poly) == 0 {
+			continue
+		}
+		kp := kmlPolygon{Outer: kmlCoordinates(poly[0])}
+		for _, hole := range poly[1:] {
+			kp.Inner = append(kp.Inner, kmlRing{Coordinates: kmlCoordinates(hole)})
+		}
+		pm.Geometry.Polygons = append(pm.Geometry.Polygons, kp)
+	}
+	return pm
+}
+
+// 区域边界（children=1 时连同各下级）导出为 KML
+func (s *Server) kmlOf(ctx context.Context, code string, children bool, mode nameMode, tolerance float64) (*kmlRoot, error) {
+	shape, err := s.simplifiedShapeOf(ctx, code, tolerance)
+	if err != nil {
+		return nil, err
+	}
+	doc := &kmlRoot{
+		Xmlns: "http://www.opengis.net/kml/2.2",
+		Document: kmlDocument{
+			Name:       shape.Item.Name,
+			Styles:     kmlStyles,
+			Placemarks: []kmlPlacemark{kmlShape(shape, "area")},
+		},
+	}
+	if !children {
+		return doc, nil
+	}
+	items, _, err := s.childrenOf(ctx, code, ChildrenQuery{Sort: "name", Names: mode})
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		return nil, err
+	}
+	for _, item := range items {
+		child, err := s.simplifiedShapeOf(ctx, item.GID, tolerance)
+		if err != nil {
+			return nil, err
+		}
+		child.Item = item
+		doc.Document.Placemarks = append(doc.Document.Placemarks, kmlShape(child, "child"))
+	}
+	return doc, nil
+}
+
+func (s *Server) handleKML(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	code := strings.TrimSpace(q.Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	tolerance, err := parseTolerance(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	children := q.Get("children") == "1" || strings.EqualFold(q.Get("children"), "true")
+
+	doc, err := s.kmlOf(r.Context(), code, children, parseNameMode(r), tolerance)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "kml", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+code+`.kml"`)
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(doc); err != nil {
+		log.Println("kml write error:", err)
+	}
+}
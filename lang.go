@@ -0,0 +1,85 @@
+// lang.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 返回哪一套名称列
+type nameMode int
+
+const (
+	nameLatin nameMode = iota // NAME_x（默认，拉丁字母）
+	nameLocal                 // NL_NAME_x（本地文字，如中文、阿拉伯文）
+	nameAlt                   // VARNAME_x（别名，多个时取第一个）
+	nameModes
+)
+
+// ?lang=：空/en/latin 用 NAME；alt/var/varname 用 VARNAME；local/native/nl 和 localLangs 中的语言代码用 NL_NAME。
+// 带地区的代码（zh-CN、zh_TW）和 Accept-Language 形式的列表（zh-CN,zh;q=0.9）取第一个的主语言。
+// GADM 的 NL_NAME 是当地文字的名称，只对用非拉丁文字的语言有意义；其他未知的值回退到 NAME，
+// 不把 lang=fr 这类请求当成本地文字。对应列为空或不存在时同样回退到 NAME。
+func parseNameMode(r *http.Request) nameMode {
+	return nameModeOf(r.URL.Query().Get("lang"))
+}
+
+// NL_NAME 所用文字的语言（GADM 中 NL_NAME 非空的国家的官方语言）
+var localLangs = map[string]bool{
+	"zh": true, "ja": true, "ko": true, "ar": true, "fa": true, "ur": true, "ps": true,
+	"ru": true, "uk": true, "be": true, "bg": true, "sr": true, "mk": true, "kk": true, "ky": true, "mn": true, "tg": true,
+	"el": true, "he": true, "hi": true, "ne": true, "bn": true, "ta": true, "si": true,
+	"th": true, "lo": true, "km": true, "my": true, "ka": true, "hy": true, "am": true, "dv": true, "bo": true,
+}
+
+func nameModeOf(lang string) nameMode {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, ",;"); i >= 0 {
+		lang = strings.TrimSpace(lang[:i])
+	}
+	switch lang {
+	case "", "en", "latin":
+		return nameLatin
+	case "alt", "var", "varname":
+		return nameAlt
+	case "local", "native", "nl":
+		return nameLocal
+	}
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if localLangs[lang] {
+		return nameLocal
+	}
+	return nameLatin
+}
+
+// 该层名称的原始列，mode 对应的列不存在时返回 NAME_x
+func (s *Server) nameColumn(lvl int, mode nameMode) string {
+	var col string
+	switch mode {
+	case nameLocal:
+		col = fmt.Sprintf("NL_NAME_%d", lvl)
+	case nameAlt:
+		col = fmt.Sprintf("VARNAME_%d", lvl)
+	}
+	if col == "" || !s.columns[col] {
+		return fmt.Sprintf("NAME_%d", lvl)
+	}
+	return col
+}
+
+// 该层名称的 SQL 表达式，空值回退到 NAME_x
+func (s *Server) nameExpr(lvl int, mode nameMode) string {
+	name := fmt.Sprintf("NAME_%d", lvl)
+	col := s.nameColumn(lvl, mode)
+	if col == name {
+		return fmt.Sprintf("IFNULL(%s, '')", name)
+	}
+	if mode == nameAlt {
+		// VARNAME 形如 "Jabar|West Java"，取第一个
+		col = fmt.Sprintf("CASE WHEN instr(%[1]s, '|') > 0 THEN substr(%[1]s, 1, instr(%[1]s, '|') - 1) ELSE %[1]s END", col)
+	}
+	return fmt.Sprintf("COALESCE(NULLIF(TRIM(%s), ''), %s, '')", col, name)
+}
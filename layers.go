@@ -0,0 +1,266 @@
+// layers.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// LAYERS_CONFIG 中的一个附加图层（销售区域、邮政分区等），每个要素带一到多层 code/name 列
+type LayerConfig struct {
+	Name   string             `json:"name"`
+	Path   string             `json:"path"`
+	Table  string             `json:"table"`
+	Geom   string             `json:"geom"`
+	Levels []LayerLevelConfig `json:"levels"`
+}
+
+type LayerLevelConfig struct {
+	Code  string `json:"code"`
+	Name  string `json:"name"`
+	Label string `json:"label"`
+}
+
+type layer struct {
+	cfg LayerConfig
+	ds  *dataset
+	sql string
+}
+
+// 每个图层命中的层级链，未命中时 list 为空
+type LayerHit struct {
+	Layer string         `json:"layer"`
+	Found bool           `json:"found"`
+	List  []ChildrenItem `json:"list"`
+}
+
+type LayerHitList struct {
+	List []LayerHit `json:"list"`
+}
+
+type LayerHitRes struct {
+	Code int           `json:"code"`
+	Msg  string        `json:"msg"`
+	Data *LayerHitList `json:"data"`
+}
+
+func loadLayers(path string) ([]*layer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs []LayerConfig
+	if err := json.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	out := make([]*layer, 0, len(cfgs))
+	seen := map[string]bool{"gadm": true}
+	for _, c := range cfgs {
+		if c.Name == "" || c.Path == "" || c.Table == "" || len(c.Levels) == 0 {
+			return nil, fmt.Errorf("layer %q: name, path, table and levels are required", c.Name)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("layer %q: duplicate name", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Geom == "" {
+			c.Geom = "geom"
+		}
+		ds, err := openDataset(c.Path, c.Table, c.Geom)
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %w", c.Name, err)
+		}
+		cols, err := tableColumns(ds.db, c.Table)
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %w", c.Name, err)
+		}
+		selects := make([]string, 0, 2*len(c.Levels)+1)
+		for i, lv := range c.Levels {
+			if !cols[strings.ToUpper(lv.Code)] || !cols[strings.ToUpper(lv.Name)] {
+				return nil, fmt.Errorf("layer %q: level %d columns %s/%s not found", c.Name, i, lv.Code, lv.Name)
+			}
+			selects = append(selects, "a."+lv.Code, "a."+lv.Name)
+		}
+		selects = append(selects, "a."+c.Geom)
+		out = append(out, &layer{
+			cfg: c,
+			ds:  ds,
+			sql: fmt.Sprintf(`
+SELECT %s
+FROM %s AS a
+JOIN %s AS r ON a.rowid = r.id
+WHERE r.minx <= ? AND r.maxx >= ? AND r.miny <= ? AND r.maxy >= ?
+ORDER BY (r.maxx - r.minx) * (r.maxy - r.miny), r.id
+LIMIT ? OFFSET ?;`, strings.Join(selects, ", "), c.Table, ds.rtree),
+		})
+	}
+	return out, nil
+}
+
+// 在图层中找包含该点的第一个要素（外接矩形小的在前），返回其层级链；候选同 GADM 分页取出
+func (l *layer) lookup(pt orb.Point) ([]ChildrenItem, error) {
+	for offset := 0; ; offset += candidatePage {
+		chain, n, err := l.lookupPage(pt, offset)
+		if err != nil || chain != nil || n < candidatePage {
+			return chain, err
+		}
+	}
+}
+
+func (l *layer) lookupPage(pt orb.Point, offset int) ([]ChildrenItem, int, error) {
+	rows, err := l.ds.db.Query(l.sql, pt.Lon(), pt.Lon(), pt.Lat(), pt.Lat(), candidatePage, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	n := len(l.cfg.Levels)
+	count := 0
+	for rows.Next() {
+		count++
+		vals := make([]sql.NullString, 2*n)
+		var blob []byte
+		dest := make([]any, 0, 2*n+1)
+		for i := range vals {
+			dest = append(dest, &vals[i])
+		}
+		dest = append(dest, &blob)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, count, err
+		}
+		wkbBytes, _, err := gpkgToWKB(blob)
+		if err != nil {
+			continue
+		}
+		mp, err := decodeMultiPolygon(wkbBytes)
+		if err != nil || !planar.MultiPolygonContains(mp, pt) {
+			continue
+		}
+
+		chain := make([]ChildrenItem, 0, n)
+		parent := ""
+		for i, lv := range l.cfg.Levels {
+			code := vals[2*i].String
+			if code == "" {
+				continue
+			}
+			label := lv.Label
+			if label == "" {
+				label = fmt.Sprintf("LEVEL_%d", i)
+			}
+			chain = append(chain, ChildrenItem{GID: code, Name: vals[2*i+1].String, ParentCode: parent, Level: label})
+			parent = code
+		}
+		return chain, count, nil
+	}
+	return nil, count, rows.Err()
+}
+
+/************* 多图层反查 *************/
+func (s *Server) reverseAll(ctx context.Context, lon, lat float64, mode nameMode, view string) ([]LayerHit, error) {
+	hits := make([]LayerHit, 0, len(s.layers)+1)
+
+	gadm := LayerHit{Layer: "gadm", List: make([]ChildrenItem, 0)}
+	res, err := s.reverse(ctx, lon, lat, mode)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if res != nil {
+		s.views.apply(res, view, mode)
+		gadm.Found, gadm.List = true, res.List
+	}
+	hits = append(hits, gadm)
+
+	overlays, err := s.layerHits(s.layers, lon, lat)
+	if err != nil {
+		return nil, err
+	}
+	return append(hits, overlays...), nil
+}
+
+// 在给定图层中逐个查找，每个图层一条结果
+func (s *Server) layerHits(layers []*layer, lon, lat float64) ([]LayerHit, error) {
+	rlon, rlat := s.roundPoint(lon, lat)
+	hits := make([]LayerHit, 0, len(layers))
+	for _, l := range layers {
+		chain, err := l.lookup(orb.Point{rlon, rlat})
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", l.cfg.Name, err)
+		}
+		hit := LayerHit{Layer: l.cfg.Name, Found: chain != nil, List: chain}
+		if hit.List == nil {
+			hit.List = make([]ChildrenItem, 0)
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+// /reverse?overlays=sales,zones 中的图层，按参数顺序；all 为所有图层，未配置的名称报错
+func (s *Server) parseOverlays(r *http.Request) ([]*layer, error) {
+	v := strings.TrimSpace(r.URL.Query().Get("overlays"))
+	if v == "" {
+		return nil, nil
+	}
+	if v == "all" {
+		return s.layers, nil
+	}
+	var out []*layer
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		found := false
+		for _, l := range s.layers {
+			if l.cfg.Name == name {
+				out, found = append(out, l), true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown overlay %s", name)
+		}
+	}
+	return out, nil
+}
+
+func (s *Server) handleReverseAll(w http.ResponseWriter, r *http.Request) {
+	lat, lon, err := parseLatLon(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "lat/lon out of range")
+		return
+	}
+	view, err := s.views.parse(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	hits, err := s.reverseAll(r.Context(), lon, lat, parseNameMode(r), view)
+	if err != nil {
+		writeQueryError(w, "reverse all", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, LayerHitRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &LayerHitList{List: hits},
+	})
+}
@@ -0,0 +1,159 @@
+// levelreverse.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+// 按层反查：?level= 只要到某一层时，不必找到包含该点的叶子。预处理的库中每层的行政区另存一份
+// 合并了各叶子、再按 coarseTolerance 简化的几何（<table>_levelN.coarse），启动时把它们的外接矩形读进内存索引。
+// 反查时先在该层的索引中筛出外接矩形包含该点的行政区，用合并几何判断：点在某个区内且离简化边界超过容差时
+// 直接采用（理由同两段判断，见 coarse.go），只解码这几个区的合并几何，不查叶子、不解码叶子几何。
+// 没有这样的区（点落在容差带内、在海上、该处的叶子比 level 浅等）时照常完整反查再截断，结果相同。
+// 只用于 0..maxLevelLookup-1 层；未预处理的库、旧版本构建的库（没有 coarse 列）、
+// 有修正层几何（见 overrides.go）、争议地区（见 disputed.go）或 STORAGE=spatialite 时都照常完整反查
+const maxLevelLookup = 5
+
+// 每层一个外接矩形索引，rowid 为该层表的 rowid
+type levelIndexes [maxLevelLookup]*memIndex
+
+func loadLevelIndexes(db *sql.DB, table string) (levelIndexes, error) {
+	var idx levelIndexes
+	if cols, err := tableColumns(db, levelTableName(table, 0)); err != nil || !cols["COARSE"] {
+		return idx, nil
+	}
+	for lvl := range idx {
+		rows, err := db.Query(fmt.Sprintf(`SELECT rowid, minx, miny, maxx, maxy FROM "%s" WHERE coarse IS NOT NULL;`, levelTableName(table, lvl)))
+		if err != nil {
+			return idx, err
+		}
+		var boxes []boxNode
+		for rows.Next() {
+			var n boxNode
+			if err := rows.Scan(&n.ref, &n.minx, &n.miny, &n.maxx, &n.maxy); err != nil {
+				rows.Close()
+				return idx, err
+			}
+			boxes = append(boxes, n)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return idx, err
+		}
+		idx[lvl] = buildMemIndex(boxes)
+	}
+	return idx, nil
+}
+
+// 反查到 level 层为止，结果只含 0..level 层
+func (s *Server) reverseLevel(ctx context.Context, lon, lat float64, mode nameMode, level int) (*AdminLevels, error) {
+	if res, err := s.levelHit(ctx, lon, lat, mode, level); res != nil || err != nil {
+		return res, err
+	}
+	res, err := s.reverse(ctx, lon, lat, mode)
+	if err != nil {
+		return nil, err
+	}
+	res.truncate(level)
+	return res, nil
+}
+
+// 按该层的合并几何判断，不能确定时返回 nil, nil
+func (s *Server) levelHit(ctx context.Context, lon, lat float64, mode nameMode, level int) (*AdminLevels, error) {
+	if level >= maxLevelLookup || s.levelIndex[level] == nil || s.coarseTolerance == 0 || s.spatial != nil || len(s.overrides.areas) > 0 || len(s.views.disputed) > 0 {
+		return nil, nil
+	}
+	rlon, rlat := s.roundPoint(lon, lat)
+	// 完整的结果已在缓存中时直接截断
+	if res, ok := s.results.get(pointKey{rlon, rlat, mode}); ok && res != nil {
+		res = res.clone()
+		res.truncate(level)
+		return res, nil
+	}
+	ids := s.levelIndex[level].search(rlon, rlat, rlon, rlat)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	pt := orb.Point{rlon, rlat}
+	table := levelTableName(s.table, level)
+	idsJSON, _ := json.Marshal(ids)
+	rows, err := s.stmts.query(ctx, fmt.Sprintf(`SELECT a.rowid, a.gid, a.coarse FROM json_each(?) AS j JOIN "%s" AS a ON a.rowid = j.value ORDER BY j.key;`, table), string(idsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	gid := ""
+	for rows.Next() {
+		var (
+			rowid int64
+			g     string
+			blob  []byte
+		)
+		if err := rows.Scan(&rowid, &g, &blob); err != nil {
+			return nil, err
+		}
+		if idx := s.levelShape(level, rowid, blob); idx != nil && !idx.near(pt, s.coarseTolerance*1.001) && idx.contains(pt) {
+			gid = g
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if gid == "" {
+		return nil, nil
+	}
+	return s.levelChain(ctx, gid, level, mode)
+}
+
+// 解码该层行政区的合并几何，与叶子的简化几何共用缓存，键为 -(rowid*maxLevelLookup+level+1)，不与叶子的 rowid 冲突
+func (s *Server) levelShape(level int, rowid int64, blob []byte) *shapeIndex {
+	key := -(rowid*maxLevelLookup + int64(level) + 1)
+	if _, idx, ok := s.coarseCache.get(key); ok {
+		return idx
+	}
+	mp, err := decodeMultiPolygon(blob)
+	if err != nil {
+		return nil
+	}
+	idx := newShapeIndex(mp)
+	s.coarseCache.put(key, mp, idx)
+	return idx
+}
+
+// 该层行政区及其上级的 GID 和名称，取自它的任意一个叶子行
+func (s *Server) levelChain(ctx context.Context, gid string, level int, mode nameMode) (*AdminLevels, error) {
+	cols := make([]string, 0, 2*(level+1))
+	for lvl := 0; lvl <= level; lvl++ {
+		cols = append(cols, fmt.Sprintf("IFNULL(GID_%d, '')", lvl))
+	}
+	for lvl := 0; lvl <= level; lvl++ {
+		cols = append(cols, s.nameExpr(lvl, mode))
+	}
+	var c candidate
+	dest := make([]any, 0, len(cols))
+	for lvl := 0; lvl <= level; lvl++ {
+		dest = append(dest, &c.gids[lvl])
+	}
+	for lvl := 0; lvl <= level; lvl++ {
+		dest = append(dest, &c.names[lvl])
+	}
+	err := s.stmts.queryRow(ctx, fmt.Sprintf(`SELECT %s FROM "%s" WHERE GID_%d = ? LIMIT 1;`, strings.Join(cols, ", "), s.table, level), gid).Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := c.adminLevels()
+	s.overrides.rename(res, mode)
+	return res, nil
+}
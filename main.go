@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/binary"
 	"encoding/json"
@@ -11,13 +12,19 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/graphql-go/graphql"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
 	"github.com/paulmach/orb/planar"
 )
 
@@ -37,12 +44,68 @@ type AdminLevels struct {
 	Name5 string `json:"level5Name,omitempty"`
 
 	List []ChildrenItem `json:"list,omitempty"`
+
+	// 仅在最近行政区兜底时返回：点到该区域边界的距离（米）
+	DistanceM float64 `json:"distance_m,omitempty"`
+
+	// 所在时区
+	Timezone *TimezoneInfo `json:"timezone,omitempty"`
+
+	// ?include_levels=1 时每层的详细信息
+	Levels []LevelDetail `json:"levels,omitempty"`
+
+	// ?include_geometry= 时返回命中区域的边界
+	Geometry *OutputGeometry `json:"geometry,omitempty"`
+
+	// ?overlays= 时附加图层（见 layers.go）的命中，每个图层一条
+	Overlays []LayerHit `json:"overlays,omitempty"`
+
+	// 命中的区域或某一层的名称来自修正层（见 overrides.go）
+	Overridden bool `json:"overridden,omitempty"`
+
+	// 命中的区域属于争议地区，层级链按 ?view= 视角给出（见 disputed.go）
+	Disputed bool `json:"disputed,omitempty"`
+
+	// 命中的最末级多边形及其 GID
+	geom  orb.MultiPolygon
+	index *shapeIndex
+	leaf  string
+}
+
+// 命中的最末级多边形是否包含该点，用于批量反查时复用最近的结果
+func (a *AdminLevels) contains(pt orb.Point) bool {
+	if a.index != nil {
+		return a.index.contains(pt)
+	}
+	return planar.MultiPolygonContains(a.geom, pt)
+}
+
+// 调用方会改写结果（截断、附加时区和几何等），缓存中的结果只给出副本；多边形只读，共用
+func (a *AdminLevels) clone() *AdminLevels {
+	c := *a
+	c.List = append([]ChildrenItem(nil), a.List...)
+	c.Levels = append([]LevelDetail(nil), a.Levels...)
+	c.Overlays = append([]LayerHit(nil), a.Overlays...)
+	return &c
+}
+
+// 只保留 0..level 层，用于 ?level= 粗粒度反查
+func (a *AdminLevels) truncate(level int) {
+	gids := []*string{&a.GID0, &a.GID1, &a.GID2, &a.GID3, &a.GID4, &a.GID5}
+	names := []*string{&a.Name0, &a.Name1, &a.Name2, &a.Name3, &a.Name4, &a.Name5}
+	for i := level + 1; i <= 5; i++ {
+		*gids[i] = ""
+		*names[i] = ""
+	}
+	if level+1 < len(a.List) {
+		a.List = a.List[:level+1]
+	}
 }
 
 type AdminLevelsRes struct {
-	Code int           `json:"code"`
-	Msg  string        `json:"msg"`
-	Data *AdminLevels  `json:"data"`
+	Code int          `json:"code"`
+	Msg  string       `json:"msg"`
+	Data *AdminLevels `json:"data"`
 }
 
 type ChildrenItem struct {
@@ -50,9 +113,17 @@ type ChildrenItem struct {
 	Name       string `json:"name"`
 	ParentCode string `json:"parentCode"`
 	Level      string `json:"level"`
+
+	// ?include= 时的外部属性，见 attributes.go
+	Attributes map[string]any `json:"attributes,omitempty"`
+	// 名称来自修正层，见 overrides.go
+	Overridden bool `json:"overridden,omitempty"`
 }
 type ChildrenItemList struct {
-	List []ChildrenItem `json:"list"`
+	List   []ChildrenItem `json:"list"`
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit,omitempty"`
+	Offset int            `json:"offset,omitempty"`
 }
 type ChildrenRes struct {
 	Code int               `json:"code"`
@@ -62,31 +133,91 @@ type ChildrenRes struct {
 
 // 行政区域的坐标点
 type LatlngItem struct {
-	GID        string `json:"code"`
-	Latitude float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-	Name       string `json:"name"`
-	ParentCode string `json:"parentCode"`
-	Level      string `json:"level"`
+	GID        string  `json:"code"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Name       string  `json:"name"`
+	ParentCode string  `json:"parentCode"`
+	Level      string  `json:"level"`
 	Elevation  float64 `json:"elevation"`
+	// ?geohash_precision= 时返回中心点的 geohash
+	Geohash  string        `json:"geohash,omitempty"`
+	Timezone *TimezoneInfo `json:"timezone,omitempty"`
+	// 不可达极点：区域内离边界最远的点，一定落在区域内，适合放标注
+	Pole *LabelPoint `json:"pole,omitempty"`
 }
 
 type LatlngRes struct {
-	Code int               `json:"code"`
-	Msg  string            `json:"msg"`
-	Data *LatlngItem 	`json:"data"`
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data *LatlngItem `json:"data"`
 }
 
-
 type Server struct {
 	db           *sql.DB
 	elevationDB  *sql.DB
 	table        string
 	geomCol      string
 	rtreeTable   string
-	sqlCandidate string
+	sqlCandidate [nameModes]string
+	sqlRow       [nameModes]string
+	spatial      *spatialStore
 	roundPlaces  int
-	googleAPIKey string
+	elevationSrc elevationSource
+	// /elevation/batch 查询海拔来源的限制，见 elevation.go
+	elevationLimit *elevationLimit
+	columns        map[string]bool
+	nearestMaxM    float64
+	names          atomic.Pointer[nameIndex]
+	isoCrosswalk   isoCrosswalk
+	tz             *tzIndex
+	stats          func() (*DatasetStats, error)
+	meta           func() (*DatasetMeta, error)
+	prev           *dataset
+	crosswalk      *gidCrosswalk
+	layers         []*layer
+	attributes     *attributeStore
+	overrides      *overrideStore
+	views          *disputedViews
+	tiles          *tileCache
+	gqlSchema      graphql.Schema
+	jobs           *jobStore
+	// 数据文件路径，质心缓存库（CENTROIDS_PATH）及其加载结果，见 centroids.go
+	path          string
+	kind, source  string
+	centroidsPath string
+	centroids     atomic.Pointer[centroidStore]
+	// 由 build 命令预处理的库（见 build.go），builtTolerance 为其中各层简化几何的容差
+	built          bool
+	builtTolerance float64
+	// 其中已有的多分辨率几何，由粗到细
+	geomStores []geomResolution
+	// 所属的数据集版本，热更新后旧版本等引用全部释放再关闭，见 reload.go
+	gen *generation
+	// 进程内的外接矩形索引（见 memindex.go），及按 rowid 取候选行的查询
+	index            *memIndex
+	sqlCandidateByID [nameModes]string
+	// 解码后的叶子多边形缓存，见 geomcache.go
+	geomCache *geomCache
+	// 按取整后的坐标缓存的反查结果，见 resultcache.go
+	results *resultCache
+	// 请求路径上反复执行的查询的预编译语句，见 stmt.go
+	stmts *stmtCache
+	// 每次反查并行判断候选的 goroutine 数，见 parallel.go
+	workers int
+	// 两段判断用的简化几何的容差（0 为没有）及其解码缓存，见 coarse.go
+	coarseTolerance float64
+	coarseCache     *geomCache
+	// GID 到层级和 rowid 的对照表，未启用时为 nil，见 gidindex.go
+	gids gidIndex
+	// /children 的结果缓存，见 childcache.go
+	childrenCache *childrenCache
+	// 叶子内部的 S2 格子，没有时为空，见 cells.go
+	cells cellIndex
+	// 各层合并几何的外接矩形索引，没有时为 nil，见 levelreverse.go
+	levelIndex levelIndexes
+	// 预处理的库中每层表有面积列（旧版本构建的库没有），见 build.go
+	builtAreas bool
 }
 
 func env(key, def string) string {
@@ -97,13 +228,22 @@ func env(key, def string) string {
 }
 
 /************* 统一 JSON 响应工具（错误固定 ChildrenRes） *************/
+// 按 Accept 协商的格式写出（默认 JSON），见 negotiate.go
 func writeJSON(w http.ResponseWriter, status int, v any) {
-	w.Header().Set("Content-Type", "application/json")
+	if isBare(w) {
+		v = unwrapEnvelope(v)
+	}
+	format := responseFormatOf(w, v)
+	w.Header().Set("Content-Type", formatContentTypes[format])
 	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(v)
+	_ = encodeResponse(w, format, v)
 }
 
 func writeErrorJSON(w http.ResponseWriter, httpStatus, bizCode int, msg string) {
+	if isBare(w) {
+		writeProblem(w, httpStatus, msg)
+		return
+	}
 	// 所有错误一律用 ChildrenRes 格式返回
 	resp := ChildrenRes{
 		Code: bizCode,
@@ -172,120 +312,354 @@ func levelNameMap() map[int]string {
 		2: "CITY",
 		3: "DISTRICT",
 		4: "VILLAGE",
+		5: "SUBVILLAGE",
 	}
 }
 
+// 层级名称 → 层级数字
+func levelRankMap() map[string]int {
+	m := make(map[string]int)
+	for lvl, name := range levelNameMap() {
+		m[name] = lvl
+	}
+	return m
+}
+
+// 按层级顺序把 GID/Name 串成 ChildrenItem 链，空 GID 的层级跳过
+func chainOf(gids, names []string) []ChildrenItem {
+	levelName := levelNameMap()
+	list := make([]ChildrenItem, 0, len(gids))
+	parent := ""
+	for i, gid := range gids {
+		if gid == "" {
+			continue
+		}
+		list = append(list, ChildrenItem{
+			GID:        gid,
+			Name:       names[i],
+			ParentCode: parent,
+			Level:      levelName[i],
+		})
+		parent = gid
+	}
+	return list
+}
+
 /************* 反向地理 *************/
-func (s *Server) reverse(lon, lat float64) (*AdminLevels, error) {
-	f := math.Pow10(s.roundPlaces)
-	rlon := math.Round(lon*f) / f
-	rlat := math.Round(lat*f) / f
+// r-tree 候选行：叶子多边形及其完整层级
+type candidate struct {
+	gids  [6]string
+	names [6]string
+	geom  orb.MultiPolygon
+	index *shapeIndex // 点面判断的索引，见 polyindex.go
+	// 数据表中的 rowid 和解码前的几何
+	rowid int64
+	blob  []byte
+	// 解码前的简化几何，用于两段判断，见 coarse.go
+	coarse []byte
+}
 
-	rows, err := s.db.Query(s.sqlCandidate, rlon, rlon, rlat, rlat)
-	if err != nil {
-		return nil, err
+func (c *candidate) contains(pt orb.Point) bool {
+	if c.index != nil {
+		return c.index.contains(pt)
 	}
-	defer rows.Close()
+	return planar.MultiPolygonContains(c.geom, pt)
+}
 
-	for rows.Next() {
-		var (
-			g0, g1, g2, g3, g4, g5 string
-			n0, n1, n2, n3, n4, n5 string
-			blob                   []byte
-		)
-		if err := rows.Scan(&g0, &g1, &g2, &g3, &g4, &g5, &n0, &n1, &n2, &n3, &n4, &n5, &blob); err != nil {
-			return nil, err
+func (c *candidate) adminLevels() *AdminLevels {
+	g, n := c.gids, c.names
+	leaf := ""
+	for _, gid := range g {
+		if gid != "" {
+			leaf = gid
+		}
+	}
+	return &AdminLevels{
+		GID0: g[0], GID1: g[1], GID2: g[2], GID3: g[3], GID4: g[4], GID5: g[5],
+		Name0: n[0], Name1: n[1], Name2: n[2], Name3: n[3], Name4: n[4], Name5: n[5],
+		List:  chainOf(g[:], n[:]),
+		geom:  c.geom,
+		index: c.index,
+		leaf:  leaf,
+	}
+}
+
+// 遍历 bbox 与 [minx,maxx]x[miny,maxy] 相交的候选行，fn 返回 false 时停止。
+// 外接矩形小的在前：包含该点的最小候选几乎总是命中的那个，国家大小的候选往往不用解码
+func (s *Server) eachCandidate(ctx context.Context, mode nameMode, minx, miny, maxx, maxy float64, fn func(c *candidate) bool) error {
+	return s.eachRawCandidate(ctx, mode, minx, miny, maxx, maxy, func(c *candidate) bool {
+		return !s.decodeCandidate(c) || fn(c)
+	})
+}
+
+// 候选分页取出，每页 candidatePage 行：通常第一页就能找到结果，
+// 市区等候选很多的地方接着取下一页，不会因为候选多而漏掉
+const candidatePage = 200
+
+// 同 eachCandidate，但几何还未解码（blob 中为原始的 GeoPackage 几何），由调用方用 decodeCandidate 解码
+func (s *Server) eachRawCandidate(ctx context.Context, mode nameMode, minx, miny, maxx, maxy float64, fn func(c *candidate) bool) error {
+	if s.index != nil {
+		ids := s.index.search(minx, miny, maxx, maxy)
+		for len(ids) > 0 {
+			page := ids[:min(len(ids), candidatePage)]
+			ids = ids[len(page):]
+			idsJSON, _ := json.Marshal(page)
+			rows, err := s.stmts.query(ctx, s.sqlCandidateByID[mode], string(idsJSON))
+			if err != nil {
+				return err
+			}
+			if _, more, err := scanCandidates(rows, fn); err != nil || !more {
+				return err
+			}
 		}
-		wkbBytes, _, err := gpkgToWKB(blob)
+		return nil
+	}
+	for offset := 0; ; offset += candidatePage {
+		rows, err := s.stmts.query(ctx, s.sqlCandidate[mode], maxx, minx, maxy, miny, candidatePage, offset)
 		if err != nil {
-			continue
+			return err
+		}
+		n, more, err := scanCandidates(rows, fn)
+		if err != nil || !more || n < candidatePage {
+			return err
+		}
+	}
+}
+
+// 逐行回调一页候选并关闭 rows，返回行数；fn 返回 false 时 more 为 false
+func scanCandidates(rows *sql.Rows, fn func(c *candidate) bool) (n int, more bool, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		c := new(candidate)
+		dest := make([]any, 0, 14)
+		dest = append(dest, &c.rowid)
+		for i := range c.gids {
+			dest = append(dest, &c.gids[i])
 		}
-		mp, err := decodeMultiPolygon(wkbBytes)
+		for i := range c.names {
+			dest = append(dest, &c.names[i])
+		}
+		dest = append(dest, &c.blob, &c.coarse)
+		if err := rows.Scan(dest...); err != nil {
+			return n, false, err
+		}
+		n++
+		if !fn(c) {
+			return n, false, nil
+		}
+	}
+	return n, true, rows.Err()
+}
+
+// 解码候选的几何，先查几何缓存；几何无法解码时返回 false，跳过该候选
+func (s *Server) decodeCandidate(c *candidate) bool {
+	var ok bool
+	if c.geom, c.index, ok = s.geomCache.get(c.rowid); !ok {
+		wkbBytes, _, err := gpkgToWKB(c.blob)
 		if err != nil {
-			continue
+			return false
 		}
-		if planar.MultiPolygonContains(mp, orb.Point{rlon, rlat}) {
-			levelName := levelNameMap()
-			// GID 和 Name 成对存起来
-			gids := []struct {
-				gid  string
-				name string
-			}{
-				{g0, n0},
-				{g1, n1},
-				{g2, n2},
-				{g3, n3},
-				{g4, n4},
-				{g5, n5},
-			}
+		if c.geom, err = decodeMultiPolygon(wkbBytes); err != nil {
+			return false
+		}
+		c.index = newShapeIndex(c.geom)
+		s.geomCache.put(c.rowid, c.geom, c.index)
+	}
+	c.blob = nil
+	return true
+}
 
-			// 构造 ChildrenItem 列表
-			list := make([]ChildrenItem, 0, 6)
-			parent := ""
-			for i, item := range gids {
-				if item.gid != "" {
-					list = append(list, ChildrenItem{
-						GID:        item.gid,
-						Name:       item.name,
-						ParentCode: parent,
-						Level:      levelName[i],
-					})
-					parent = item.gid
-				}
-			}
-			return &AdminLevels{
-				GID0: g0, GID1: g1, GID2: g2, GID3: g3, GID4: g4, GID5: g5,
-				Name0: n0, Name1: n1, Name2: n2, Name3: n3, Name4: n4, Name5: n5,
-				List: list,
-			}, nil
+func (s *Server) roundPoint(lon, lat float64) (float64, float64) {
+	f := math.Pow10(s.roundPlaces)
+	return math.Round(lon*f) / f, math.Round(lat*f) / f
+}
+
+func (s *Server) reverse(ctx context.Context, lon, lat float64, mode nameMode) (*AdminLevels, error) {
+	rlon, rlat := s.roundPoint(lon, lat)
+	key := pointKey{rlon, rlat, mode}
+	if res, ok := s.results.get(key); ok {
+		if res == nil {
+			return nil, sql.ErrNoRows
 		}
+		return res.clone(), nil
 	}
-	if err := rows.Err(); err != nil {
+	start := time.Now()
+	res, err := s.reverseAt(ctx, rlon, rlat, mode)
+	reverseTiming.observe(time.Since(start))
+	if errors.Is(err, sql.ErrNoRows) {
+		s.results.put(key, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.results.put(key, res)
+	return res.clone(), nil
+}
+
+func (s *Server) reverseAt(ctx context.Context, rlon, rlat float64, mode nameMode) (*AdminLevels, error) {
+	pt := orb.Point{rlon, rlat}
+	// 修正层的几何优先，被它替换的 GADM 叶子不再命中
+	if res := s.overrides.at(pt); res != nil {
+		return res, nil
+	}
+	if s.spatial != nil {
+		res, err := s.reverseSpatialite(ctx, rlon, rlat, mode)
+		if err != nil {
+			return nil, err
+		}
+		if s.overrides.replaced(res.leaf) {
+			return nil, sql.ErrNoRows
+		}
+		s.overrides.rename(res, mode)
+		return res, nil
+	}
+
+	hit, err := s.cellCandidate(ctx, mode, pt)
+	if hit == nil && err == nil {
+		hit, err = s.firstContaining(ctx, mode, pt)
+	}
+	if err != nil {
 		return nil, err
 	}
-	return nil, sql.ErrNoRows
+	var res *AdminLevels
+	if hit != nil {
+		if res = hit.adminLevels(); s.overrides.replaced(res.leaf) {
+			res = nil
+		}
+	}
+	if res == nil {
+		return nil, sql.ErrNoRows
+	}
+	s.overrides.rename(res, mode)
+	return res, nil
 }
 
 /************* Children（父→子列表） *************/
-func (s *Server) childrenOf(parentGID string) ([]ChildrenItem, error) {
-	parentGID = strings.TrimSpace(parentGID)
+// /children 的排序、过滤与分页参数
+type ChildrenQuery struct {
+	Page
+	Sort         string // name | code
+	Desc         bool
+	NamePrefix   string
+	NameContains string
+	Names        nameMode
+}
+
+func parseChildrenQuery(r *http.Request) (ChildrenQuery, error) {
+	q := r.URL.Query()
+	cq := ChildrenQuery{
+		Page:         parsePage(r, 5000),
+		Sort:         strings.ToLower(strings.TrimSpace(q.Get("sort"))),
+		NamePrefix:   strings.TrimSpace(q.Get("name_prefix")),
+		NameContains: strings.TrimSpace(q.Get("name_contains")),
+		Names:        parseNameMode(r),
+	}
+	switch cq.Sort {
+	case "":
+		cq.Sort = "name"
+	case "name", "code":
+	default:
+		return cq, fmt.Errorf("invalid sort, use name or code")
+	}
+	switch strings.ToLower(strings.TrimSpace(q.Get("order"))) {
+	case "", "asc":
+	case "desc":
+		cq.Desc = true
+	default:
+		return cq, fmt.Errorf("invalid order, use asc or desc")
+	}
+	return cq, nil
+}
+
+// 转义 LIKE 通配符，配合 ESCAPE '\' 使用
+func escapeLike(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(v)
+}
+
+// 先查结果缓存，见 childcache.go
+func (s *Server) childrenOf(ctx context.Context, parentGID string, cq ChildrenQuery) ([]ChildrenItem, int, error) {
+	key := childrenKey{strings.TrimSpace(parentGID), cq}
+	if items, total, ok := s.childrenCache.get(key); ok {
+		return items, total, nil
+	}
+	items, total, err := s.queryChildren(ctx, key.parent, cq)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.childrenCache.put(key, items, total)
+	return items, total, nil
+}
+
+func (s *Server) queryChildren(ctx context.Context, parentGID string, cq ChildrenQuery) ([]ChildrenItem, int, error) {
 	if parentGID == "" {
-		return nil, fmt.Errorf("gid required")
+		return nil, 0, fmt.Errorf("gid required")
+	}
+	parentGID, err := s.resolveCode(ctx, parentGID)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	levelName := levelNameMap()
 
-	level, err := s.detectLevel(parentGID)
+	level, err := s.detectLevel(ctx, parentGID)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if level == 5 {
-		return []ChildrenItem{}, nil
+		return []ChildrenItem{}, 0, nil
 	}
 
 	childGIDCol := fmt.Sprintf("GID_%d", level+1)
-	childNameCol := fmt.Sprintf("NAME_%d", level+1)
+	childNameCol := s.nameExpr(level+1, cq.Names)
 	parentCol := fmt.Sprintf("GID_%d", level)
 
-	sqlStr := fmt.Sprintf(`
-SELECT DISTINCT %s, %s
+	fromWhere := fmt.Sprintf(`
 FROM %s
 WHERE %s = ?
   AND %s IS NOT NULL
-ORDER BY %s COLLATE NOCASE;`,
-		childGIDCol, childNameCol, s.table, parentCol, childGIDCol, childNameCol)
+  AND %s <> ''`,
+		s.table, parentCol, childGIDCol, childGIDCol)
+	args := []any{parentGID}
+	if cq.NamePrefix != "" {
+		fromWhere += fmt.Sprintf("\n  AND %s LIKE ? ESCAPE '\\'", childNameCol)
+		args = append(args, escapeLike(cq.NamePrefix)+"%")
+	}
+	if cq.NameContains != "" {
+		fromWhere += fmt.Sprintf("\n  AND %s LIKE ? ESCAPE '\\'", childNameCol)
+		args = append(args, "%"+escapeLike(cq.NameContains)+"%")
+	}
 
-	rows, err := s.db.Query(sqlStr, parentGID)
+	var total int
+	countSQL := fmt.Sprintf(`SELECT COUNT(*) FROM (SELECT DISTINCT %s, %s %s);`,
+		childGIDCol, childNameCol, fromWhere)
+	if err := s.stmts.queryRow(ctx, countSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	orderCol := childNameCol + " COLLATE NOCASE"
+	if cq.Sort == "code" {
+		orderCol = childGIDCol
+	}
+	if cq.Desc {
+		orderCol += " DESC"
+	}
+	clause, pageArgs := cq.clause()
+	sqlStr := fmt.Sprintf(`
+SELECT DISTINCT %s, %s %s
+ORDER BY %s%s;`,
+		childGIDCol, childNameCol, fromWhere, orderCol, clause)
+
+	rows, err := s.stmts.query(ctx, sqlStr, append(args, pageArgs...)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var out []ChildrenItem
+	out := make([]ChildrenItem, 0)
 	for rows.Next() {
 		var gid, name sql.NullString
 		if err := rows.Scan(&gid, &name); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		if gid.Valid && name.Valid && len(gid.String) > 0 {
 			out = append(out, ChildrenItem{
@@ -297,18 +671,34 @@ ORDER BY %s COLLATE NOCASE;`,
 		}
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return out, nil
+	s.overrides.renameItems(out, cq.Names)
+	return out, total, nil
 }
 
 // 检测 GID 属于哪一层（0..5）
-func (s *Server) detectLevel(gid string) (int, error) {
+func (s *Server) detectLevel(ctx context.Context, gid string) (int, error) {
+	if s.gids != nil {
+		ref, ok := s.gids[gid]
+		if !ok {
+			return 0, fmt.Errorf("gid not found in any level")
+		}
+		return ref.level, nil
+	}
+	if s.built {
+		var lvl int
+		err := s.stmts.queryRow(ctx, fmt.Sprintf(`SELECT level FROM "%s" WHERE gid = ?;`, gidsTableName(s.table)), gid).Scan(&lvl)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("gid not found in any level")
+		}
+		return lvl, err
+	}
 	for lvl := 0; lvl <= 5; lvl++ {
 		col := fmt.Sprintf("GID_%d", lvl)
 		sqlStr := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ? LIMIT 1;", s.table, col)
 		var one int
-		err := s.db.QueryRow(sqlStr, gid).Scan(&one)
+		err := s.stmts.queryRow(ctx, sqlStr, gid).Scan(&one)
 		if err == nil {
 			return lvl, nil
 		}
@@ -322,6 +712,15 @@ func (s *Server) detectLevel(gid string) (int, error) {
 /************* HTTP 层 *************/
 func parseLatLon(r *http.Request) (lat float64, lon float64, err error) {
 	q := r.URL.Query()
+	if gh := q.Get("geohash"); gh != "" {
+		return decodeGeohash(gh)
+	}
+	if cell := q.Get("h3"); cell != "" {
+		return decodeH3(cell)
+	}
+	if crs := q.Get("crs"); crs != "" {
+		return parseProjected(crs, q.Get("x"), q.Get("y"))
+	}
 	if ll := q.Get("latlng"); ll != "" {
 		parts := strings.Split(ll, ",")
 		if len(parts) != 2 {
@@ -337,7 +736,7 @@ func parseLatLon(r *http.Request) (lat float64, lon float64, err error) {
 	latStr := q.Get("latitude")
 	lonStr := q.Get("longitude")
 	if latStr == "" || lonStr == "" {
-		return 0, 0, fmt.Errorf("latitude/longitude or latlng are required")
+		return 0, 0, fmt.Errorf("latitude/longitude, latlng, geohash or h3 are required")
 	}
 	lat, err1 := strconv.ParseFloat(latStr, 64)
 	lon, err2 := strconv.ParseFloat(lonStr, 64)
@@ -347,8 +746,81 @@ func parseLatLon(r *http.Request) (lat float64, lon float64, err error) {
 	return lat, lon, nil
 }
 
+// 读取整数查询参数，缺省或非法时用 def，并限制在 [min, max]
+func queryInt(r *http.Request, key string, def, min, max int) int {
+	v, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get(key)))
+	if err != nil {
+		return def
+	}
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// 读取浮点查询参数，缺省或非法时用 def，并限制在 [min, max]
+func queryFloat(r *http.Request, key string, def, min, max float64) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(r.URL.Query().Get(key)), 64)
+	if err != nil || math.IsNaN(v) {
+		return def
+	}
+	return math.Max(min, math.Min(max, v))
+}
+
+// 列表分页参数，Limit 为 0 表示不分页
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// 读取 limit/offset，limit 缺省时不分页，最大 maxLimit
+func parsePage(r *http.Request, maxLimit int) Page {
+	return Page{
+		Limit:  queryInt(r, "limit", 0, 0, maxLimit),
+		Offset: queryInt(r, "offset", 0, 0, math.MaxInt32),
+	}
+}
+
+func (p Page) clause() (string, []any) {
+	if p.Limit <= 0 {
+		if p.Offset > 0 {
+			return " LIMIT -1 OFFSET ?", []any{p.Offset}
+		}
+		return "", nil
+	}
+	return " LIMIT ? OFFSET ?", []any{p.Limit, p.Offset}
+}
+
+// 对内存中的列表做分页
+func pageSlice[T any](items []T, p Page) []T {
+	if p.Offset >= len(items) {
+		return items[:0]
+	}
+	items = items[p.Offset:]
+	if p.Limit > 0 && p.Limit < len(items) {
+		items = items[:p.Limit]
+	}
+	return items
+}
+
 func (s *Server) handleReverse(w http.ResponseWriter, r *http.Request) {
-	lat, lon, err := parseLatLon(r)
+	var (
+		lat, lon float64
+		err      error
+	)
+	if pc := r.URL.Query().Get("pluscode"); pc != "" {
+		lat, lon, err = s.resolvePlusCode(r.Context(), pc)
+		if err != nil && !strings.Contains(err.Error(), "plus code") && !strings.Contains(err.Error(), "locality not found") {
+			log.Println("pluscode error:", err)
+			writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+			return
+		}
+	} else {
+		lat, lon, err = parseLatLon(r)
+	}
 	if err != nil {
 		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
 		return
@@ -357,16 +829,74 @@ func (s *Server) handleReverse(w http.ResponseWriter, r *http.Request) {
 		writeErrorJSON(w, http.StatusBadRequest, 400, "lat/lon out of range")
 		return
 	}
-	res, err := s.reverse(lon, lat)
+	level := 5
+	if v := strings.TrimSpace(r.URL.Query().Get("level")); v != "" {
+		if level, err = strconv.Atoi(v); err != nil || level < 0 || level > 5 {
+			writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+			return
+		}
+	}
+	includeGeom := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("include_geometry")))
+	if includeGeom != "" && includeGeom != "simplified" && includeGeom != "full" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid include_geometry, use simplified or full")
+		return
+	}
+	tolerance, err := parseTolerance(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	asWKT, err := parseGeomFormat(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	overlays, err := s.parseOverlays(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	view, err := s.views.parse(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	mode := parseNameMode(r)
+	res, err := s.reverseLevel(r.Context(), lon, lat, mode, level)
+	if errors.Is(err, sql.ErrNoRows) {
+		res, err = s.nearest(r.Context(), lon, lat, queryFloat(r, "max_distance_m", s.nearestMaxM, 0, 100000), mode)
+	}
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
 			return
 		}
-		log.Println("reverse error:", err)
-		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		writeQueryError(w, "reverse", err)
 		return
 	}
+	s.views.apply(res, view, mode)
+	res.Timezone = s.timezoneOf(res.GID0, res.GID1)
+	res.truncate(level)
+	if r.URL.Query().Get("include_levels") == "1" {
+		if err := s.attachLevelDetails(r.Context(), res); err != nil {
+			log.Println("reverse levels error:", err)
+			writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+			return
+		}
+	}
+	if includeGeom != "" {
+		if err := s.attachGeometry(r.Context(), res, includeGeom == "full", tolerance, asWKT); err != nil {
+			writeQueryError(w, "reverse geometry", err)
+			return
+		}
+	}
+	if len(overlays) > 0 {
+		if res.Overlays, err = s.layerHits(overlays, lon, lat); err != nil {
+			log.Println("reverse overlays error:", err)
+			writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+			return
+		}
+	}
 	writeJSON(w, http.StatusOK, AdminLevelsRes{
 		Code: 200,
 		Msg:  "success",
@@ -379,45 +909,74 @@ func (s *Server) handleChildren(w http.ResponseWriter, r *http.Request) {
 	if parentCode == "" {
 		parentCode = env("GPKG_PARENT_CODE", "IDN")
 	}
-	items, err := s.childrenOf(parentCode)
+	cq, err := parseChildrenQuery(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	include, err := s.parseInclude(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	items, total, err := s.childrenOf(r.Context(), parentCode, cq)
 	if err != nil {
 		// 标准化 404 判定
 		if strings.Contains(err.Error(), "not found") {
 			items = make([]ChildrenItem, 0)
 		} else {
-			log.Println("children error:", err)
-			writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+			writeQueryError(w, "children", err)
+			return
+		}
+	}
+	if len(include) > 0 {
+		for i := range items {
+			items[i].Attributes = s.attributes.of(items[i].GID, include)
+		}
+	}
+	if wantsCSV(r) {
+		w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+		writeChildrenCSV(w, items, include)
+		return
+	}
+	if wantsGeoJSON(r) {
+		tolerance, err := parseTolerance(r)
+		if err != nil {
+			writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
 			return
 		}
+		w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+		s.streamItemsFeatureCollection(r.Context(), w, items, tolerance, geojson.Properties{"total": total, "limit": cq.Limit, "offset": cq.Offset})
+		return
 	}
 	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
 	writeJSON(w, http.StatusOK, ChildrenRes{
 		Code: 200,
 		Msg:  "success",
-		Data: &ChildrenItemList{List: items},
+		Data: &ChildrenItemList{List: items, Total: total, Limit: cq.Limit, Offset: cq.Offset},
 	})
 }
 
 /************* 获取行政区域的中心坐标 *************/
-func (s *Server) latlngOf(GID string) (*LatlngItem, error) {
+func (s *Server) latlngOf(ctx context.Context, GID string) (*LatlngItem, error) {
 	GID = strings.TrimSpace(GID)
 	if GID == "" {
 		return nil, fmt.Errorf("gid required")
 	}
-
-	levelName := map[int]string{
-		0: "LEVEL_UNSPECIFIED",
-		1: "PROVINCE",
-		2: "CITY",
-		3: "DISTRICT",
-		4: "VILLAGE",
-		5: "SUBVILLAGE",
+	GID, err := s.resolveCode(ctx, GID)
+	if err != nil {
+		return nil, err
 	}
 
-	level, err := s.detectLevel(GID)
+	levelName := levelNameMap()
+
+	level, err := s.detectLevel(ctx, GID)
 	if err != nil {
 		return nil, err
 	}
+	if s.built {
+		return s.builtLatlng(ctx, GID, level)
+	}
 
 	gidCol := fmt.Sprintf("GID_%d", level)
 	nameCol := fmt.Sprintf("NAME_%d", level)
@@ -428,17 +987,17 @@ func (s *Server) latlngOf(GID string) (*LatlngItem, error) {
 		parentGidCol = "NULL"
 	}
 
-	sqlStr := fmt.Sprintf(`SELECT %s, %s, %s, %s FROM %s WHERE %s = ? LIMIT 1`,
-		gidCol, nameCol, parentGidCol, s.geomCol, s.table, gidCol)
+	where, arg := s.gidWhere(GID, level)
+	sqlStr := fmt.Sprintf(`SELECT %s, %s, %s FROM %s WHERE %s LIMIT 1`,
+		gidCol, nameCol, parentGidCol, s.table, where)
 
 	var (
 		gid       string
 		name      string
 		parentGid sql.NullString
-		blob      []byte
 	)
 
-	err = s.db.QueryRow(sqlStr, GID).Scan(&gid, &name, &parentGid, &blob)
+	err = s.stmts.queryRow(ctx, sqlStr, arg).Scan(&gid, &name, &parentGid)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("gid not found")
@@ -446,18 +1005,12 @@ func (s *Server) latlngOf(GID string) (*LatlngItem, error) {
 		return nil, err
 	}
 
-	wkbBytes, _, err := gpkgToWKB(blob)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert gpkg to wkb: %w", err)
-	}
-
-	mp, err := decodeMultiPolygon(wkbBytes)
+	// 质心按整个行政区（所有叶子）计算，见 centroids.go
+	centroid, pole, err := s.centroidOf(ctx, gid)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode multipolygon: %w", err)
+		return nil, err
 	}
 
-	centroid, _ := planar.CentroidArea(mp)
-
 	return &LatlngItem{
 		GID:        gid,
 		Latitude:   centroid.Lat(),
@@ -465,7 +1018,8 @@ func (s *Server) latlngOf(GID string) (*LatlngItem, error) {
 		Name:       name,
 		ParentCode: parentGid.String,
 		Level:      levelName[level],
-		Elevation: 0.0,
+		Elevation:  0.0,
+		Pole:       labelPoint(pole),
 	}, nil
 }
 
@@ -488,60 +1042,82 @@ func (s *Server) saveElevation(gid string, elevation float64) error {
 	return err
 }
 
-func (s *Server) fetchElevationFromGoogle(lat, lon float64) (float64, error) {
-	if s.googleAPIKey == "" {
-		return 0, fmt.Errorf("GOOGLE_API_KEY is not set")
+// 按 ELEVATION_PROVIDER 请求谷歌或读本地 DEM 瓦片，见 dem.go
+func (s *Server) fetchElevation(lat, lon float64) (float64, error) {
+	elevations, err := s.elevationSrc.elevations([]orb.Point{{lon, lat}})
+	if err != nil {
+		return 0, err
 	}
+	if math.IsNaN(elevations[0]) {
+		return 0, fmt.Errorf("no elevation data at %f,%f", lat, lon)
+	}
+	return elevations[0], nil
+}
 
-	url := fmt.Sprintf("https://maps.googleapis.com/maps/api/elevation/json?locations=%f,%f&key=%s", lat, lon, s.googleAPIKey)
+// 一次请求多个点（warm 命令批量填充海拔缓存时用），结果与 pts 一一对应
+func fetchElevations(apiKey string, pts []orb.Point) ([]float64, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("GOOGLE_API_KEY is not set")
+	}
+
+	locations := make([]string, len(pts))
+	for i, p := range pts {
+		locations[i] = fmt.Sprintf("%f,%f", p.Lat(), p.Lon())
+	}
+	url := fmt.Sprintf("https://maps.googleapis.com/maps/api/elevation/json?locations=%s&key=%s", strings.Join(locations, "|"), apiKey)
 	resp, err := http.Get(url)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("google api request failed with status: %s", resp.Status)
+		return nil, fmt.Errorf("google api request failed with status: %s", resp.Status)
 	}
 
 	var elevationResp ElevationResponse
 	if err := json.NewDecoder(resp.Body).Decode(&elevationResp); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	if elevationResp.Status != "OK" {
-		return 0, fmt.Errorf("google api error: %s, message: %s", elevationResp.Status, elevationResp.ErrorMessage)
+		return nil, fmt.Errorf("google api error: %s, message: %s", elevationResp.Status, elevationResp.ErrorMessage)
 	}
 
-	if len(elevationResp.Results) == 0 {
-		return 0, fmt.Errorf("no elevation results from google api")
+	if len(elevationResp.Results) != len(pts) {
+		if len(elevationResp.Results) == 0 {
+			return nil, fmt.Errorf("no elevation results from google api")
+		}
+		return nil, fmt.Errorf("google api returned %d elevations for %d locations", len(elevationResp.Results), len(pts))
 	}
 
-	return elevationResp.Results[0].Elevation, nil
+	elevations := make([]float64, len(pts))
+	for i, r := range elevationResp.Results {
+		elevations[i] = r.Elevation
+	}
+	return elevations, nil
 }
 
-
 // 获取行政区域的坐标点
 func (s *Server) handleLatlng(w http.ResponseWriter, r *http.Request) {
 	code := strings.TrimSpace(r.URL.Query().Get("code"))
 	if code == "" {
 		code = env("GPKG_PARENT_CODE", "IDN")
 	}
-	item, err := s.latlngOf(code)
+	item, err := s.latlngOf(r.Context(), code)
 	if err != nil {
 		if strings.Contains(err.Error(), "gid not found") {
 			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
 			return
 		}
-		log.Println("latlngOf error:", err)
-		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		writeQueryError(w, "latlngOf", err)
 		return
 	}
 
 	elevation, err := s.getElevation(item.GID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			newElevation, fetchErr := s.fetchElevationFromGoogle(item.Latitude, item.Longitude)
+			newElevation, fetchErr := s.fetchElevation(item.Latitude, item.Longitude)
 			if fetchErr != nil {
 				log.Printf("Failed to fetch elevation for GID %s: %v", item.GID, fetchErr)
 				item.Elevation = 0.0
@@ -559,6 +1135,21 @@ func (s *Server) handleLatlng(w http.ResponseWriter, r *http.Request) {
 	} else {
 		item.Elevation = elevation
 	}
+	if p := queryInt(r, "geohash_precision", 0, 0, 12); p > 0 {
+		item.Geohash = encodeGeohash(item.Latitude, item.Longitude, p)
+	}
+	if chain, err := s.ancestorsOf(r.Context(), item.GID); err == nil {
+		var gid0, gid1 string
+		if len(chain) > 0 {
+			gid0 = chain[0].GID
+		}
+		if len(chain) > 1 {
+			gid1 = chain[1].GID
+		}
+		item.Timezone = s.timezoneOf(gid0, gid1)
+	} else {
+		log.Printf("Failed to get ancestors for GID %s: %v", item.GID, err)
+	}
 
 	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
 	writeJSON(w, http.StatusOK, LatlngRes{
@@ -574,26 +1165,101 @@ func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 }
 
 /************* 启动 *************/
-func newServer() (*Server, error) {
-	gpkgPath := env("GPKG_PATH", "data/gadm_410.gpkg")
-	table := env("GPKG_TABLE", "gadm_410")
-	geomCol := env("GPKG_GEOM_COL", "geom")
-	roundStr := env("ROUND_PLACES", "4")
-	rp, _ := strconv.Atoi(roundStr)
-	if rp < 0 || rp > 6 {
-		rp = 4
-	}
-
-	dsn := fmt.Sprintf("file:%s?mode=ro&cache=shared&_busy_timeout=5000&immutable=1", gpkgPath)
-	db, err := sql.Open("sqlite3", dsn)
+// 读取表的列名，用于兼容不同 GADM 版本的可选属性列
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
 	if err != nil {
 		return nil, err
 	}
-	db.SetMaxOpenConns(1)
-	db.SetConnMaxIdleTime(5 * time.Minute)
+	defer rows.Close()
+
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			typ     string
+			notnull int
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols[strings.ToUpper(name)] = true
+	}
+	return cols, rows.Err()
+}
+
+// 默认的单个 GeoPackage；GPKG_DIR 见 registry.go
+func singleDataset(elevationDB *sql.DB, jobs *jobStore) (files func() ([]string, error), open func() ([]*Server, []apiRoute, error)) {
+	cfg := datasetConfig{
+		path:           env("GPKG_PATH", "data/gadm_410.gpkg"),
+		kind:           "gadm",
+		table:          env("GPKG_TABLE", "gadm_410"),
+		geomCol:        env("GPKG_GEOM_COL", "geom"),
+		spatialitePath: env("SPATIALITE_PATH", "data/gadm_spatialite.sqlite"),
+		centroidsPath:  env("CENTROIDS_PATH", "data/gadm_centroids.sqlite"),
+	}
+	// 远程地址只在第一次加载时下载，之后热更新检查的是本地缓存，见 remote.go；
+	// embedded 为随程序打包的演示数据，见 embedded.go
+	if cfg.path == embeddedPath {
+		cfg.kind, cfg.geomCol = embeddedPath, "geom"
+	}
+	fetch := sync.OnceValues(func() (string, error) {
+		switch {
+		case cfg.path == embeddedPath:
+			return prepareEmbedded(cfg.table)
+		case isRemotePath(cfg.path):
+			return fetchRemote(cfg.path, env("GPKG_CACHE_DIR", "data/remote"), env("GPKG_SHA256", ""))
+		}
+		return cfg.path, nil
+	})
+	files = func() ([]string, error) {
+		local, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		return []string{local}, nil
+	}
+	open = func() ([]*Server, []apiRoute, error) {
+		local, err := fetch()
+		if err != nil {
+			return nil, nil, err
+		}
+		// GADM 分层下载（ADM_0..ADM_5 每层一张表）合并成单表，缓存在同目录的 .cache 下，见 registry.go
+		prepared, err := prepareCountryFile(local, cfg.table, cfg.geomCol, filepath.Join(filepath.Dir(local), ".cache"))
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg := cfg
+		cfg.path = prepared.path
+		if prepared.path != local {
+			cfg.source = local
+		}
+		s, err := openServer(cfg, elevationDB, jobs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*Server{s}, s.apiRoutes(), nil
+	}
+	return files, open
+}
+
+// 一个数据集的打开参数
+type datasetConfig struct {
+	path, table, geomCol string
+	// 数据来源（gadm / naturalearth）及原始数据文件，为空时为 path，见 meta.go
+	kind, source string
+	// STORAGE=spatialite 时导入的 SpatiaLite 库
+	spatialitePath string
+	// 质心缓存库，为空时不缓存
+	centroidsPath string
+}
 
-	elevationDbPath := env("ELEVATION_DB_PATH", "data/elevations.db")
-	elevationDB, err := sql.Open("sqlite3", elevationDbPath)
+// 海拔缓存库，按 GID 记录谷歌 Elevation API 的结果；warm 命令可离线填满
+func openElevationDB(path string) (*sql.DB, error) {
+	elevationDB, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open elevation db: %w", err)
 	}
@@ -603,48 +1269,429 @@ func newServer() (*Server, error) {
         elevation REAL NOT NULL
     );`)
 	if err != nil {
+		elevationDB.Close()
 		return nil, fmt.Errorf("failed to create elevations table: %w", err)
 	}
+	return elevationDB, nil
+}
+
+// 多个数据集（GPKG_DIR）共用的海拔缓存库和批量任务
+func openShared() (*sql.DB, *jobStore, error) {
+	elevationDB, err := openElevationDB(env("ELEVATION_DB_PATH", "data/elevations.db"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jobMaxMB, _ := strconv.Atoi(env("JOBS_MAX_UPLOAD_MB", "512"))
+	jobConcurrency, _ := strconv.Atoi(env("JOBS_CONCURRENCY", "2"))
+	jobTTLHours, _ := strconv.Atoi(env("JOBS_TTL_HOURS", "24"))
+	jobs, err := newJobStore(env("JOBS_DIR", filepath.Join(os.TempDir(), "gpkg-reverse-jobs")),
+		int64(jobMaxMB)<<20, jobConcurrency, time.Duration(jobTTLHours)*time.Hour)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to init jobs dir: %w", err)
+	}
+	return elevationDB, jobs, nil
+}
+
+func openServer(cfg datasetConfig, elevationDB *sql.DB, jobs *jobStore) (_ *Server, err error) {
+	gpkgPath, table, geomCol := cfg.path, cfg.table, cfg.geomCol
+	roundStr := env("ROUND_PLACES", "4")
+	rp, _ := strconv.Atoi(roundStr)
+	if rp < 0 || rp > 6 {
+		rp = 4
+	}
+
+	// 连接池大小和 pragma 见 sqlitepool.go
+	dbs, err := openDatasetDB(gpkgPath)
+	if err != nil {
+		return nil, err
+	}
+	db := dbs[0]
+	// 热更新时打开失败不能泄漏已打开的库
+	var s *Server
+	defer func() {
+		if err != nil {
+			if s != nil {
+				s.close()
+			} else {
+				for _, db := range dbs {
+					db.Close()
+				}
+			}
+		}
+	}()
+
+	nearestMaxM, _ := strconv.ParseFloat(env("NEAREST_MAX_DISTANCE_M", "0"), 64)
+
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found in %s", table, gpkgPath)
+	}
+
+	isoCrosswalk, err := loadISOCrosswalk(env("ISO_CROSSWALK_PATH", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load iso crosswalk: %w", err)
+	}
+	elevationSrc, err := loadElevationSource()
+	if err != nil {
+		return nil, fmt.Errorf("failed to init elevation source: %w", err)
+	}
+	elevationLimit, err := loadElevationLimit()
+	if err != nil {
+		return nil, err
+	}
 
 	rtree := fmt.Sprintf("rtree_%s_%s", table, geomCol)
-	sqlCand := fmt.Sprintf(`
-SELECT a.GID_0, a.GID_1, a.GID_2, a.GID_3, a.GID_4, a.GID_5,
-       a.NAME_0, a.NAME_1, a.NAME_2, a.NAME_3, a.NAME_4, a.NAME_5,
-       a.%s
+
+	s = &Server{
+		db:             db,
+		elevationDB:    elevationDB,
+		table:          table,
+		geomCol:        geomCol,
+		rtreeTable:     rtree,
+		roundPlaces:    rp,
+		elevationSrc:   elevationSrc,
+		elevationLimit: elevationLimit,
+		columns:        columns,
+		nearestMaxM:    nearestMaxM,
+		isoCrosswalk:   isoCrosswalk,
+		jobs:           jobs,
+		stmts:          newStmtCache(dbs),
+	}
+	s.path, s.centroidsPath = gpkgPath, cfg.centroidsPath
+	s.kind, s.source = cfg.kind, cfg.source
+	if s.source == "" {
+		s.source = gpkgPath
+	}
+	geomCacheMB, _ := strconv.Atoi(env("GEOM_CACHE_MB", "64"))
+	s.geomCache = newGeomCache(geomCacheMB, geomCacheStats)
+	s.coarseCache = newGeomCache(geomCacheMB/4, coarseCacheStats)
+	resultCacheSize, _ := strconv.Atoi(env("RESULT_CACHE_SIZE", "50000"))
+	s.results = newResultCache(resultCacheSize, resultCacheStats)
+	childrenCacheSize, _ := strconv.Atoi(env("CHILDREN_CACHE_SIZE", "10000"))
+	childrenCacheTTL, err := time.ParseDuration(env("CHILDREN_CACHE_TTL", "1h"))
+	if err != nil || childrenCacheTTL <= 0 {
+		return nil, fmt.Errorf("invalid CHILDREN_CACHE_TTL")
+	}
+	s.childrenCache = newChildrenCache(childrenCacheSize, childrenCacheTTL, childrenCacheStats)
+	if s.workers, err = strconv.Atoi(env("REVERSE_WORKERS", strconv.Itoa(min(runtime.NumCPU(), 4)))); err != nil || s.workers < 1 {
+		return nil, fmt.Errorf("invalid REVERSE_WORKERS")
+	}
+	if env("MEMORY_INDEX", "1") != "0" {
+		start := time.Now()
+		if s.index, err = loadMemIndex(db, rtree); err != nil {
+			return nil, fmt.Errorf("failed to load %s into memory: %w", rtree, err)
+		}
+		log.Printf("memory index: %d boxes from %s in %s", s.index.leaves, rtree, time.Since(start).Round(time.Millisecond))
+	}
+	if env("GID_INDEX", "1") != "0" {
+		start := time.Now()
+		if s.gids, err = loadGIDIndex(db, table); err != nil {
+			return nil, fmt.Errorf("failed to load gid index of %s: %w", table, err)
+		}
+		log.Printf("gid index: %d gids from %s in %s", len(s.gids), table, time.Since(start).Round(time.Millisecond))
+	}
+	if cols, err := tableColumns(db, gidsTableName(table)); err == nil && len(cols) > 0 {
+		var tol string
+		if err := db.QueryRow(fmt.Sprintf(`SELECT value FROM "%s_build" WHERE key = 'tolerance';`, table)).Scan(&tol); err != nil {
+			return nil, fmt.Errorf("failed to read build info of %s: %w", gpkgPath, err)
+		}
+		levelCols, err := tableColumns(db, levelTableName(table, 0))
+		if err != nil || !levelCols["POLE_LON"] {
+			return nil, fmt.Errorf("%s was built by an older version, run build again", gpkgPath)
+		}
+		s.built = true
+		s.builtAreas = levelCols["AREA"]
+		s.builtTolerance, _ = strconv.ParseFloat(tol, 64)
+		s.geomStores = builtResolutions(db, table)
+		s.coarseTolerance = builtCoarseTolerance(db, table)
+		if s.coarseTolerance > 0 {
+			if s.levelIndex, err = loadLevelIndexes(db, table); err != nil {
+				return nil, fmt.Errorf("failed to load level index of %s: %w", table, err)
+			}
+		}
+		if cols, err := tableColumns(db, cellsTableName(table)); err == nil && len(cols) > 0 && env("CELL_INDEX", "1") != "0" {
+			start := time.Now()
+			if s.cells, err = loadCellIndex(db, table); err != nil {
+				return nil, fmt.Errorf("failed to load cell index of %s: %w", table, err)
+			}
+			log.Printf("cell index: %d cells from %s in %s", len(s.cells), cellsTableName(table), time.Since(start).Round(time.Millisecond))
+		}
+	}
+	for mode := range s.sqlCandidate {
+		s.sqlCandidate[mode] = s.candidateSQL(nameMode(mode))
+		s.sqlCandidateByID[mode] = s.candidateByIDSQL(nameMode(mode))
+	}
+	// 可选：点面判断交给 SpatiaLite
+	switch storage := env("STORAGE", "gpkg"); storage {
+	case "gpkg":
+	case "spatialite":
+		s.spatial, err = openSpatialite(cfg.spatialitePath, env("SPATIALITE_EXTENSION", "mod_spatialite"), gpkgPath, table, geomCol)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open spatialite storage: %w", err)
+		}
+		for mode := range s.sqlRow {
+			s.sqlRow[mode] = s.rowSQL(nameMode(mode))
+		}
+	default:
+		return nil, fmt.Errorf("invalid STORAGE %q, use gpkg or spatialite", storage)
+	}
+	s.stats = sync.OnceValues(s.computeStats)
+	s.meta = sync.OnceValues(s.computeMeta)
+	// 可选：上一个版本的数据集，供 /diff 对比
+	if prevPath := env("GPKG_PREV_PATH", ""); prevPath != "" {
+		if s.prev, err = openDataset(prevPath, env("GPKG_PREV_TABLE", "gadm"), env("GPKG_PREV_GEOM_COL", geomCol)); err != nil {
+			return nil, fmt.Errorf("failed to open previous dataset: %w", err)
+		}
+	}
+	// 可选：旧版本 GID 对照表，供 /migrate 使用，见 crosswalk.go
+	if s.crosswalk, err = s.loadCrosswalk(env("CROSSWALK_PATH", "")); err != nil {
+		return nil, fmt.Errorf("failed to load crosswalk: %w", err)
+	}
+	if s.layers, err = loadLayers(env("LAYERS_CONFIG", "")); err != nil {
+		return nil, fmt.Errorf("failed to load layers: %w", err)
+	}
+	if s.attributes, err = loadAttributes(splitList(env("ATTRIBUTES_PATH", ""))); err != nil {
+		return nil, fmt.Errorf("failed to load attributes: %w", err)
+	}
+	if s.overrides, err = s.loadOverrides(env("OVERRIDES_PATH", "")); err != nil {
+		return nil, fmt.Errorf("failed to load overrides: %w", err)
+	}
+	if s.views, err = s.loadDisputedViews(env("DISPUTED_VIEWS_PATH", ""), env("DISPUTED_VIEW", worldwideView)); err != nil {
+		return nil, fmt.Errorf("failed to load disputed views: %w", err)
+	}
+	if s.tz, err = s.loadTimezones(env("TZ_OVERRIDES_PATH", "")); err != nil {
+		return nil, fmt.Errorf("failed to load timezones: %w", err)
+	}
+	tileCacheSize, _ := strconv.Atoi(env("TILE_CACHE_SIZE", "4096"))
+	s.tiles = newTileCache(tileCacheSize)
+	if s.gqlSchema, err = s.graphqlSchema(); err != nil {
+		return nil, fmt.Errorf("graphql schema: %w", err)
+	}
+	return s, nil
+}
+
+// 关闭数据集自己的库；海拔缓存库和任务是共用的，不在这里关
+func (s *Server) close() {
+	s.stmts.close()
+	for _, db := range s.stmts.dbs {
+		db.Close()
+	}
+	if s.spatial != nil {
+		s.spatial.db.Close()
+	}
+	if s.prev != nil {
+		s.prev.db.Close()
+	}
+	if st := s.centroids.Load(); st != nil {
+		st.lookup.Close()
+		st.bbox.Close()
+		st.db.Close()
+	}
+}
+
+// r-tree 候选查询，名称列随 mode 变化
+func (s *Server) candidateSQL(mode nameMode) string {
+	names := make([]string, 0, 6)
+	for lvl := 0; lvl <= 5; lvl++ {
+		names = append(names, s.nameExpr(lvl, mode))
+	}
+	coarseCol, coarseJoin := s.coarseColumns()
+	return fmt.Sprintf(`
+SELECT a.rowid, a.GID_0, a.GID_1, a.GID_2, a.GID_3, a.GID_4, a.GID_5,
+       %s,
+       a.%s, %s
 FROM %s AS a
 JOIN %s AS r ON a.rowid = r.id
+%s
 WHERE r.minx <= ? AND r.maxx >= ? AND r.miny <= ? AND r.maxy >= ?
-LIMIT 200;`, geomCol, table, rtree)
-
-	return &Server{
-		db:           db,
-		elevationDB:  elevationDB,
-		table:        table,
-		geomCol:      geomCol,
-		rtreeTable:   rtree,
-		sqlCandidate: sqlCand,
-		roundPlaces:  rp,
-		googleAPIKey: env("GOOGLE_API_KEY", ""),
-	}, nil
+ORDER BY (r.maxx - r.minx) * (r.maxy - r.miny), r.id
+LIMIT ? OFFSET ?;`, strings.Join(names, ", "), s.geomCol, coarseCol, s.table, s.rtreeTable, coarseJoin)
+}
+
+// 按内存索引筛出的 rowid（JSON 数组）取候选行，保持数组中的顺序
+func (s *Server) candidateByIDSQL(mode nameMode) string {
+	names := make([]string, 0, 6)
+	for lvl := 0; lvl <= 5; lvl++ {
+		names = append(names, s.nameExpr(lvl, mode))
+	}
+	coarseCol, coarseJoin := s.coarseColumns()
+	return fmt.Sprintf(`
+SELECT a.rowid, a.GID_0, a.GID_1, a.GID_2, a.GID_3, a.GID_4, a.GID_5,
+       %s,
+       a.%s, %s
+FROM json_each(?) AS j
+JOIN %s AS a ON a.rowid = j.value
+%s
+ORDER BY j.key;`, strings.Join(names, ", "), s.geomCol, coarseCol, s.table, coarseJoin)
 }
 
 func main() {
-	s, err := newServer()
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := runDiff(os.Args[2:]); err != nil {
+			log.Fatal("diff error:", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "crosswalk" {
+		if err := runCrosswalk(os.Args[2:]); err != nil {
+			log.Fatal("crosswalk error:", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		if err := runBuild(os.Args[2:]); err != nil {
+			log.Fatal("build error:", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "extract" {
+		if err := runExtract(os.Args[2:]); err != nil {
+			log.Fatal("extract error:", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			log.Fatal("import error:", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			log.Fatal("bench error:", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		if err := runWarm(os.Args[2:]); err != nil {
+			log.Fatal("warm error:", err)
+		}
+		return
+	}
+	limits, err := loadHTTPLimits()
+	if err != nil {
+		log.Fatal("init error:", err)
+	}
+	elevationDB, jobs, err := openShared()
+	if err != nil {
+		log.Fatal("init error:", err)
+	}
+	defer elevationDB.Close()
+	var (
+		files func() ([]string, error)
+		open  func() ([]*Server, []apiRoute, error)
+	)
+	switch dataset := env("DATASET", "gadm"); {
+	case env("GPKG_DIR", "") != "":
+		files, open = registryDataset(env("GPKG_DIR", ""), elevationDB, jobs)
+	case dataset == "gadm":
+		files, open = singleDataset(elevationDB, jobs)
+	case dataset == "naturalearth":
+		files, open = naturalEarthDataset(elevationDB, jobs)
+	default:
+		log.Fatalf("init error: invalid DATASET %q, use gadm or naturalearth", dataset)
+	}
+	// 修正层库参与版本计算，修改后随热更新生效，见 overrides.go
+	if path := env("OVERRIDES_PATH", ""); path != "" {
+		if err := initOverrides(path); err != nil {
+			log.Fatal("init error:", fmt.Errorf("overrides: %w", err))
+		}
+		dataFiles := files
+		files = func() ([]string, error) {
+			list, err := dataFiles()
+			return append(list, path), err
+		}
+	}
+	rl, err := newReloader(files, open)
 	if err != nil {
 		log.Fatal("init error:", err)
 	}
-	defer s.db.Close()
-	defer s.elevationDB.Close()
+	go rl.reloadOnSIGHUP()
+	if interval, _ := time.ParseDuration(env("RELOAD_WATCH_INTERVAL", "0")); interval > 0 {
+		go rl.watch(interval)
+	}
+	prefetch, err := loadPrefetchConfig()
+	if err != nil {
+		log.Fatal("init error:", err)
+	}
+	if prefetch != nil {
+		go rl.prefetchElevations(*prefetch)
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/reverse", s.handleReverse)
-	mux.HandleFunc("/children", s.handleChildren)
-	mux.HandleFunc("/latlng", s.handleLatlng)
+	routes := append(rl.routes(), apiRoute{Pattern: "/version", Handler: rl.handleVersion,
+		Summary: "当前数据集版本", Response: VersionRes{}})
+	routes = append(routes, apiRoute{Pattern: "POST /rpc", Handler: newRPCHandler(routes),
+		Summary: "JSON-RPC 2.0，方法名为路径（如 reverse、latlng.batch），支持批量调用",
+		Body:    RPCRequest{}, Response: RPCResponse{}})
+	// 管理接口不经 JSON-RPC 暴露
+	routes = append(routes, apiRoute{Pattern: "POST /admin/reload", Handler: rl.handleReload,
+		Summary: "重新加载数据集（需 Authorization: Bearer ADMIN_TOKEN），完成后返回新版本", Response: VersionRes{}, LongLived: true})
+	for _, rt := range routes {
+		mux.HandleFunc(rt.Pattern, timed(rt.Pattern, limits.bound(rt)))
+	}
+	openapiDoc, err := json.Marshal(buildOpenAPI(routes))
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux.HandleFunc("GET /openapi.json", serveOpenAPI(openapiDoc))
+	mux.HandleFunc("GET /docs", handleDocs)
+	if debugAddr := env("DEBUG_ADDR", ""); debugAddr != "" {
+		serveDiagnostics(debugAddr, func() string { return rl.cur.Load().version })
+	}
 	addr := env("ADDR", "0.0.0.0:8082")
+	log.Println("http://" + addr + "/docs")
 	log.Println("http://" + addr + "/health")
+	log.Println("http://" + addr + "/version")
+	log.Println("http://" + addr + "/stats")
+	log.Println("http://" + addr + "/meta")
+	log.Println("http://" + addr + "/diff?level=2&country=IDN")
 	log.Println("http://" + addr + "/reverse?latitude=-6.193835958650485&longitude=106.79943779288192")
 	log.Println("http://" + addr + "/children?parent_code=IDN.8_1")
 	log.Println("http://" + addr + "/latlng?code=IDN.8_1")
-	log.Fatal(http.ListenAndServe(addr, mux))
-}
\ No newline at end of file
+	log.Println("http://" + addr + "/reverse/all?latlng=-6.1938,106.7994")
+	log.Println("http://" + addr + "/reverse?geohash=qqguw3")
+	log.Println("http://" + addr + "/reverse?pluscode=6P58QRJ7%2B3W")
+	log.Println("http://" + addr + "/reverse?crs=EPSG:32748&x=699500&y=9315000")
+	log.Println("http://" + addr + "/search?q=Jawa%20Barat/Bandung")
+	log.Println("http://" + addr + "/resolve?path=Indonesia/Jawa%20Barat/Bandung")
+	log.Println("http://" + addr + "/boundary?code=IDN.8_1&tolerance=0.001")
+	log.Println("http://" + addr + "/ancestors?code=IDN.8.2.1_1")
+	log.Println("http://" + addr + "/details?code=IDN.8_1")
+	log.Println("http://" + addr + "/tree?code=IDN.8_1&depth=2")
+	log.Println("http://" + addr + "/contains?code=IDN.8_1&latlng=-6.1938,106.7994")
+	log.Println("http://" + addr + "/distance?code=IDN.8_1&latlng=-6.1938,106.7994")
+	log.Println("http://" + addr + "/bbox?code=IDN.8_1")
+	log.Println("http://" + addr + "/area?code=IDN.8_1")
+	log.Println("http://" + addr + "/levels?code=IDN")
+	log.Println("http://" + addr + "/autocomplete?q=band&limit=10")
+	log.Println("http://" + addr + "/iso?code=ID-JB")
+	log.Println("http://" + addr + "/within?bbox=106.6,-6.4,107.0,-6.1&level=3")
+	log.Println("http://" + addr + "/topojson?code=IDN.8_1&level=3")
+	log.Println("http://" + addr + "/kml?code=IDN.8_1&children=1&tolerance=0.001")
+	log.Println("http://" + addr + "/tiles/7/101/66.pbf")
+	log.Println("http://" + addr + "/export?code=IDN.8_1&level=3&format=gpkg")
+	log.Println("http://" + addr + "/nearby?latlng=-6.1938,106.7994&radius_km=25&level=3")
+	log.Println("http://" + addr + "/random?code=IDN.8_1&n=100")
+	log.Println("http://" + addr + "/h3?code=IDN.8_1&resolution=7")
+	log.Println("POST http://" + addr + "/rpc")
+	log.Println("POST http://" + addr + "/jobs")
+	log.Println("POST http://" + addr + "/admin/reload")
+	log.Println("http://" + addr + "/compat/google/geocode/json?latlng=-6.1938,106.7994")
+	log.Println("http://" + addr + "/compat/nominatim/reverse?lat=-6.1938&lon=106.7994&format=jsonv2")
+	log.Println("http://" + addr + "/graphql?query={adminArea(code:%22IDN.8_1%22){name%20children{code%20name}}}")
+	log.Println("POST http://" + addr + "/latlng/batch {\"codes\":[\"IDN.8.1_1\",\"IDN.8.2_1\"]}")
+	log.Println("POST http://" + addr + "/elevation/batch {\"codes\":[\"IDN.8.1_1\"],\"points\":[{\"latitude\":-6.1938,\"longitude\":106.7994}]}")
+	log.Println("POST http://" + addr + "/intersect {\"geometry\":{\"type\":\"Polygon\",\"coordinates\":[...]},\"level\":3}")
+	log.Println("POST http://" + addr + "/aggregate {\"points\":[{\"latitude\":-6.19,\"longitude\":106.79}],\"level\":3}")
+	log.Println("POST http://" + addr + "/reverse/route {\"polyline\":\"...\",\"level\":1}")
+	var handler http.Handler = negotiate(mux, env("RESPONSE_ENVELOPE", "true") != "false")
+	if env("COMPRESSION", "true") != "false" {
+		handler = compress(handler)
+	}
+	log.Fatal(serve(addr, handler, limits))
+}
@@ -0,0 +1,113 @@
+// memindex.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 进程内的外接矩形索引：启动时把 rtree_<table>_<geom> 整个读进内存，按 STR 打包成静态 R-tree。
+// 反查的候选筛选不再走 SQL（每次都占用一个连接），落在海上等没有候选的点不碰数据库；
+// 有候选时再按 rowid 一次取出这些行。MEMORY_INDEX=0 时仍用 SQLite 的 r-tree
+const memIndexNodeSize = 16
+
+type boxNode struct {
+	minx, miny, maxx, maxy float64
+	// 叶子为 rowid，内部节点为第一个子节点的下标
+	ref int64
+	// 内部节点的子节点数，叶子为 0
+	count int32
+}
+
+type memIndex struct {
+	nodes  []boxNode // 叶子在前，根节点在最后
+	leaves int
+}
+
+func loadMemIndex(db *sql.DB, rtree string) (*memIndex, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT id, minx, miny, maxx, maxy FROM "%s";`, rtree))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var leaves []boxNode
+	for rows.Next() {
+		var n boxNode
+		if err := rows.Scan(&n.ref, &n.minx, &n.miny, &n.maxx, &n.maxy); err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buildMemIndex(leaves), nil
+}
+
+// STR（Sort-Tile-Recursive）：按中心 x 切成竖条，条内按中心 y 排序，相邻的 16 个打包成一个节点
+func buildMemIndex(leaves []boxNode) *memIndex {
+	cx := func(n boxNode) float64 { return n.minx + n.maxx }
+	cy := func(n boxNode) float64 { return n.miny + n.maxy }
+	sort.Slice(leaves, func(i, j int) bool { return cx(leaves[i]) < cx(leaves[j]) })
+	pages := (len(leaves) + memIndexNodeSize - 1) / memIndexNodeSize
+	strip := int(math.Ceil(math.Sqrt(float64(pages)))) * memIndexNodeSize
+	for i := 0; i < len(leaves); i += strip {
+		part := leaves[i:min(i+strip, len(leaves))]
+		sort.Slice(part, func(a, b int) bool { return cy(part[a]) < cy(part[b]) })
+	}
+
+	idx := &memIndex{nodes: leaves, leaves: len(leaves)}
+	for start, end := 0, len(leaves); end-start > 1; {
+		for i := start; i < end; i += memIndexNodeSize {
+			n := boxNode{minx: math.Inf(1), miny: math.Inf(1), maxx: math.Inf(-1), maxy: math.Inf(-1), ref: int64(i)}
+			for _, c := range idx.nodes[i:min(i+memIndexNodeSize, end)] {
+				n.minx, n.miny = math.Min(n.minx, c.minx), math.Min(n.miny, c.miny)
+				n.maxx, n.maxy = math.Max(n.maxx, c.maxx), math.Max(n.maxy, c.maxy)
+				n.count++
+			}
+			idx.nodes = append(idx.nodes, n)
+		}
+		start, end = end, len(idx.nodes)
+	}
+	return idx
+}
+
+// 外接矩形与 [minx,maxx]x[miny,maxy] 相交的所有 rowid，按外接矩形面积从小到大，面积相同时按 rowid
+func (idx *memIndex) search(minx, miny, maxx, maxy float64) []int64 {
+	if len(idx.nodes) == 0 {
+		return nil
+	}
+	var hits []boxNode
+	stack := []int{len(idx.nodes) - 1}
+	for len(stack) > 0 {
+		n := idx.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if n.minx > maxx || n.maxx < minx || n.miny > maxy || n.maxy < miny {
+			continue
+		}
+		if n.count == 0 {
+			hits = append(hits, n)
+			continue
+		}
+		for i := int(n.ref) + int(n.count) - 1; i >= int(n.ref); i-- {
+			stack = append(stack, i)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if ai, aj := hits[i].area(), hits[j].area(); ai != aj {
+			return ai < aj
+		}
+		return hits[i].ref < hits[j].ref
+	})
+	ids := make([]int64, 0, len(hits))
+	for _, n := range hits {
+		ids = append(ids, n.ref)
+	}
+	return ids
+}
+
+func (n boxNode) area() float64 {
+	return (n.maxx - n.minx) * (n.maxy - n.miny)
+}
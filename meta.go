@@ -0,0 +1,144 @@
+// meta.go
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// 数据集来源：合规需要证明某个历史响应用的是哪一版边界。
+// 响应头 X-Dataset-Version 与这里的 version 相同，据此可以查到数据文件、GADM 版本、校验和等
+
+// 各数据来源的默认许可说明，DATASET_LICENSE 可覆盖（如 import 的自有边界）
+var datasetLicenses = map[string]string{
+	"gadm":         "GADM: free for academic and other non-commercial use; redistribution and commercial use require permission, see https://gadm.org/license.html",
+	"naturalearth": "Natural Earth: public domain, see https://www.naturalearthdata.com/about/terms-of-use/",
+	"embedded":     "synthetic demo data bundled with gpkg-reverse, no restrictions",
+}
+
+// 表名或文件名中的 GADM 版本，如 gadm_410 / gadm41_IDN.gpkg → 4.1
+var gadmVersionRe = regexp.MustCompile(`(?i)gadm_?(\d)(\d)`)
+
+type DatasetMeta struct {
+	Version string `json:"version"`
+	Kind    string `json:"kind"` // gadm / naturalearth / embedded
+	// 原始数据文件；GADM 分层文件、Natural Earth 转换后加载的是 path
+	Source  string `json:"source"`
+	Path    string `json:"path"`
+	Table   string `json:"table"`
+	Vintage string `json:"vintage,omitempty"`
+	License string `json:"license,omitempty"`
+	// 原始数据文件的大小、修改时间和 SHA-256
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+	Checksum   string    `json:"checksum"`
+	// GeoPackage gpkg_contents 中该表的最后修改时间
+	ContentsUpdated string `json:"contentsUpdated,omitempty"`
+	// build 命令预处理的库中的构建信息（source、tolerance、built_at 等）
+	Build      map[string]string `json:"build,omitempty"`
+	Rows       int               `json:"rows"`
+	Levels     []LevelCount      `json:"levels"`
+	ComputedAt time.Time         `json:"computedAt"`
+}
+
+type MetaRes struct {
+	Code int          `json:"code"`
+	Msg  string       `json:"msg"`
+	Data *DatasetMeta `json:"data"`
+}
+
+/************* 数据集来源（首次请求时计算一次，校验和需读完整个文件） *************/
+func (s *Server) computeMeta() (*DatasetMeta, error) {
+	m := &DatasetMeta{
+		Kind:    s.kind,
+		Source:  s.source,
+		Path:    s.path,
+		Table:   s.table,
+		Vintage: env("DATASET_VINTAGE", ""),
+		License: env("DATASET_LICENSE", datasetLicenses[s.kind]),
+	}
+	if m.Vintage == "" && s.kind == "gadm" {
+		for _, name := range []string{s.table, filepath.Base(s.source)} {
+			if v := gadmVersionRe.FindStringSubmatch(name); v != nil {
+				m.Vintage = v[1] + "." + v[2]
+				break
+			}
+		}
+	}
+
+	f, err := os.Open(s.source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m.Size, m.ModifiedAt = st.Size(), st.ModTime().UTC()
+	start := time.Now()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	m.Checksum = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	log.Printf("meta: checksum of %s in %s", s.source, time.Since(start).Round(time.Millisecond))
+
+	var updated sql.NullString
+	if err := s.db.QueryRow(`SELECT last_change FROM gpkg_contents WHERE table_name = ?;`, s.table).Scan(&updated); err == nil {
+		m.ContentsUpdated = updated.String
+	}
+	if s.built {
+		rows, err := s.db.Query(fmt.Sprintf(`SELECT key, value FROM "%s_build";`, s.table))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		m.Build = make(map[string]string)
+		for rows.Next() {
+			var k, v string
+			if err := rows.Scan(&k, &v); err != nil {
+				return nil, err
+			}
+			m.Build[k] = v
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	stats, err := s.stats()
+	if err != nil {
+		return nil, err
+	}
+	m.Rows, m.Levels = stats.Rows, stats.Levels
+	m.ComputedAt = time.Now().UTC()
+	return m, nil
+}
+
+func (s *Server) handleMeta(w http.ResponseWriter, _ *http.Request) {
+	base, err := s.meta()
+	if err != nil {
+		log.Println("meta error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	m := *base
+	if s.gen != nil {
+		m.Version = s.gen.version
+	}
+	writeJSON(w, http.StatusOK, MetaRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &m,
+	})
+}
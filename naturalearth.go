@@ -0,0 +1,186 @@
+// naturalearth.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DATASET=naturalearth：用 Natural Earth 的国家（admin-0）和一级行政区（admin-1）代替 GADM，
+// 只需要国家 / 省级反查时数据从几 GB 降到几十 MB。
+// 打开前转换成与 gadm_410 相同的结构（GID_x / NAME_x 等列）并缓存，其他代码不用区分数据来源
+
+func naturalEarthDataset(elevationDB *sql.DB, jobs *jobStore) (files func() ([]string, error), open func() ([]*Server, []apiRoute, error)) {
+	src := env("NE_PATH", "data/natural_earth_vector.gpkg")
+	files = func() ([]string, error) {
+		return []string{src}, nil
+	}
+	open = func() ([]*Server, []apiRoute, error) {
+		cfg, err := prepareNaturalEarth(src,
+			env("NE_ADMIN0_TABLE", "ne_10m_admin_0_countries"),
+			env("NE_ADMIN1_TABLE", "ne_10m_admin_1_states_provinces"),
+			env("NE_CACHE_PATH", "data/naturalearth.sqlite"),
+			env("GPKG_TABLE", "gadm_410"), env("GPKG_GEOM_COL", "geom"))
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.spatialitePath = env("SPATIALITE_PATH", "data/naturalearth_spatialite.sqlite")
+		cfg.centroidsPath = env("CENTROIDS_PATH", "data/naturalearth_centroids.sqlite")
+		s, err := openServer(cfg, elevationDB, jobs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*Server{s}, s.apiRoutes(), nil
+	}
+	return files, open
+}
+
+// Natural Earth 源表及其列（列名大写）
+type neSource struct {
+	admin0, admin1 string
+	geom0, geom1   string
+	cols0, cols1   map[string]bool
+}
+
+// 缓存不存在或比源文件旧时重新转换；没有 admin-1 表时只有国家一层
+func prepareNaturalEarth(path, admin0, admin1, out, table, geomCol string) (datasetConfig, error) {
+	cfg := datasetConfig{path: out, kind: "naturalearth", source: path, table: table, geomCol: geomCol}
+	srcInfo, err := os.Stat(path)
+	if err != nil {
+		return cfg, err
+	}
+	if st, err := os.Stat(out); err == nil && !st.ModTime().Before(srcInfo.ModTime()) {
+		return cfg, nil
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&immutable=1", path))
+	if err != nil {
+		return cfg, err
+	}
+	defer db.Close()
+	src := neSource{admin0: admin0, admin1: admin1, geom0: "geom", geom1: "geom"}
+	if src.cols0, err = tableColumns(db, admin0); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if !src.cols0["ADM0_A3"] {
+		return cfg, fmt.Errorf("%s: table %s with column ADM0_A3 not found", path, admin0)
+	}
+	if src.cols1, err = tableColumns(db, admin1); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(src.cols1) == 0 {
+		log.Printf("naturalearth: table %s not found in %s, countries only", admin1, path)
+		src.admin1 = ""
+	} else if !src.cols1["ADM1_CODE"] || !src.cols1["ADM0_A3"] {
+		return cfg, fmt.Errorf("%s: table %s has no adm1_code / adm0_a3 columns", path, admin1)
+	}
+	_ = db.QueryRow(`SELECT column_name FROM gpkg_geometry_columns WHERE table_name = ?;`, admin0).Scan(&src.geom0)
+	_ = db.QueryRow(`SELECT column_name FROM gpkg_geometry_columns WHERE table_name = ?;`, admin1).Scan(&src.geom1)
+	db.Close()
+
+	start := time.Now()
+	if err := normalizeNaturalEarth(path, src, out, table, geomCol); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	log.Printf("naturalearth: converted %s into %s in %s", path, out, time.Since(start).Round(time.Millisecond))
+	return cfg, nil
+}
+
+// 源表中第一个存在的列，都不存在时为空串
+func neCol(cols map[string]bool, alias string, names ...string) string {
+	for _, name := range names {
+		if cols[strings.ToUpper(name)] {
+			return fmt.Sprintf(`IFNULL(%s."%s", '')`, alias, name)
+		}
+	}
+	return "''"
+}
+
+// 与 GADM 一样 GID_0 用 ISO 3166-1 alpha-3；Natural Earth 中没有 ISO 代码的（ISO_A3 为 -99）用 ADM0_A3
+func neCountryCode(cols map[string]bool, fallback string) string {
+	iso := neCol(cols, "c", "ISO_A3_EH", "ISO_A3")
+	return fmt.Sprintf(`CASE WHEN %[1]s GLOB '[A-Z][A-Z][A-Z]' THEN %[1]s ELSE %[2]s END`, iso, fallback)
+}
+
+// 先写临时文件再改名，失败时不留下半个缓存
+func normalizeNaturalEarth(path string, src neSource, out, table, geomCol string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	tmp := out + ".tmp"
+	os.Remove(tmp)
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	db, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// ATTACH 只对当前连接有效
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`ATTACH DATABASE ? AS src;`, fmt.Sprintf("file:%s?mode=ro&immutable=1", path)); err != nil {
+		return err
+	}
+
+	// 一级行政区的属性对应到 GADM 的同名列，2..5 层留空
+	level1 := []string{"GID_1", "NAME_1", "VARNAME_1", "NL_NAME_1", "TYPE_1", "ENGTYPE_1", "HASC_1", "ISO_1"}
+	defs := []string{`"GID_0" TEXT NOT NULL DEFAULT ''`, `"NAME_0" TEXT NOT NULL DEFAULT ''`, `"COUNTRY" TEXT NOT NULL DEFAULT ''`}
+	for _, col := range level1 {
+		defs = append(defs, fmt.Sprintf(`"%s" TEXT NOT NULL DEFAULT ''`, col))
+	}
+	for lvl := 2; lvl <= 5; lvl++ {
+		defs = append(defs, fmt.Sprintf(`"GID_%d" TEXT NOT NULL DEFAULT ''`, lvl), fmt.Sprintf(`"NAME_%d" TEXT NOT NULL DEFAULT ''`, lvl))
+	}
+	name0 := neCol(src.cols0, "c", "NAME", "ADMIN", "NAME_LONG")
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE "%s" (fid INTEGER PRIMARY KEY, %s, "%s" BLOB);`, table, strings.Join(defs, ", "), geomCol),
+	}
+
+	// 没有一级行政区的国家（或没有 admin-1 表时所有国家）用国家多边形作为叶子
+	countries := fmt.Sprintf(`INSERT INTO "%[1]s" (GID_0, NAME_0, COUNTRY, "%[2]s")
+SELECT %[3]s, %[4]s, %[4]s, c."%[5]s"
+FROM src."%[6]s" AS c
+WHERE c."%[5]s" IS NOT NULL`, table, geomCol, neCountryCode(src.cols0, `c."ADM0_A3"`), name0, src.geom0, src.admin0)
+	if src.admin1 != "" {
+		a := func(names ...string) string { return neCol(src.cols1, "a", names...) }
+		stmts = append(stmts, fmt.Sprintf(`INSERT INTO "%[1]s" (GID_0, NAME_0, COUNTRY, %[2]s, "%[3]s")
+SELECT %[4]s, COALESCE(NULLIF(%[5]s, ''), %[6]s), COALESCE(NULLIF(%[5]s, ''), %[6]s),
+       a."adm1_code", %[7]s, %[8]s, %[9]s, %[10]s, %[11]s, %[12]s, %[13]s, a."%[14]s"
+FROM src."%[15]s" AS a
+LEFT JOIN src."%[16]s" AS c ON c."ADM0_A3" = a."adm0_a3"
+WHERE a."%[14]s" IS NOT NULL;`,
+			table, strings.Join(level1, ", "), geomCol,
+			neCountryCode(src.cols0, `a."adm0_a3"`), name0, a("admin"),
+			a("name"), a("name_alt"), a("name_local"), a("type"), a("type_en"), a("code_hasc"), a("iso_3166_2"),
+			src.geom1, src.admin1, src.admin0))
+		countries += fmt.Sprintf(` AND c."ADM0_A3" NOT IN (SELECT "adm0_a3" FROM src."%s" WHERE "adm0_a3" IS NOT NULL)`, src.admin1)
+	}
+	stmts = append(stmts, countries+";",
+		fmt.Sprintf(`CREATE INDEX "idx_%[1]s_gid0" ON "%[1]s" (GID_0, GID_1, NAME_1);`, table),
+		fmt.Sprintf(`CREATE INDEX "idx_%[1]s_gid1" ON "%[1]s" (GID_1);`, table),
+	)
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := buildRTree(db, table, geomCol); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DETACH DATABASE src;`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ANALYZE;`); err != nil {
+		return err
+	}
+	db.Close()
+	return os.Rename(tmp, out)
+}
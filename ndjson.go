@@ -0,0 +1,94 @@
+// ndjson.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// 每写这么多行 Flush 一次
+const ndjsonFlushRows = 100
+
+func wantsNDJSON(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("format"), "ndjson")
+}
+
+// 一行一个 JSON 对象，边计算边以 chunked 方式发出
+type ndjsonStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	started bool
+	rows    int
+}
+
+func newNDJSONStream(w http.ResponseWriter) *ndjsonStream {
+	return &ndjsonStream{w: w, enc: json.NewEncoder(w)}
+}
+
+// 响应头推迟到第一行再发，开始输出前出错仍可返回正常的错误响应
+func (nd *ndjsonStream) write(v any) error {
+	if !nd.started {
+		nd.w.Header().Set("Content-Type", "application/x-ndjson")
+		nd.w.WriteHeader(http.StatusOK)
+		nd.started = true
+	}
+	if err := nd.enc.Encode(v); err != nil {
+		return errClientGone
+	}
+	nd.rows++
+	if nd.rows%ndjsonFlushRows == 0 {
+		nd.flush()
+	}
+	return nil
+}
+
+func (nd *ndjsonStream) flush() {
+	if f, ok := nd.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+var errClientGone = errors.New("client gone")
+
+// 结束输出。已开始输出后再出错只能追加一行 {"code":500,...}，客户端据此判断结果不完整
+func (nd *ndjsonStream) finish(what string, err error) {
+	switch {
+	case err == nil:
+		if !nd.started {
+			nd.w.Header().Set("Content-Type", "application/x-ndjson")
+			nd.w.WriteHeader(http.StatusOK)
+		}
+		nd.flush()
+	case errors.Is(err, errClientGone), errors.Is(err, context.Canceled):
+	case strings.Contains(err.Error(), "gid not found") && !nd.started:
+		writeErrorJSON(nd.w, http.StatusNotFound, 404, "not found")
+	case errors.Is(err, context.DeadlineExceeded) && !nd.started:
+		writeErrorJSON(nd.w, http.StatusServiceUnavailable, 503, "request timeout")
+	default:
+		log.Println(what+" error:", err)
+		if !nd.started {
+			writeErrorJSON(nd.w, http.StatusInternalServerError, 500, "internal error")
+			return
+		}
+		_ = nd.enc.Encode(ChildrenRes{Code: 500, Msg: "internal error"})
+		nd.flush()
+	}
+}
+
+// /latlng/batch 中找不到的 code
+type ndjsonMissing struct {
+	GID     string `json:"code"`
+	Missing bool   `json:"missing"`
+}
+
+// 子树按先序逐个节点输出，不带 children
+func (s *Server) streamTree(ctx context.Context, w http.ResponseWriter, code string, depth int) {
+	nd := newNDJSONStream(w)
+	nd.finish("tree", s.walkTree(ctx, code, depth, func(node, _ *TreeNode) error {
+		return nd.write(node)
+	}))
+}
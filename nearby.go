@@ -0,0 +1,134 @@
+// nearby.go
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// 半径上限（千米）
+const maxNearbyRadiusKm = 200
+
+// 圆内的行政区及其到圆心的距离，圆心落在区域内时距离为 0
+type NearbyItem struct {
+	GID        string  `json:"code"`
+	Name       string  `json:"name"`
+	ParentCode string  `json:"parentCode"`
+	Level      string  `json:"level"`
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+type NearbyItemList struct {
+	List   []NearbyItem `json:"list"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit,omitempty"`
+	Offset int          `json:"offset,omitempty"`
+}
+
+type NearbyRes struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data *NearbyItemList `json:"data"`
+}
+
+/************* 半径内的行政区 *************/
+func (s *Server) nearby(lon, lat, radiusM float64, level int, mode nameMode) ([]NearbyItem, error) {
+	rlon, rlat := s.roundPoint(lon, lat)
+	pt := orb.Point{rlon, rlat}
+	rows, err := s.levelRowsIn(boundAroundPoint(pt, radiusM), level, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// 每个区域取其各叶子多边形到圆心的最小距离
+	best := make(map[string]*NearbyItem)
+	for _, row := range rows {
+		item, ok := best[row.item.GID]
+		if ok && item.DistanceKm == 0 {
+			continue
+		}
+		mp, err := s.leafGeom(row.rowid)
+		if err != nil {
+			continue
+		}
+		d := 0.0
+		if !planar.MultiPolygonContains(mp, pt) {
+			d = distanceToBoundaryM(mp, pt)
+		}
+		if d > radiusM {
+			continue
+		}
+		if !ok {
+			best[row.item.GID] = &NearbyItem{
+				GID:        row.item.GID,
+				Name:       row.item.Name,
+				ParentCode: row.item.ParentCode,
+				Level:      row.item.Level,
+				DistanceKm: d / 1000,
+			}
+		} else if d/1000 < item.DistanceKm {
+			item.DistanceKm = d / 1000
+		}
+	}
+
+	out := make([]NearbyItem, 0, len(best))
+	for _, item := range best {
+		item.DistanceKm = math.Round(item.DistanceKm*1000) / 1000
+		out = append(out, *item)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].DistanceKm != out[j].DistanceKm {
+			return out[i].DistanceKm < out[j].DistanceKm
+		}
+		return out[i].GID < out[j].GID
+	})
+	return out, nil
+}
+
+func (s *Server) handleNearby(w http.ResponseWriter, r *http.Request) {
+	lat, lon, err := parseLatLon(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "lat/lon out of range")
+		return
+	}
+	q := r.URL.Query()
+	radiusKm, err := strconv.ParseFloat(strings.TrimSpace(q.Get("radius_km")), 64)
+	if err != nil || !(radiusKm > 0) || radiusKm > maxNearbyRadiusKm {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid radius_km, use (0, 200]")
+		return
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(q.Get("level")))
+	if err != nil || level < 0 || level > 5 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+		return
+	}
+	page := parsePage(r, 5000)
+
+	items, err := s.nearby(lon, lat, radiusKm*1000, level, parseNameMode(r))
+	if err != nil {
+		log.Println("nearby error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, NearbyRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &NearbyItemList{
+			List:   pageSlice(items, page),
+			Total:  len(items),
+			Limit:  page.Limit,
+			Offset: page.Offset,
+		},
+	})
+}
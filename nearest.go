@@ -0,0 +1,41 @@
+// nearest.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+/************* 最近行政区（海上/湖面/沿海漂移的兜底） *************/
+func (s *Server) nearest(ctx context.Context, lon, lat, maxM float64, mode nameMode) (*AdminLevels, error) {
+	if maxM <= 0 {
+		return nil, sql.ErrNoRows
+	}
+	rlon, rlat := s.roundPoint(lon, lat)
+	pt := orb.Point{rlon, rlat}
+	b := boundAroundPoint(pt, maxM)
+
+	var (
+		best  *candidate
+		bestD = math.Inf(1)
+	)
+	err := s.eachCandidate(ctx, mode, b.Min.Lon(), b.Min.Lat(), b.Max.Lon(), b.Max.Lat(), func(c *candidate) bool {
+		if d := distanceToBoundaryM(c.geom, pt); d < bestD {
+			bestD = d
+			best = c
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if best == nil || bestD > maxM {
+		return nil, sql.ErrNoRows
+	}
+	res := best.adminLevels()
+	res.DistanceM = math.Round(bestD*10) / 10
+	return res, nil
+}
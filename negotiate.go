@@ -0,0 +1,328 @@
+// negotiate.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// 响应编码格式，由 Accept 头协商
+type responseFormat int
+
+const (
+	formatJSON responseFormat = iota
+	formatXML
+	formatProtobuf
+	formatMsgpack
+)
+
+var formatMediaTypes = map[string]responseFormat{
+	"application/json": formatJSON,
+	"application/xml":  formatXML,
+	"text/xml":         formatXML,
+
+	"application/x-protobuf": formatProtobuf,
+	"application/protobuf":   formatProtobuf,
+
+	"application/x-msgpack":   formatMsgpack,
+	"application/msgpack":     formatMsgpack,
+	"application/vnd.msgpack": formatMsgpack,
+}
+
+var formatContentTypes = map[responseFormat]string{
+	formatJSON:     "application/json",
+	formatXML:      "application/xml; charset=utf-8",
+	formatProtobuf: "application/x-protobuf",
+	formatMsgpack:  "application/x-msgpack",
+}
+
+// 取 Accept 中 q 值最高的已支持类型；都不支持（含 */*）时为 JSON
+func negotiateFormat(accept string) responseFormat {
+	best, bestQ := formatJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		f, ok := formatMediaTypes[mt]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+// 记录协商结果的 ResponseWriter，writeJSON 据此选择编码
+type negotiatedWriter struct {
+	http.ResponseWriter
+	format responseFormat
+	// 不带 {code,msg,data} 外壳，直接返回资源
+	bare bool
+}
+
+func (w *negotiatedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *negotiatedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// 所有接口共用的内容协商：按 Accept 选择响应格式；envelope 为服务默认是否带外壳，
+// 可被请求的 ?envelope=true|false 覆盖
+func negotiate(next http.Handler, envelope bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		bare := !envelope
+		switch strings.ToLower(r.URL.Query().Get("envelope")) {
+		case "false", "0":
+			bare = true
+		case "true", "1":
+			bare = false
+		}
+		next.ServeHTTP(&negotiatedWriter{ResponseWriter: w, format: negotiateFormat(r.Header.Get("Accept")), bare: bare}, r)
+	})
+}
+
+func isBare(w http.ResponseWriter) bool {
+	nw, ok := w.(*negotiatedWriter)
+	return ok && nw.bare
+}
+
+// 取出 {Code, Msg, Data} 外壳中的 Data；不是外壳时原样返回
+func unwrapEnvelope(v any) any {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return v
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return v
+	}
+	code, msg, data := rv.FieldByName("Code"), rv.FieldByName("Msg"), rv.FieldByName("Data")
+	if !code.IsValid() || !msg.IsValid() || !data.IsValid() || code.Kind() != reflect.Int || msg.Kind() != reflect.String {
+		return v
+	}
+	switch data.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		if data.IsNil() {
+			return nil
+		}
+	}
+	return data.Interface()
+}
+
+// 不带外壳时的错误响应（RFC 9457 problem details）
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	p := Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+	format := responseFormatOf(w, p)
+	switch format {
+	case formatJSON:
+		w.Header().Set("Content-Type", "application/problem+json")
+	case formatXML:
+		w.Header().Set("Content-Type", "application/problem+xml")
+	default:
+		w.Header().Set("Content-Type", formatContentTypes[format])
+	}
+	w.WriteHeader(status)
+	_ = encodeResponse(w, format, p)
+}
+
+// 协商到 protobuf 但该响应没有对应的 proto 消息时退回 JSON
+func responseFormatOf(w http.ResponseWriter, v any) responseFormat {
+	nw, ok := w.(*negotiatedWriter)
+	if !ok {
+		return formatJSON
+	}
+	if _, isProto := v.(protoMessage); nw.format == formatProtobuf && !isProto {
+		return formatJSON
+	}
+	return nw.format
+}
+
+// 按协商格式写出响应体；非 JSON 格式都从 JSON 结构转换，字段名与 JSON 一致
+func encodeResponse(w io.Writer, format responseFormat, v any) error {
+	switch format {
+	case formatJSON:
+		// 热点响应手写编码，见 jsonenc.go
+		if m, ok := v.(jsonMessage); ok {
+			if ok, err := writeJSONMessage(w, m); ok {
+				return err
+			}
+		}
+		return json.NewEncoder(w).Encode(v)
+	case formatProtobuf:
+		_, err := w.Write(v.(protoMessage).appendProto(nil))
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case formatXML:
+		return jsonToXML(w, b)
+	case formatMsgpack:
+		return jsonToMsgpack(w, b)
+	}
+	return fmt.Errorf("unsupported response format %d", format)
+}
+
+/************* JSON → MessagePack *************/
+
+// 键与 JSON 相同，按键名排序；数值用最短的无损编码
+func jsonToMsgpack(w io.Writer, b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	enc := msgpack.NewEncoder(w)
+	enc.SetSortMapKeys(true)
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	return enc.Encode(msgpackValue(v))
+}
+
+func msgpackValue(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n
+		}
+		f, _ := t.Float64()
+		return f
+	case []any:
+		for i := range t {
+			t[i] = msgpackValue(t[i])
+		}
+	case map[string]any:
+		for k := range t {
+			t[k] = msgpackValue(t[k])
+		}
+	}
+	return v
+}
+
+/************* JSON → XML *************/
+
+// 根元素为 <response>；对象字段为同名子元素，数组元素为 <item>，null 为 nil="true" 的空元素。
+// 不是合法 XML 名的键（如纯数字）写成 <entry key="...">。
+func jsonToXML(w io.Writer, b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := xmlValue(dec, enc, xmlElement("response")); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func xmlValue(dec *json.Decoder, enc *xml.Encoder, start xml.StartElement) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	var text string
+	switch t := tok.(type) {
+	case json.Delim:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for dec.More() {
+			child := xmlElement("item")
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = xmlElement(key.(string))
+			}
+			if err := xmlValue(dec, enc, child); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	case nil:
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+	case string:
+		text = t
+	case json.Number:
+		text = t.String()
+	case bool:
+		text = strconv.FormatBool(t)
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if text != "" {
+		if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func xmlElement(key string) xml.StartElement {
+	if isXMLName(key) {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{
+		Name: xml.Name{Local: "entry"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+	}
+}
+
+func isXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c == '.' || c >= '0' && c <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,491 @@
+// openapi.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// 接口注册表：路由和 OpenAPI 文档都从这里生成，新增接口只需加一项
+type apiRoute struct {
+	Pattern string
+	Handler http.HandlerFunc
+	Summary string
+	Params  []apiParam
+	// 请求体类型（POST），nil 表示无请求体
+	Body any
+	// 非 JSON 请求体（如上传文件）的媒体类型
+	Consumes []string
+	// JSON 响应类型，nil 时按 Produces 描述为二进制/文本
+	Response any
+	Produces string
+	// 长时间的上传、下载或推送，不受 HTTP 读写超时和 REQUEST_TIMEOUT 限制（见 httpserver.go）
+	LongLived bool
+}
+
+type apiParam struct {
+	Name     string
+	In       string
+	Type     string
+	Desc     string
+	Required bool
+	Enum     []string
+}
+
+func queryParam(name, typ, desc string, enum ...string) apiParam {
+	return apiParam{Name: name, In: "query", Type: typ, Desc: desc, Enum: enum}
+}
+
+func requiredParam(name, typ, desc string) apiParam {
+	return apiParam{Name: name, In: "query", Type: typ, Desc: desc, Required: true}
+}
+
+func params(groups ...[]apiParam) []apiParam {
+	var out []apiParam
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
+
+var (
+	pointParams = []apiParam{
+		queryParam("latitude", "number", "纬度，与 longitude 一起使用"),
+		queryParam("longitude", "number", "经度"),
+		queryParam("latlng", "string", "\"lat,lon\""),
+		queryParam("geohash", "string", "Geohash，取其中心点"),
+		queryParam("h3", "string", "H3 六边形 id，取其中心点"),
+		queryParam("crs", "string", "投影坐标系，如 EPSG:32748，与 x、y 一起使用"),
+		queryParam("x", "number", "投影坐标 x"),
+		queryParam("y", "number", "投影坐标 y"),
+	}
+	pageParams = []apiParam{
+		queryParam("limit", "integer", "每页条数，缺省不分页"),
+		queryParam("offset", "integer", "跳过的条数"),
+	}
+	langParams      = []apiParam{queryParam("lang", "string", "名称语言：en/latin（默认）、alt、或本地文字（如 zh、local）")}
+	codeParams      = []apiParam{requiredParam("code", "string", "行政区 GID，如 IDN.8_1")}
+	toleranceParams = []apiParam{
+		queryParam("tolerance", "number", "Douglas-Peucker 简化容差（度）"),
+		queryParam("zoom", "integer", "按缩放级别简化（0..22），不能与 tolerance 同时使用"),
+	}
+	includeParams   = []apiParam{queryParam("include", "string", "逗号分隔的外部属性（ATTRIBUTES_PATH），all 为全部")}
+	geomFormatParam = queryParam("geom_format", "string", "内联几何格式", "geojson", "wkt")
+	viewParam       = queryParam("view", "string", "争议地区视角（DISPUTED_VIEWS_PATH），如 IN、CN，worldwide 为 GADM 原样")
+	jobIDParams     = []apiParam{{Name: "id", In: "path", Type: "string", Required: true}}
+)
+
+func (s *Server) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{Pattern: "/health", Handler: s.handleHealth, Summary: "健康检查", Produces: "text/plain"},
+		{Pattern: "/reverse", Handler: s.handleReverse, Summary: "经纬度反查行政区",
+			Params: params(pointParams, []apiParam{
+				queryParam("pluscode", "string", "Plus Code（完整或带地名的短码）"),
+				queryParam("level", "integer", "只返回到该层级（0..5）"),
+				queryParam("include_geometry", "string", "内联边界", "simplified", "full"),
+				geomFormatParam,
+				queryParam("include_levels", "string", "1 时返回每层详情", "1"),
+				queryParam("max_distance_m", "number", "落在缝隙中时最近行政区的最大距离（米）"),
+				queryParam("overlays", "string", "逗号分隔的附加图层名称（LAYERS_CONFIG），all 为全部"),
+				viewParam,
+			}, toleranceParams, langParams),
+			Response: AdminLevelsRes{}},
+		{Pattern: "/reverse/all", Handler: s.handleReverseAll, Summary: "多图层反查",
+			Params: params(pointParams, langParams, []apiParam{viewParam}), Response: LayerHitRes{}},
+		{Pattern: "/stats", Handler: s.handleStats, Summary: "数据集统计", Response: StatsRes{}},
+		{Pattern: "/meta", Handler: s.handleMeta, Summary: "数据集来源：版本、许可、校验和", Response: MetaRes{}},
+		{Pattern: "/diff", Handler: s.handleDiff, Summary: "与旧版本 GADM 的差异",
+			Params: params([]apiParam{
+				queryParam("level", "string", "层级，缺省时比较所有层级"),
+				queryParam("country", "string", "按 GID_0 过滤"),
+				queryParam("tolerance", "number", "外接矩形移动阈值（度）"),
+			}, pageParams),
+			Response: DiffRes{}},
+		{Pattern: "/migrate", Handler: s.handleMigrate, Summary: "旧版本 GID 在当前版本中的对应代码",
+			Params: []apiParam{requiredParam("code", "string", "旧版本的 GID")}, Response: MigrateRes{}},
+		{Pattern: "/children", Handler: s.handleChildren, Summary: "下级行政区",
+			Params: params([]apiParam{
+				queryParam("parent_code", "string", "上级 GID，缺省为 GPKG_PARENT_CODE"),
+				queryParam("sort", "string", "排序字段", "name", "code"),
+				queryParam("order", "string", "排序方向", "asc", "desc"),
+				queryParam("name_prefix", "string", "名称前缀过滤"),
+				queryParam("name_contains", "string", "名称包含过滤"),
+				queryParam("format", "string", "输出格式", "csv", "geojson"),
+			}, pageParams, toleranceParams, includeParams, langParams),
+			Response: ChildrenRes{}},
+		{Pattern: "/latlng", Handler: s.handleLatlng, Summary: "行政区中心点、海拔和时区",
+			Params: []apiParam{
+				queryParam("code", "string", "行政区 GID，缺省为 GPKG_PARENT_CODE"),
+				queryParam("geohash_precision", "integer", "返回该精度的 Geohash（1..12）"),
+			},
+			Response: LatlngRes{}},
+		{Pattern: "/search", Handler: s.handleSearch, Summary: "名称搜索",
+			Params: params([]apiParam{
+				requiredParam("q", "string", "名称或以 / 分隔的名称路径"),
+				queryParam("fuzzy", "string", "1 总是模糊匹配，0 关闭，缺省时无结果才模糊匹配"),
+			}, pageParams, langParams),
+			Response: SearchRes{}},
+		{Pattern: "/resolve", Handler: s.handleResolve, Summary: "名称路径解析",
+			Params:   []apiParam{requiredParam("path", "string", "以 / 分隔的名称路径")},
+			Response: ResolveRes{}},
+		{Pattern: "/boundary", Handler: s.handleBoundary, Summary: "行政区边界",
+			Params: params(codeParams, toleranceParams, []apiParam{
+				geomFormatParam,
+				queryParam("format", "string", "fgb 时输出 FlatGeobuf（支持 Range）", "fgb"),
+			}),
+			Response: BoundaryRes{}},
+		{Pattern: "/ancestors", Handler: s.handleAncestors, Summary: "上级链", Params: codeParams, Response: ChildrenRes{}},
+		{Pattern: "/details", Handler: s.handleDetails, Summary: "行政区属性", Params: params(codeParams, includeParams), Response: DetailsRes{}},
+		{Pattern: "/tree", Handler: s.handleTree, Summary: "子树",
+			Params: params(codeParams, []apiParam{
+				queryParam("depth", "integer", "深度（1..5）"),
+				queryParam("format", "string", "输出格式", "csv", "ndjson"),
+			}),
+			Response: TreeRes{}},
+		{Pattern: "/contains", Handler: s.handleContains, Summary: "点是否在行政区内",
+			Params: params(codeParams, pointParams), Response: ContainsRes{}},
+		{Pattern: "/distance", Handler: s.handleDistance, Summary: "点到行政区边界的距离",
+			Params: params(codeParams, pointParams), Response: DistanceRes{}},
+		{Pattern: "/bbox", Handler: s.handleBBox, Summary: "外接矩形", Params: codeParams, Response: BBoxRes{}},
+		{Pattern: "/area", Handler: s.handleArea, Summary: "面积与周长", Params: codeParams, Response: AreaRes{}},
+		{Pattern: "/levels", Handler: s.handleLevels, Summary: "层级深度", Params: codeParams, Response: LevelsRes{}},
+		{Pattern: "/autocomplete", Handler: s.handleAutocomplete, Summary: "名称自动补全",
+			Params: []apiParam{
+				requiredParam("q", "string", "名称前缀"),
+				queryParam("limit", "integer", "条数（1..50）"),
+			},
+			Response: ChildrenRes{}},
+		{Pattern: "/iso", Handler: s.handleISO, Summary: "ISO 3166 与 GID 对照",
+			Params: []apiParam{
+				queryParam("code", "string", "ISO 3166-1/3166-2 代码"),
+				queryParam("gid", "string", "行政区 GID"),
+			},
+			Response: IsoRes{}},
+		{Pattern: "POST /reverse/route", Handler: s.handleReverseRoute, Summary: "路线经过的行政区",
+			Body: RouteRequest{}, Response: RouteRes{}},
+		{Pattern: "/within", Handler: s.handleWithin, Summary: "与矩形相交的行政区",
+			Params: params([]apiParam{
+				requiredParam("bbox", "string", "minLon,minLat,maxLon,maxLat"),
+				requiredParam("level", "integer", "层级（0..5）"),
+				queryParam("format", "string", "输出格式", "ndjson"),
+			}, pageParams, langParams),
+			Response: ChildrenRes{}},
+		{Pattern: "/topojson", Handler: s.handleTopoJSON, Summary: "某一层行政区的 TopoJSON",
+			Params: params(codeParams, []apiParam{
+				requiredParam("level", "integer", "层级，须深于 code 所在层级"),
+				queryParam("quantization", "integer", "量化精度，0 为不量化"),
+			}, toleranceParams, langParams),
+			Response: Topology{}},
+		{Pattern: "/kml", Handler: s.handleKML, Summary: "KML 导出",
+			Params: params(codeParams, []apiParam{
+				queryParam("children", "string", "1 时同时导出下一级", "1"),
+			}, toleranceParams, langParams),
+			Produces: "application/vnd.google-earth.kml+xml"},
+		{Pattern: "/export", Handler: s.handleExport, Summary: "子树导出为 Shapefile、GeoPackage 或 FlatGeobuf",
+			Params: params(codeParams, []apiParam{
+				requiredParam("level", "integer", "导出的层级"),
+				{Name: "format", In: "query", Type: "string", Desc: "文件格式", Required: true, Enum: []string{"shp", "gpkg", "fgb"}},
+			}),
+			Produces: "application/octet-stream", LongLived: true},
+		{Pattern: "GET /tiles/{z}/{x}/{y}", Handler: s.handleTile, Summary: "矢量瓦片（y 可带 .pbf 后缀）",
+			Params: params([]apiParam{
+				{Name: "z", In: "path", Type: "integer", Required: true},
+				{Name: "x", In: "path", Type: "integer", Required: true},
+				{Name: "y", In: "path", Type: "string", Required: true},
+				queryParam("level", "integer", "指定层级，缺省按缩放级别选择"),
+			}, langParams),
+			Produces: "application/vnd.mapbox-vector-tile"},
+		{Pattern: "/nearby", Handler: s.handleNearby, Summary: "半径内的行政区",
+			Params: params(pointParams, []apiParam{
+				requiredParam("radius_km", "number", "半径（公里）"),
+				requiredParam("level", "integer", "层级（0..5）"),
+			}, pageParams, langParams),
+			Response: NearbyRes{}},
+		{Pattern: "POST /latlng/batch", Handler: s.handleLatlngBatch, Summary: "批量中心点",
+			Params: []apiParam{
+				queryParam("geohash_precision", "integer", "返回该精度的 Geohash（1..12）"),
+				queryParam("format", "string", "输出格式", "csv", "ndjson"),
+			},
+			Body: LatlngBatchRequest{}, Response: LatlngBatchRes{}},
+		{Pattern: "POST /elevation/batch", Handler: s.handleElevationBatch, Summary: "批量海拔（行政区中心点或坐标）",
+			Body: ElevationBatchRequest{}, Response: ElevationBatchRes{}},
+		{Pattern: "/random", Handler: s.handleRandom, Summary: "行政区内随机取点",
+			Params: params(codeParams, []apiParam{
+				queryParam("n", "integer", "点数"),
+				queryParam("seed", "integer", "随机种子，相同种子结果相同"),
+			}),
+			Response: RandomRes{}},
+		{Pattern: "/h3", Handler: s.handleH3, Summary: "覆盖行政区的 H3 六边形",
+			Params: params(codeParams, []apiParam{
+				queryParam("resolution", "integer", "H3 分辨率（0..15，默认 7）"),
+				queryParam("containment", "string", "center：中心在区域内（默认）；full：整个在区域内；overlap：有重叠", "center", "full", "overlap"),
+			}),
+			Response: H3Res{}},
+		{Pattern: "POST /intersect", Handler: s.handleIntersect, Summary: "与多边形相交的行政区",
+			Params: langParams, Body: IntersectRequest{}, Response: IntersectRes{}},
+		{Pattern: "POST /jobs", Handler: s.handleCreateJob, Summary: "创建异步批量反查任务（上传 CSV 或 NDJSON）",
+			Params: params([]apiParam{
+				queryParam("format", "string", "输入格式，缺省按 Content-Type", "csv", "ndjson"),
+				queryParam("level", "integer", "只返回到该层级（0..5）"),
+			}, langParams),
+			Consumes: []string{"text/csv", "application/x-ndjson"},
+			Response: JobRes{}, LongLived: true},
+		{Pattern: "GET /jobs/{id}", Handler: s.handleJob, Summary: "任务状态",
+			Params: jobIDParams, Response: JobRes{}},
+		{Pattern: "DELETE /jobs/{id}", Handler: s.handleDeleteJob, Summary: "取消并删除任务",
+			Params: jobIDParams, Response: JobRes{}},
+		{Pattern: "GET /jobs/{id}/events", Handler: s.handleJobEvents, Summary: "任务进度（SSE）",
+			Params: jobIDParams, Produces: "text/event-stream", LongLived: true},
+		{Pattern: "GET /jobs/{id}/result", Handler: s.handleJobResult, Summary: "任务结果（格式同输入，支持 Range）",
+			Params: jobIDParams, Produces: "application/x-ndjson", LongLived: true},
+		{Pattern: "GET /compat/google/geocode/json", Handler: s.handleGoogleGeocode, Summary: "谷歌 Geocoding API 兼容的反查",
+			Params: []apiParam{
+				requiredParam("latlng", "string", "\"lat,lng\""),
+				queryParam("language", "string", "en 为拉丁字母名称，其他为本地文字名称"),
+				queryParam("region", "string", "与争议地区视角同名时按该视角返回，如 in"),
+				queryParam("result_type", "string", "按类型过滤，| 分隔，如 country|administrative_area_level_1"),
+				queryParam("location_type", "string", "只有 APPROXIMATE"),
+				queryParam("key", "string", "忽略"),
+			},
+			Response: GoogleGeocodeResponse{}},
+		{Pattern: "GET /compat/nominatim/reverse", Handler: s.handleNominatimReverse, Summary: "Nominatim 兼容的反查",
+			Params: []apiParam{
+				requiredParam("lat", "number", "纬度"),
+				requiredParam("lon", "number", "经度"),
+				queryParam("format", "string", "缺省为 xml", "xml", "json", "jsonv2", "geojson"),
+				queryParam("zoom", "integer", "地址详细程度：3 国家、5 省州、8 县、10 城市、12 以上乡镇村"),
+				queryParam("addressdetails", "integer", "0 时不返回 address"),
+				queryParam("accept-language", "string", "en 为拉丁字母名称，其他为本地文字名称"),
+				queryParam("polygon_geojson", "integer", "1 时返回 GeoJSON 边界"),
+				queryParam("polygon_text", "integer", "1 时返回 WKT 边界"),
+				queryParam("polygon_threshold", "number", "边界简化容差（度），0 为原始边界"),
+			},
+			Response: NominatimPlace{}},
+		{Pattern: "/graphql", Handler: s.handleGraphQL, Summary: "GraphQL 查询（GET 或 POST {query, variables, operationName}）",
+			Params: []apiParam{
+				requiredParam("query", "string", "GraphQL 查询"),
+				queryParam("variables", "string", "JSON 编码的变量"),
+				queryParam("operationName", "string", "操作名"),
+			},
+			Response: graphql.Result{}},
+		{Pattern: "POST /aggregate", Handler: s.handleAggregate, Summary: "点集按行政区聚合",
+			Params: langParams, Body: AggregateRequest{}, Response: AggregateRes{}},
+	}
+}
+
+/************* OpenAPI 文档生成 *************/
+
+// 按 Go 类型反射生成 JSON Schema，具名结构体放进 components/schemas 并用 $ref 引用
+type schemaGen struct {
+	schemas map[string]any
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func (g *schemaGen) schemaOf(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		return g.schemaOf(t.Elem())
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType):
+		// 自定义编码（json.RawMessage、几何等）无法从类型推断
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// 先占位，处理 TreeNode 这类自引用的类型
+			g.schemas[t.Name()] = nil
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	g.addFields(t, props)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+// 与 encoding/json 一致：按 json 标签命名，匿名嵌入的结构体字段提升到外层
+func (g *schemaGen) addFields(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.addFields(ft, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schemaOf(f.Type)
+	}
+}
+
+func buildOpenAPI(routes []apiRoute) map[string]any {
+	g := &schemaGen{schemas: make(map[string]any)}
+	paths := make(map[string]any)
+	for _, rt := range routes {
+		method, path, ok := strings.Cut(rt.Pattern, " ")
+		if !ok {
+			method, path = "GET", rt.Pattern
+		}
+
+		op := map[string]any{"summary": rt.Summary}
+		if len(rt.Params) > 0 {
+			ps := make([]any, 0, len(rt.Params))
+			for _, p := range rt.Params {
+				schema := map[string]any{"type": p.Type}
+				if len(p.Enum) > 0 {
+					schema["enum"] = p.Enum
+				}
+				param := map[string]any{"name": p.Name, "in": p.In, "schema": schema}
+				if p.Desc != "" {
+					param["description"] = p.Desc
+				}
+				if p.Required {
+					param["required"] = true
+				}
+				ps = append(ps, param)
+			}
+			op["parameters"] = ps
+		}
+		if rt.Body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": g.schemaOf(reflect.TypeOf(rt.Body))},
+				},
+			}
+		}
+		if rt.Body == nil && len(rt.Consumes) > 0 {
+			content := make(map[string]any)
+			for _, ct := range rt.Consumes {
+				content[ct] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+			}
+			op["requestBody"] = map[string]any{"required": true, "content": content}
+		}
+		responses := map[string]any{
+			"default": map[string]any{
+				"description": "错误",
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": g.schemaOf(reflect.TypeOf(ChildrenRes{}))},
+				},
+			},
+		}
+		switch {
+		case rt.Response != nil:
+			responses["200"] = map[string]any{
+				"description": "成功",
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": g.schemaOf(reflect.TypeOf(rt.Response))},
+				},
+			}
+		default:
+			schema := map[string]any{"type": "string"}
+			if rt.Produces != "text/plain" {
+				schema["format"] = "binary"
+			}
+			responses["200"] = map[string]any{
+				"description": "成功",
+				"content":     map[string]any{rt.Produces: map[string]any{"schema": schema}},
+			}
+		}
+		op["responses"] = responses
+
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = make(map[string]any)
+			paths[path] = item
+		}
+		item[strings.ToLower(method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "gpkg-reverse",
+			"version": "1.0.0",
+			"description": "基于 GADM GeoPackage 的行政区反查服务。JSON 响应默认包在 {code, msg, data} 中，" +
+				"envelope=false 时直接返回 data；按 Accept 协商 JSON / XML / Protobuf / MessagePack，按 Accept-Encoding 协商压缩。",
+		},
+		"servers":    []any{map[string]any{"url": "/"}},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.schemas},
+	}
+}
+
+// 返回预先生成的 OpenAPI 文档，不经过响应外壳和格式协商
+func serveOpenAPI(doc []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		_, _ = w.Write(doc)
+	}
+}
+
+// Swagger UI 页面，静态资源取自 CDN
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>gpkg-reverse API</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+func handleDocs(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(swaggerUIPage))
+}
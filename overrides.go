@@ -0,0 +1,223 @@
+// overrides.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// 修正层：OVERRIDES_PATH 为运维维护的独立 SQLite 库（不存在时创建），GADM 中已知的错误
+// （如印尼部分 kelurahan 的名称和边界）在这里修正，查询时叠加在 GADM 之上，结果带 overridden: true。
+//   - name_overrides：按 GID 修改名称（默认的拉丁字母名称）
+//   - area_overrides：按最末级 GID 给出 GeoJSON 几何。GID 已是 GADM 的叶子时替换它的几何，
+//     否则为新增区域，挂在 parent 下
+//
+// 库文件也参与数据集版本的计算，修改后随热更新生效，见 reload.go
+const overridesSchema = `
+CREATE TABLE IF NOT EXISTS name_overrides (
+  gid TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE TABLE IF NOT EXISTS area_overrides (
+  gid TEXT PRIMARY KEY,
+  name TEXT NOT NULL DEFAULT '',
+  parent TEXT NOT NULL DEFAULT '',
+  geometry TEXT NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);`
+
+// 修正层中的一个区域：替换 GADM 叶子的几何，或新增的叶子
+type overrideArea struct {
+	candidate
+	gid   string
+	level int
+	added bool
+	bound orb.Bound
+}
+
+type overrideStore struct {
+	names map[string]string
+	areas []*overrideArea
+	byGID map[string]*overrideArea
+}
+
+// 启动时建库建表，运维直接用 sqlite3 等工具写入
+func initOverrides(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(overridesSchema)
+	return err
+}
+
+// 未配置 OVERRIDES_PATH 时为空的修正层
+func (s *Server) loadOverrides(path string) (*overrideStore, error) {
+	o := &overrideStore{names: make(map[string]string), byGID: make(map[string]*overrideArea)}
+	if path == "" {
+		return o, nil
+	}
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT gid, name FROM name_overrides WHERE name <> '';`)
+	if err != nil {
+		return nil, fmt.Errorf("name_overrides: %w", err)
+	}
+	for rows.Next() {
+		var gid, name string
+		if err := rows.Scan(&gid, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		o.names[strings.TrimSpace(gid)] = name
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT gid, name, parent, geometry FROM area_overrides ORDER BY gid;`)
+	if err != nil {
+		return nil, fmt.Errorf("area_overrides: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var gid, name, parent, geom string
+		if err := rows.Scan(&gid, &name, &parent, &geom); err != nil {
+			return nil, err
+		}
+		a, err := s.overrideArea(strings.TrimSpace(gid), strings.TrimSpace(parent), geom)
+		if err != nil {
+			return nil, fmt.Errorf("area_overrides %s: %w", gid, err)
+		}
+		if name != "" {
+			o.names[a.gid] = name
+		}
+		if a.added && o.names[a.gid] == "" {
+			return nil, fmt.Errorf("area_overrides %s: name required for a new area", gid)
+		}
+		o.areas = append(o.areas, a)
+		o.byGID[a.gid] = a
+	}
+	return o, rows.Err()
+}
+
+// GADM 中已有的叶子取它的层级链，新增的区域取 parent 的层级链再加上自身
+func (s *Server) overrideArea(gid, parent, geom string) (*overrideArea, error) {
+	g, err := geojson.UnmarshalGeometry([]byte(geom))
+	if err != nil {
+		return nil, fmt.Errorf("geometry: %w", err)
+	}
+	a := &overrideArea{gid: gid}
+	switch gg := g.Geometry().(type) {
+	case orb.Polygon:
+		a.geom = orb.MultiPolygon{gg}
+	case orb.MultiPolygon:
+		a.geom = gg
+	default:
+		return nil, fmt.Errorf("geometry must be Polygon or MultiPolygon, got %s", g.Type)
+	}
+	a.bound = a.geom.Bound()
+	a.index = newShapeIndex(a.geom)
+
+	chain, err := s.ancestorsOf(context.Background(), gid)
+	switch {
+	case err == nil:
+		var leaves int
+		if err := s.db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE GID_%d = ?;`, s.table, len(chain)-1), gid).Scan(&leaves); err != nil {
+			return nil, err
+		}
+		if leaves != 1 || (len(chain) <= 5 && s.hasChildren(gid, len(chain)-1)) {
+			return nil, fmt.Errorf("%s is not a leaf, override its leaves instead", gid)
+		}
+	case strings.Contains(err.Error(), "gid not found"):
+		if parent == "" {
+			return nil, fmt.Errorf("parent required for a new area")
+		}
+		if chain, err = s.ancestorsOf(context.Background(), parent); err != nil {
+			return nil, fmt.Errorf("parent %s: %w", parent, err)
+		}
+		if len(chain) > 5 {
+			return nil, fmt.Errorf("parent %s is at the deepest level", parent)
+		}
+		chain = append(chain, ChildrenItem{GID: gid, ParentCode: parent, Level: levelNameMap()[len(chain)]})
+		a.added = true
+	default:
+		return nil, err
+	}
+	for i, item := range chain {
+		a.gids[i], a.names[i] = item.GID, item.Name
+	}
+	a.level = len(chain) - 1
+	return a, nil
+}
+
+func (s *Server) hasChildren(gid string, level int) bool {
+	var one int
+	err := s.db.QueryRow(fmt.Sprintf(`SELECT 1 FROM %s WHERE GID_%d = ? AND GID_%d <> '' LIMIT 1;`, s.table, level, level+1), gid).Scan(&one)
+	return err == nil
+}
+
+// 包含该点的修正区域
+func (o *overrideStore) at(pt orb.Point) *AdminLevels {
+	for _, a := range o.areas {
+		if a.bound.Contains(pt) && a.contains(pt) {
+			res := a.adminLevels()
+			o.rename(res, nameLatin)
+			res.Overridden = true
+			return res
+		}
+	}
+	return nil
+}
+
+// 几何被修正层替换的 GADM 叶子，反查时跳过
+func (o *overrideStore) replaced(leaf string) bool {
+	a := o.byGID[leaf]
+	return a != nil && !a.added
+}
+
+// 按 name_overrides 改名，只改默认的拉丁字母名称
+func (o *overrideStore) rename(a *AdminLevels, mode nameMode) {
+	if mode != nameLatin || len(o.names) == 0 {
+		return
+	}
+	gids := []string{a.GID0, a.GID1, a.GID2, a.GID3, a.GID4, a.GID5}
+	names := []*string{&a.Name0, &a.Name1, &a.Name2, &a.Name3, &a.Name4, &a.Name5}
+	for i, gid := range gids {
+		if name, ok := o.names[gid]; ok && gid != "" {
+			*names[i] = name
+			a.Overridden = true
+		}
+	}
+	o.renameItems(a.List, mode)
+}
+
+func (o *overrideStore) renameItems(items []ChildrenItem, mode nameMode) {
+	if mode != nameLatin {
+		return
+	}
+	for i := range items {
+		if name, ok := o.names[items[i].GID]; ok {
+			items[i].Name = name
+			items[i].Overridden = true
+		}
+	}
+}
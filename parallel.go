@@ -0,0 +1,72 @@
+// parallel.go
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/paulmach/orb"
+)
+
+// 并行判断候选：市区的点常有很多候选，逐个解码、判断时只用到一个核。
+// 每次反查最多 REVERSE_WORKERS 个 goroutine（默认 CPU 核数与 4 取小），按顺序分发候选，
+// 某个候选命中后不再分发，排在它后面的也不再判断；排在它前面的照常判断完，
+// 结果与逐个判断时相同（按外接矩形从小到大的第一个命中）。1 为逐个判断
+func (s *Server) firstContaining(ctx context.Context, mode nameMode, pt orb.Point) (*candidate, error) {
+	if s.workers <= 1 {
+		var hit *candidate
+		err := s.eachRawCandidate(ctx, mode, pt[0], pt[1], pt[0], pt[1], func(c *candidate) bool {
+			if s.candidateContains(c, pt) {
+				hit = c
+				return false
+			}
+			return true
+		})
+		return hit, err
+	}
+
+	type job struct {
+		i int
+		c *candidate
+	}
+	var (
+		mu   sync.Mutex
+		best = -1
+		hit  *candidate
+		wg   sync.WaitGroup
+	)
+	found := func(i int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return best >= 0 && i > best
+	}
+	jobs := make(chan job)
+	for w := 0; w < s.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if found(j.i) || !s.candidateContains(j.c, pt) {
+					continue
+				}
+				mu.Lock()
+				if best < 0 || j.i < best {
+					best, hit = j.i, j.c
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	i := 0
+	err := s.eachRawCandidate(ctx, mode, pt[0], pt[1], pt[0], pt[1], func(c *candidate) bool {
+		if found(i) {
+			return false
+		}
+		jobs <- job{i, c}
+		i++
+		return true
+	})
+	close(jobs)
+	wg.Wait()
+	return hit, err
+}
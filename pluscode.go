@@ -0,0 +1,191 @@
+// pluscode.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Open Location Code（Plus Code），见 https://github.com/google/open-location-code
+const (
+	olcAlphabet  = "23456789CFGHJMPQRVWX"
+	olcSeparator = '+'
+	olcSepPos    = 8
+	olcPadding   = '0'
+	olcPairLen   = 10
+	olcMaxLen    = 15
+)
+
+var errPlusCodeNeedsLocality = errors.New("short plus code needs a locality, e.g. 'QRJ7+3W Jakarta'")
+
+// 校验并规范化（大写），返回分隔符位置
+func checkPlusCode(code string) (string, int, error) {
+	code = strings.ToUpper(code)
+	sep := strings.IndexByte(code, olcSeparator)
+	if sep < 0 || sep != strings.LastIndexByte(code, olcSeparator) || sep > olcSepPos || sep%2 != 0 {
+		return "", 0, fmt.Errorf("invalid plus code")
+	}
+	if len(code)-sep-1 == 1 || len(code) > olcMaxLen+1 {
+		return "", 0, fmt.Errorf("invalid plus code")
+	}
+	// 填充只能出现在完整码的分隔符前，且成对出现
+	if p := strings.IndexByte(code, olcPadding); p >= 0 {
+		if sep != olcSepPos || p == 0 || p%2 != 0 || sep != len(code)-1 ||
+			strings.Trim(code[p:sep], string(olcPadding)) != "" {
+			return "", 0, fmt.Errorf("invalid plus code")
+		}
+	}
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		if c != olcSeparator && c != olcPadding && strings.IndexByte(olcAlphabet, c) < 0 {
+			return "", 0, fmt.Errorf("invalid plus code character %q", c)
+		}
+	}
+	return code, sep, nil
+}
+
+// 解码完整码，返回格子中心点
+func decodePlusCode(code string) (lat, lon float64, err error) {
+	code, sep, err := checkPlusCode(code)
+	if err != nil {
+		return 0, 0, err
+	}
+	if sep != olcSepPos {
+		return 0, 0, errPlusCodeNeedsLocality
+	}
+	digits := strings.TrimRight(strings.Replace(code, string(olcSeparator), "", 1), string(olcPadding))
+	if len(digits) > olcMaxLen {
+		digits = digits[:olcMaxLen]
+	}
+
+	latLo, lonLo := -90.0, -180.0
+	latRes, lonRes := 400.0, 400.0
+	for i := 0; i < len(digits) && i < olcPairLen; i += 2 {
+		latRes /= 20
+		lonRes /= 20
+		latLo += float64(strings.IndexByte(olcAlphabet, digits[i])) * latRes
+		lonLo += float64(strings.IndexByte(olcAlphabet, digits[i+1])) * lonRes
+	}
+	// 第 11 位起为 5 行 x 4 列的网格
+	for i := olcPairLen; i < len(digits); i++ {
+		latRes /= 5
+		lonRes /= 4
+		v := strings.IndexByte(olcAlphabet, digits[i])
+		latLo += float64(v/4) * latRes
+		lonLo += float64(v%4) * lonRes
+	}
+	lat = math.Min(90, latLo+latRes/2)
+	lon = lonLo + lonRes/2
+	return lat, lon, nil
+}
+
+// 编码前 olcPairLen 位，只用于短码补全前缀
+func encodePlusCodePairs(lat, lon float64) string {
+	lat = math.Max(-90, math.Min(90, lat))
+	for lon < -180 {
+		lon += 360
+	}
+	for lon >= 180 {
+		lon -= 360
+	}
+	// 以最小格子（0.000125°）为单位做整数运算，避免浮点误差
+	latVal := int64(math.Floor((lat + 90) * 8000))
+	lonVal := int64(math.Floor((lon + 180) * 8000))
+	latVal = min(latVal, 180*8000-1)
+	b := make([]byte, olcPairLen)
+	for i := olcPairLen - 2; i >= 0; i -= 2 {
+		b[i] = olcAlphabet[latVal%20]
+		b[i+1] = olcAlphabet[lonVal%20]
+		latVal /= 20
+		lonVal /= 20
+	}
+	return string(b)
+}
+
+// 用参考点补全短码：取参考点最近的那个格子
+func recoverPlusCode(short string, refLat, refLon float64) (lat, lon float64, err error) {
+	short, sep, err := checkPlusCode(short)
+	if err != nil {
+		return 0, 0, err
+	}
+	if sep == olcSepPos {
+		return decodePlusCode(short)
+	}
+	padding := olcSepPos - sep
+	resolution := math.Pow(20, float64(2-padding/2))
+	half := resolution / 2
+
+	lat, lon, err = decodePlusCode(encodePlusCodePairs(refLat, refLon)[:padding] + short)
+	if err != nil {
+		return 0, 0, err
+	}
+	if refLat+half < lat && lat-resolution >= -90 {
+		lat -= resolution
+	} else if refLat-half > lat && lat+resolution <= 90 {
+		lat += resolution
+	}
+	if refLon+half < lon {
+		lon -= resolution
+	} else if refLon-half > lon {
+		lon += resolution
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	for lon >= 180 {
+		lon -= 360
+	}
+	return lat, lon, nil
+}
+
+// 解析 "6P58QRJ7+3W" 或带地名的短码 "QRJ7+3W Jakarta, Indonesia"；
+// 地名按从小到大、逗号分隔，通过名称搜索取其中心点作为参考点
+func (s *Server) resolvePlusCode(ctx context.Context, v string) (lat, lon float64, err error) {
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("invalid plus code")
+	}
+	// 查询串里未转义的 '+' 会被解成空格，这里还原
+	if !strings.ContainsRune(fields[0], olcSeparator) {
+		switch {
+		case len(fields) > 1 && isPlusCodeDigits(fields[1]):
+			fields = append([]string{fields[0] + string(olcSeparator) + fields[1]}, fields[2:]...)
+		case len(fields[0]) == olcSepPos:
+			fields[0] += string(olcSeparator)
+		}
+	}
+	code, locality := fields[0], strings.Join(fields[1:], " ")
+	if locality == "" {
+		return decodePlusCode(code)
+	}
+
+	parts := strings.Split(locality, ",")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	items, err := s.search(ctx, strings.Join(parts, "/"), nameLatin)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(items) == 0 {
+		return 0, 0, fmt.Errorf("locality not found: %s", locality)
+	}
+	ref, err := s.latlngOf(ctx, items[0].GID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return recoverPlusCode(code, ref.Latitude, ref.Longitude)
+}
+
+func isPlusCodeDigits(v string) bool {
+	v = strings.ToUpper(v)
+	for i := 0; i < len(v); i++ {
+		if strings.IndexByte(olcAlphabet, v[i]) < 0 {
+			return false
+		}
+	}
+	return v != ""
+}
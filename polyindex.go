@@ -0,0 +1,224 @@
+// polyindex.go
+package main
+
+import (
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// 带索引的点面判断：雅加达一带的多边形有几万个顶点，planar.MultiPolygonContains 每次都要
+// 逐环重算外接矩形再逐边射线求交。这里预先算好每个环的外接矩形，外接矩形不含该点的环直接跳过；
+// 顶点多的环再按 x 分带，每带记录 x 范围与之相交的边，射线（沿 y 方向）只需检查点所在的那一带。
+// 结果与 planar.MultiPolygonContains 完全相同（边界上的点算在外环内、洞内）
+const (
+	ringIndexMinPoints = 64
+	ringIndexBandEdges = 16 // 平均每带的边数
+)
+
+type ringIndex struct {
+	ring  orb.Ring
+	bound orb.Bound
+	// 分带：第 k 带为 [minX + k*width, minX + (k+1)*width]，带中为边的下标，
+	// 边 j 为 ring[j]→ring[j+1]，j == len(ring)-1 为首尾相连的边 ring[0]→ring[len-1]
+	width float64
+	bands [][]int32
+}
+
+type polygonIndex struct {
+	bound orb.Bound
+	rings []ringIndex // 第一个为外环，其余为洞
+}
+
+type shapeIndex struct {
+	bound orb.Bound
+	polys []polygonIndex
+}
+
+func newShapeIndex(mp orb.MultiPolygon) *shapeIndex {
+	idx := &shapeIndex{bound: mp.Bound(), polys: make([]polygonIndex, 0, len(mp))}
+	for _, p := range mp {
+		if len(p) == 0 || len(p[0]) == 0 {
+			continue
+		}
+		pi := polygonIndex{rings: make([]ringIndex, len(p))}
+		for i, r := range p {
+			pi.rings[i] = newRingIndex(r)
+		}
+		pi.bound = pi.rings[0].bound
+		idx.polys = append(idx.polys, pi)
+	}
+	return idx
+}
+
+func newRingIndex(r orb.Ring) ringIndex {
+	ri := ringIndex{ring: r, bound: r.Bound()}
+	if len(r) < ringIndexMinPoints || ri.bound.Max[0] <= ri.bound.Min[0] {
+		return ri
+	}
+	n := len(r) / ringIndexBandEdges
+	ri.width = (ri.bound.Max[0] - ri.bound.Min[0]) / float64(n)
+	ri.bands = make([][]int32, n)
+	add := func(j int, s, e orb.Point) {
+		lo, hi := ri.band(math.Min(s[0], e[0])), ri.band(math.Max(s[0], e[0]))
+		for k := lo; k <= hi; k++ {
+			ri.bands[k] = append(ri.bands[k], int32(j))
+		}
+	}
+	for j := 0; j < len(r)-1; j++ {
+		add(j, r[j], r[j+1])
+	}
+	add(len(r)-1, r[0], r[len(r)-1])
+	return ri
+}
+
+func (ri *ringIndex) band(x float64) int {
+	k := int((x - ri.bound.Min[0]) / ri.width)
+	return max(0, min(k, len(ri.bands)-1))
+}
+
+func (ri *ringIndex) contains(pt orb.Point) bool {
+	if !ri.bound.Contains(pt) {
+		return false
+	}
+	if ri.bands == nil {
+		return planar.RingContains(ri.ring, pt)
+	}
+	c := false
+	for _, j := range ri.bands[ri.band(pt[0])] {
+		s, e := ri.edge(j)
+		inter, on := rayIntersect(pt, s, e)
+		if on {
+			return true
+		}
+		if inter {
+			c = !c
+		}
+	}
+	return c
+}
+
+func (ri *ringIndex) edge(j int32) (orb.Point, orb.Point) {
+	r := ri.ring
+	if int(j) < len(r)-1 {
+		return r[j], r[j+1]
+	}
+	return r[0], r[len(r)-1]
+}
+
+// 是否有边与该点的距离不超过 d，用于两段判断的容差带（见 coarse.go）
+func (ri *ringIndex) near(pt orb.Point, d float64) bool {
+	if !ri.bound.Pad(d).Contains(pt) {
+		return false
+	}
+	d2 := d * d
+	if ri.bands == nil {
+		r := ri.ring
+		for j := range r {
+			if planar.DistanceFromSegmentSquared(r[j], r[(j+1)%len(r)], pt) <= d2 {
+				return true
+			}
+		}
+		return false
+	}
+	for k := ri.band(pt[0] - d); k <= ri.band(pt[0]+d); k++ {
+		for _, j := range ri.bands[k] {
+			if s, e := ri.edge(j); planar.DistanceFromSegmentSquared(s, e, pt) <= d2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (idx *shapeIndex) contains(pt orb.Point) bool {
+	if !idx.bound.Contains(pt) {
+		return false
+	}
+polys:
+	for i := range idx.polys {
+		p := &idx.polys[i]
+		if !p.bound.Contains(pt) || !p.rings[0].contains(pt) {
+			continue
+		}
+		for h := 1; h < len(p.rings); h++ {
+			if p.rings[h].contains(pt) {
+				continue polys
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (idx *shapeIndex) near(pt orb.Point, d float64) bool {
+	if !idx.bound.Pad(d).Contains(pt) {
+		return false
+	}
+	for i := range idx.polys {
+		p := &idx.polys[i]
+		if !p.bound.Pad(d).Contains(pt) {
+			continue
+		}
+		for h := range p.rings {
+			if p.rings[h].near(pt, d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 与 planar 中未导出的 rayIntersect 相同：从 p 沿 y 方向的射线与线段 s→e 是否相交，p 是否在线段上
+func rayIntersect(p, s, e orb.Point) (intersects, on bool) {
+	if s[0] > e[0] {
+		s, e = e, s
+	}
+
+	if p[0] == s[0] {
+		if p[1] == s[1] {
+			return false, true
+		} else if s[0] == e[0] {
+			// 竖直的线段
+			if s[1] > e[1] && s[1] >= p[1] && p[1] >= e[1] {
+				return false, true
+			}
+			if e[1] > s[1] && e[1] >= p[1] && p[1] >= s[1] {
+				return false, true
+			}
+		}
+		// 退化情形：把 p 稍微右移
+		p[0] = math.Nextafter(p[0], math.Inf(1))
+	} else if p[0] == e[0] {
+		if p[1] == e[1] {
+			return false, true
+		}
+		p[0] = math.Nextafter(p[0], math.Inf(1))
+	}
+
+	if p[0] < s[0] || p[0] > e[0] {
+		return false, false
+	}
+
+	if s[1] > e[1] {
+		if p[1] > s[1] {
+			return false, false
+		} else if p[1] < e[1] {
+			return true, false
+		}
+	} else {
+		if p[1] > e[1] {
+			return false, false
+		} else if p[1] < s[1] {
+			return true, false
+		}
+	}
+
+	rs := (p[1] - s[1]) / (p[0] - s[0])
+	ds := (e[1] - s[1]) / (e[0] - s[0])
+	if rs == ds {
+		return false, true
+	}
+	return rs <= ds, false
+}
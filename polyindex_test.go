@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+// 带湖、带飞地的凹多边形：锯齿状的外环，中间一个星形的洞，外加一个小岛
+func jaggedShape(rnd *rand.Rand) orb.MultiPolygon {
+	var outer orb.Ring
+	for i := 0; i < 400; i++ {
+		a := 2 * math.Pi * float64(i) / 400
+		r := 1 + 0.3*rnd.Float64()
+		if i%7 == 0 {
+			r = 0.5
+		}
+		outer = append(outer, orb.Point{r * math.Cos(a), r * math.Sin(a)})
+	}
+	var hole orb.Ring
+	for i := 0; i < 40; i++ {
+		a := -2 * math.Pi * float64(i) / 40
+		r := 0.2
+		if i%2 == 0 {
+			r = 0.4
+		}
+		hole = append(hole, orb.Point{r * math.Cos(a), r * math.Sin(a)})
+	}
+	island := orb.Ring{{1.6, 1.6}, {1.9, 1.6}, {1.9, 1.9}, {1.6, 1.9}}
+	for _, r := range []*orb.Ring{&outer, &hole, &island} {
+		*r = append(*r, (*r)[0])
+	}
+	return orb.MultiPolygon{{outer, hole}, {island}}
+}
+
+func TestShapeIndexContains(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	shapes := []orb.MultiPolygon{jaggedShape(rnd)}
+	fc, err := geojson.UnmarshalFeatureCollection(embeddedGeoJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fc.Features {
+		shapes = append(shapes, polygonsOf(f.Geometry))
+	}
+	for _, mp := range shapes {
+		idx := newShapeIndex(mp)
+		b := mp.Bound().Pad(0.1)
+		for i := 0; i < 20000; i++ {
+			pt := orb.Point{b.Min[0] + rnd.Float64()*(b.Max[0]-b.Min[0]), b.Min[1] + rnd.Float64()*(b.Max[1]-b.Min[1])}
+			if got, want := idx.contains(pt), planar.MultiPolygonContains(mp, pt); got != want {
+				t.Fatalf("%v: shapeIndex %v, planar %v", pt, got, want)
+			}
+		}
+	}
+}
+
+// 两段判断的前提（见 coarse.go）：离简化边界超过容差的点，简化前后的判断相同
+func TestCoarseBand(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	mp := jaggedShape(rnd)
+	const tolerance = 0.05
+	coarse, _ := coarseSimplify(mp, tolerance)
+	orig, simple := newShapeIndex(mp), newShapeIndex(coarse)
+	outside := 0
+	for i := 0; i < 50000; i++ {
+		pt := orb.Point{rnd.Float64()*4 - 2, rnd.Float64()*4 - 2}
+		if simple.near(pt, tolerance*1.001) {
+			continue
+		}
+		outside++
+		if got, want := simple.contains(pt), orig.contains(pt); got != want {
+			t.Fatalf("%v outside the band: coarse %v, original %v", pt, got, want)
+		}
+	}
+	if outside == 0 {
+		t.Fatal("no points outside the band")
+	}
+	// 容差带确实覆盖了简化改变结论的点
+	changed := 0
+	for i := 0; i < 50000; i++ {
+		pt := orb.Point{rnd.Float64()*4 - 2, rnd.Float64()*4 - 2}
+		if simple.contains(pt) != orig.contains(pt) {
+			changed++
+			if !simple.near(pt, tolerance*1.001) {
+				t.Fatalf("%v: conclusion changed outside the band", pt)
+			}
+		}
+	}
+	if changed == 0 {
+		t.Fatal("simplification changed nothing, tolerance too small for the test")
+	}
+}
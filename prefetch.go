@@ -0,0 +1,90 @@
+// prefetch.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// 后台预取海拔：ELEVATION_PREFETCH=1 时服务启动后在后台遍历所有行政区，按 warm -elevation 的方式
+// 把缓存中还没有的海拔按批查好，之后 /latlng 都直接读缓存，不用等外部 HTTP 请求（预取完成前照旧当场查询）。
+//
+//	ELEVATION_PREFETCH_INTERVAL  完成后隔多久再跑一遍（如 24h，补上热更新后新增的行政区），默认 0 只在启动时跑一次
+//	ELEVATION_PREFETCH_RATE      每秒最多请求海拔来源几次，谷歌默认 5，本地 DEM 默认 0 不限
+//	ELEVATION_PREFETCH_BATCH     每次请求的点数（默认 100，最多 512）
+//
+// 每批查到后即写入缓存；出错（如超出配额、质心还没算好）时 1 分钟后重试，之后间隔加倍、最长 30 分钟，
+// 重试时从缺的开始。每一遍用开始时的数据集版本，热更新后旧版本等这一遍跑完才关闭
+type prefetchConfig struct {
+	interval time.Duration
+	// 两次请求的最小间隔
+	every time.Duration
+	batch int
+}
+
+// 未开启时返回 nil
+func loadPrefetchConfig() (*prefetchConfig, error) {
+	if env("ELEVATION_PREFETCH", "") != "1" {
+		return nil, nil
+	}
+	src, err := loadElevationSource()
+	if err != nil {
+		return nil, err
+	}
+	defaultRate := "5"
+	if g, ok := src.(googleElevation); ok {
+		if g.apiKey == "" {
+			return nil, fmt.Errorf("ELEVATION_PREFETCH needs GOOGLE_API_KEY or ELEVATION_PROVIDER=dem")
+		}
+	} else {
+		defaultRate = "0"
+	}
+
+	var cfg prefetchConfig
+	if cfg.interval, err = time.ParseDuration(env("ELEVATION_PREFETCH_INTERVAL", "0")); err != nil || cfg.interval < 0 {
+		return nil, fmt.Errorf("invalid ELEVATION_PREFETCH_INTERVAL")
+	}
+	rate, err := strconv.ParseFloat(env("ELEVATION_PREFETCH_RATE", defaultRate), 64)
+	if err != nil || rate < 0 {
+		return nil, fmt.Errorf("invalid ELEVATION_PREFETCH_RATE")
+	}
+	if rate > 0 {
+		cfg.every = time.Duration(float64(time.Second) / rate)
+	}
+	if cfg.batch, err = strconv.Atoi(env("ELEVATION_PREFETCH_BATCH", "100")); err != nil || cfg.batch < 1 || cfg.batch > maxElevationBatch {
+		return nil, fmt.Errorf("invalid ELEVATION_PREFETCH_BATCH, use 1..%d", maxElevationBatch)
+	}
+	return &cfg, nil
+}
+
+func (rl *reloader) prefetchElevations(cfg prefetchConfig) {
+	retry := time.Minute
+	for {
+		if err := rl.prefetchOnce(cfg); err != nil {
+			log.Printf("elevation prefetch error: %v, retry in %s", err, retry)
+			time.Sleep(retry)
+			retry = min(retry*2, 30*time.Minute)
+			continue
+		}
+		if cfg.interval <= 0 {
+			return
+		}
+		retry = time.Minute
+		time.Sleep(cfg.interval)
+	}
+}
+
+func (rl *reloader) prefetchOnce(cfg prefetchConfig) error {
+	g := rl.acquire()
+	defer g.release()
+	start := time.Now()
+	for _, s := range g.servers {
+		if err := s.warmElevations(cfg.batch, cfg.every); err != nil {
+			return fmt.Errorf("%s: %w", s.path, err)
+		}
+	}
+	log.Printf("elevation prefetch: %d datasets (%s) in %s", len(g.servers), g.version, time.Since(start).Round(time.Millisecond))
+	return nil
+}
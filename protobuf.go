@@ -0,0 +1,202 @@
+// protobuf.go
+package main
+
+import (
+	"math"
+
+	"github.com/paulmach/orb/encoding/wkb"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 可按 proto/gpkg_reverse.proto 编码的响应；其他响应协商到 protobuf 时退回 JSON
+type protoMessage interface {
+	appendProto(b []byte) []byte
+}
+
+// proto3 语义：零值字段不写出
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendProtoInt(b []byte, num protowire.Number, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(v)))
+}
+
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendProtoDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendProtoPackedDoubles(b []byte, num protowire.Number, vs []float64) []byte {
+	if len(vs) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(8*len(vs)))
+	for _, v := range vs {
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// 子消息即使为空也写出，用于区分 data 为 null
+func appendProtoMessage(b []byte, num protowire.Number, m protoMessage) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m.appendProto(nil))
+}
+
+func (t *TimezoneInfo) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, t.ID)
+	b = appendProtoString(b, 2, t.Abbreviation)
+	b = appendProtoString(b, 3, t.UTCOffset)
+	return appendProtoInt(b, 4, t.OffsetSeconds)
+}
+
+func (c ChildrenItem) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, c.GID)
+	b = appendProtoString(b, 2, c.Name)
+	b = appendProtoString(b, 3, c.ParentCode)
+	b = appendProtoString(b, 4, c.Level)
+	// map<string, string>：每项一个 key = 1、value = 2 的子消息
+	for _, k := range sortedAttributeKeys(c.Attributes) {
+		entry := appendProtoString(nil, 1, k)
+		entry = appendProtoString(entry, 2, attributeText(c.Attributes[k]))
+		b = appendProtoBytes(b, 5, entry)
+	}
+	return appendProtoBool(b, 6, c.Overridden)
+}
+
+func (d LevelDetail) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, d.GID)
+	b = appendProtoString(b, 2, d.Name)
+	b = appendProtoString(b, 3, d.ParentCode)
+	b = appendProtoString(b, 4, d.Level)
+	b = appendProtoInt(b, 5, d.Depth)
+	b = appendProtoDouble(b, 6, d.Latitude)
+	b = appendProtoDouble(b, 7, d.Longitude)
+	return appendProtoPackedDoubles(b, 8, d.BBox[:])
+}
+
+func (a *AdminLevels) appendProto(b []byte) []byte {
+	codes := []string{a.GID0, a.GID1, a.GID2, a.GID3, a.GID4, a.GID5}
+	names := []string{a.Name0, a.Name1, a.Name2, a.Name3, a.Name4, a.Name5}
+	for i, v := range codes {
+		b = appendProtoString(b, protowire.Number(1+i), v)
+	}
+	for i, v := range names {
+		b = appendProtoString(b, protowire.Number(7+i), v)
+	}
+	for _, item := range a.List {
+		b = appendProtoMessage(b, 13, item)
+	}
+	b = appendProtoDouble(b, 14, a.DistanceM)
+	if a.Timezone != nil {
+		b = appendProtoMessage(b, 15, a.Timezone)
+	}
+	for _, d := range a.Levels {
+		b = appendProtoMessage(b, 16, d)
+	}
+	if a.Geometry != nil {
+		if g, err := wkb.Marshal(a.Geometry.Geom); err == nil {
+			b = appendProtoBytes(b, 17, g)
+		}
+	}
+	for _, h := range a.Overlays {
+		b = appendProtoMessage(b, 18, h)
+	}
+	b = appendProtoBool(b, 19, a.Overridden)
+	return appendProtoBool(b, 20, a.Disputed)
+}
+
+func (h LayerHit) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, h.Layer)
+	b = appendProtoBool(b, 2, h.Found)
+	for _, item := range h.List {
+		b = appendProtoMessage(b, 3, item)
+	}
+	return b
+}
+
+func (l *ChildrenItemList) appendProto(b []byte) []byte {
+	for _, item := range l.List {
+		b = appendProtoMessage(b, 1, item)
+	}
+	b = appendProtoInt(b, 2, l.Total)
+	b = appendProtoInt(b, 3, l.Limit)
+	return appendProtoInt(b, 4, l.Offset)
+}
+
+func (l *LatlngItem) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, l.GID)
+	b = appendProtoDouble(b, 2, l.Latitude)
+	b = appendProtoDouble(b, 3, l.Longitude)
+	b = appendProtoString(b, 4, l.Name)
+	b = appendProtoString(b, 5, l.ParentCode)
+	b = appendProtoString(b, 6, l.Level)
+	b = appendProtoDouble(b, 7, l.Elevation)
+	b = appendProtoString(b, 8, l.Geohash)
+	if l.Timezone != nil {
+		b = appendProtoMessage(b, 9, l.Timezone)
+	}
+	if l.Pole != nil {
+		b = appendProtoMessage(b, 10, l.Pole)
+	}
+	return b
+}
+
+func appendProtoEnvelope(b []byte, code int, msg string, data protoMessage) []byte {
+	b = appendProtoInt(b, 1, code)
+	b = appendProtoString(b, 2, msg)
+	if data != nil {
+		b = appendProtoMessage(b, 3, data)
+	}
+	return b
+}
+
+func (r AdminLevelsRes) appendProto(b []byte) []byte {
+	if r.Data == nil {
+		return appendProtoEnvelope(b, r.Code, r.Msg, nil)
+	}
+	return appendProtoEnvelope(b, r.Code, r.Msg, r.Data)
+}
+
+func (r ChildrenRes) appendProto(b []byte) []byte {
+	if r.Data == nil {
+		return appendProtoEnvelope(b, r.Code, r.Msg, nil)
+	}
+	return appendProtoEnvelope(b, r.Code, r.Msg, r.Data)
+}
+
+func (r LatlngRes) appendProto(b []byte) []byte {
+	if r.Data == nil {
+		return appendProtoEnvelope(b, r.Code, r.Msg, nil)
+	}
+	return appendProtoEnvelope(b, r.Code, r.Msg, r.Data)
+}
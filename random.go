@@ -0,0 +1,119 @@
+// random.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+)
+
+const maxRandomPoints = 10000
+
+type RandomPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type RandomResult struct {
+	GID    string        `json:"code"`
+	Seed   int64         `json:"seed"`
+	Points []RandomPoint `json:"points"`
+}
+
+type RandomRes struct {
+	Code int           `json:"code"`
+	Msg  string        `json:"msg"`
+	Data *RandomResult `json:"data"`
+}
+
+/************* 行政区内随机取点 *************/
+// 先按面积选多边形，再在其外接矩形内拒绝采样；纬度按 sin 均匀取值，保证按球面面积均匀
+func randomPoints(mp orb.MultiPolygon, n int, rng *rand.Rand) ([]RandomPoint, error) {
+	weights := make([]float64, len(mp))
+	total := 0.0
+	for i, poly := range mp {
+		total += geo.Area(poly)
+		weights[i] = total
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("area has no extent")
+	}
+
+	out := make([]RandomPoint, 0, n)
+	for attempts := 0; len(out) < n; attempts++ {
+		if attempts > n*1000 {
+			return nil, fmt.Errorf("sampling gave up after %d attempts", attempts)
+		}
+		w := rng.Float64() * total
+		i := 0
+		for i < len(weights)-1 && weights[i] < w {
+			i++
+		}
+		poly := mp[i]
+		b := poly.Bound()
+		lo, hi := math.Sin(deg2rad(b.Min.Lat())), math.Sin(deg2rad(b.Max.Lat()))
+		lat := math.Asin(lo+rng.Float64()*(hi-lo)) * 180 / math.Pi
+		lon := b.Min.Lon() + rng.Float64()*(b.Max.Lon()-b.Min.Lon())
+		if !planar.PolygonContains(poly, orb.Point{lon, lat}) {
+			continue
+		}
+		out = append(out, RandomPoint{
+			Latitude:  math.Round(lat*1e6) / 1e6,
+			Longitude: math.Round(lon*1e6) / 1e6,
+		})
+	}
+	return out, nil
+}
+
+func (s *Server) handleRandom(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	code := strings.TrimSpace(q.Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	n := queryInt(r, "n", 10, 1, maxRandomPoints)
+	// 指定 seed 时结果可复现
+	seed := time.Now().UnixNano()
+	if v := strings.TrimSpace(q.Get("seed")); v != "" {
+		var err error
+		if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeErrorJSON(w, http.StatusBadRequest, 400, "invalid seed")
+			return
+		}
+	}
+
+	shape, err := s.shapeOf(r.Context(), code)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "random", err)
+		return
+	}
+	points, err := randomPoints(shape.Geom, n, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		log.Println("random error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, RandomRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &RandomResult{
+			GID:    shape.Item.GID,
+			Seed:   seed,
+			Points: points,
+		},
+	})
+}
@@ -0,0 +1,538 @@
+// registry.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+)
+
+// GPKG_DIR：一个目录下放多个分国家的 GeoPackage，只部署需要的地区。
+// 每个文件打开为一个独立的 Server，请求按国家分发：
+// 带 code / parent_code / gid 的按代码前缀，带坐标的先用各国外接矩形粗筛再做点面判断
+
+type countryDataset struct {
+	codes []string // 文件中的 GID_0
+	path  string
+	bound orb.Bound
+	srv   *Server
+}
+
+type registry struct {
+	byCode  map[string]*countryDataset
+	list    []*countryDataset
+	primary *countryDataset
+}
+
+// 热更新时也会重新列出目录，新增的文件随之加载
+func registryDataset(dir string, elevationDB *sql.DB, jobs *jobStore) (files func() ([]string, error), open func() ([]*Server, []apiRoute, error)) {
+	files = func() ([]string, error) {
+		return gpkgFiles(dir)
+	}
+	open = func() ([]*Server, []apiRoute, error) {
+		reg, err := openRegistry(dir, elevationDB, jobs)
+		if err != nil {
+			return nil, nil, err
+		}
+		servers := make([]*Server, 0, len(reg.list))
+		for _, d := range reg.list {
+			servers = append(servers, d.srv)
+		}
+		return servers, reg.routes(), nil
+	}
+	return files, open
+}
+
+func gpkgFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.gpkg"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .gpkg files in %s", dir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func openRegistry(dir string, elevationDB *sql.DB, jobs *jobStore) (_ *registry, err error) {
+	files, err := gpkgFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	table, geomCol := env("GPKG_TABLE", "gadm_410"), env("GPKG_GEOM_COL", "geom")
+	cacheDir := env("GPKG_DIR_CACHE", filepath.Join(dir, ".cache"))
+
+	reg := &registry{byCode: make(map[string]*countryDataset)}
+	defer func() {
+		if err != nil {
+			reg.close()
+		}
+	}()
+	for _, file := range files {
+		cfg, err := prepareCountryFile(file, table, geomCol, cacheDir)
+		if err != nil {
+			return nil, err
+		}
+		base := strings.TrimSuffix(filepath.Base(file), ".gpkg")
+		cfg.spatialitePath = filepath.Join(cacheDir, base+".spatialite")
+		cfg.centroidsPath = filepath.Join(cacheDir, base+".centroids.sqlite")
+		srv, err := openServer(cfg, elevationDB, jobs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		d := &countryDataset{path: file, srv: srv}
+		reg.list = append(reg.list, d)
+		if d.codes, d.bound, err = srv.countryExtent(); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, code := range d.codes {
+			if prev := reg.byCode[code]; prev != nil {
+				return nil, fmt.Errorf("country %s is in both %s and %s", code, prev.path, file)
+			}
+			reg.byCode[code] = d
+		}
+		log.Printf("dataset %s: %s", strings.Join(d.codes, ","), file)
+	}
+
+	reg.primary = reg.list[0]
+	if code := env("GPKG_DEFAULT_COUNTRY", ""); code != "" {
+		if reg.primary = reg.byCode[strings.ToUpper(code)]; reg.primary == nil {
+			return nil, fmt.Errorf("GPKG_DEFAULT_COUNTRY %s not found in %s", code, dir)
+		}
+	}
+	return reg, nil
+}
+
+func (reg *registry) close() {
+	for _, d := range reg.list {
+		d.srv.close()
+	}
+}
+
+// 文件中的国家代码和全部多边形的外接矩形（来自 r-tree）
+func (s *Server) countryExtent() ([]string, orb.Bound, error) {
+	var bound orb.Bound
+	err := s.db.QueryRow(fmt.Sprintf(`SELECT MIN(minx), MIN(miny), MAX(maxx), MAX(maxy) FROM %s;`, s.rtreeTable)).
+		Scan(&bound.Min[0], &bound.Min[1], &bound.Max[0], &bound.Max[1])
+	if err != nil {
+		return nil, bound, err
+	}
+	codes, err := s.distinctGIDs(context.Background(), fmt.Sprintf(`SELECT DISTINCT GID_0 FROM %s WHERE GID_0 <> '' ORDER BY GID_0;`, s.table))
+	if err != nil {
+		return nil, bound, err
+	}
+	if len(codes) == 0 {
+		return nil, bound, fmt.Errorf("no GID_0 in %s", s.table)
+	}
+	return codes, bound, nil
+}
+
+/************* GADM 分层文件 *************/
+
+// 分国家下载为 ADM_ADM_0..ADM_ADM_N，全球分层下载（gadm_410-levels.gpkg）为 ADM_0..ADM_5
+var admLayerRe = regexp.MustCompile(`^(?:ADM_)?ADM_(\d)$`)
+
+// 一个分层表
+type admLayer struct {
+	name, geomCol string
+	level         int
+}
+
+// 文件中的分层表，按层级从浅到深；同一层两种命名都有时取先找到的
+func admLayers(db *sql.DB) ([]admLayer, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'ADM_%' ORDER BY name;`)
+	if err != nil {
+		return nil, err
+	}
+	byLevel := make(map[int]admLayer)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if m := admLayerRe.FindStringSubmatch(name); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			if _, ok := byLevel[n]; !ok && n <= 5 {
+				byLevel[n] = admLayer{name: name, level: n}
+			}
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	layers := make([]admLayer, 0, len(byLevel))
+	for _, l := range byLevel {
+		l.geomCol = "geom"
+		_ = db.QueryRow(`SELECT column_name FROM gpkg_geometry_columns WHERE table_name = ?;`, l.name).Scan(&l.geomCol)
+		layers = append(layers, l)
+	}
+	sort.Slice(layers, func(i, j int) bool { return layers[i].level < layers[j].level })
+	return layers, nil
+}
+
+// 含 table 的文件直接使用；GADM 每层一张表的文件（分国家或全球分层下载）合并成与 gadm_410 相同的单表：
+// 每层只取没有下级的行，各行政区的最末级都在其中，补齐 GID_x / NAME_x 到第 5 层并建 r-tree，缓存到 cacheDir
+func prepareCountryFile(path, table, geomCol, cacheDir string) (datasetConfig, error) {
+	cfg := datasetConfig{path: path, kind: "gadm", source: path, table: table, geomCol: geomCol}
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&immutable=1", path))
+	if err != nil {
+		return cfg, err
+	}
+	defer db.Close()
+	if cols, err := tableColumns(db, table); err == nil && cols["GID_0"] {
+		return cfg, nil
+	}
+	layers, err := admLayers(db)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(layers) == 0 {
+		return cfg, fmt.Errorf("%s: neither table %s nor ADM_n / ADM_ADM_n layers found", path, table)
+	}
+
+	out := filepath.Join(cacheDir, strings.TrimSuffix(filepath.Base(path), ".gpkg")+".sqlite")
+	srcInfo, err := os.Stat(path)
+	if err != nil {
+		return cfg, err
+	}
+	if st, err := os.Stat(out); err != nil || st.ModTime().Before(srcInfo.ModTime()) {
+		start := time.Now()
+		if err := normalizeGADMLayers(path, layers, out, table, geomCol); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+		log.Printf("dataset: normalized %s (%d layers) into %s in %s", path, len(layers), out, time.Since(start).Round(time.Millisecond))
+	}
+	cfg.path = out
+	return cfg, nil
+}
+
+// 先写临时文件再改名，失败时不留下半个缓存
+func normalizeGADMLayers(path string, layers []admLayer, out, table, geomCol string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	tmp := out + ".tmp"
+	os.Remove(tmp)
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	db, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// ATTACH 和临时表只对当前连接有效
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`ATTACH DATABASE ? AS src;`, fmt.Sprintf("file:%s?mode=ro&immutable=1", path)); err != nil {
+		return err
+	}
+
+	// 各层的列不同（上层没有下层的 GID / NAME），合表取并集；GID_x / NAME_x 统一为非空文本，
+	// 浅层的行在深层的列上为空串
+	levelCol := make(map[string]bool)
+	var defs []string
+	for lvl := 0; lvl <= 5; lvl++ {
+		for _, col := range []string{fmt.Sprintf("GID_%d", lvl), fmt.Sprintf("NAME_%d", lvl)} {
+			levelCol[`"`+col+`"`] = true
+			defs = append(defs, fmt.Sprintf(`"%s" TEXT NOT NULL DEFAULT ''`, col))
+		}
+	}
+	layerCols := make([][]string, len(layers))
+	seen := make(map[string]bool)
+	for i, l := range layers {
+		ldefs, cols, _, err := sourceColumns(db, "src", l.name, l.geomCol)
+		if err != nil {
+			return err
+		}
+		layerCols[i] = cols
+		for j, col := range cols {
+			key := strings.ToUpper(col)
+			if !seen[key] && !levelCol[key] {
+				defs = append(defs, ldefs[j])
+			}
+			seen[key] = true
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (fid INTEGER PRIMARY KEY, %s, "%s" BLOB);`, table, strings.Join(defs, ", "), geomCol)); err != nil {
+		return err
+	}
+
+	// 从深到浅，每层跳过下一层中有下级的行政区
+	for i := len(layers) - 1; i >= 0; i-- {
+		l := layers[i]
+		where := ""
+		if i < len(layers)-1 {
+			stmts := []string{
+				`DROP TABLE IF EXISTS temp.parents;`,
+				`CREATE TEMP TABLE parents (gid TEXT PRIMARY KEY) WITHOUT ROWID;`,
+				fmt.Sprintf(`INSERT OR IGNORE INTO temp.parents SELECT GID_%d FROM src."%s" WHERE GID_%d IS NOT NULL;`, l.level, layers[i+1].name, l.level),
+			}
+			for _, stmt := range stmts {
+				if _, err := db.Exec(stmt); err != nil {
+					return fmt.Errorf("%s: %w", l.name, err)
+				}
+			}
+			where = fmt.Sprintf(` WHERE GID_%d NOT IN (SELECT gid FROM temp.parents)`, l.level)
+		}
+		exprs := make([]string, len(layerCols[i]))
+		for j, col := range layerCols[i] {
+			exprs[j] = col
+			if levelCol[strings.ToUpper(col)] {
+				exprs[j] = fmt.Sprintf(`COALESCE(%s, '')`, col)
+			}
+		}
+		res, err := db.Exec(fmt.Sprintf(`INSERT INTO "%s" (%s, "%s") SELECT %s, "%s" FROM src."%s"%s;`,
+			table, strings.Join(layerCols[i], ", "), geomCol, strings.Join(exprs, ", "), l.geomCol, l.name, where))
+		if err != nil {
+			return fmt.Errorf("%s: %w", l.name, err)
+		}
+		n, _ := res.RowsAffected()
+		log.Printf("dataset: %s: %d leaves", l.name, n)
+	}
+	// GADM 的分层表中国家名称列为 COUNTRY，没有 NAME_0
+	if seen[`"COUNTRY"`] {
+		if _, err := db.Exec(fmt.Sprintf(`UPDATE "%s" SET NAME_0 = COALESCE(COUNTRY, '') WHERE NAME_0 = '';`, table)); err != nil {
+			return err
+		}
+	}
+	if err := buildRTree(db, table, geomCol); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DETACH DATABASE src;`); err != nil {
+		return err
+	}
+	db.Close()
+	return os.Rename(tmp, out)
+}
+
+// 源表除主键和几何列以外的列定义和列名，以及主键列（没有时为 rowid）；
+// 其余代码按 GID_0..GID_5 / NAME_0..NAME_5 查询，缺的层在 defs 中补空串列
+func sourceColumns(db *sql.DB, schema, table, geomCol string) (defs, cols []string, pk string, err error) {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA %s.table_info("%s");`, schema, table))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer rows.Close()
+	pk = "rowid"
+	have := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notnull, isPK int
+			name, typ          string
+			dflt               sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notnull, &dflt, &isPK); err != nil {
+			return nil, nil, "", err
+		}
+		switch {
+		case isPK == 1:
+			pk = name
+		case name == geomCol:
+		default:
+			defs = append(defs, fmt.Sprintf(`"%s" %s`, name, typ))
+			cols = append(cols, fmt.Sprintf(`"%s"`, name))
+			have[strings.ToUpper(name)] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, "", err
+	}
+	if len(have) == 0 {
+		return nil, nil, "", fmt.Errorf("table %s not found", table)
+	}
+	for lvl := 0; lvl <= 5; lvl++ {
+		for _, col := range []string{fmt.Sprintf("GID_%d", lvl), fmt.Sprintf("NAME_%d", lvl)} {
+			if !have[col] {
+				defs = append(defs, fmt.Sprintf(`"%s" TEXT NOT NULL DEFAULT ''`, col))
+			}
+		}
+	}
+	return defs, cols, pk, nil
+}
+
+/************* 分发 *************/
+
+// code 形如 IDN.8_1（GID）、ID.JB（HASC）或 ID-JB（ISO 3166-2），取前缀定位国家
+func (reg *registry) byGID(code string) *countryDataset {
+	prefix := strings.ToUpper(strings.TrimSpace(code))
+	if i := strings.IndexAny(prefix, ".-_"); i >= 0 {
+		prefix = prefix[:i]
+	}
+	if d := reg.byCode[prefix]; d != nil {
+		return d
+	}
+	if len(prefix) == 2 {
+		if tables, err := loadISOTables(); err == nil {
+			if c := tables.byAlpha2[prefix]; c != nil {
+				return reg.byCode[c.Alpha3]
+			}
+		}
+	}
+	return nil
+}
+
+// 外接矩形包含该点的国家中，真正包含该点的那个；都不包含时取外接矩形最近的，以便最近行政区兜底
+func (reg *registry) at(lon, lat float64) *countryDataset {
+	pt := orb.Point{lon, lat}
+	var candidates []*countryDataset
+	for _, d := range reg.list {
+		if d.bound.Contains(pt) {
+			candidates = append(candidates, d)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	for _, d := range candidates {
+		if _, err := d.srv.reverse(context.Background(), lon, lat, nameLatin); err == nil {
+			return d
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0]
+	}
+	best, bestDist := reg.primary, math.Inf(1)
+	for _, d := range reg.list {
+		dx := math.Max(0, math.Max(d.bound.Min[0]-lon, lon-d.bound.Max[0]))
+		dy := math.Max(0, math.Max(d.bound.Min[1]-lat, lat-d.bound.Max[1]))
+		if dist := dx*dx + dy*dy; dist < bestDist {
+			best, bestDist = d, dist
+		}
+	}
+	return best
+}
+
+// 按请求参数选择数据集；无法判断的（如 /search、POST 接口）交给默认国家
+func (reg *registry) pick(r *http.Request) *countryDataset {
+	q := r.URL.Query()
+	if c := q.Get("country"); c != "" {
+		if d := reg.byGID(c); d != nil {
+			return d
+		}
+	}
+	for _, name := range []string{"code", "parent_code", "gid"} {
+		if v := q.Get(name); v != "" {
+			if d := reg.byGID(v); d != nil {
+				return d
+			}
+		}
+	}
+	if lat, lon, err := parseLatLon(r); err == nil {
+		return reg.at(lon, lat)
+	}
+	// Nominatim 兼容接口的 lat / lon
+	lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
+	lon, err2 := strconv.ParseFloat(q.Get("lon"), 64)
+	if err1 == nil && err2 == nil {
+		return reg.at(lon, lat)
+	}
+	if v := q.Get("bbox"); v != "" {
+		if b, err := parseBBox(v); err == nil {
+			c := b.Center()
+			return reg.at(c[0], c[1])
+		}
+	}
+	if z, err := strconv.Atoi(r.PathValue("z")); err == nil {
+		x, errX := strconv.Atoi(r.PathValue("x"))
+		y, errY := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(r.PathValue("y"), ".pbf"), ".mvt"))
+		if errX == nil && errY == nil && z >= 0 && z <= 30 {
+			c := maptile.New(uint32(x), uint32(y), maptile.Zoom(z)).Bound().Center()
+			return reg.at(c[0], c[1])
+		}
+	}
+	if parent := q.Get("parent_code"); parent == "" && strings.HasSuffix(r.URL.Path, "/children") {
+		if d := reg.byGID(env("GPKG_PARENT_CODE", "IDN")); d != nil {
+			return d
+		}
+	}
+	return reg.primary
+}
+
+// 以默认国家的接口表为准，每个接口按请求分发到对应国家的同一接口
+func (reg *registry) routes() []apiRoute {
+	routes := reg.primary.srv.apiRoutes()
+	handlers := make(map[*countryDataset][]apiRoute, len(reg.list))
+	for _, d := range reg.list {
+		handlers[d] = d.srv.apiRoutes()
+	}
+	for i := range routes {
+		i := i
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			handlers[reg.pick(r)][i].Handler(w, r)
+		}
+	}
+	return routes
+}
+
+// 建 rtree_<table>_<geomCol>，外接矩形由解码后的几何计算
+func buildRTree(db *sql.DB, table, geomCol string) error {
+	rtree := fmt.Sprintf("rtree_%s_%s", table, geomCol)
+	if _, err := db.Exec(fmt.Sprintf(`CREATE VIRTUAL TABLE "%s" USING rtree(id, minx, maxx, miny, maxy);`, rtree)); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ins, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" VALUES (?, ?, ?, ?, ?);`, rtree))
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+	geoms, err := tx.Query(fmt.Sprintf(`SELECT fid, "%s" FROM "%s" WHERE "%s" IS NOT NULL;`, geomCol, table, geomCol))
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		id int64
+		b  orb.Bound
+	}
+	var entries []entry
+	for geoms.Next() {
+		var (
+			id   int64
+			blob []byte
+		)
+		if err := geoms.Scan(&id, &blob); err != nil {
+			geoms.Close()
+			return err
+		}
+		wkbBytes, _, err := gpkgToWKB(blob)
+		if err != nil {
+			continue
+		}
+		mp, err := decodeMultiPolygon(wkbBytes)
+		if err != nil || len(mp) == 0 {
+			continue
+		}
+		entries = append(entries, entry{id, mp.Bound()})
+	}
+	geoms.Close()
+	if err := geoms.Err(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, err := ins.Exec(e.id, e.b.Min[0], e.b.Max[0], e.b.Min[1], e.b.Max[1]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,302 @@
+// reload.go
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 数据集热更新：新数据集在后台打开、建好名称索引后原子替换，不用重启。
+// 每个请求开始时取当前版本并持有到结束，进行中的请求和批量任务继续用旧版本，
+// 旧版本在最后一个引用释放后关闭
+
+// 一次加载得到的数据集（GPKG_DIR 时每个文件一个 Server）和它们的接口
+type generation struct {
+	version  string
+	files    []string
+	loadedAt time.Time
+	servers  []*Server
+	routes   []apiRoute
+	handlers map[string]http.HandlerFunc
+
+	refs      atomic.Int64
+	retired   atomic.Bool
+	closeOnce sync.Once
+}
+
+func newGeneration(version string, files []string, servers []*Server, routes []apiRoute) *generation {
+	g := &generation{
+		version:  version,
+		files:    files,
+		loadedAt: time.Now(),
+		servers:  servers,
+		routes:   routes,
+		handlers: make(map[string]http.HandlerFunc, len(routes)),
+	}
+	for _, s := range servers {
+		s.gen = g
+	}
+	for _, rt := range routes {
+		g.handlers[rt.Pattern] = rt.Handler
+	}
+	return g
+}
+
+func (g *generation) release() {
+	if g.refs.Add(-1) == 0 && g.retired.Load() {
+		g.close()
+	}
+}
+
+// 被替换后调用；仍有引用时由最后一个 release 关闭
+func (g *generation) retire() {
+	g.retired.Store(true)
+	if g.refs.Load() == 0 {
+		g.close()
+	}
+}
+
+func (g *generation) close() {
+	g.closeOnce.Do(func() {
+		for _, s := range g.servers {
+			s.close()
+		}
+		log.Println("dataset closed:", g.version)
+	})
+}
+
+// 后台任务在请求结束后还要用数据集，另外持有一个引用；调用方必须已持有引用
+func (s *Server) hold() func() {
+	g := s.gen
+	if g == nil {
+		return func() {}
+	}
+	g.refs.Add(1)
+	return g.release
+}
+
+// 数据文件的版本：最新的修改时间加上所有文件路径、大小、修改时间的哈希，
+// 文件没变时重启前后版本相同
+func datasetVersion(files []string) (string, error) {
+	h := fnv.New32a()
+	var latest time.Time
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", f, st.Size(), st.ModTime().UnixNano())
+		if st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+	return fmt.Sprintf("%s-%08x", latest.UTC().Format("20060102T150405Z"), h.Sum32()), nil
+}
+
+type reloader struct {
+	cur atomic.Pointer[generation]
+	// 同一时间只做一次加载
+	mu      sync.Mutex
+	reloads atomic.Int64
+	// files 列出当前的数据文件（GPKG_DIR 时会发现新增的文件），open 打开它们
+	files      func() ([]string, error)
+	open       func() ([]*Server, []apiRoute, error)
+	adminToken string
+}
+
+func newReloader(files func() ([]string, error), open func() ([]*Server, []apiRoute, error)) (*reloader, error) {
+	rl := &reloader{files: files, open: open, adminToken: env("ADMIN_TOKEN", "")}
+	g, err := rl.load()
+	if err != nil {
+		return nil, err
+	}
+	rl.cur.Store(g)
+	// 启动时不等名称索引和质心，先提供其他接口
+	for _, s := range g.servers {
+		release := s.hold()
+		go func() {
+			defer release()
+			s.loadNameIndex()
+			s.loadCentroids()
+		}()
+	}
+	return rl, nil
+}
+
+func (rl *reloader) load() (*generation, error) {
+	files, err := rl.files()
+	if err != nil {
+		return nil, err
+	}
+	version, err := datasetVersion(files)
+	if err != nil {
+		return nil, err
+	}
+	servers, routes, err := rl.open()
+	if err != nil {
+		return nil, err
+	}
+	// 开始提供服务前预热，见 warmup.go
+	for _, s := range servers {
+		s.warmup()
+	}
+	log.Println("dataset version:", version)
+	return newGeneration(version, files, servers, routes), nil
+}
+
+// 打开并预热新数据集后替换当前版本；失败时继续用旧版本
+func (rl *reloader) reload() (*generation, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	start := time.Now()
+	g, err := rl.load()
+	if err != nil {
+		return nil, err
+	}
+	// 名称索引建好再切换，切换后自动补全、搜索不会短暂返回 503
+	for _, s := range g.servers {
+		s.loadNameIndex()
+	}
+	// 质心可能要重新计算，不等它，期间 /latlng 现算
+	for _, s := range g.servers {
+		release := s.hold()
+		go func() {
+			defer release()
+			s.loadCentroids()
+		}()
+	}
+	old := rl.cur.Swap(g)
+	old.retire()
+	rl.reloads.Add(1)
+	log.Printf("dataset reloaded: %s -> %s in %s", old.version, g.version, time.Since(start).Round(time.Millisecond))
+	return g, nil
+}
+
+// 取当前版本并持有引用；取到后版本已被替换时重取，保证不会拿到已关闭的库
+func (rl *reloader) acquire() *generation {
+	for {
+		g := rl.cur.Load()
+		g.refs.Add(1)
+		if rl.cur.Load() == g {
+			return g
+		}
+		g.release()
+	}
+}
+
+// 把各接口包装成按当前版本分发，响应头 X-Dataset-Version 带上版本
+func (rl *reloader) routes() []apiRoute {
+	routes := append([]apiRoute(nil), rl.cur.Load().routes...)
+	for i := range routes {
+		pattern := routes[i].Pattern
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			g := rl.acquire()
+			defer g.release()
+			w.Header().Set("X-Dataset-Version", g.version)
+			h := g.handlers[pattern]
+			if h == nil {
+				http.NotFound(w, r)
+				return
+			}
+			h(w, r)
+		}
+	}
+	return routes
+}
+
+// 定期检查数据文件，版本变化且连续两次检查相同（文件已写完）时重新加载；
+// 加载失败的版本不再重试，直到文件再次变化
+func (rl *reloader) watch(interval time.Duration) {
+	var pending, failed string
+	for range time.Tick(interval) {
+		files, err := rl.files()
+		if err != nil {
+			log.Println("dataset watch error:", err)
+			continue
+		}
+		version, err := datasetVersion(files)
+		if err != nil {
+			log.Println("dataset watch error:", err)
+			continue
+		}
+		if version == rl.cur.Load().version || version == failed {
+			pending = ""
+			continue
+		}
+		if version != pending {
+			pending = version
+			continue
+		}
+		pending = ""
+		if _, err := rl.reload(); err != nil {
+			failed = version
+			log.Println("dataset reload error:", err)
+		}
+	}
+}
+
+// kill -HUP 触发重新加载
+func (rl *reloader) reloadOnSIGHUP() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if _, err := rl.reload(); err != nil {
+			log.Println("dataset reload error:", err)
+		}
+	}
+}
+
+type DatasetVersion struct {
+	Version  string    `json:"version"`
+	Files    []string  `json:"files"`
+	LoadedAt time.Time `json:"loadedAt"`
+	Reloads  int64     `json:"reloads"`
+}
+
+type VersionRes struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data *DatasetVersion `json:"data"`
+}
+
+func (rl *reloader) versionOf(g *generation) *DatasetVersion {
+	return &DatasetVersion{Version: g.version, Files: g.files, LoadedAt: g.loadedAt, Reloads: rl.reloads.Load()}
+}
+
+func (rl *reloader) handleVersion(w http.ResponseWriter, _ *http.Request) {
+	g := rl.cur.Load()
+	w.Header().Set("X-Dataset-Version", g.version)
+	writeJSON(w, http.StatusOK, VersionRes{Code: 200, Msg: "success", Data: rl.versionOf(g)})
+}
+
+// 需要 ADMIN_TOKEN；加载完成后才返回，全球数据可能需要几十秒
+func (rl *reloader) handleReload(w http.ResponseWriter, r *http.Request) {
+	if rl.adminToken == "" {
+		writeErrorJSON(w, http.StatusForbidden, 403, "reload disabled, set ADMIN_TOKEN")
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(rl.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeErrorJSON(w, http.StatusUnauthorized, 401, "unauthorized")
+		return
+	}
+	g, err := rl.reload()
+	if err != nil {
+		log.Println("dataset reload error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "reload failed: "+err.Error())
+		return
+	}
+	w.Header().Set("X-Dataset-Version", g.version)
+	writeJSON(w, http.StatusOK, VersionRes{Code: 200, Msg: "success", Data: rl.versionOf(g)})
+}
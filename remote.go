@@ -0,0 +1,406 @@
+// remote.go
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GPKG_PATH 为 s3:// 或 http(s):// 地址时，启动前下载到本地缓存目录（GPKG_CACHE_DIR）再加载，
+// 不用把几 GB 的文件打进镜像。先写到 .part，中断后下次启动用 Range 续传；
+// 响应的 ETag / Last-Modified 记在 .meta 旁文件中，续传时带 If-Range，远端文件已变时服务端返回整个新文件，
+// 不会把新旧两个版本拼在一起；本地已有的缓存在启动时用 HEAD 比对，远端已变时重新下载。
+// 给出 GPKG_SHA256 时校验，校验通过的记在 .sha256 旁文件中，之后启动不用重新计算。
+// s3:// 用 AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY（及 AWS_SESSION_TOKEN）签名，没有时匿名访问
+
+// 下载失败时重试的次数，每次从已下载的位置续传
+const remoteAttempts = 3
+
+func isRemotePath(p string) bool {
+	for _, prefix := range []string{"s3://", "http://", "https://"} {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回本地文件路径；本地已有且校验和相符（未给校验和时远端未变）就不再下载
+func fetchRemote(rawURL, dir, want string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	if name == "" || name == "." || name == "/" {
+		return "", fmt.Errorf("%s: no file name in path", rawURL)
+	}
+	want = strings.ToLower(strings.TrimSpace(want))
+	local := filepath.Join(dir, name)
+	if _, err := os.Stat(local); err == nil {
+		if want == "" {
+			if remoteUnchanged(rawURL, local) {
+				log.Printf("remote: using cached %s", local)
+				return local, nil
+			}
+			log.Printf("remote: %s changed since %s was downloaded, downloading again", rawURL, local)
+			os.Remove(local)
+			os.Remove(local + ".meta")
+		} else {
+			ok, err := checksumMatches(local, want)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				log.Printf("remote: using cached %s", local)
+				return local, nil
+			}
+			log.Printf("remote: cached %s does not match GPKG_SHA256, downloading again", local)
+			os.Remove(local)
+			os.Remove(local + ".sha256")
+			os.Remove(local + ".meta")
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	part := local + ".part"
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err = downloadPart(rawURL, part)
+		if err == nil {
+			break
+		}
+		if attempt == remoteAttempts {
+			return "", fmt.Errorf("%s: %w", rawURL, err)
+		}
+		log.Printf("remote: %s: %v, resuming (attempt %d/%d)", rawURL, err, attempt+1, remoteAttempts)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	if want != "" {
+		sum, err := fileSHA256(part)
+		if err != nil {
+			return "", err
+		}
+		if sum != want {
+			os.Remove(part)
+			os.Remove(part + ".meta")
+			return "", fmt.Errorf("%s: sha256 %s does not match GPKG_SHA256 %s", rawURL, sum, want)
+		}
+	}
+	if err := os.Rename(part, local); err != nil {
+		return "", err
+	}
+	if err := os.Rename(part+".meta", local+".meta"); err != nil && !os.IsNotExist(err) {
+		log.Println("remote: meta file error:", err)
+	}
+	if want != "" {
+		if err := writeChecksumFile(local, want); err != nil {
+			log.Println("remote: checksum file error:", err)
+		}
+	}
+	st, _ := os.Stat(local)
+	log.Printf("remote: downloaded %s -> %s (%d bytes) in %s", rawURL, local, st.Size(), time.Since(start).Round(time.Millisecond))
+	return local, nil
+}
+
+// 本地缓存的远端文件仍是最新版本：HEAD 的 ETag（没有时 Last-Modified）与下载时记下的相同。
+// 旧版本下载的缓存没有 .meta，按大小比对并补记；远端不可达时照常用缓存，不影响离线启动
+func remoteUnchanged(rawURL, local string) bool {
+	req, err := remoteRequest(http.MethodHead, rawURL, 0, time.Now())
+	if err != nil {
+		return true
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("remote: cannot revalidate %s: %v", local, err)
+		return true
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("remote: cannot revalidate %s: %s", local, resp.Status)
+		return true
+	}
+	cur := remoteMetaOf(resp)
+	old, err := readRemoteMeta(local)
+	if err != nil {
+		st, err := os.Stat(local)
+		if err != nil || (resp.ContentLength >= 0 && st.Size() != resp.ContentLength) {
+			return false
+		}
+		if err := writeRemoteMeta(local, cur); err != nil {
+			log.Println("remote: meta file error:", err)
+		}
+		return true
+	}
+	if old.etag != "" && cur.etag != "" {
+		return old.etag == cur.etag
+	}
+	return old.lastModified == "" || old.lastModified == cur.lastModified
+}
+
+// 下载时远端文件的版本标识，记在 <file>.meta 中：第一行 ETag，第二行 Last-Modified
+type remoteMeta struct {
+	etag         string
+	lastModified string
+}
+
+func remoteMetaOf(resp *http.Response) remoteMeta {
+	return remoteMeta{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+}
+
+// If-Range 的值：强 ETag 优先，弱 ETag 不能用于 If-Range，退回 Last-Modified
+func (m remoteMeta) ifRange() string {
+	if m.etag != "" && !strings.HasPrefix(m.etag, "W/") {
+		return m.etag
+	}
+	return m.lastModified
+}
+
+func readRemoteMeta(p string) (remoteMeta, error) {
+	data, err := os.ReadFile(p + ".meta")
+	if err != nil {
+		return remoteMeta{}, err
+	}
+	lines := strings.SplitN(string(data), "\n", 3)
+	if len(lines) < 2 {
+		return remoteMeta{}, fmt.Errorf("%s.meta: malformed", p)
+	}
+	return remoteMeta{etag: lines[0], lastModified: lines[1]}, nil
+}
+
+func writeRemoteMeta(p string, m remoteMeta) error {
+	return os.WriteFile(p+".meta", []byte(m.etag+"\n"+m.lastModified+"\n"), 0o644)
+}
+
+// 从 part 的末尾续传，带上次响应的 If-Range；服务端不支持 Range、远端文件已变或没有记下版本时从头下载
+func downloadPart(rawURL, part string) error {
+	var (
+		offset  int64
+		ifRange string
+	)
+	if st, err := os.Stat(part); err == nil && st.Size() > 0 {
+		if m, err := readRemoteMeta(part); err == nil && m.ifRange() != "" {
+			offset, ifRange = st.Size(), m.ifRange()
+		}
+	}
+	req, err := remoteRequest(http.MethodGet, rawURL, offset, time.Now())
+	if err != nil {
+		return err
+	}
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		log.Printf("remote: resuming %s at %d bytes", rawURL, offset)
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+		if offset > 0 {
+			log.Printf("remote: %s changed or does not support ranges, restarting", rawURL)
+		}
+		offset = 0
+		log.Printf("remote: downloading %s (%d bytes)", rawURL, resp.ContentLength)
+		if err := writeRemoteMeta(part, remoteMetaOf(resp)); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 上次已下载完、改名前退出：Content-Range 为 bytes */<总长>
+		if total, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes */"); ok && total == strconv.FormatInt(offset, 10) {
+			return nil
+		}
+		os.Remove(part)
+		os.Remove(part + ".meta")
+		return fmt.Errorf("range not satisfiable, restarting")
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	f, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("short read: %d of %d bytes", n, resp.ContentLength)
+	}
+	return nil
+}
+
+// http(s) 地址直接请求；s3://bucket/key 转成虚拟主机风格的地址（设置 AWS_ENDPOINT_URL 时为路径风格，
+// 用于 MinIO 等兼容服务），有凭证时签名
+func remoteRequest(method, rawURL string, offset int64, now time.Time) (*http.Request, error) {
+	s3 := strings.HasPrefix(rawURL, "s3://")
+	region := env("AWS_REGION", env("AWS_DEFAULT_REGION", "us-east-1"))
+	if s3 {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		key := awsURIEncode(strings.TrimPrefix(u.Path, "/"))
+		if endpoint := strings.TrimSuffix(env("AWS_ENDPOINT_URL", ""), "/"); endpoint != "" {
+			rawURL = endpoint + "/" + u.Host + "/" + key
+		} else {
+			rawURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", u.Host, region, key)
+		}
+	}
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if s3 {
+		if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+			signS3(req, region, id, secret, os.Getenv("AWS_SESSION_TOKEN"), now)
+		}
+	}
+	return req, nil
+}
+
+// 空请求体的 SHA-256
+const emptyPayloadSHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// AWS Signature Version 4，只用于无请求体的 GET / HEAD；签名 host、range 和 x-amz-* 头
+func signS3(req *http.Request, region, id, secret, token string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadSHA256)
+	if token != "" {
+		req.Header.Set("x-amz-security-token", token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		emptyPayloadSHA256,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		id, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// AWS 的 URI 编码：除字母数字和 -_.~ 外都编码，对象键中的 / 保留
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 旁文件 <file>.sha256 记录校验和及当时的大小、修改时间，文件没变时直接比对
+func checksumMatches(p, want string) (bool, error) {
+	st, err := os.Stat(p)
+	if err != nil {
+		return false, err
+	}
+	if data, err := os.ReadFile(p + ".sha256"); err == nil {
+		if f := strings.Fields(string(data)); len(f) == 3 &&
+			f[1] == strconv.FormatInt(st.Size(), 10) && f[2] == strconv.FormatInt(st.ModTime().UnixNano(), 10) {
+			return f[0] == want, nil
+		}
+	}
+	sum, err := fileSHA256(p)
+	if err != nil {
+		return false, err
+	}
+	if sum != want {
+		return false, nil
+	}
+	if err := writeChecksumFile(p, sum); err != nil {
+		log.Println("remote: checksum file error:", err)
+	}
+	return true, nil
+}
+
+func writeChecksumFile(p, sum string) error {
+	st, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p+".sha256", []byte(fmt.Sprintf("%s %d %d\n", sum, st.Size(), st.ModTime().UnixNano())), 0o644)
+}
@@ -0,0 +1,196 @@
+// resolutions.go
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/planar"
+)
+
+// 多分辨率几何：build 时把每个叶子按几档容差预先简化，每档一张表（fid 与叶子表相同），
+// 边界、瓦片和 GeoJSON 输出按请求的 zoom / tolerance 取不比请求粗的最粗一档，不用读取并简化原始几何。
+// 包含判断、求交等点面判断始终用原始几何，这几档简化几何只用于输出（反查的先行判断另有一份，见 coarse.go）；
+// build 时还校验简化后的叶子仍包含原几何的不可达极点、环没有退化，不满足的改用更小的容差
+
+type geomResolution struct {
+	maxZoom   int
+	tolerance float64
+}
+
+// 由粗到细。每档容差不超过该段最大缩放级别的一个瓦片像素（见 zoomTolerance）：
+// z0-6 为 0.001（z6 一个像素约 0.00137），z7-10 为 0.00005（z10 约 0.000086），z11 以上用原始几何
+var geomResolutions = []geomResolution{
+	{maxZoom: 6, tolerance: 0.001},
+	{maxZoom: 10, tolerance: 0.00005},
+}
+
+func resolutionTableName(table string, r geomResolution) string {
+	return fmt.Sprintf("%s_geom_z%d", table, r.maxZoom)
+}
+
+// 该缩放级别下一个瓦片像素（1/4096 瓦片宽）对应的经度跨度
+func zoomTolerance(z int) float64 {
+	return 360 / float64(uint64(1)<<z) / mvt.DefaultExtent
+}
+
+// 容差不超过 tolerance 的最粗一档的表和几何列，及其容差；都不满足时为原始几何，容差为 0
+func (s *Server) geomSource(tolerance float64) (table, col string, sourceTolerance float64) {
+	for _, r := range s.geomStores {
+		if r.tolerance <= tolerance {
+			return resolutionTableName(s.table, r), "geom", r.tolerance
+		}
+	}
+	return s.table, s.geomCol, 0
+}
+
+// 按 tolerance 简化；简化后多边形退化或不再包含 probe 时容差减半重试，都不行时原样返回。
+// 返回实际使用的容差
+func validatedSimplify(mp orb.MultiPolygon, probe orb.Point, tolerance float64) (orb.MultiPolygon, float64) {
+	inside := planar.MultiPolygonContains(mp, probe)
+	for t := tolerance; t >= tolerance/16; t /= 2 {
+		out := simplifyShape(mp, t)
+		if validPolygons(out) && (!inside || planar.MultiPolygonContains(out, probe)) {
+			return out, t
+		}
+	}
+	return mp, 0
+}
+
+// 输出时再按请求的容差简化；比容差还小的区域会整个消失，这时保留原来的几何
+func resimplify(mp orb.MultiPolygon, tolerance float64) orb.MultiPolygon {
+	if out := simplifyShape(mp, tolerance); validPolygons(out) {
+		return out
+	}
+	return mp
+}
+
+// 每个多边形都有至少 4 个点、面积不为 0 的环
+func validPolygons(mp orb.MultiPolygon) bool {
+	if len(mp) == 0 {
+		return false
+	}
+	for _, poly := range mp {
+		if len(poly) == 0 {
+			return false
+		}
+		for _, ring := range poly {
+			if len(ring) < 4 || planar.Area(ring) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 为每档分辨率建表；从源库读叶子（与写入的库各用一个连接），按 fid 对应
+func buildResolutions(src, db *sql.DB, table, geomCol, pk string) error {
+	for _, r := range geomResolutions {
+		name := resolutionTableName(table, r)
+		if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE "%s" (fid INTEGER PRIMARY KEY, geom BLOB NOT NULL);`, name)); err != nil {
+			return err
+		}
+		n, finer, err := fillResolution(src, db, table, geomCol, pk, name, func(mp orb.MultiPolygon) (orb.MultiPolygon, bool) {
+			out, used := validatedSimplify(mp, poleOfInaccessibility(mp), r.tolerance)
+			return out, used != r.tolerance
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		log.Printf("build: %s (tolerance %g), %d leaves, %d kept finer to stay valid", name, r.tolerance, n, finer)
+	}
+	return nil
+}
+
+// 逐个叶子用 simplify 简化后写入 name 表；simplify 返回的 bool 为是否改用了更小的容差
+func fillResolution(src, db *sql.DB, table, geomCol, pk, name string, simplify func(mp orb.MultiPolygon) (orb.MultiPolygon, bool)) (n, finer int, err error) {
+	rows, err := src.Query(fmt.Sprintf(`SELECT "%s", "%s" FROM "%s" WHERE "%s" IS NOT NULL;`, pk, geomCol, table, geomCol))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	ins, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" VALUES (?, ?);`, name))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer ins.Close()
+	for rows.Next() {
+		var (
+			fid  int64
+			blob []byte
+		)
+		if err := rows.Scan(&fid, &blob); err != nil {
+			return 0, 0, err
+		}
+		wkbBytes, _, err := gpkgToWKB(blob)
+		if err != nil {
+			continue
+		}
+		mp, err := decodeMultiPolygon(wkbBytes)
+		if err != nil || len(mp) == 0 {
+			continue
+		}
+		out, kept := simplify(mp)
+		if kept {
+			finer++
+		}
+		b, err := wkb.Marshal(out, binary.LittleEndian)
+		if err != nil {
+			return 0, 0, fmt.Errorf("fid %d: %w", fid, err)
+		}
+		if _, err := ins.Exec(fid, b); err != nil {
+			return 0, 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	return n, finer, tx.Commit()
+}
+
+// 已有的分辨率表，由粗到细
+func builtResolutions(db *sql.DB, table string) []geomResolution {
+	var out []geomResolution
+	for _, r := range geomResolutions {
+		if cols, err := tableColumns(db, resolutionTableName(table, r)); err == nil && len(cols) > 0 {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// 简化后的叶子几何，只用于输出
+func (s *Server) leafGeomAt(rowid int64, tolerance float64) (orb.MultiPolygon, error) {
+	table, col, _ := s.geomSource(tolerance)
+	var blob []byte
+	sqlStr := fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE rowid = ?;`, col, table)
+	if err := s.db.QueryRow(sqlStr, rowid).Scan(&blob); err != nil {
+		return nil, err
+	}
+	wkbBytes, _, err := gpkgToWKB(blob)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMultiPolygon(wkbBytes)
+}
+
+// resolutions 元数据的写法，如 "z6:0.001,z10:5e-05"
+func resolutionsMeta() string {
+	parts := make([]string, len(geomResolutions))
+	for i, r := range geomResolutions {
+		parts[i] = fmt.Sprintf("z%d:%g", r.maxZoom, r.tolerance)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,194 @@
+// resolve.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type ResolveRes struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data *SearchItemList `json:"data"`
+}
+
+type resolveCandidate struct {
+	gid, name string
+}
+
+// 名称是否与某一层的名称或别名（VARNAME / NL_NAME，以 '|' 分隔）相同，大小写和多余空白不敏感
+func nameMatches(seg string, names ...sql.NullString) bool {
+	for _, n := range names {
+		for _, part := range strings.Split(n.String, "|") {
+			if p := normalizeName(part); p != "" && p == seg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 在 level 层中找名称为 seg、上级属于 parents 的区域；parents 为 nil 时不限上级
+func (s *Server) resolveStep(level int, seg string, parents []string) ([]resolveCandidate, error) {
+	cols := []string{fmt.Sprintf("GID_%d", level), fmt.Sprintf("NAME_%d", level)}
+	for _, c := range []string{"VARNAME", "NL_NAME"} {
+		if col := fmt.Sprintf("%s_%d", c, level); s.columns[col] {
+			cols = append(cols, col)
+		}
+	}
+	where := fmt.Sprintf("GID_%d <> ''", level)
+	args := make([]any, 0, len(parents))
+	if parents != nil {
+		where += fmt.Sprintf(" AND GID_%d IN (?%s)", level-1, strings.Repeat(", ?", len(parents)-1))
+		for _, p := range parents {
+			args = append(args, p)
+		}
+	}
+	sqlStr := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s;", strings.Join(cols, ", "), s.table, where)
+
+	rows, err := s.db.Query(sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []resolveCandidate
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var gid string
+		names := make([]sql.NullString, len(cols)-1)
+		dest := []any{&gid}
+		for i := range names {
+			dest = append(dest, &names[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if !seen[gid] && nameMatches(seg, names...) {
+			seen[gid] = true
+			out = append(out, resolveCandidate{gid: gid, name: names[0].String})
+		}
+	}
+	return out, rows.Err()
+}
+
+// 国家一级也接受 ISO alpha-2 / alpha-3 代码
+func (s *Server) resolveCountryCode(ctx context.Context, seg string) []string {
+	code := strings.ToUpper(seg)
+	if t, err := loadISOTables(); err == nil {
+		if c, ok := t.byAlpha2[code]; ok {
+			code = c.Alpha3
+		}
+	}
+	if _, err := s.detectLevel(ctx, code); err == nil && !strings.Contains(code, ".") {
+		return []string{code}
+	}
+	return nil
+}
+
+/************* 名称路径 → GID *************/
+// 从国家开始逐层按名称向下走；第一段不是国家时从一级行政区开始（表格里常省略国家）
+func (s *Server) resolvePath(ctx context.Context, path string) ([]SearchItem, error) {
+	segs := splitNamePath(path)
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("path required")
+	}
+	if len(segs) > 6 {
+		return nil, fmt.Errorf("path too deep, at most 6 names")
+	}
+	raw := segs
+	segs = make([]string, len(raw))
+	for i := range raw {
+		segs[i] = normalizeName(raw[i])
+	}
+
+	level := 0
+	cands, err := s.resolveStep(0, segs[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	var gids []string
+	for _, c := range cands {
+		gids = append(gids, c.gid)
+	}
+	if len(gids) == 0 {
+		gids = s.resolveCountryCode(ctx, segs[0])
+	}
+	if len(gids) == 0 && len(segs) < 6 {
+		level = 1
+		if cands, err = s.resolveStep(1, segs[0], nil); err != nil {
+			return nil, err
+		}
+		for _, c := range cands {
+			gids = append(gids, c.gid)
+		}
+	}
+	if len(gids) == 0 {
+		return nil, fmt.Errorf("no match for %q", raw[0])
+	}
+
+	for i, seg := range segs[1:] {
+		level++
+		if level > 5 {
+			return nil, fmt.Errorf("path too deep")
+		}
+		cands, err := s.resolveStep(level, seg, gids)
+		if err != nil {
+			return nil, err
+		}
+		if len(cands) == 0 {
+			return nil, fmt.Errorf("no match for %q", raw[i+1])
+		}
+		gids = gids[:0]
+		for _, c := range cands {
+			gids = append(gids, c.gid)
+		}
+	}
+
+	out := make([]SearchItem, 0, len(gids))
+	for _, gid := range gids {
+		chain, err := s.ancestorsOf(ctx, gid)
+		if err != nil {
+			return nil, err
+		}
+		last := chain[len(chain)-1]
+		out = append(out, SearchItem{
+			GID:        last.GID,
+			Name:       last.Name,
+			ParentCode: last.ParentCode,
+			Level:      last.Level,
+			Path:       chain,
+		})
+	}
+	return out, nil
+}
+
+func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSpace(r.URL.Query().Get("path"))
+	if path == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "path required")
+		return
+	}
+	items, err := s.resolvePath(r.Context(), path)
+	if err != nil {
+		msg := err.Error()
+		switch {
+		case strings.HasPrefix(msg, "no match"):
+			writeErrorJSON(w, http.StatusNotFound, 404, msg)
+		case strings.HasPrefix(msg, "path"):
+			writeErrorJSON(w, http.StatusBadRequest, 400, msg)
+		default:
+			writeQueryError(w, "resolve", err)
+		}
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, ResolveRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &SearchItemList{List: items, Total: len(items)},
+	})
+}
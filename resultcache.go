@@ -0,0 +1,66 @@
+// resultcache.go
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// 反查结果缓存（LRU）：坐标已按 roundPlaces 取整，停在场站的车队等会反复查同一个点，
+// 命中时整个反查（候选、解码、点面判断）都跳过。不在任何区域的点也缓存（值为 nil）。
+// 条数由 RESULT_CACHE_SIZE 设置，0 为不缓存；热更新时随新版本重建
+type pointKey struct {
+	lon, lat float64
+	mode     nameMode
+}
+
+type resultCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List
+	items map[pointKey]*list.Element
+	stats *cacheStats
+}
+
+type resultEntry struct {
+	key pointKey
+	res *AdminLevels
+}
+
+func newResultCache(max int, stats *cacheStats) *resultCache {
+	return &resultCache{max: max, order: list.New(), items: make(map[pointKey]*list.Element), stats: stats}
+}
+
+func (c *resultCache) get(key pointKey) (*AdminLevels, bool) {
+	if c.max <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	c.stats.record(ok)
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*resultEntry).res, true
+}
+
+func (c *resultCache) put(key pointKey, res *AdminLevels) {
+	if c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*resultEntry).res = res
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&resultEntry{key: key, res: res})
+	for c.order.Len() > c.max {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*resultEntry).key)
+	}
+}
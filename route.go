@@ -0,0 +1,310 @@
+// route.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const (
+	maxRouteVertices = 10000
+	maxRouteSamples  = 50000
+	maxRouteBody     = 8 << 20
+	// 默认每 500 米取一个采样点
+	defaultRouteStepM = 500
+)
+
+// POST /reverse/route 的请求体：polyline 与 geometry 二选一
+type RouteRequest struct {
+	Polyline  string          `json:"polyline"`
+	Precision int             `json:"precision"`
+	Geometry  json.RawMessage `json:"geometry"`
+	Level     *int            `json:"level"`
+	StepM     float64         `json:"step_m"`
+}
+
+// 路线经过的一个行政区（连续经过的一段）
+type RouteArea struct {
+	GID        string         `json:"code"`
+	Name       string         `json:"name"`
+	ParentCode string         `json:"parentCode"`
+	Level      string         `json:"level"`
+	Path       []ChildrenItem `json:"path"`
+	EntryIndex int            `json:"entryIndex"`
+	ExitIndex  int            `json:"exitIndex"`
+	DistanceKm float64        `json:"distanceKm"`
+}
+
+type RouteResult struct {
+	List    []RouteArea `json:"list"`
+	TotalKm float64     `json:"totalKm"`
+}
+
+type RouteRes struct {
+	Code int          `json:"code"`
+	Msg  string       `json:"msg"`
+	Data *RouteResult `json:"data"`
+}
+
+// Google encoded polyline，precision 5（Google）或 6（OSRM/Valhalla）
+func decodePolyline(s string, precision int) (orb.LineString, error) {
+	factor := math.Pow10(precision)
+	var (
+		ls       orb.LineString
+		lat, lon int
+	)
+	for i := 0; i < len(s); {
+		var vals [2]int
+		for k := range vals {
+			shift, result := 0, 0
+			for {
+				if i >= len(s) {
+					return nil, errors.New("truncated polyline")
+				}
+				b := int(s[i]) - 63
+				i++
+				if b < 0 || b > 63 {
+					return nil, errors.New("invalid polyline character")
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				vals[k] = ^(result >> 1)
+			} else {
+				vals[k] = result >> 1
+			}
+		}
+		lat += vals[0]
+		lon += vals[1]
+		ls = append(ls, orb.Point{float64(lon) / factor, float64(lat) / factor})
+	}
+	return ls, nil
+}
+
+// 解析 GeoJSON LineString（也接受包着 LineString 的 Feature）
+func parseLineString(raw json.RawMessage) (orb.LineString, error) {
+	if f, err := geojson.UnmarshalFeature(raw); err == nil && f.Geometry != nil {
+		if ls, ok := f.Geometry.(orb.LineString); ok {
+			return ls, nil
+		}
+	}
+	g, err := geojson.UnmarshalGeometry(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid geometry: %w", err)
+	}
+	ls, ok := g.Geometry().(orb.LineString)
+	if !ok {
+		return nil, fmt.Errorf("geometry must be a LineString")
+	}
+	return ls, nil
+}
+
+type routeSample struct {
+	pt     orb.Point
+	vertex int // 所在原始线段的起点下标
+}
+
+// 按 stepM 加密路线，避免两个顶点之间穿过的小区域被漏掉
+func densify(ls orb.LineString, stepM float64) []routeSample {
+	out := []routeSample{{pt: ls[0], vertex: 0}}
+	for i := 1; i < len(ls); i++ {
+		a, b := ls[i-1], ls[i]
+		n := 1
+		if stepM > 0 {
+			n = int(math.Ceil(angularDistance(a, b) * earthRadiusM / stepM))
+			n = max(1, n)
+		}
+		for k := 1; k <= n; k++ {
+			t := float64(k) / float64(n)
+			vertex := i - 1
+			if k == n {
+				vertex = i
+			}
+			out = append(out, routeSample{
+				pt:     orb.Point{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t},
+				vertex: vertex,
+			})
+		}
+	}
+	return out
+}
+
+/************* 路线反查 *************/
+// 沿路线依次反查，把连续落在同一区域的采样点合并成一段；level 为聚合层级
+func (s *Server) reverseRoute(ctx context.Context, ls orb.LineString, level int, stepM float64) (*RouteResult, error) {
+	samples := densify(ls, stepM)
+	if len(samples) > maxRouteSamples {
+		return nil, fmt.Errorf("route too long, %d samples exceeds %d, increase step_m", len(samples), maxRouteSamples)
+	}
+
+	// 相邻采样点大多落在同一最末级多边形里，先用上一次命中的多边形判断
+	areas := make([]*AdminLevels, len(samples))
+	var last *AdminLevels
+	for i, smp := range samples {
+		if last != nil && last.contains(smp.pt) {
+			areas[i] = last
+			continue
+		}
+		res, err := s.reverse(ctx, smp.pt.Lon(), smp.pt.Lat(), nameLatin)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.views.apply(res, s.views.def, nameLatin)
+		areas[i], last = res, res
+	}
+
+	// 第 i 个采样点在聚合层级上的区域；不在任何区域（海上等）时为 nil
+	itemAt := func(i int) *ChildrenItem {
+		if areas[i] == nil || len(areas[i].List) == 0 {
+			return nil
+		}
+		list := areas[i].List
+		return &list[min(level, len(list)-1)]
+	}
+
+	result := &RouteResult{List: make([]RouteArea, 0)}
+	var cur *RouteArea
+	for i, smp := range samples {
+		item := itemAt(i)
+		var d float64
+		if i > 0 {
+			d = angularDistance(samples[i-1].pt, smp.pt) * earthRadiusM / 1000
+			result.TotalKm += d
+		}
+		if item == nil {
+			// 离开区域的这一段算一半给上一个区域
+			if cur != nil {
+				cur.DistanceKm += d / 2
+			}
+			cur = nil
+			continue
+		}
+		if cur != nil && cur.GID == item.GID {
+			cur.DistanceKm += d
+			cur.ExitIndex = smp.vertex
+			continue
+		}
+		// 跨越边界的一段两边各算一半
+		if cur != nil {
+			cur.DistanceKm += d / 2
+		}
+		list := areas[i].List
+		result.List = append(result.List, RouteArea{
+			GID:        item.GID,
+			Name:       item.Name,
+			ParentCode: item.ParentCode,
+			Level:      item.Level,
+			Path:       list[:min(level+1, len(list))],
+			EntryIndex: smp.vertex,
+			ExitIndex:  smp.vertex,
+			DistanceKm: d / 2,
+		})
+		cur = &result.List[len(result.List)-1]
+	}
+
+	for i := range result.List {
+		result.List[i].DistanceKm = math.Round(result.List[i].DistanceKm*1000) / 1000
+	}
+	result.TotalKm = math.Round(result.TotalKm*1000) / 1000
+	return result, nil
+}
+
+func (s *Server) handleReverseRoute(w http.ResponseWriter, r *http.Request) {
+	var req RouteRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRouteBody))
+	if err := dec.Decode(&req); err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid json body")
+		return
+	}
+
+	var (
+		ls  orb.LineString
+		err error
+	)
+	switch {
+	case req.Polyline != "" && len(req.Geometry) > 0:
+		writeErrorJSON(w, http.StatusBadRequest, 400, "use either polyline or geometry, not both")
+		return
+	case req.Polyline != "":
+		precision := req.Precision
+		if precision == 0 {
+			precision = 5
+		}
+		if precision < 1 || precision > 7 {
+			writeErrorJSON(w, http.StatusBadRequest, 400, "invalid precision, use 1..7")
+			return
+		}
+		ls, err = decodePolyline(strings.TrimSpace(req.Polyline), precision)
+	case len(req.Geometry) > 0:
+		ls, err = parseLineString(req.Geometry)
+	default:
+		writeErrorJSON(w, http.StatusBadRequest, 400, "polyline or geometry required")
+		return
+	}
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	if len(ls) == 0 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "route has no points")
+		return
+	}
+	if len(ls) > maxRouteVertices {
+		writeErrorJSON(w, http.StatusBadRequest, 400, fmt.Sprintf("too many points, max %d", maxRouteVertices))
+		return
+	}
+	for _, p := range ls {
+		if p.Lat() < -90 || p.Lat() > 90 || p.Lon() < -180 || p.Lon() > 180 {
+			writeErrorJSON(w, http.StatusBadRequest, 400, "coordinate out of range")
+			return
+		}
+	}
+
+	level := 5
+	if req.Level != nil {
+		if *req.Level < 0 || *req.Level > 5 {
+			writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+			return
+		}
+		level = *req.Level
+	}
+	if req.StepM < 0 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid step_m")
+		return
+	}
+	stepM := req.StepM
+	if stepM == 0 {
+		stepM = defaultRouteStepM
+	}
+
+	res, err := s.reverseRoute(r.Context(), ls, level, stepM)
+	if err != nil && strings.Contains(err.Error(), "route too long") {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	if err != nil {
+		writeQueryError(w, "reverse route", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, RouteRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
@@ -0,0 +1,278 @@
+// rpc.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 单个批量请求的调用数上限
+const maxRPCBatch = 100
+
+// JSON-RPC 2.0 错误码
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// 服务端自定义：对应 HTTP 404 等其他状态
+	rpcNotFound    = -32004
+	rpcServerError = -32000
+)
+
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	// 缺省时为通知，不返回响应
+	ID *json.RawMessage `json:"id,omitempty"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// 对应的 HTTP 状态码
+	Data *RPCErrorData `json:"data,omitempty"`
+}
+
+type RPCErrorData struct {
+	Status int `json:"status"`
+}
+
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// 方法名由路径得到："/reverse/all" → "reverse.all"
+func rpcMethodName(path string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(path, "/"), "/", ".")
+}
+
+type rpcMethod struct {
+	post    bool
+	handler http.HandlerFunc
+}
+
+// 只收录返回 JSON、不上传文件且路径不带参数的接口，兼容第三方协议的 /compat/ 接口除外；params 为 GET 接口的查询参数或 POST 接口的请求体
+func newRPCHandler(routes []apiRoute) http.HandlerFunc {
+	methods := make(map[string]rpcMethod)
+	for _, rt := range routes {
+		method, path, ok := strings.Cut(rt.Pattern, " ")
+		if !ok {
+			method, path = http.MethodGet, rt.Pattern
+		}
+		if rt.Response == nil || len(rt.Consumes) > 0 || strings.Contains(path, "{") || path == "/graphql" ||
+			strings.HasPrefix(path, "/compat/") {
+			continue
+		}
+		methods[rpcMethodName(path)] = rpcMethod{post: method == http.MethodPost, handler: rt.Handler}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&body); err != nil {
+			writeRPC(w, rpcFailure(nil, rpcParseError, "parse error", 0))
+			return
+		}
+		body = bytes.TrimSpace(body)
+
+		if len(body) == 0 || body[0] != '[' {
+			if res := callRPC(r, methods, body); res != nil {
+				writeRPC(w, res)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeRPC(w, rpcFailure(nil, rpcParseError, "parse error", 0))
+			return
+		}
+		if len(batch) == 0 {
+			writeRPC(w, rpcFailure(nil, rpcInvalidRequest, "empty batch", 0))
+			return
+		}
+		if len(batch) > maxRPCBatch {
+			writeRPC(w, rpcFailure(nil, rpcInvalidRequest, fmt.Sprintf("batch too large, max %d", maxRPCBatch), 0))
+			return
+		}
+		out := make([]*RPCResponse, 0, len(batch))
+		for _, raw := range batch {
+			if res := callRPC(r, methods, raw); res != nil {
+				out = append(out, res)
+			}
+		}
+		// 全部是通知时不返回内容
+		if len(out) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeRPC(w, out)
+	}
+}
+
+func writeRPC(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func rpcFailure(id *json.RawMessage, code int, msg string, status int) *RPCResponse {
+	res := &RPCResponse{JSONRPC: "2.0", Error: &RPCError{Code: code, Message: msg}, ID: json.RawMessage("null")}
+	if id != nil {
+		res.ID = *id
+	}
+	if status != 0 {
+		res.Error.Data = &RPCErrorData{Status: status}
+	}
+	return res
+}
+
+// 执行一次调用；通知返回 nil
+func callRPC(parent *http.Request, methods map[string]rpcMethod, raw json.RawMessage) *RPCResponse {
+	var req RPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(nil, rpcInvalidRequest, "invalid request", 0)
+	}
+	if req.ID == nil {
+		// "id": null 也会解码成 nil，它不是通知
+		var probe map[string]json.RawMessage
+		_ = json.Unmarshal(raw, &probe)
+		if _, ok := probe["id"]; ok {
+			null := json.RawMessage("null")
+			req.ID = &null
+		}
+	}
+	res := invokeRPC(parent, methods, &req)
+	if req.ID == nil {
+		return nil
+	}
+	return res
+}
+
+func invokeRPC(parent *http.Request, methods map[string]rpcMethod, req *RPCRequest) *RPCResponse {
+	m, ok := methods[req.Method]
+	if !ok {
+		return rpcFailure(req.ID, rpcMethodNotFound, "method not found", 0)
+	}
+	params := make(map[string]json.RawMessage)
+	if p := bytes.TrimSpace(req.Params); len(p) > 0 && !bytes.Equal(p, []byte("null")) {
+		if p[0] != '{' || json.Unmarshal(p, &params) != nil {
+			return rpcFailure(req.ID, rpcInvalidParams, "params must be an object", 0)
+		}
+	}
+	// 输出格式和外壳由 JSON-RPC 决定
+	delete(params, "format")
+	delete(params, "envelope")
+
+	query := url.Values{}
+	for k, v := range params {
+		if s, ok := rpcQueryValue(v); ok {
+			query.Set(k, s)
+		}
+	}
+	method, body := http.MethodGet, []byte(nil)
+	if m.post {
+		method = http.MethodPost
+		body, _ = json.Marshal(params)
+	}
+	path := "/" + strings.ReplaceAll(req.Method, ".", "/")
+	r, err := http.NewRequestWithContext(parent.Context(), method, path+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return rpcFailure(req.ID, rpcInvalidParams, "invalid params", 0)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	rec := &rpcRecorder{header: make(http.Header)}
+	m.handler(&negotiatedWriter{ResponseWriter: rec, format: formatJSON, bare: true}, r)
+
+	if rec.status == 0 || rec.status == http.StatusOK {
+		id := json.RawMessage("null")
+		if req.ID != nil {
+			id = *req.ID
+		}
+		return &RPCResponse{JSONRPC: "2.0", Result: bytes.TrimSpace(rec.body.Bytes()), ID: id}
+	}
+	var p Problem
+	_ = json.Unmarshal(rec.body.Bytes(), &p)
+	msg := p.Detail
+	if msg == "" {
+		msg = http.StatusText(rec.status)
+	}
+	code := rpcServerError
+	switch {
+	case rec.status == http.StatusBadRequest:
+		code = rpcInvalidParams
+	case rec.status == http.StatusNotFound:
+		code = rpcNotFound
+	case rec.status >= 500:
+		code = rpcInternalError
+	}
+	return rpcFailure(req.ID, code, msg, rec.status)
+}
+
+// 把参数值转成查询参数：字符串原样、数字和布尔转文本、数组用逗号连接；对象只放进请求体
+func rpcQueryValue(v json.RawMessage) (string, bool) {
+	var x any
+	dec := json.NewDecoder(bytes.NewReader(v))
+	dec.UseNumber()
+	if dec.Decode(&x) != nil {
+		return "", false
+	}
+	switch x := x.(type) {
+	case string:
+		return x, true
+	case json.Number:
+		return x.String(), true
+	case bool:
+		if x {
+			return "1", true
+		}
+		return "0", true
+	case []any:
+		parts := make([]string, 0, len(x))
+		for _, e := range x {
+			switch e := e.(type) {
+			case string:
+				parts = append(parts, e)
+			case json.Number:
+				parts = append(parts, e.String())
+			default:
+				return "", false
+			}
+		}
+		return strings.Join(parts, ","), true
+	}
+	return "", false
+}
+
+// 在进程内执行 HTTP handler，收集状态码和响应体
+type rpcRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *rpcRecorder) Header() http.Header { return r.header }
+
+func (r *rpcRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *rpcRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(p)
+}
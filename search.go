@@ -0,0 +1,197 @@
+// search.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 名称搜索结果：命中的行政区及其完整层级路径
+type SearchItem struct {
+	GID        string         `json:"code"`
+	Name       string         `json:"name"`
+	ParentCode string         `json:"parentCode"`
+	Level      string         `json:"level"`
+	Path       []ChildrenItem `json:"path"`
+	// 模糊匹配时的相似度（0..1），精确匹配时省略
+	Score float64 `json:"score,omitempty"`
+}
+
+type SearchItemList struct {
+	List   []SearchItem `json:"list"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit,omitempty"`
+	Offset int          `json:"offset,omitempty"`
+}
+
+// 单次搜索最多收集的匹配数，分页在此范围内进行
+const maxSearchMatches = 1000
+
+type SearchRes struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data *SearchItemList `json:"data"`
+}
+
+// 把 "Jawa Barat / Bandung" 拆成 ["Jawa Barat", "Bandung"]
+func splitNamePath(q string) []string {
+	var out []string
+	for _, p := range strings.Split(q, "/") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// 祖先名称需按顺序出现在路径中（不要求连续），名称是否相同由 eq 判断
+func matchAncestorsFunc(path []ChildrenItem, ancestors []string, eq func(a, b string) bool) bool {
+	i := 0
+	for _, item := range path {
+		if i < len(ancestors) && eq(item.Name, ancestors[i]) {
+			i++
+		}
+	}
+	return i == len(ancestors)
+}
+
+// 祖先名称的 SQL 条件：按顺序落在 0..lvl-1 层中的某几层（不要求连续），大小写不敏感，
+// 每层除 NAME 外也匹配 lang 对应的名称列。在 SQL 中过滤，LIMIT 截断的都是已满足祖先条件的行
+func (s *Server) ancestorsClause(lvl int, mode nameMode, ancestors []string) (string, []any) {
+	if len(ancestors) == 0 {
+		return "", nil
+	}
+	var (
+		alts []string
+		args []any
+	)
+	levels := make([]int, len(ancestors))
+	var pick func(i, from int)
+	pick = func(i, from int) {
+		if i == len(ancestors) {
+			conds := make([]string, len(levels))
+			for j, l := range levels {
+				conds[j] = fmt.Sprintf("(NAME_%d = ? COLLATE NOCASE OR %s = ? COLLATE NOCASE)", l, s.nameExpr(l, mode))
+				args = append(args, ancestors[j], ancestors[j])
+			}
+			alts = append(alts, "("+strings.Join(conds, " AND ")+")")
+			return
+		}
+		for l := from; l <= lvl-len(ancestors)+i; l++ {
+			levels[i] = l
+			pick(i+1, l+1)
+		}
+	}
+	pick(0, 0)
+	return "\n  AND (" + strings.Join(alts, " OR ") + ")", args
+}
+
+/************* 名称 → GID（正向地理） *************/
+func (s *Server) search(ctx context.Context, q string, mode nameMode) ([]SearchItem, error) {
+	segs := splitNamePath(q)
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("q required")
+	}
+	name := segs[len(segs)-1]
+	ancestors := segs[:len(segs)-1]
+
+	out := make([]SearchItem, 0)
+	for lvl := 0; lvl <= 5 && len(out) < maxSearchMatches; lvl++ {
+		// 祖先名称个数超过当前层级时不可能命中
+		if len(ancestors) > lvl {
+			continue
+		}
+		// 除 NAME 外也匹配 lang 对应的名称列，便于按本地文字搜索
+		where, args := s.ancestorsClause(lvl, mode, ancestors)
+		sqlStr := fmt.Sprintf(`
+SELECT DISTINCT %s
+FROM %s
+WHERE (NAME_%d = ? COLLATE NOCASE OR %s = ? COLLATE NOCASE)
+  AND GID_%d <> ''%s
+LIMIT %d;`,
+			s.pathColumns(lvl, mode), s.table, lvl, s.nameExpr(lvl, mode), lvl, where, maxSearchMatches-len(out))
+
+		rows, err := s.db.QueryContext(ctx, sqlStr, append([]any{name, name}, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			path, err := scanPath(rows, lvl)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			last := path[len(path)-1]
+			out = append(out, SearchItem{
+				GID:        last.GID,
+				Name:       last.Name,
+				ParentCode: last.ParentCode,
+				Level:      last.Level,
+				Path:       path,
+			})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "q required")
+		return
+	}
+	page := Page{
+		Limit:  queryInt(r, "limit", 20, 1, 100),
+		Offset: queryInt(r, "offset", 0, 0, maxSearchMatches),
+	}
+	// fuzzy=1 始终模糊匹配；fuzzy=0 只做精确匹配；默认精确无结果时再模糊匹配
+	always, auto := isFuzzyMode(r.URL.Query().Get("fuzzy"))
+	mode := parseNameMode(r)
+	var (
+		items     []SearchItem
+		fuzzyUsed bool
+		err       error
+	)
+	if !always {
+		items, err = s.search(r.Context(), q, mode)
+	}
+	if err == nil && (always || (auto && len(items) == 0)) {
+		items, err = s.searchFuzzy(q)
+		if errors.Is(err, errNameIndexNotReady) && !always {
+			err = nil
+		}
+		fuzzyUsed = err == nil
+	}
+	if errors.Is(err, errNameIndexNotReady) {
+		writeErrorJSON(w, http.StatusServiceUnavailable, 503, err.Error())
+		return
+	}
+	if err != nil {
+		writeQueryError(w, "search", err)
+		return
+	}
+	list := pageSlice(items, page)
+	if fuzzyUsed && mode != nameLatin {
+		if err := s.localizeSearchItems(r.Context(), list, mode); err != nil {
+			writeQueryError(w, "search", err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, SearchRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &SearchItemList{
+			List:   list,
+			Total:  len(items),
+			Limit:  page.Limit,
+			Offset: page.Offset,
+		},
+	})
+}
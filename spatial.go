@@ -0,0 +1,275 @@
+// spatial.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+)
+
+type ContainsResult struct {
+	GID       string  `json:"code"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Contains  bool    `json:"contains"`
+}
+
+type ContainsRes struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data *ContainsResult `json:"data"`
+}
+
+/************* 点是否落在行政区内 *************/
+func (s *Server) contains(ctx context.Context, GID string, lon, lat float64) (bool, error) {
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return false, err
+	}
+	rlon, rlat := s.roundPoint(lon, lat)
+	pt := orb.Point{rlon, rlat}
+
+	// 只需检查 r-tree 命中且属于该 GID 的叶子多边形
+	inside := false
+	err = s.eachCandidate(ctx, nameLatin, rlon, rlat, rlon, rlat, func(c *candidate) bool {
+		if c.gids[level] == GID && c.contains(pt) {
+			inside = true
+			return false
+		}
+		return true
+	})
+	return inside, err
+}
+
+// 解析 code + 坐标参数，供按 GID 做空间判断的接口共用
+func parseCodeLatLon(r *http.Request) (code string, lat, lon float64, errMsg string) {
+	code = strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		return "", 0, 0, "code required"
+	}
+	lat, lon, err := parseLatLon(r)
+	if err != nil {
+		return "", 0, 0, err.Error()
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return "", 0, 0, "lat/lon out of range"
+	}
+	return code, lat, lon, ""
+}
+
+func (s *Server) handleContains(w http.ResponseWriter, r *http.Request) {
+	code, lat, lon, errMsg := parseCodeLatLon(r)
+	if errMsg != "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, errMsg)
+		return
+	}
+	inside, err := s.contains(r.Context(), code, lon, lat)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "contains", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ContainsRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &ContainsResult{GID: code, Latitude: lat, Longitude: lon, Contains: inside},
+	})
+}
+
+type DistanceResult struct {
+	GID       string  `json:"code"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Inside    bool    `json:"inside"`
+	// 到边界的距离（米），点在区域内时为负数或 0
+	DistanceM float64 `json:"distance_m"`
+}
+
+type DistanceRes struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data *DistanceResult `json:"data"`
+}
+
+/************* 点到行政区边界的距离 *************/
+func (s *Server) distanceTo(ctx context.Context, GID string, lon, lat float64) (*DistanceResult, error) {
+	shape, err := s.shapeOf(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+	pt := orb.Point{lon, lat}
+	inside := planar.MultiPolygonContains(shape.Geom, pt)
+	d := distanceToEdgesM(boundaryEdges(shape.Geom), pt)
+	if inside {
+		d = -d
+	}
+	return &DistanceResult{
+		GID:       shape.Item.GID,
+		Latitude:  lat,
+		Longitude: lon,
+		Inside:    inside,
+		DistanceM: math.Round(d*10) / 10,
+	}, nil
+}
+
+func (s *Server) handleDistance(w http.ResponseWriter, r *http.Request) {
+	code, lat, lon, errMsg := parseCodeLatLon(r)
+	if errMsg != "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, errMsg)
+		return
+	}
+	res, err := s.distanceTo(r.Context(), code, lon, lat)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "distance", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, DistanceRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
+
+type BBoxResult struct {
+	GID    string  `json:"code"`
+	MinLon float64 `json:"minLon"`
+	MinLat float64 `json:"minLat"`
+	MaxLon float64 `json:"maxLon"`
+	MaxLat float64 `json:"maxLat"`
+}
+
+type BBoxRes struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data *BBoxResult `json:"data"`
+}
+
+/************* 行政区外接矩形（直接读 r-tree，不解码几何） *************/
+func (s *Server) bboxOf(ctx context.Context, GID string) (*BBoxResult, error) {
+	GID = strings.TrimSpace(GID)
+	if GID == "" {
+		return nil, fmt.Errorf("gid required")
+	}
+	level, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+	if s.built {
+		return s.builtBBox(ctx, GID, level)
+	}
+	if res, err := s.cachedBBox(ctx, GID); res != nil || err != nil {
+		return res, err
+	}
+
+	sqlStr := fmt.Sprintf(`
+SELECT MIN(r.minx), MIN(r.miny), MAX(r.maxx), MAX(r.maxy)
+FROM %s AS a
+JOIN %s AS r ON a.rowid = r.id
+WHERE a.GID_%d = ?;`, s.table, s.rtreeTable, level)
+
+	var minx, miny, maxx, maxy sql.NullFloat64
+	if err := s.db.QueryRowContext(ctx, sqlStr, GID).Scan(&minx, &miny, &maxx, &maxy); err != nil {
+		return nil, err
+	}
+	if !minx.Valid {
+		return nil, fmt.Errorf("gid not found")
+	}
+	return &BBoxResult{
+		GID:    GID,
+		MinLon: minx.Float64,
+		MinLat: miny.Float64,
+		MaxLon: maxx.Float64,
+		MaxLat: maxy.Float64,
+	}, nil
+}
+
+func (s *Server) handleBBox(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	res, err := s.bboxOf(r.Context(), code)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "bbox", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, BBoxRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
+
+type AreaResult struct {
+	GID         string  `json:"code"`
+	AreaKm2     float64 `json:"areaKm2"`
+	PerimeterKm float64 `json:"perimeterKm"`
+}
+
+type AreaRes struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data *AreaResult `json:"data"`
+}
+
+/************* 面积与周长（球面） *************/
+func (s *Server) areaOf(ctx context.Context, GID string) (*AreaResult, error) {
+	shape, err := s.shapeOf(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+	// 叶子多边形互不重叠，面积直接求和；周长只算外边界
+	area := geo.Area(shape.Geom)
+	perimeter := 0.0
+	for _, e := range boundaryEdges(shape.Geom) {
+		perimeter += angularDistance(e[0], e[1]) * earthRadiusM
+	}
+	return &AreaResult{
+		GID:         shape.Item.GID,
+		AreaKm2:     math.Round(area/1e6*1000) / 1000,
+		PerimeterKm: math.Round(perimeter/1e3*1000) / 1000,
+	}, nil
+}
+
+func (s *Server) handleArea(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	res, err := s.areaOf(r.Context(), code)
+	if err != nil {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		writeQueryError(w, "area", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, AreaRes{
+		Code: 200,
+		Msg:  "success",
+		Data: res,
+	})
+}
@@ -0,0 +1,158 @@
+// spatialite.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// STORAGE=spatialite：把 GADM 多边形导入 SpatiaLite 库并建空间索引，
+// 点面判断在 SQL 中用 ST_Covers 完成，不再在 Go 中逐个解码 GeoPackage blob。
+// 名称等属性仍从 GeoPackage 按 rowid 读取，其他接口不受影响
+
+const spatialiteDriver = "sqlite3_spatialite"
+
+var registerSpatialite sync.Once
+
+type spatialStore struct {
+	db *sql.DB
+}
+
+// 打开 path 处的 SpatiaLite 库；不存在或比 GeoPackage 旧时重新导入
+func openSpatialite(path, extension, gpkgPath, table, geomCol string) (*spatialStore, error) {
+	registerSpatialite.Do(func() {
+		sql.Register(spatialiteDriver, &sqlite3.SQLiteDriver{Extensions: []string{extension}})
+	})
+
+	src, err := os.Stat(gpkgPath)
+	if err != nil {
+		return nil, err
+	}
+	if st, err := os.Stat(path); err != nil || st.ModTime().Before(src.ModTime()) {
+		start := time.Now()
+		if err := buildSpatialite(path, gpkgPath, table, geomCol); err != nil {
+			return nil, err
+		}
+		log.Printf("spatialite: imported %s into %s in %s", gpkgPath, path, time.Since(start).Round(time.Second))
+	}
+
+	db, err := sql.Open(spatialiteDriver, fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, err
+	}
+	// 加载扩展失败时在这里报错，而不是等到第一次反查
+	var version string
+	if err := db.QueryRow(`SELECT spatialite_version()`).Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load %s: %w", extension, err)
+	}
+	log.Println("spatialite storage:", path, "version", version)
+	return &spatialStore{db: db}, nil
+}
+
+// 先写到临时文件，完成后再改名，中途失败不会留下半个库
+func buildSpatialite(path, gpkgPath, table, geomCol string) (err error) {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	db, err := sql.Open(spatialiteDriver, tmp)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// ATTACH 只对当前连接有效
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("spatialite import: %w", err)
+	}
+	defer conn.Close()
+
+	stmts := []string{
+		`SELECT InitSpatialMetaData(1)`,
+		`CREATE TABLE areas (id INTEGER PRIMARY KEY)`,
+		`SELECT AddGeometryColumn('areas', 'geom', 4326, 'MULTIPOLYGON', 'XY')`,
+		fmt.Sprintf(`ATTACH DATABASE '%s' AS src`, strings.ReplaceAll(gpkgPath, "'", "''")),
+		`BEGIN`,
+		// build 命令预处理的库中几何为 WKB，其余为 GeoPackage 二进制
+		fmt.Sprintf(`INSERT INTO areas (id, geom)
+SELECT rowid, SetSRID(CastToMultiPolygon(CASE WHEN substr(%[1]s, 1, 2) = X'4750' THEN GeomFromGPB(%[1]s) ELSE GeomFromWKB(%[1]s) END), 4326)
+FROM src.%[2]s
+WHERE %[1]s IS NOT NULL`, geomCol, table),
+		`COMMIT`,
+		`DETACH DATABASE src`,
+		`SELECT CreateSpatialIndex('areas', 'geom')`,
+	}
+	for _, stmt := range stmts {
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("spatialite import: %w", err)
+		}
+	}
+	conn.Close()
+	db.Close()
+	return os.Rename(tmp, path)
+}
+
+// 包含点的叶子行的 rowid；边界上的点也算命中，同 Go 实现的 planar.MultiPolygonContains
+func (st *spatialStore) containing(ctx context.Context, lon, lat float64) (int64, error) {
+	var id int64
+	err := st.db.QueryRowContext(ctx, `
+SELECT id FROM areas
+WHERE id IN (
+    SELECT rowid FROM SpatialIndex
+    WHERE f_table_name = 'areas' AND f_geometry_column = 'geom' AND search_frame = MakePoint(?, ?, 4326)
+)
+  AND ST_Covers(geom, MakePoint(?, ?, 4326)) = 1
+LIMIT 1;`, lon, lat, lon, lat).Scan(&id)
+	return id, err
+}
+
+// 按 rowid 读取属性，名称列随 mode 变化
+func (s *Server) rowSQL(mode nameMode) string {
+	names := make([]string, 0, 6)
+	for lvl := 0; lvl <= 5; lvl++ {
+		names = append(names, s.nameExpr(lvl, mode))
+	}
+	return fmt.Sprintf(`
+SELECT a.GID_0, a.GID_1, a.GID_2, a.GID_3, a.GID_4, a.GID_5,
+       %s
+FROM %s AS a
+WHERE a.rowid = ?;`, strings.Join(names, ", "), s.table)
+}
+
+// SpatiaLite 模式的反查；不带几何，需要边界时由 attachGeometry 另行读取
+func (s *Server) reverseSpatialite(ctx context.Context, rlon, rlat float64, mode nameMode) (*AdminLevels, error) {
+	id, err := s.spatial.containing(ctx, rlon, rlat)
+	if err != nil {
+		return nil, err
+	}
+	var c candidate
+	dest := make([]any, 0, 12)
+	for i := range c.gids {
+		dest = append(dest, &c.gids[i])
+	}
+	for i := range c.names {
+		dest = append(dest, &c.names[i])
+	}
+	if err := s.db.QueryRowContext(ctx, s.sqlRow[mode], id).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("spatialite row %d not in %s, delete the spatialite database to rebuild it", id, s.table)
+		}
+		return nil, err
+	}
+	return c.adminLevels(), nil
+}
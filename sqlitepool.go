@@ -0,0 +1,115 @@
+// sqlitepool.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// 数据集库的连接池：文件以 mode=ro&immutable=1 打开，SQLite 不加锁也不检查文件变化，
+// 多个连接可以同时读，不必再用一个连接串行处理所有请求。
+//   - DB_MAX_OPEN_CONNS：最大连接数，默认 CPU 核数；1 即原来的单连接
+//   - DB_MAX_IDLE_CONNS：保留的空闲连接数，默认同最大连接数（每个连接有自己的页缓存，关掉就丢了）
+//   - SQLITE_MMAP_SIZE：每个连接 mmap 的字节数，默认 256 MiB，0 为不用 mmap。
+//     多个连接映射同一个文件共用操作系统的页缓存，比各自的页缓存省内存
+//   - SQLITE_CACHE_KB：每个连接的页缓存（KiB），默认用 SQLite 的 2000
+//   - DB_SHARDS：把连接分到几个独立的 *sql.DB，默认 CPU 核数的四分之一（至少 1）。
+//     同一个 *sql.DB 取连接、预编译语句找连接都要抢它内部的锁，并发很高时成为瓶颈；
+//     请求路径上的查询（见 stmt.go）随机选一个分片，各分片的锁互不相干。
+//     DB_MAX_OPEN_CONNS 平均分给各分片（向上取整），总连接数基本不变
+//
+// 每个新连接执行 query_only 等只读 pragma，见 datasetPragmas
+const datasetDriver = "sqlite3_dataset"
+
+var (
+	registerDatasetDriver sync.Once
+	datasetDriverErr      error
+)
+
+func datasetPragmas() ([]string, error) {
+	pragmas := []string{`PRAGMA query_only = 1;`, `PRAGMA temp_store = MEMORY;`}
+	mmap, err := strconv.ParseInt(env("SQLITE_MMAP_SIZE", "268435456"), 10, 64)
+	if err != nil || mmap < 0 {
+		return nil, fmt.Errorf("invalid SQLITE_MMAP_SIZE")
+	}
+	pragmas = append(pragmas, fmt.Sprintf(`PRAGMA mmap_size = %d;`, mmap))
+	if v := env("SQLITE_CACHE_KB", ""); v != "" {
+		kb, err := strconv.Atoi(v)
+		if err != nil || kb <= 0 {
+			return nil, fmt.Errorf("invalid SQLITE_CACHE_KB")
+		}
+		// 负数为 KiB
+		pragmas = append(pragmas, fmt.Sprintf(`PRAGMA cache_size = -%d;`, kb))
+	}
+	return pragmas, nil
+}
+
+// 只读打开数据集库的各分片，第一个用于请求路径以外的查询；热更新时每个版本各开一组
+func openDatasetDB(path string) ([]*sql.DB, error) {
+	registerDatasetDriver.Do(func() {
+		var pragmas []string
+		if pragmas, datasetDriverErr = datasetPragmas(); datasetDriverErr != nil {
+			return
+		}
+		sql.Register(datasetDriver, &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				for _, p := range pragmas {
+					if _, err := conn.Exec(p, nil); err != nil {
+						return fmt.Errorf("%s: %w", p, err)
+					}
+				}
+				return nil
+			},
+		})
+	})
+	if datasetDriverErr != nil {
+		return nil, datasetDriverErr
+	}
+	maxOpen, err := strconv.Atoi(env("DB_MAX_OPEN_CONNS", strconv.Itoa(runtime.NumCPU())))
+	if err != nil || maxOpen < 1 {
+		return nil, fmt.Errorf("invalid DB_MAX_OPEN_CONNS")
+	}
+	maxIdle, err := strconv.Atoi(env("DB_MAX_IDLE_CONNS", strconv.Itoa(maxOpen)))
+	if err != nil || maxIdle < 0 {
+		return nil, fmt.Errorf("invalid DB_MAX_IDLE_CONNS")
+	}
+	shards, err := strconv.Atoi(env("DB_SHARDS", strconv.Itoa(max(1, runtime.NumCPU()/4))))
+	if err != nil || shards < 1 {
+		return nil, fmt.Errorf("invalid DB_SHARDS")
+	}
+	shards = min(shards, maxOpen)
+	perOpen := (maxOpen + shards - 1) / shards
+	perIdle := (maxIdle + shards - 1) / shards
+
+	// 不用 cache=shared：热更新时新旧数据集路径相同，共享缓存会读到旧文件的页
+	dsn := fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000&immutable=1", path)
+	dbs := make([]*sql.DB, 0, shards)
+	for i := 0; i < shards; i++ {
+		db, err := sql.Open(datasetDriver, dsn)
+		if err == nil {
+			db.SetMaxOpenConns(perOpen)
+			db.SetMaxIdleConns(perIdle)
+			db.SetConnMaxIdleTime(5 * time.Minute)
+			// 提前建一个连接，pragma 出错时启动即失败
+			if err = db.Ping(); err != nil {
+				db.Close()
+			}
+		}
+		if err != nil {
+			for _, db := range dbs {
+				db.Close()
+			}
+			return nil, err
+		}
+		dbs = append(dbs, db)
+	}
+	log.Printf("dataset db: %s, %d shards × %d connections", path, shards, perOpen)
+	return dbs, nil
+}
@@ -0,0 +1,226 @@
+// stats.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 每层的行政区数量
+type LevelCount struct {
+	Level int    `json:"level"`
+	Name  string `json:"name"`
+	Areas int    `json:"areas"`
+	// 该层为最末级的叶子行数
+	Leaves int `json:"leaves"`
+}
+
+// 每个国家的层级深度
+type CountryDepth struct {
+	GID      string `json:"code"`
+	Name     string `json:"name"`
+	MaxLevel int    `json:"maxLevel"`
+	Rows     int    `json:"rows"`
+}
+
+// 几何 blob 大小分布（字节）
+type GeometrySizes struct {
+	Total int64 `json:"total"`
+	Min   int   `json:"min"`
+	Avg   int   `json:"avg"`
+	P50   int   `json:"p50"`
+	P90   int   `json:"p90"`
+	P99   int   `json:"p99"`
+	Max   int   `json:"max"`
+	// 最大的几个叶子
+	Largest []LeafSize `json:"largest"`
+}
+
+type LeafSize struct {
+	GID   string `json:"code"`
+	Bytes int    `json:"bytes"`
+}
+
+type ElevationCacheStats struct {
+	Cached   int     `json:"cached"`
+	Areas    int     `json:"areas"`
+	FillRate float64 `json:"fillRate"`
+}
+
+type DatasetStats struct {
+	Table      string         `json:"table"`
+	Rows       int            `json:"rows"`
+	Levels     []LevelCount   `json:"levels"`
+	Countries  []CountryDepth `json:"countries"`
+	Geometry   GeometrySizes  `json:"geometry"`
+	ComputedAt time.Time      `json:"computedAt"`
+	// 每次请求实时计算
+	ElevationCache ElevationCacheStats `json:"elevationCache"`
+}
+
+type StatsRes struct {
+	Code int           `json:"code"`
+	Msg  string        `json:"msg"`
+	Data *DatasetStats `json:"data"`
+}
+
+/************* 数据集统计（GPKG 只读，首次请求时计算一次） *************/
+func (s *Server) computeStats() (*DatasetStats, error) {
+	levelName := levelNameMap()
+	st := &DatasetStats{Table: s.table, ComputedAt: time.Now().UTC()}
+
+	// 每层不同 GID 的个数
+	counts := make([]string, 0, 6)
+	for lvl := 0; lvl <= 5; lvl++ {
+		counts = append(counts, fmt.Sprintf("COUNT(DISTINCT NULLIF(GID_%d, ''))", lvl))
+	}
+	var areas [6]int
+	sqlStr := fmt.Sprintf("SELECT COUNT(*), %s FROM %s;", strings.Join(counts, ", "), s.table)
+	if err := s.db.QueryRow(sqlStr).Scan(&st.Rows, &areas[0], &areas[1], &areas[2], &areas[3], &areas[4], &areas[5]); err != nil {
+		return nil, err
+	}
+
+	// 每行的最末级，按国家汇总得到深度，按层汇总得到叶子数
+	depth := "CASE"
+	for lvl := 5; lvl >= 1; lvl-- {
+		depth += fmt.Sprintf(" WHEN GID_%d <> '' THEN %d", lvl, lvl)
+	}
+	depth += " ELSE 0 END"
+	sqlStr = fmt.Sprintf(`
+SELECT GID_0, MAX(NAME_0), %s AS depth, COUNT(*)
+FROM %s
+GROUP BY GID_0, depth;`, depth, s.table)
+	rows, err := s.db.Query(sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	var leaves [6]int
+	byCountry := make(map[string]*CountryDepth)
+	for rows.Next() {
+		var (
+			gid, name string
+			lvl, n    int
+		)
+		if err := rows.Scan(&gid, &name, &lvl, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		leaves[lvl] += n
+		c, ok := byCountry[gid]
+		if !ok {
+			c = &CountryDepth{GID: gid, Name: name}
+			byCountry[gid] = c
+		}
+		c.MaxLevel = max(c.MaxLevel, lvl)
+		c.Rows += n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for lvl := 0; lvl <= 5; lvl++ {
+		st.Levels = append(st.Levels, LevelCount{Level: lvl, Name: levelName[lvl], Areas: areas[lvl], Leaves: leaves[lvl]})
+	}
+	st.Countries = make([]CountryDepth, 0, len(byCountry))
+	for _, c := range byCountry {
+		st.Countries = append(st.Countries, *c)
+	}
+	sort.Slice(st.Countries, func(i, j int) bool { return st.Countries[i].GID < st.Countries[j].GID })
+
+	// 几何大小：只读 length()，不取 blob
+	sizes, largest, err := s.geometrySizes(5)
+	if err != nil {
+		return nil, err
+	}
+	st.Geometry = summarizeSizes(sizes)
+	st.Geometry.Largest = largest
+	return st, nil
+}
+
+func (s *Server) geometrySizes(topN int) ([]int, []LeafSize, error) {
+	leaf := "COALESCE(NULLIF(GID_5, ''), NULLIF(GID_4, ''), NULLIF(GID_3, ''), NULLIF(GID_2, ''), NULLIF(GID_1, ''), GID_0)"
+	sqlStr := fmt.Sprintf("SELECT length(%s), %s FROM %s ORDER BY length(%s) DESC;", s.geomCol, leaf, s.table, s.geomCol)
+	rows, err := s.db.Query(sqlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var (
+		sizes   []int
+		largest []LeafSize
+	)
+	for rows.Next() {
+		var (
+			n   int
+			gid string
+		)
+		if err := rows.Scan(&n, &gid); err != nil {
+			return nil, nil, err
+		}
+		sizes = append(sizes, n)
+		if len(largest) < topN {
+			largest = append(largest, LeafSize{GID: gid, Bytes: n})
+		}
+	}
+	return sizes, largest, rows.Err()
+}
+
+// sizes 已按降序排列
+func summarizeSizes(sizes []int) GeometrySizes {
+	var g GeometrySizes
+	if len(sizes) == 0 {
+		return g
+	}
+	for _, n := range sizes {
+		g.Total += int64(n)
+	}
+	pct := func(p float64) int {
+		i := int(math.Ceil(p*float64(len(sizes)))) - 1
+		return sizes[len(sizes)-1-max(0, i)]
+	}
+	g.Max = sizes[0]
+	g.Min = sizes[len(sizes)-1]
+	g.Avg = int(g.Total / int64(len(sizes)))
+	g.P50, g.P90, g.P99 = pct(0.5), pct(0.9), pct(0.99)
+	return g
+}
+
+func (s *Server) elevationCacheStats(st *DatasetStats) (ElevationCacheStats, error) {
+	var e ElevationCacheStats
+	if err := s.elevationDB.QueryRow("SELECT COUNT(*) FROM elevations;").Scan(&e.Cached); err != nil {
+		return e, err
+	}
+	for _, l := range st.Levels {
+		e.Areas += l.Areas
+	}
+	if e.Areas > 0 {
+		e.FillRate = math.Round(float64(e.Cached)/float64(e.Areas)*1e4) / 1e4
+	}
+	return e, nil
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, _ *http.Request) {
+	base, err := s.stats()
+	if err != nil {
+		log.Println("stats error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	st := *base
+	if st.ElevationCache, err = s.elevationCacheStats(&st); err != nil {
+		log.Println("stats error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, StatsRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &st,
+	})
+}
@@ -0,0 +1,73 @@
+// stmt.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+	"sync"
+)
+
+// 预编译语句缓存：按 SQL 文本缓存 *sql.Stmt，每条语句只解析一次，之后的请求直接复用。
+// SQL 文本只随表名、层级、名称模式等有限的组合变化，参数一律用占位符，缓存不会无限增长。
+// 库有多个分片时（见 sqlitepool.go）每次随机选一个，各分片分别预编译。
+// 随数据集版本关闭（见 close）
+type stmtCache struct {
+	dbs    []*sql.DB
+	shards []sync.Map // 与 dbs 对应，SQL → *sql.Stmt
+}
+
+func newStmtCache(dbs []*sql.DB) *stmtCache {
+	return &stmtCache{dbs: dbs, shards: make([]sync.Map, len(dbs))}
+}
+
+func (c *stmtCache) prepare(query string) (*sql.Stmt, error) {
+	i := 0
+	if len(c.dbs) > 1 {
+		i = rand.IntN(len(c.dbs))
+	}
+	stmts := &c.shards[i]
+	if st, ok := stmts.Load(query); ok {
+		return st.(*sql.Stmt), nil
+	}
+	st, err := c.dbs[i].Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if prev, loaded := stmts.LoadOrStore(query, st); loaded {
+		st.Close()
+		return prev.(*sql.Stmt), nil
+	}
+	return st, nil
+}
+
+// ctx 为请求的上下文：请求超时或客户端断开时中止查询（见 httpserver.go）
+func (c *stmtCache) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	st, err := c.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return st.QueryContext(ctx, args...)
+}
+
+// 预编译失败时错误在 Scan 时返回，与 db.QueryRow 相同
+func (c *stmtCache) queryRow(ctx context.Context, query string, args ...any) rowScanner {
+	st, err := c.prepare(query)
+	if err != nil {
+		return errRow{err}
+	}
+	return st.QueryRowContext(ctx, args...)
+}
+
+func (c *stmtCache) close() {
+	for i := range c.shards {
+		c.shards[i].Range(func(_, st any) bool {
+			st.(*sql.Stmt).Close()
+			return true
+		})
+	}
+}
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
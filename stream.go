@@ -0,0 +1,108 @@
+// stream.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// 流式 JSON：整国的 /tree、不简化的 /boundary、带边界的 /children?format=geojson 边查询边编码输出，
+// 不在内存中先拼出完整的结构，也不先把整个响应编码到缓冲区。
+// 写入 http.ResponseWriter 在客户端读得慢时阻塞，查询随之暂停（背压），占用的内存只与单个元素有关。
+// 输出与整体编码时逐字节相同（键的顺序、转义、末尾换行）。
+// 只用于 JSON 响应，协商到 XML、MessagePack 等格式时照常整体编码。
+// 响应头推迟到第一次写入时发出，之前出错仍可返回正常的错误响应；之后出错只能中断连接，
+// 客户端收到不完整的响应（chunked 没有结束块），不会误当作完整结果
+const jsonStreamBuffer = 32 << 10
+
+type jsonStream struct {
+	w           http.ResponseWriter
+	bw          *bufio.Writer
+	contentType string
+	envelope    bool // 带 {code,msg,data} 外壳
+	started     bool
+}
+
+// 该响应是否按 JSON 输出，v 为整体编码时的响应值（用于判断是否有 proto 消息）
+func streamsJSON(w http.ResponseWriter, v any) bool {
+	return responseFormatOf(w, v) == formatJSON
+}
+
+func newJSONStream(w http.ResponseWriter, contentType string, envelope bool) *jsonStream {
+	return &jsonStream{w: w, bw: bufio.NewWriterSize(w, jsonStreamBuffer), contentType: contentType, envelope: envelope}
+}
+
+// 原样写出一段 JSON 文本；客户端断开后返回 errClientGone，调用方据此停止查询
+func (st *jsonStream) raw(s string) error {
+	st.start()
+	if _, err := st.bw.WriteString(s); err != nil {
+		return errClientGone
+	}
+	return nil
+}
+
+// 写出一个值的 JSON 编码
+func (st *jsonStream) value(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	st.start()
+	if _, err := st.bw.Write(b); err != nil {
+		return errClientGone
+	}
+	return nil
+}
+
+func (st *jsonStream) start() {
+	if !st.started {
+		st.w.Header().Set("Content-Type", st.contentType)
+		st.w.WriteHeader(http.StatusOK)
+		st.started = true
+	}
+}
+
+// 外壳 {"code":200,"msg":"success","data": 的开头；不带外壳时为空
+func (st *jsonStream) open() {
+	if st.envelope {
+		st.raw(`{"code":200,"msg":"success","data":`)
+	}
+}
+
+// 闭合外壳，与 json.Encoder 一样以换行结束
+func (st *jsonStream) close() {
+	if st.envelope {
+		st.raw("}")
+	}
+	st.raw("\n")
+	st.bw.Flush()
+}
+
+// 输出途中出错：尚未输出时返回正常的错误响应，否则中断连接
+func (st *jsonStream) fail(what string, err error) {
+	// 客户端断开时查询随请求上下文取消
+	if errors.Is(err, errClientGone) || errors.Is(err, context.Canceled) {
+		return
+	}
+	if !st.started {
+		if strings.Contains(err.Error(), "gid not found") {
+			writeErrorJSON(st.w, http.StatusNotFound, 404, "not found")
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeErrorJSON(st.w, http.StatusServiceUnavailable, 503, "request timeout")
+			return
+		}
+		log.Println(what+" error:", err)
+		writeErrorJSON(st.w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	log.Println(what+" error after output started:", err)
+	st.bw.Flush()
+	panic(http.ErrAbortHandler)
+}
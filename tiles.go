@@ -0,0 +1,313 @@
+// tiles.go
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/simplify"
+)
+
+const maxTileZoom = 22
+
+// 各层级开始显示的最小缩放级别：z0-4 国家，z5-6 省，z7-8 市，z9-10 区，z11-12 村，z13+ 第 5 级
+var tileLevelMinZoom = [6]int{0, 5, 7, 9, 11, 13}
+
+// 瓦片坐标中保留的缓冲（4096 为一个瓦片宽），避免线宽在瓦片边缘被截断
+var tileClipBound = orb.Bound{
+	Min: orb.Point{-64, -64},
+	Max: orb.Point{mvt.DefaultExtent + 64, mvt.DefaultExtent + 64},
+}
+
+func tileLevel(z int) int {
+	level := 0
+	for l, minZoom := range tileLevelMinZoom {
+		if z >= minZoom {
+			level = l
+		}
+	}
+	return level
+}
+
+// 已编码瓦片的 LRU 缓存
+type tileCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type tileEntry struct {
+	key  string
+	data []byte
+}
+
+func newTileCache(max int) *tileCache {
+	return &tileCache{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *tileCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*tileEntry).data, true
+}
+
+func (c *tileCache) put(key string, data []byte) {
+	if c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*tileEntry).data = data
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&tileEntry{key: key, data: data})
+	for c.order.Len() > c.max {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*tileEntry).key)
+	}
+}
+
+/************* 生成瓦片 *************/
+
+// 单个瓦片最多参与绘制的行政区数（读每层表时）或叶子数，超过时拒绝，不在一个请求里解码整片大陆的叶子
+const maxTileAreas = 5000
+
+var errTileTooComplex = fmt.Errorf("tile covers too many areas, at most %d, use a higher zoom or a lower level", maxTileAreas)
+
+// 瓦片中的一个行政区
+type tileArea struct {
+	item ChildrenItem
+	geom orb.MultiPolygon
+}
+
+// 一个 "admin" 图层，每个行政区一个要素；没有要素时返回 nil
+func (s *Server) renderTile(ctx context.Context, tile maptile.Tile, level int, mode nameMode) ([]byte, error) {
+	bound := tile.Bound(1.0 / 64)
+	// 先按一个瓦片像素（1/4096 瓦片宽）在经纬度上简化并裁剪，低缩放级别不必投影全部顶点
+	tolerance := (tile.Bound().Max.Lon() - tile.Bound().Min.Lon()) / mvt.DefaultExtent
+	var (
+		areas []tileArea
+		err   error
+	)
+	if s.levelGeomCovers(tolerance) {
+		areas, err = s.levelTileAreas(ctx, bound, level, mode, tolerance)
+	} else {
+		areas, err = s.leafTileAreas(bound, level, mode, tolerance)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fc := geojson.NewFeatureCollection()
+	for _, a := range areas {
+		f := geojson.NewFeature(a.geom)
+		f.Properties["code"] = a.item.GID
+		f.Properties["name"] = a.item.Name
+		f.Properties["parentCode"] = a.item.ParentCode
+		f.Properties["level"] = a.item.Level
+		fc.Append(f)
+	}
+	if len(fc.Features) == 0 {
+		return nil, nil
+	}
+
+	layers := mvt.NewLayers(map[string]*geojson.FeatureCollection{"admin": fc})
+	layers.ProjectToTile(tile)
+	layers.Clip(tileClipBound)
+	layers.Simplify(simplify.DouglasPeucker(1.0))
+	layers.RemoveEmpty(1.0, 1.0)
+	for _, f := range layers[0].Features {
+		windForMVT(f.Geometry)
+	}
+	return mvt.Marshal(layers)
+}
+
+// 预处理的库中每层表里合并、简化好的几何（见 build.go），每个行政区读一行
+func (s *Server) levelTileAreas(ctx context.Context, bound orb.Bound, level int, mode nameMode, tolerance float64) ([]tileArea, error) {
+	nameCol := "l.name"
+	if mode != nameLatin {
+		nameCol = fmt.Sprintf(`(SELECT %s FROM "%s" WHERE GID_%d = l.gid LIMIT 1)`, s.nameExpr(level, mode), s.table, level)
+	}
+	sqlStr := fmt.Sprintf(`
+SELECT l.gid, %s, l.parent, l.geom
+FROM "%s" AS l
+WHERE l.geom IS NOT NULL AND l.minx <= ? AND l.maxx >= ? AND l.miny <= ? AND l.maxy >= ?
+LIMIT %d;`, nameCol, levelTableName(s.table, level), maxTileAreas+1)
+	rows, err := s.db.QueryContext(ctx, sqlStr, bound.Max.Lon(), bound.Min.Lon(), bound.Max.Lat(), bound.Min.Lat())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var (
+		areas []tileArea
+		n     int
+	)
+	for rows.Next() {
+		a := tileArea{item: ChildrenItem{Level: levelNameMap()[level]}}
+		var blob []byte
+		if err := rows.Scan(&a.item.GID, &a.item.Name, &a.item.ParentCode, &blob); err != nil {
+			return nil, err
+		}
+		if n++; n > maxTileAreas {
+			return nil, errTileTooComplex
+		}
+		mp, err := decodeMultiPolygon(blob)
+		if err != nil {
+			continue
+		}
+		if tolerance > s.builtTolerance {
+			mp = simplifyShape(mp, tolerance)
+		}
+		if a.geom = clip.MultiPolygon(bound, mp); len(a.geom) > 0 {
+			areas = append(areas, a)
+		}
+	}
+	return areas, rows.Err()
+}
+
+// 从叶子拼出各行政区：只有一个叶子落在瓦片中时取预简化的几何（见 resolutions.go）；
+// 有多个时把原始几何裁剪到瓦片后合并（见 dissolve），不画出叶子之间的边界，再简化
+func (s *Server) leafTileAreas(bound orb.Bound, level int, mode nameMode, tolerance float64) ([]tileArea, error) {
+	rows, err := s.levelRowsIn(bound, level, mode)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > maxTileAreas {
+		return nil, errTileTooComplex
+	}
+	var order []string
+	byGID := make(map[string][]levelRow)
+	for _, row := range rows {
+		if _, ok := byGID[row.item.GID]; !ok {
+			order = append(order, row.item.GID)
+		}
+		byGID[row.item.GID] = append(byGID[row.item.GID], row)
+	}
+	areas := make([]tileArea, 0, len(order))
+	for _, gid := range order {
+		leaves := byGID[gid]
+		a := tileArea{item: leaves[0].item}
+		if len(leaves) == 1 {
+			if mp, err := s.leafGeomAt(leaves[0].rowid, tolerance); err == nil {
+				a.geom = clip.MultiPolygon(bound, simplifyShape(mp, tolerance))
+			}
+		} else {
+			a.geom = s.dissolvedTileGeom(bound, leaves, tolerance)
+		}
+		if len(a.geom) > 0 {
+			areas = append(areas, a)
+		}
+	}
+	return areas, nil
+}
+
+// 合并失败时退回各叶子分别简化后拼接
+func (s *Server) dissolvedTileGeom(bound orb.Bound, leaves []levelRow, tolerance float64) orb.MultiPolygon {
+	var clipped orb.MultiPolygon
+	for _, row := range leaves {
+		if mp, err := s.leafGeom(row.rowid); err == nil {
+			clipped = append(clipped, clip.MultiPolygon(bound, mp)...)
+		}
+	}
+	if mp, _, err := dissolve(clipped); err == nil {
+		return simplifyShape(mp, tolerance)
+	}
+	var out orb.MultiPolygon
+	for _, row := range leaves {
+		if mp, err := s.leafGeomAt(row.rowid, tolerance); err == nil {
+			out = append(out, clip.MultiPolygon(bound, simplifyShape(mp, tolerance))...)
+		}
+	}
+	return out
+}
+
+// MVT 按环的方向区分外环和洞：瓦片坐标（y 向下）中外环面积为正，即 orb 计算的 CCW，洞相反。
+// GADM 的环方向不统一，编码前统一调整
+func windForMVT(g orb.Geometry) {
+	var polys []orb.Polygon
+	switch gg := g.(type) {
+	case orb.Polygon:
+		polys = []orb.Polygon{gg}
+	case orb.MultiPolygon:
+		polys = gg
+	}
+	for _, poly := range polys {
+		for i, ring := range poly {
+			want := orb.CCW
+			if i > 0 {
+				want = orb.CW
+			}
+			if ring.Orientation() != want {
+				ring.Reverse()
+			}
+		}
+	}
+}
+
+// /tiles/{z}/{x}/{y}.pbf
+func (s *Server) handleTile(w http.ResponseWriter, r *http.Request) {
+	z, errZ := strconv.Atoi(r.PathValue("z"))
+	x, errX := strconv.Atoi(r.PathValue("x"))
+	y, errY := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(r.PathValue("y"), ".pbf"), ".mvt"))
+	if errZ != nil || errX != nil || errY != nil || z < 0 || z > maxTileZoom {
+		writeErrorJSON(w, http.StatusBadRequest, 400, fmt.Sprintf("invalid tile, use /tiles/{z}/{x}/{y}.pbf with z in 0..%d", maxTileZoom))
+		return
+	}
+	if x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "tile x/y out of range for zoom")
+		return
+	}
+	level := tileLevel(z)
+	if v := strings.TrimSpace(r.URL.Query().Get("level")); v != "" {
+		var err error
+		if level, err = strconv.Atoi(v); err != nil || level < 0 || level > 5 {
+			writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+			return
+		}
+	}
+	mode := parseNameMode(r)
+	tile := maptile.New(uint32(x), uint32(y), maptile.Zoom(z))
+
+	key := fmt.Sprintf("%d/%d/%d/%d/%d", z, x, y, level, mode)
+	data, ok := s.tiles.get(key)
+	if !ok {
+		var err error
+		if data, err = s.renderTile(r.Context(), tile, level, mode); err != nil {
+			if errors.Is(err, errTileTooComplex) {
+				writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+				return
+			}
+			writeQueryError(w, "tile", err)
+			return
+		}
+		s.tiles.put(key, data)
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	if len(data) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
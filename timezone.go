@@ -0,0 +1,209 @@
+// timezone.go
+package main
+
+import (
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // 运行镜像（alpine）不带 tzdata
+)
+
+// 随程序打包的 tzdb zone.tab（公有领域）和按一级行政区指定时区的覆盖表
+//
+//go:embed tz/zone.tab tz/overrides.csv
+var tzFS embed.FS
+
+type TimezoneInfo struct {
+	ID            string `json:"id"`
+	Abbreviation  string `json:"abbreviation"`
+	UTCOffset     string `json:"utcOffset"`
+	OffsetSeconds int    `json:"offsetSeconds"`
+}
+
+// 时区只在能确定时给出：国家的各时区全年偏移和缩写都相同（包括只有一个时区）时取 zone.tab 中该国的第一个，
+// 或该一级行政区在覆盖表中；其余（多时区国家中没有覆盖的省、没有 ISO 代码的争议地区等）不给出，不按最近的城市猜
+type tzIndex struct {
+	byCountry map[string]string // GID_0（ISO alpha3）
+	byGID1    map[string]string
+}
+
+func readTZOverrides(r io.Reader) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 && strings.EqualFold(rows[0][0], "gid0") {
+		rows = rows[1:]
+	}
+	return rows, nil
+}
+
+// 加载时区索引；extraPath（TZ_OVERRIDES_PATH）为可选的附加覆盖表，格式同 tz/overrides.csv
+func (s *Server) loadTimezones(extraPath string) (*tzIndex, error) {
+	isoT, err := loadISOTables()
+	if err != nil {
+		return nil, err
+	}
+	idx := &tzIndex{byCountry: make(map[string]string), byGID1: make(map[string]string)}
+
+	data, err := tzFS.ReadFile("tz/zone.tab")
+	if err != nil {
+		return nil, err
+	}
+	zones := make(map[string][]string)
+	var countries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) < 3 {
+			continue
+		}
+		c, ok := isoT.byAlpha2[f[0]]
+		if !ok {
+			continue
+		}
+		if _, seen := zones[c.Alpha3]; !seen {
+			countries = append(countries, c.Alpha3)
+		}
+		zones[c.Alpha3] = append(zones[c.Alpha3], f[2])
+	}
+	now := time.Now()
+	for _, c := range countries {
+		same, err := sameClock(zones[c], now)
+		if err != nil {
+			return nil, fmt.Errorf("zone.tab: %w", err)
+		}
+		if same {
+			idx.byCountry[c] = zones[c][0]
+		}
+	}
+
+	f, err := tzFS.Open("tz/overrides.csv")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := readTZOverrides(f)
+	if err != nil {
+		return nil, fmt.Errorf("tz/overrides.csv: %w", err)
+	}
+	if extraPath != "" {
+		ef, err := os.Open(extraPath)
+		if err != nil {
+			return nil, err
+		}
+		defer ef.Close()
+		extra, err := readTZOverrides(ef)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", extraPath, err)
+		}
+		rows = append(rows, extra...)
+	}
+
+	// 覆盖表按名称书写，启动时换算成 GID_1，查询时与 lang 无关
+	byName := make(map[string]map[string]string)
+	for _, r := range rows {
+		gid0, name1, tz := strings.TrimSpace(r[0]), strings.TrimSpace(r[1]), strings.TrimSpace(r[2])
+		if _, err := time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("timezone override %s/%s: %w", gid0, name1, err)
+		}
+		if byName[gid0] == nil {
+			byName[gid0] = make(map[string]string)
+		}
+		byName[gid0][strings.ToLower(name1)] = tz
+	}
+	for gid0, names := range byName {
+		sqlStr := fmt.Sprintf("SELECT DISTINCT GID_1, NAME_1 FROM %s WHERE GID_0 = ? AND GID_1 <> '';", s.table)
+		dbRows, err := s.db.Query(sqlStr, gid0)
+		if err != nil {
+			return nil, err
+		}
+		for dbRows.Next() {
+			var gid1, name1 string
+			if err := dbRows.Scan(&gid1, &name1); err != nil {
+				dbRows.Close()
+				return nil, err
+			}
+			if tz, ok := names[strings.ToLower(name1)]; ok {
+				idx.byGID1[gid1] = tz
+			}
+		}
+		dbRows.Close()
+		if err := dbRows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// 这些时区在 now 起的一年内每天的偏移和缩写是否都相同，如德国的 Europe/Berlin 与 Europe/Busingen
+func sameClock(ids []string, now time.Time) (bool, error) {
+	locs := make([]*time.Location, len(ids))
+	for i, id := range ids {
+		loc, err := time.LoadLocation(id)
+		if err != nil {
+			return false, err
+		}
+		locs[i] = loc
+	}
+	for d := 0; d < 366; d++ {
+		t := now.AddDate(0, 0, d)
+		abbr, offset := t.In(locs[0]).Zone()
+		for _, loc := range locs[1:] {
+			if a, o := t.In(loc).Zone(); a != abbr || o != offset {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// 按国家和一级行政区确定时区，不能确定时为空
+func (idx *tzIndex) lookup(gid0, gid1 string) string {
+	if tz, ok := idx.byGID1[gid1]; ok && gid1 != "" {
+		return tz
+	}
+	return idx.byCountry[gid0]
+}
+
+// 当前时刻的偏移，含夏令时
+func timezoneInfo(id string, now time.Time) *TimezoneInfo {
+	loc, err := time.LoadLocation(id)
+	if err != nil {
+		return nil
+	}
+	abbr, offset := now.In(loc).Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+	}
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	return &TimezoneInfo{
+		ID:            id,
+		Abbreviation:  abbr,
+		UTCOffset:     fmt.Sprintf("%c%02d:%02d", sign, abs/3600, abs%3600/60),
+		OffsetSeconds: offset,
+	}
+}
+
+func (s *Server) timezoneOf(gid0, gid1 string) *TimezoneInfo {
+	if s.tz == nil {
+		return nil
+	}
+	id := s.tz.lookup(gid0, gid1)
+	if id == "" {
+		return nil
+	}
+	return timezoneInfo(id, time.Now())
+}
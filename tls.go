@@ -0,0 +1,128 @@
+// tls.go
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// 证书文件被替换（如 certbot 续期）后自动重新加载，最多每分钟检查一次
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	st, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime = &cert, st.ModTime()
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) > time.Minute {
+		c.checked = time.Now()
+		if st, err := os.Stat(c.certFile); err == nil && !st.ModTime().Equal(c.modTime) {
+			if err := c.load(); err != nil {
+				// 新证书有问题时继续用旧的
+				log.Println("tls reload error:", err)
+			} else {
+				log.Println("tls certificate reloaded")
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// 按环境变量选择监听方式：
+// 未配置 TLS 时只在 addr 上提供 HTTP；
+// TLS_CERT_FILE + TLS_KEY_FILE 或 TLS_AUTOCERT_DOMAINS 时另在 TLS_ADDR 上提供 HTTPS，
+// addr 上的 HTTP 继续可用（自动证书时同时响应 ACME HTTP-01 验证），TLS_ONLY=true 时关闭。
+// 两者的超时与限制相同，见 httpserver.go
+func serve(addr string, handler http.Handler, limits httpLimits) error {
+	certFile, keyFile := env("TLS_CERT_FILE", ""), env("TLS_KEY_FILE", "")
+	domains := splitList(env("TLS_AUTOCERT_DOMAINS", ""))
+	tlsAddr := env("TLS_ADDR", "0.0.0.0:443")
+	tlsOnly := env("TLS_ONLY", "false") == "true"
+
+	var (
+		tlsConfig   *tls.Config
+		httpHandler = handler
+	)
+	switch {
+	case len(domains) > 0 && (certFile != "" || keyFile != ""):
+		return fmt.Errorf("set either TLS_CERT_FILE/TLS_KEY_FILE or TLS_AUTOCERT_DOMAINS, not both")
+	case len(domains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(env("TLS_AUTOCERT_CACHE", "data/autocert")),
+			Email:      env("TLS_AUTOCERT_EMAIL", ""),
+		}
+		tlsConfig = m.TLSConfig()
+		httpHandler = m.HTTPHandler(handler)
+		log.Println("tls: automatic certificates for", strings.Join(domains, ", "))
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		c, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{GetCertificate: c.GetCertificate}
+	default:
+		return limits.server(addr, handler).ListenAndServe()
+	}
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	errc := make(chan error, 2)
+	go func() {
+		srv := limits.server(tlsAddr, handler)
+		srv.TLSConfig = tlsConfig
+		log.Println("https listening on", tlsAddr)
+		errc <- srv.ListenAndServeTLS("", "")
+	}()
+	if !tlsOnly {
+		go func() { errc <- limits.server(addr, httpHandler).ListenAndServe() }()
+	}
+	return <-errc
+}
@@ -0,0 +1,361 @@
+// topojson.go
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/simplify"
+)
+
+// 默认量化精度（与 topojson 命令行工具一致）
+const defaultQuantization = 10000
+
+type Topology struct {
+	Type      string                     `json:"type"`
+	BBox      [4]float64                 `json:"bbox"`
+	Transform *TopoTransform             `json:"transform,omitempty"`
+	Objects   map[string]*TopoCollection `json:"objects"`
+	Arcs      [][][2]float64             `json:"arcs"`
+}
+
+type TopoTransform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+type TopoCollection struct {
+	Type       string         `json:"type"`
+	Geometries []TopoGeometry `json:"geometries"`
+}
+
+type TopoGeometry struct {
+	Type       string       `json:"type"`
+	ID         string       `json:"id"`
+	Properties ChildrenItem `json:"properties"`
+	// Polygon 为 [][]int，MultiPolygon 为 [][][]int；负数 ~i 表示反向使用第 i 条弧
+	Arcs any `json:"arcs"`
+}
+
+// 某层级的一个行政区及其合并后的多边形
+type topoArea struct {
+	item  ChildrenItem
+	polys orb.MultiPolygon
+}
+
+// 列出 GID 之下 level 层的所有行政区，按 code 排序，各叶子合并成一个轮廓
+func (s *Server) areasAtLevel(ctx context.Context, GID string, level int, mode nameMode) ([]topoArea, error) {
+	codeLevel, err := s.detectLevel(ctx, GID)
+	if err != nil {
+		return nil, err
+	}
+	if level <= codeLevel {
+		return nil, fmt.Errorf("level must be deeper than the level of code (%d)", codeLevel)
+	}
+	sqlStr := fmt.Sprintf(`
+SELECT GID_%d, %s, IFNULL(GID_%d, ''), %s
+FROM %s
+WHERE GID_%d = ? AND GID_%d <> ''
+ORDER BY GID_%d;`,
+		level, s.nameExpr(level, mode), level-1, s.geomCol, s.table, codeLevel, level, level)
+
+	rows, err := s.db.QueryContext(ctx, sqlStr, GID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levelName := levelNameMap()
+	var out []topoArea
+	for rows.Next() {
+		var (
+			item ChildrenItem
+			blob []byte
+		)
+		if err := rows.Scan(&item.GID, &item.Name, &item.ParentCode, &blob); err != nil {
+			return nil, err
+		}
+		if n := len(out); n == 0 || out[n-1].item.GID != item.GID {
+			item.Level = levelName[level]
+			out = append(out, topoArea{item: item})
+		}
+		wkbBytes, _, err := gpkgToWKB(blob)
+		if err != nil {
+			continue
+		}
+		mp, err := decodeMultiPolygon(wkbBytes)
+		if err != nil {
+			continue
+		}
+		out[len(out)-1].polys = append(out[len(out)-1].polys, mp...)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// 每个行政区合并成一个轮廓，去掉叶子之间的边界（见 dissolve）；合并失败时保留各叶子
+	for i := range out {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		mp, _, err := dissolve(out[i].polys)
+		if err != nil {
+			log.Printf("topojson: dissolve %s: %v, leaves kept separate", out[i].item.GID, err)
+			continue
+		}
+		out[i].polys = mp
+	}
+	return out, nil
+}
+
+/************* 拓扑构建 *************/
+
+// 共享边界只存一次：在交汇点（邻接点不一致的顶点）处切分环，相同或反向相同的弧合并
+type topoBuilder struct {
+	seen     map[orb.Point][2]orb.Point
+	junction map[orb.Point]bool
+	arcs     [][]orb.Point
+	index    map[string]int
+}
+
+// 去掉闭合点，少于 3 个点的环返回 nil
+func openRing(r orb.Ring) []orb.Point {
+	pts := []orb.Point(r)
+	if len(pts) > 1 && pts[0] == pts[len(pts)-1] {
+		pts = pts[:len(pts)-1]
+	}
+	if len(pts) < 3 {
+		return nil
+	}
+	return pts
+}
+
+func (t *topoBuilder) markJunctions(ring []orb.Point) {
+	n := len(ring)
+	for i, p := range ring {
+		prev, next := ring[(i+n-1)%n], ring[(i+1)%n]
+		old, ok := t.seen[p]
+		if !ok {
+			t.seen[p] = [2]orb.Point{prev, next}
+			continue
+		}
+		if !(old[0] == prev && old[1] == next) && !(old[0] == next && old[1] == prev) {
+			t.junction[p] = true
+		}
+	}
+}
+
+func arcKey(pts []orb.Point, reverse bool) string {
+	b := make([]byte, 0, 16*len(pts))
+	for i := range pts {
+		p := pts[i]
+		if reverse {
+			p = pts[len(pts)-1-i]
+		}
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(p[0]))
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(p[1]))
+	}
+	return string(b)
+}
+
+func (t *topoBuilder) arcIndex(pts []orb.Point) int {
+	if i, ok := t.index[arcKey(pts, false)]; ok {
+		return i
+	}
+	if i, ok := t.index[arcKey(pts, true)]; ok {
+		return ^i
+	}
+	i := len(t.arcs)
+	t.arcs = append(t.arcs, pts)
+	t.index[arcKey(pts, false)] = i
+	return i
+}
+
+func lessPoint(a, b orb.Point) bool {
+	return a[0] < b[0] || a[0] == b[0] && a[1] < b[1]
+}
+
+// 把一个环切成弧，返回弧下标
+func (t *topoBuilder) ringArcs(ring []orb.Point) []int {
+	n := len(ring)
+	start := -1
+	for i, p := range ring {
+		if t.junction[p] {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		// 没有交汇点的环整体作为一条闭合弧，从最小点开始以便与相同的环（如飞地的洞）合并
+		start = 0
+		for i, p := range ring {
+			if lessPoint(p, ring[start]) {
+				start = i
+			}
+		}
+		arc := make([]orb.Point, 0, n+1)
+		for i := 0; i <= n; i++ {
+			arc = append(arc, ring[(start+i)%n])
+		}
+		return []int{t.arcIndex(arc)}
+	}
+
+	var out []int
+	arc := []orb.Point{ring[start]}
+	for i := 1; i <= n; i++ {
+		p := ring[(start+i)%n]
+		arc = append(arc, p)
+		if t.junction[p] {
+			out = append(out, t.arcIndex(arc))
+			arc = []orb.Point{p}
+		}
+	}
+	return out
+}
+
+// quantization 为 0 时不量化，输出原始经纬度；tolerance > 0 时按弧简化，端点保留，拓扑不变
+func buildTopology(areas []topoArea, object string, quantization int, tolerance float64) *Topology {
+	t := &topoBuilder{
+		seen:     make(map[orb.Point][2]orb.Point),
+		junction: make(map[orb.Point]bool),
+		index:    make(map[string]int),
+	}
+	bound := orb.Bound{Min: orb.Point{math.Inf(1), math.Inf(1)}, Max: orb.Point{math.Inf(-1), math.Inf(-1)}}
+	for _, a := range areas {
+		for _, poly := range a.polys {
+			for _, r := range poly {
+				if ring := openRing(r); ring != nil {
+					t.markJunctions(ring)
+					bound = bound.Union(r.Bound())
+				}
+			}
+		}
+	}
+
+	coll := &TopoCollection{Type: "GeometryCollection", Geometries: make([]TopoGeometry, 0, len(areas))}
+	for _, a := range areas {
+		var polys [][][]int
+		for _, poly := range a.polys {
+			var rings [][]int
+			for _, r := range poly {
+				if ring := openRing(r); ring != nil {
+					rings = append(rings, t.ringArcs(ring))
+				}
+			}
+			if len(rings) > 0 {
+				polys = append(polys, rings)
+			}
+		}
+		g := TopoGeometry{Type: "MultiPolygon", ID: a.item.GID, Properties: a.item, Arcs: polys}
+		if len(polys) == 1 {
+			g.Type, g.Arcs = "Polygon", polys[0]
+		}
+		coll.Geometries = append(coll.Geometries, g)
+	}
+
+	topo := &Topology{
+		Type:    "Topology",
+		BBox:    [4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]},
+		Objects: map[string]*TopoCollection{object: coll},
+		Arcs:    make([][][2]float64, 0, len(t.arcs)),
+	}
+	var kx, ky float64
+	if quantization > 1 {
+		kx = (bound.Max[0] - bound.Min[0]) / float64(quantization-1)
+		ky = (bound.Max[1] - bound.Min[1]) / float64(quantization-1)
+		if kx == 0 {
+			kx = 1
+		}
+		if ky == 0 {
+			ky = 1
+		}
+		topo.Transform = &TopoTransform{Scale: [2]float64{kx, ky}, Translate: [2]float64{bound.Min[0], bound.Min[1]}}
+	}
+	for _, arc := range t.arcs {
+		if tolerance > 0 {
+			ls := simplify.DouglasPeucker(tolerance).LineString(orb.LineString(arc).Clone())
+			// 闭合弧至少保留 4 个点，否则退化
+			if arc[0] != arc[len(arc)-1] || len(ls) >= 4 {
+				arc = ls
+			}
+		}
+		if topo.Transform == nil {
+			out := make([][2]float64, len(arc))
+			for i, p := range arc {
+				out[i] = [2]float64{p[0], p[1]}
+			}
+			topo.Arcs = append(topo.Arcs, out)
+			continue
+		}
+		// 量化后差分编码，去掉量化后重合的相邻点
+		out := make([][2]float64, 0, len(arc))
+		var px, py float64
+		for i, p := range arc {
+			x := math.Round((p[0] - bound.Min[0]) / kx)
+			y := math.Round((p[1] - bound.Min[1]) / ky)
+			switch {
+			case i == 0:
+				out = append(out, [2]float64{x, y})
+			case x != px || y != py:
+				out = append(out, [2]float64{x - px, y - py})
+			}
+			px, py = x, y
+		}
+		if len(out) == 1 {
+			out = append(out, [2]float64{0, 0})
+		}
+		topo.Arcs = append(topo.Arcs, out)
+	}
+	return topo
+}
+
+// 导出 code 之下某一层所有行政区的 TopoJSON
+func (s *Server) handleTopoJSON(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	code := strings.TrimSpace(q.Get("code"))
+	if code == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "code required")
+		return
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(q.Get("level")))
+	if err != nil || level < 1 || level > 5 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 1..5")
+		return
+	}
+	tolerance, err := parseTolerance(r)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	quantization := queryInt(r, "quantization", defaultQuantization, 0, 1e7)
+
+	areas, err := s.areasAtLevel(r.Context(), code, level, parseNameMode(r))
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "gid not found"):
+			writeErrorJSON(w, http.StatusNotFound, 404, "not found")
+		case strings.HasPrefix(err.Error(), "level must"):
+			writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		case errors.Is(err, context.DeadlineExceeded):
+			writeErrorJSON(w, http.StatusServiceUnavailable, 503, "request timeout")
+		default:
+			log.Println("topojson error:", err)
+			writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		}
+		return
+	}
+	if len(areas) == 0 {
+		writeErrorJSON(w, http.StatusNotFound, 404, fmt.Sprintf("no areas at level %d", level))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, buildTopology(areas, "areas", quantization, tolerance))
+}
@@ -0,0 +1,272 @@
+// warm.go
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// 命令行：离线填满缓存库，生成可以随数据文件一起分发的“预热好”的缓存，部署后不用再在启动时计算或逐个请求谷歌。
+//
+//	gpkg-reverse warm -elevation
+//
+// 数据集按与服务相同的环境变量打开（GPKG_PATH、DATASET、GPKG_DIR 等），逐层遍历所有行政区：
+//   - 质心、不可达极点和外接矩形写入 CENTROIDS_PATH（GPKG_DIR 时为每个文件的缓存库），另记数据文件的校验和，
+//     复制到别处后路径、修改时间变了也照样使用，见 centroids.go；build 预处理的库中已含这些列，跳过
+//   - -elevation 时按质心批量查询缓存中还没有的海拔，写入 ELEVATION_DB_PATH：默认请求谷歌 Elevation API（需要 GOOGLE_API_KEY），
+//     ELEVATION_PROVIDER=dem 时读本地瓦片（见 dem.go）。中途出错时已查到的保留，再次运行从缺的开始
+//
+// 缓存库已对应当前数据文件且记有校验和时不重新计算，-force 时照样重算
+
+// 谷歌 Elevation API 一次最多 512 个点，再多 URL 也太长
+const maxElevationBatch = 512
+
+func runWarm(args []string) error {
+	fs := flag.NewFlagSet("warm", flag.ExitOnError)
+	elevation := fs.Bool("elevation", false, "also fetch missing elevations (Google Elevation API, or local tiles with ELEVATION_PROVIDER=dem)")
+	elevationPath := fs.String("elevations", env("ELEVATION_DB_PATH", "data/elevations.db"), "elevation cache database path")
+	batch := fs.Int("batch", 100, "locations per elevation request")
+	force := fs.Bool("force", false, "recompute the centroid caches even if they are up to date")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *batch < 1 || *batch > maxElevationBatch {
+		return fmt.Errorf("invalid -batch, use 1..%d", maxElevationBatch)
+	}
+	if *elevation {
+		src, err := loadElevationSource()
+		if err != nil {
+			return err
+		}
+		if g, ok := src.(googleElevation); ok && g.apiKey == "" {
+			return fmt.Errorf("-elevation needs GOOGLE_API_KEY or ELEVATION_PROVIDER=dem")
+		}
+	}
+
+	var elevationDB *sql.DB
+	if *elevation {
+		var err error
+		if elevationDB, err = openElevationDB(*elevationPath); err != nil {
+			return err
+		}
+		defer elevationDB.Close()
+	}
+	var (
+		files func() ([]string, error)
+		open  func() ([]*Server, []apiRoute, error)
+	)
+	switch dataset := env("DATASET", "gadm"); {
+	case env("GPKG_DIR", "") != "":
+		files, open = registryDataset(env("GPKG_DIR", ""), elevationDB, nil)
+	case dataset == "gadm":
+		files, open = singleDataset(elevationDB, nil)
+	case dataset == "naturalearth":
+		files, open = naturalEarthDataset(elevationDB, nil)
+	default:
+		return fmt.Errorf("invalid DATASET %q, use gadm or naturalearth", dataset)
+	}
+	if _, err := files(); err != nil {
+		return err
+	}
+	servers, _, err := open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, s := range servers {
+			s.close()
+		}
+	}()
+
+	start := time.Now()
+	for _, s := range servers {
+		if err := s.warmCentroids(*force); err != nil {
+			return fmt.Errorf("%s: %w", s.path, err)
+		}
+		if *elevation {
+			if err := s.warmElevations(*batch, 0); err != nil {
+				return fmt.Errorf("%s: %w", s.path, err)
+			}
+		}
+	}
+	log.Printf("warm: %d datasets in %s", len(servers), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func (s *Server) warmCentroids(force bool) error {
+	if s.built {
+		log.Printf("warm: %s is a built database, centroids and bboxes are already included", s.path)
+		return nil
+	}
+	if s.centroidsPath == "" {
+		return nil
+	}
+	version, err := datasetVersion([]string{s.path})
+	if err != nil {
+		return err
+	}
+	if !force && centroidsCurrent(s.path, s.centroidsPath, version) && centroidsChecksum(s.centroidsPath) != "" {
+		log.Printf("warm: %s is up to date", s.centroidsPath)
+	} else {
+		start := time.Now()
+		sum, err := fileSHA256(s.path)
+		if err != nil {
+			return err
+		}
+		if err := buildCentroids(s.path, s.centroidsPath, s.table, s.geomCol, version, sum); err != nil {
+			return err
+		}
+		log.Printf("warm: %s -> %s in %s", s.path, s.centroidsPath, time.Since(start).Round(time.Millisecond))
+	}
+	// 查海拔时从缓存库读质心
+	s.loadCentroids()
+	if s.centroids.Load() == nil {
+		return fmt.Errorf("failed to open %s", s.centroidsPath)
+	}
+	return nil
+}
+
+// 缓存库中记下的数据文件校验和，没有时为空
+func centroidsChecksum(path string) string {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return ""
+	}
+	defer db.Close()
+	var sum string
+	db.QueryRow(`SELECT value FROM meta WHERE key = 'sha256';`).Scan(&sum)
+	return sum
+}
+
+// 逐个行政区的质心（与 /latlng 相同），按层级、GID 排序
+func (s *Server) eachCentroid(fn func(gid string, c orb.Point) error) error {
+	query := func(db *sql.DB, q string, args ...any) error {
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				gid string
+				c   orb.Point
+			)
+			if err := rows.Scan(&gid, &c[0], &c[1]); err != nil {
+				return err
+			}
+			if err := fn(gid, c); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+	if s.built {
+		for lvl := 0; lvl <= 5; lvl++ {
+			q := fmt.Sprintf(`SELECT gid, lon, lat FROM "%s" WHERE lon IS NOT NULL ORDER BY gid;`, levelTableName(s.table, lvl))
+			if err := query(s.db, q); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	st := s.centroids.Load()
+	if st == nil {
+		return fmt.Errorf("centroids are not loaded")
+	}
+	return query(st.db, `SELECT gid, lon, lat FROM centroids ORDER BY level, gid;`)
+}
+
+// 缓存中没有的海拔按质心批量查询，每批查到后即写入；本地 DEM 没有数据的不写入。
+// every 为两次请求海拔来源的最小间隔（后台预取时限速，见 prefetch.go），0 时不限
+func (s *Server) warmElevations(batch int, every time.Duration) error {
+	cached := make(map[string]bool)
+	rows, err := s.elevationDB.Query(`SELECT gid FROM elevations;`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var gid string
+		if err := rows.Scan(&gid); err != nil {
+			rows.Close()
+			return err
+		}
+		cached[gid] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var (
+		gids    []string
+		pts     []orb.Point
+		fetched int
+		skipped int
+		missing int
+		start   = time.Now()
+		last    time.Time
+	)
+	flush := func() error {
+		if len(gids) == 0 {
+			return nil
+		}
+		if d := every - time.Since(last); every > 0 && d > 0 {
+			time.Sleep(d)
+		}
+		last = time.Now()
+		elevations, err := s.elevationSrc.elevations(pts)
+		if err != nil {
+			return err
+		}
+		tx, err := s.elevationDB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for i, gid := range gids {
+			if math.IsNaN(elevations[i]) {
+				missing++
+				continue
+			}
+			if _, err := tx.Exec(`INSERT OR REPLACE INTO elevations (gid, elevation) VALUES (?, ?);`, gid, elevations[i]); err != nil {
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		fetched += len(gids)
+		if fetched%10000 < len(gids) {
+			log.Printf("elevations: %s, %d fetched", s.path, fetched)
+		}
+		gids, pts = gids[:0], pts[:0]
+		return nil
+	}
+	err = s.eachCentroid(func(gid string, c orb.Point) error {
+		if cached[gid] {
+			skipped++
+			return nil
+		}
+		// 同一 GID 出现在多层时只查一次
+		cached[gid] = true
+		gids, pts = append(gids, gid), append(pts, c)
+		if len(gids) < batch {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return fmt.Errorf("elevations (%d fetched before the error): %w", fetched, err)
+	}
+	log.Printf("elevations: %s, %d fetched, %d already cached, %d without data, in %s", s.path, fetched-missing, skipped, missing, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,195 @@
+// warmup.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 启动预热：部署后的第一分钟几何缓存、结果缓存、预编译语句和操作系统页缓存都是空的，延迟很差。
+// 设置 WARMUP_PATH 后，数据集打开后、开始监听（热更新时为切换版本）之前先按文件内容预热：
+//   - GID：解码该区域所有叶子的几何（及两段判断的简化几何）放进几何缓存，并查一次中心点
+//   - 坐标或请求路径：照常反查一次，填满几何缓存和结果缓存；路径中只有 code 时同 GID
+//
+// 文件一行一条，# 开头为注释：IDN.8_1、-6.1938,106.7994（纬度,经度），
+// 或访问日志中的请求路径（/reverse?latitude=..&longitude=..、/latlng?code=..，坐标参数同 /reverse）。
+// 找不到的 GID、不在任何区域的点跳过。WARMUP_TIMEOUT（默认 60s）为预热的时间上限，到时停止、照常启动。
+// WARMUP_PAGE_CACHE=1 时另把数据文件完整读一遍，读进操作系统的页缓存，mmap 的连接直接命中。
+// 几何缓存按 GEOM_CACHE_MB 淘汰，列出的区域超过容量时只有后面的留在缓存中
+type warmupEntry struct {
+	gid      string
+	lat, lon float64
+}
+
+func loadWarmup(path string) ([]warmupEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []warmupEntry
+	skipped := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if e, ok := parseWarmupLine(line); ok {
+			entries = append(entries, e)
+		} else {
+			skipped++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if skipped > 0 {
+		log.Printf("warmup: %s, %d lines not understood, skipped", path, skipped)
+	}
+	return entries, nil
+}
+
+func parseWarmupLine(line string) (warmupEntry, bool) {
+	// 访问日志的一行可能还有方法、状态码等，取其中的请求路径
+	if i := strings.IndexByte(line, '?'); i >= 0 {
+		start := strings.LastIndexAny(line[:i], " \t\"") + 1
+		end := strings.IndexAny(line[i:], " \t\"")
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += i
+		}
+		u, err := url.Parse(line[start:end])
+		if err != nil {
+			return warmupEntry{}, false
+		}
+		if lat, lon, err := parseLatLon(&http.Request{URL: u}); err == nil {
+			return warmupEntry{lat: lat, lon: lon}, true
+		}
+		q := u.Query()
+		for _, key := range []string{"code", "parent_code"} {
+			if gid := strings.TrimSpace(q.Get(key)); gid != "" {
+				return warmupEntry{gid: gid}, true
+			}
+		}
+		return warmupEntry{}, false
+	}
+	if a, b, ok := strings.Cut(line, ","); ok {
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		lon, err2 := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err1 != nil || err2 != nil {
+			return warmupEntry{}, false
+		}
+		return warmupEntry{lat: lat, lon: lon}, true
+	}
+	if strings.ContainsAny(line, " \t") {
+		return warmupEntry{}, false
+	}
+	return warmupEntry{gid: line}, true
+}
+
+// 按配置预热刚打开的数据集；出错只记日志，不影响启动
+func (s *Server) warmup() {
+	path := env("WARMUP_PATH", "")
+	pageCache := env("WARMUP_PAGE_CACHE", "0") == "1"
+	if path == "" && !pageCache {
+		return
+	}
+	timeout, err := time.ParseDuration(env("WARMUP_TIMEOUT", "60s"))
+	if err != nil || timeout <= 0 {
+		log.Println("warmup error: invalid WARMUP_TIMEOUT")
+		return
+	}
+	start := time.Now()
+	deadline := start.Add(timeout)
+	if pageCache {
+		n, err := readThrough(s.path)
+		if err != nil {
+			log.Println("warmup error:", err)
+		}
+		log.Printf("warmup: read %d MiB of %s into page cache", n>>20, s.path)
+	}
+	if path == "" {
+		return
+	}
+	entries, err := loadWarmup(path)
+	if err != nil {
+		log.Println("warmup error:", err)
+		return
+	}
+	// 有中心点缓存库时先打开（需要重新计算时也在这里算完，不受 WARMUP_TIMEOUT 限制），预热的中心点查询直接查表
+	s.loadCentroids()
+	var gids, points, missed int
+	for i, e := range entries {
+		if time.Now().After(deadline) {
+			log.Printf("warmup: WARMUP_TIMEOUT reached after %d of %d entries", i, len(entries))
+			break
+		}
+		if e.gid == "" {
+			points++
+			if _, err := s.reverse(context.Background(), e.lon, e.lat, nameLatin); err != nil {
+				missed++
+			}
+			continue
+		}
+		gids++
+		if err := s.warmArea(e.gid); err != nil {
+			missed++
+		}
+	}
+	log.Printf("warmup: %d areas, %d points (%d not found) from %s in %s",
+		gids, points, missed, path, time.Since(start).Round(time.Millisecond))
+}
+
+// 解码该区域所有叶子的几何放进缓存，并查一次中心点
+func (s *Server) warmArea(gid string) error {
+	gid, err := s.resolveCode(context.Background(), gid)
+	if err != nil {
+		return err
+	}
+	level, err := s.detectLevel(context.Background(), gid)
+	if err != nil {
+		return err
+	}
+	coarseCol, coarseJoin := s.coarseColumns()
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT a.rowid, a.%s, %s FROM %s AS a %s WHERE a.GID_%d = ?;`,
+		s.geomCol, coarseCol, s.table, coarseJoin, level), gid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := new(candidate)
+		if err := rows.Scan(&c.rowid, &c.blob, &c.coarse); err != nil {
+			return err
+		}
+		s.coarseShape(c)
+		s.decodeCandidate(c)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = s.latlngOf(context.Background(), gid)
+	return err
+}
+
+// 顺序读完整个文件，返回读到的字节数
+func readThrough(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(io.Discard, f)
+}
@@ -0,0 +1,210 @@
+// within.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+)
+
+// /within 的 limit 上限
+const maxWithinAreas = 5000
+
+// 解析 "minLon,minLat,maxLon,maxLat"
+func parseBBox(v string) (orb.Bound, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return orb.Bound{}, fmt.Errorf("invalid bbox, use 'minLon,minLat,maxLon,maxLat'")
+	}
+	var f [4]float64
+	for i, p := range parts {
+		x, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return orb.Bound{}, fmt.Errorf("invalid bbox values")
+		}
+		f[i] = x
+	}
+	b := orb.Bound{Min: orb.Point{f[0], f[1]}, Max: orb.Point{f[2], f[3]}}
+	if b.Min.Lon() < -180 || b.Max.Lon() > 180 || b.Min.Lat() < -90 || b.Max.Lat() > 90 {
+		return orb.Bound{}, fmt.Errorf("bbox out of range")
+	}
+	if b.Min.Lon() > b.Max.Lon() || b.Min.Lat() > b.Max.Lat() {
+		return orb.Bound{}, fmt.Errorf("invalid bbox, min must not exceed max")
+	}
+	return b, nil
+}
+
+// 读取一行叶子多边形
+func (s *Server) leafGeom(rowid int64) (orb.MultiPolygon, error) {
+	var blob []byte
+	sqlStr := fmt.Sprintf("SELECT %s FROM %s WHERE rowid = ?;", s.geomCol, s.table)
+	if err := s.db.QueryRow(sqlStr, rowid).Scan(&blob); err != nil {
+		return nil, err
+	}
+	wkbBytes, _, err := gpkgToWKB(blob)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMultiPolygon(wkbBytes)
+}
+
+// r-tree 命中的一行叶子多边形及其在指定层级上的行政区
+type levelRow struct {
+	rowid int64
+	item  ChildrenItem
+	bound orb.Bound
+}
+
+// 列出外接矩形与 b 相交、且在 level 层有 GID 的所有叶子行
+func (s *Server) levelRowsIn(b orb.Bound, level int, mode nameMode) ([]levelRow, error) {
+	levelName := levelNameMap()
+	parentCol := "''"
+	if level > 0 {
+		parentCol = fmt.Sprintf("a.GID_%d", level-1)
+	}
+	sqlStr := fmt.Sprintf(`
+SELECT a.rowid, a.GID_%d, %s, %s, r.minx, r.maxx, r.miny, r.maxy
+FROM %s AS a
+JOIN %s AS r ON a.rowid = r.id
+WHERE r.minx <= ? AND r.maxx >= ? AND r.miny <= ? AND r.maxy >= ?
+  AND a.GID_%d <> '';`,
+		level, s.nameExpr(level, mode), parentCol, s.table, s.rtreeTable, level)
+
+	rows, err := s.db.Query(sqlStr, b.Max.Lon(), b.Min.Lon(), b.Max.Lat(), b.Min.Lat())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]levelRow, 0)
+	for rows.Next() {
+		var (
+			row                    levelRow
+			minx, maxx, miny, maxy float64
+		)
+		if err := rows.Scan(&row.rowid, &row.item.GID, &row.item.Name, &row.item.ParentCode, &minx, &maxx, &miny, &maxy); err != nil {
+			return nil, err
+		}
+		row.item.Level = levelName[level]
+		row.bound = orb.Bound{Min: orb.Point{minx, miny}, Max: orb.Point{maxx, maxy}}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+/************* 与矩形相交的行政区 *************/
+func (s *Server) within(b orb.Bound, level int, mode nameMode) ([]ChildrenItem, error) {
+	out := make([]ChildrenItem, 0)
+	err := s.withinEach(b, level, mode, func(item ChildrenItem) error {
+		out = append(out, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GID < out[j].GID })
+	return out, nil
+}
+
+// 每确认一个相交的行政区就回调一次：先是外接矩形完全落在 bbox 内的，
+// 再是逐个判断多边形后命中的；同一行政区只回调一次，整体不排序
+func (s *Server) withinEach(b orb.Bound, level int, mode nameMode, emit func(ChildrenItem) error) error {
+	rows, err := s.levelRowsIn(b, level, mode)
+	if err != nil {
+		return err
+	}
+
+	items := make(map[string]ChildrenItem)
+	hit := make(map[string]bool)
+	pending := make(map[string][]int64)
+	for _, row := range rows {
+		gid := row.item.GID
+		if _, ok := items[gid]; !ok {
+			items[gid] = row.item
+		}
+		if hit[gid] {
+			continue
+		}
+		if b.Contains(row.bound.Min) && b.Contains(row.bound.Max) {
+			hit[gid] = true
+			delete(pending, gid)
+			if err := emit(items[gid]); err != nil {
+				return err
+			}
+			continue
+		}
+		pending[gid] = append(pending[gid], row.rowid)
+	}
+
+	gids := make([]string, 0, len(pending))
+	for gid := range pending {
+		gids = append(gids, gid)
+	}
+	sort.Strings(gids)
+	for _, gid := range gids {
+		for _, rowid := range pending[gid] {
+			mp, err := s.leafGeom(rowid)
+			if err != nil {
+				continue
+			}
+			if len(clip.MultiPolygon(b, mp)) > 0 {
+				if err := emit(items[gid]); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleWithin(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bboxStr := strings.TrimSpace(q.Get("bbox"))
+	if bboxStr == "" {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "bbox required")
+		return
+	}
+	b, err := parseBBox(bboxStr)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(q.Get("level")))
+	if err != nil || level < 0 || level > 5 {
+		writeErrorJSON(w, http.StatusBadRequest, 400, "invalid level, use 0..5")
+		return
+	}
+	if wantsNDJSON(r) {
+		nd := newNDJSONStream(w)
+		nd.finish("within", s.withinEach(b, level, parseNameMode(r), func(item ChildrenItem) error {
+			return nd.write(item)
+		}))
+		return
+	}
+	page := parsePage(r, maxWithinAreas)
+
+	items, err := s.within(b, level, parseNameMode(r))
+	if err != nil {
+		log.Println("within error:", err)
+		writeErrorJSON(w, http.StatusInternalServerError, 500, "internal error")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=2592000, stale-if-error=2592000")
+	writeJSON(w, http.StatusOK, ChildrenRes{
+		Code: 200,
+		Msg:  "success",
+		Data: &ChildrenItemList{
+			List:   pageSlice(items, page),
+			Total:  len(items),
+			Limit:  page.Limit,
+			Offset: page.Offset,
+		},
+	})
+}